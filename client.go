@@ -3,8 +3,12 @@ package gres
 import (
 	"context"
 	"fmt"
+	"io"
 	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/clovers4/gres/commands"
 	"github.com/clovers4/gres/engine"
@@ -15,9 +19,30 @@ import (
 type Client struct {
 	ctx context.Context
 
-	conn *proto.Conn
-	db   *engine.DB // Pointer to currently selected DB
-	srv  *Server
+	id       int64
+	name     string
+	conn     *proto.Conn
+	wmu      sync.Mutex // 命令的回复与推送的消息可能同时写入
+	protover int
+	user     *commands.User // nil 表示尚未认证
+	db       *engine.DB     // Pointer to currently selected DB
+	tx       commands.Tx
+	srv      *Server
+
+	// replication, 连接是 replica 时使用
+	replicaPort int    // replica 监听的端口
+	syncing     bool   // PSYNC 之后, 连接交给 serveReplica
+	psyncID     string // PSYNC 的复制 id 与位置, replica 从此处继续
+	psyncOffset int64
+	stream      *engine.ReplicaStream // 传播给 replica 的写命令
+	ackOffset   int64                 // replica 确认已应用的位置, 由 srv.mu 保护
+	ackTime     time.Time
+
+	// pub/sub, 只在 Interact 所在的 goroutine 中修改
+	channels map[string]struct{}
+	patterns map[string]struct{}
+	pushCh   chan []interface{}
+	pushDone chan struct{}
 
 	log *zap.Logger
 }
@@ -28,12 +53,17 @@ func NewClient(netConn net.Conn, srv *Server) *Client {
 	ctx = engine.CtxWithDB(ctx, srv.db)
 
 	cli := &Client{
-		ctx:  ctx,
-		conn: conn,
-		db:   srv.db,
-		srv:  srv,
-		log:  srv.log,
+		id:       atomic.AddInt64(&srv.nextClientID, 1),
+		conn:     conn,
+		protover: proto.RESP2,
+		user:     srv.acl.InitialUser(),
+		db:       srv.db,
+		srv:      srv,
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+		log:      srv.log,
 	}
+	cli.ctx = commands.CtxWithSession(ctx, cli)
 
 	srv.mu.Lock()
 	srv.clients = append(srv.clients, cli)
@@ -42,22 +72,28 @@ func NewClient(netConn net.Conn, srv *Server) *Client {
 }
 
 func (cli *Client) Interact() {
+	// 连接断开后, 释放 watch 的 key, 取消所有订阅
+	defer cli.tx.Reset()
+	defer cli.stopPush()
+
 	var err error
 	conn := cli.conn
-	ctx := cli.ctx
 	for {
+		// SELECT 等命令会修改 cli.ctx, 每条命令都要重新读取
+		ctx := cli.ctx
 		var reply *proto.Reply
-		var quit bool
+		var quit, skip bool
+		var readErr error
 		err = conn.WithReader(ctx, 0, func(rd *proto.Reader) error { // todo:time
-			input, err := rd.ReadReply()
+			args, err := rd.ReadCommand()
 			if err != nil {
+				readErr = err
 				return err
 			}
-
-			var args []string
-			args, ok := input.([]string)
-			if !ok || len(args) == 0 {
-				return fmt.Errorf("incorrect input: %v", input)
+			// 空行等, 不需要回复
+			if len(args) == 0 {
+				skip = true
+				return nil
 			}
 
 			args[0] = strings.ToLower(args[0])
@@ -66,10 +102,49 @@ func (cli *Client) Interact() {
 				return nil
 			}
 
+			// 未认证, 没有权限或写只读的 replica 时, 不执行也不入队
+			err = commands.CheckPerm(cli, args)
+			if err == nil {
+				err = cli.srv.checkWritable(args[0])
+			}
+			if err != nil {
+				if cli.tx.InMulti() && commands.Queueable(args[0]) {
+					reply = cli.tx.Reject(err)
+					return nil
+				}
+				return err
+			}
+
+			// RESP2 订阅后只能执行订阅相关的命令, RESP3 的消息与回复可以区分, 不受限制
+			if cli.subscribed() && cli.protover == proto.RESP2 && !commands.SubscribedAllowed(args[0]) {
+				return fmt.Errorf("Can't execute '%v': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context", args[0])
+			}
+
+			// raft mode 下, 写命令经过 raft 的日志执行
+			if g := cli.srv.raft; g != nil {
+				var handled bool
+				if reply, handled, err = g.do(cli, args); handled {
+					return err
+				}
+			}
+
+			// 事务中, 命令入队, 在 EXEC 时执行
+			if cli.tx.InMulti() && commands.Queueable(args[0]) {
+				reply = cli.tx.Queue(cli.db, args)
+				return nil
+			}
+
 			cmd := commands.GetCmd(args[0])
 			if cmd == nil {
 				return fmt.Errorf("cannot find cmd: %v", args[0])
 			}
+			// 阻塞中连接断开或服务器关闭时, 不再等待
+			if commands.MayBlock(args[0]) {
+				blockCtx, stop := cli.watchClose(ctx)
+				reply = cmd.Do(blockCtx, args)
+				stop()
+				return nil
+			}
 			reply = cmd.Do(ctx, args)
 
 			return nil
@@ -78,8 +153,25 @@ func (cli *Client) Interact() {
 		if quit == true {
 			break
 		}
+		if skip {
+			continue
+		}
+		// 连接已断开
+		_, protoErr := readErr.(proto.ProtocolError)
+		if readErr != nil && !protoErr {
+			break
+		}
 
-		err = conn.WithWriter(ctx, 0, func(wr *proto.Writer) error { // todo:time
+		// PSYNC 之后, 连接只用于复制
+		if cli.syncing {
+			cli.srv.serveReplica(cli)
+			break
+		}
+		// (un)subscribe 的回复已经推送
+		if err == nil && reply == nil {
+			continue
+		}
+		err = cli.write(func(wr *proto.Writer) error {
 			if err != nil {
 				return wr.ReplyErr(err)
 			}
@@ -90,7 +182,119 @@ func (cli *Client) Interact() {
 			cli.log.Warn("[Client Interact] conn.WithWriter()", zap.String("err", err.Error()))
 			break
 		}
+		// 协议错误后无法确定下一条命令的开始, 回复后关闭连接
+		if protoErr {
+			break
+		}
+	}
+}
+
+// watchClose returns a context which is canceled once the connection is closed,
+// while a blocking command waits. stop must be called before reading the next command.
+func (cli *Client) watchClose(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := cli.conn.Peek()
+		if ne, ok := err.(net.Error); err != nil && !(ok && ne.Timeout()) {
+			cancel()
+		}
+	}()
+
+	return ctx, func() {
+		// 使 Peek 立即返回
+		cli.conn.SetReadDeadline(time.Now())
+		<-done
+		cli.conn.SetReadDeadline(time.Time{})
+		cancel()
+	}
+}
+
+func (cli *Client) write(fn func(wr *proto.Writer) error) error {
+	cli.wmu.Lock()
+	defer cli.wmu.Unlock()
+	return cli.conn.WithWriter(cli.ctx, 0, fn) // todo:time
+}
+
+// writeRaw writes the bytes which are already encoded, such as the snapshot and the
+// write commands sent to a replica.
+func (cli *Client) writeRaw(fn func(w io.Writer) error) error {
+	cli.wmu.Lock()
+	defer cli.wmu.Unlock()
+	return fn(cli.conn)
+}
+
+// DB implements commands.Session.
+func (cli *Client) DB() *engine.DB {
+	return cli.db
+}
+
+// SelectDB implements commands.Session.
+func (cli *Client) SelectDB(db *engine.DB) {
+	cli.db = db
+	cli.ctx = engine.CtxWithDB(cli.ctx, db)
+}
+
+// ID implements commands.Session.
+func (cli *Client) ID() int64 {
+	return cli.id
+}
+
+// Proto implements commands.Session.
+func (cli *Client) Proto() int {
+	return cli.protover
+}
+
+// SetProto implements commands.Session.
+func (cli *Client) SetProto(protover int) {
+	cli.wmu.Lock()
+	defer cli.wmu.Unlock()
+	cli.protover = protover
+	cli.conn.SetProto(protover)
+}
+
+// SetName implements commands.Session.
+func (cli *Client) SetName(name string) {
+	cli.name = name
+}
+
+// User implements commands.Session.
+func (cli *Client) User() *commands.User {
+	return cli.user
+}
+
+// SetUser implements commands.Session.
+func (cli *Client) SetUser(u *commands.User) {
+	cli.user = u
+}
+
+// ACL implements commands.Session.
+func (cli *Client) ACL() *commands.ACL {
+	return cli.srv.acl
+}
+
+// Tx implements commands.Session.
+func (cli *Client) Tx() *commands.Tx {
+	return &cli.tx
+}
+
+// Config implements commands.Session.
+func (cli *Client) Config() commands.Config {
+	return cli.srv
+}
+
+// Replication implements commands.Session.
+func (cli *Client) Replication() commands.Replication {
+	return cli.srv
+}
+
+// Raft implements commands.Session.
+func (cli *Client) Raft() commands.Raft {
+	if cli.srv.raft == nil {
+		return nil
 	}
+	return cli.srv.raft
 }
 
 func (cli *Client) Close() error {
@@ -3,6 +3,8 @@ package cmd
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
+	"flag"
 	"fmt"
 	"net"
 	"os"
@@ -13,6 +15,7 @@ import (
 	"time"
 
 	"github.com/clovers4/gres/proto"
+	"github.com/clovers4/gres/util"
 	"go.uber.org/zap"
 )
 
@@ -21,6 +24,12 @@ const readSize = 4096
 var (
 	port = 9876
 	host = "127.0.0.1"
+
+	useTLS   = flag.Bool("tls", false, "establish a secure TLS connection.")
+	cacert   = flag.String("cacert", "", "CA certificate file to verify the server with.")
+	cert     = flag.String("cert", "", "client certificate to authenticate with.")
+	key      = flag.String("key", "", "private key file to authenticate with.")
+	insecure = flag.Bool("insecure", false, "allow insecure TLS connection by skipping cert validation.")
 )
 
 // Client represents another side of Server, and is not the same as
@@ -38,6 +47,7 @@ type Client struct {
 type clientOptions struct {
 	remoteAddr        string        // Record the remote address, the form is host:port
 	connectionTimeout time.Duration // Max timeout of connection
+	tlsConfig         *tls.Config   // nil 表示不使用 TLS
 }
 
 var defaultClientOptions = clientOptions{
@@ -56,7 +66,14 @@ func NewClient() *Client {
 	opts := defaultClientOptions
 	initFlag(&opts)
 
-	netConn, err := net.DialTimeout("tcp", opts.remoteAddr, opts.connectionTimeout)
+	var netConn net.Conn
+	var err error
+	if opts.tlsConfig != nil {
+		dialer := &net.Dialer{Timeout: opts.connectionTimeout}
+		netConn, err = tls.DialWithDialer(dialer, "tcp", opts.remoteAddr, opts.tlsConfig)
+	} else {
+		netConn, err = net.DialTimeout("tcp", opts.remoteAddr, opts.connectionTimeout)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "dail err=%v\n", err)
 		os.Exit(0)
@@ -75,6 +92,14 @@ func NewClient() *Client {
 
 func initFlag(opts *clientOptions) {
 	opts.remoteAddr = fmt.Sprintf("%s:%d", host, port)
+	if *useTLS {
+		conf, err := util.ClientTLSConfig(host, *cert, *key, *cacert, *insecure)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tls err=%v\n", err)
+			os.Exit(0)
+		}
+		opts.tlsConfig = conf
+	}
 }
 
 func (cli *Client) Start() {
@@ -152,30 +177,45 @@ func (cli *Client) interact(input string) (output string, err error) {
 		if err != nil {
 			return err
 		}
-		if reply == "" {
-			output = "(nil)"
-		} else if _, ok := reply.(string); ok {
-			output = fmt.Sprintf("\"%v\"", reply)
-		} else if ss, ok := reply.([]string); ok {
-			for i, s := range ss {
-				output += fmt.Sprintf("%v) \"%v\"", i+1, s)
-				if i < len(ss)-1 {
-					output += "\n"
-				}
-			}
-			if len(ss) == 0 {
-				output += "(empty list or set)"
-			}
-		} else if i, ok := reply.(int64); ok {
-			output += fmt.Sprintf("(integer) %v", i)
-		} else {
-			output = fmt.Sprintf("%v", reply)
-		}
+		output = formatReply(reply, "")
 		return nil
 	})
 	return output, err
 }
 
+// formatReply formats reply like redis-cli, the elements of nested arrays are indented.
+func formatReply(reply interface{}, indent string) string {
+	switch r := reply.(type) {
+	case nil:
+		return "(nil)"
+	case string:
+		return fmt.Sprintf("\"%v\"", r)
+	case int64:
+		return fmt.Sprintf("(integer) %v", r)
+	case float64:
+		return fmt.Sprintf("(double) %v", r)
+	case bool:
+		return fmt.Sprintf("(%v)", r)
+	case proto.RedisError:
+		return fmt.Sprintf("(error) %v", r)
+	case []interface{}:
+		if len(r) == 0 {
+			return "(empty list or set)"
+		}
+		var b strings.Builder
+		for i, v := range r {
+			prefix := fmt.Sprintf("%v) ", i+1)
+			if i > 0 {
+				b.WriteString("\n" + indent)
+			}
+			b.WriteString(prefix)
+			b.WriteString(formatReply(v, indent+strings.Repeat(" ", len(prefix))))
+		}
+		return b.String()
+	}
+	return fmt.Sprintf("%v", reply)
+}
+
 // GracefulExit does some remaining work and will exit gracefully.
 // It will close the connection, ... , etc.
 func (cli *Client) GracefulExit() {
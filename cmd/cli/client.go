@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"net"
@@ -14,6 +15,7 @@ import (
 	"time"
 
 	"github.com/clovers4/gres/proto"
+	"github.com/clovers4/gres/util"
 	"go.uber.org/zap"
 )
 
@@ -22,6 +24,14 @@ const readSize = 4096
 var (
 	port = flag.Int("p", 9876, "specify port to use.  defaults to 9876.")
 	host = flag.String("h", "127.0.0.1", "specify host to use.  defaults to 127.0.0.1.")
+	sock = flag.String("s", "", "specify unix socket to use, which overrides host and port.")
+
+	useTLS   = flag.Bool("tls", false, "establish a secure TLS connection.")
+	sni      = flag.String("sni", "", "server name indication for TLS. defaults to host.")
+	cacert   = flag.String("cacert", "", "CA certificate file to verify the server with.")
+	cert     = flag.String("cert", "", "client certificate to authenticate with.")
+	key      = flag.String("key", "", "private key file to authenticate with.")
+	insecure = flag.Bool("insecure", false, "allow insecure TLS connection by skipping cert validation.")
 )
 
 func init() {
@@ -41,11 +51,14 @@ type Client struct {
 }
 
 type clientOptions struct {
-	remoteAddr        string        // Record the remote address, the form is host:port
+	network           string        // tcp or unix
+	remoteAddr        string        // Record the remote address, the form is host:port, or the path of unix socket
 	connectionTimeout time.Duration // Max timeout of connection
+	tlsConfig         *tls.Config   // nil 表示不使用 TLS
 }
 
 var defaultClientOptions = clientOptions{
+	network:           "tcp",
 	remoteAddr:        "127.0.0.1:9876",
 	connectionTimeout: 5 * time.Second,
 }
@@ -61,7 +74,14 @@ func NewClient() *Client {
 	opts := defaultClientOptions
 	initFlag(&opts)
 
-	netConn, err := net.DialTimeout("tcp", opts.remoteAddr, opts.connectionTimeout)
+	var netConn net.Conn
+	var err error
+	if opts.tlsConfig != nil {
+		dialer := &net.Dialer{Timeout: opts.connectionTimeout}
+		netConn, err = tls.DialWithDialer(dialer, opts.network, opts.remoteAddr, opts.tlsConfig)
+	} else {
+		netConn, err = net.DialTimeout(opts.network, opts.remoteAddr, opts.connectionTimeout)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "dail err=%v\n", err)
 		os.Exit(0)
@@ -79,7 +99,25 @@ func NewClient() *Client {
 }
 
 func initFlag(opts *clientOptions) {
-	opts.remoteAddr = fmt.Sprintf("%s:%d", *host, *port)
+	if *useTLS {
+		serverName := *sni
+		if serverName == "" {
+			serverName = *host
+		}
+		conf, err := util.ClientTLSConfig(serverName, *cert, *key, *cacert, *insecure)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tls err=%v\n", err)
+			os.Exit(0)
+		}
+		opts.tlsConfig = conf
+	}
+
+	if *sock != "" {
+		opts.network = "unix"
+		opts.remoteAddr = *sock
+		return
+	}
+	opts.remoteAddr = net.JoinHostPort(*host, strconv.Itoa(*port))
 }
 
 func (cli *Client) Start() {
@@ -157,30 +195,45 @@ func (cli *Client) interact(input string) (output string, err error) {
 		if err != nil {
 			return err
 		}
-		if reply == "" {
-			output = "(nil)"
-		} else if _, ok := reply.(string); ok {
-			output = fmt.Sprintf("\"%v\"", reply)
-		} else if ss, ok := reply.([]string); ok {
-			for i, s := range ss {
-				output += fmt.Sprintf("%v) \"%v\"", i+1, s)
-				if i < len(ss)-1 {
-					output += "\n"
-				}
-			}
-			if len(ss) == 0 {
-				output += "(empty list or set)"
-			}
-		} else if i, ok := reply.(int64); ok {
-			output += fmt.Sprintf("(integer) %v", i)
-		} else {
-			output = fmt.Sprintf("%v", reply)
-		}
+		output = formatReply(reply, "")
 		return nil
 	})
 	return output, err
 }
 
+// formatReply formats reply like redis-cli, the elements of nested arrays are indented.
+func formatReply(reply interface{}, indent string) string {
+	switch r := reply.(type) {
+	case nil:
+		return "(nil)"
+	case string:
+		return fmt.Sprintf("\"%v\"", r)
+	case int64:
+		return fmt.Sprintf("(integer) %v", r)
+	case float64:
+		return fmt.Sprintf("(double) %v", r)
+	case bool:
+		return fmt.Sprintf("(%v)", r)
+	case proto.RedisError:
+		return fmt.Sprintf("(error) %v", r)
+	case []interface{}:
+		if len(r) == 0 {
+			return "(empty list or set)"
+		}
+		var b strings.Builder
+		for i, v := range r {
+			prefix := fmt.Sprintf("%v) ", i+1)
+			if i > 0 {
+				b.WriteString("\n" + indent)
+			}
+			b.WriteString(prefix)
+			b.WriteString(formatReply(v, indent+strings.Repeat(" ", len(prefix))))
+		}
+		return b.String()
+	}
+	return fmt.Sprintf("%v", reply)
+}
+
 // GracefulExit does some remaining work and will exit gracefully.
 // It will close the connection, ... , etc.
 func (cli *Client) GracefulExit() {
@@ -0,0 +1,738 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/clovers4/gres/engine"
+	"github.com/clovers4/gres/proto"
+	"github.com/clovers4/gres/util"
+)
+
+// DefaultUser is the user of the connections which are not authenticated by AUTH.
+const DefaultUser = "default"
+
+var (
+	ErrNoAuth          = errors.New("NOAUTH Authentication required.")
+	ErrNoPermKeys      = errors.New("NOPERM this user has no permissions to access one of the keys used as arguments")
+	ErrDelDefaultUser  = errors.New("ERR The 'default' user cannot be removed")
+	ErrNoACLFile       = errors.New("ERR This gres instance is not configured to use an ACL file. You may want to specify the aclfile in the config file")
+	ErrDefaultNoPass   = errors.New("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+	errACLSyntax       = errors.New("Syntax error")
+	errACLUnknownCmd   = errors.New("Unknown command or category name in ACL")
+	errACLNoSuchPass   = errors.New("The password you are trying to remove from the user does not exist")
+	errACLBadHash      = errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+	errACLKeysAfterAll = errors.New("Adding a pattern after the * pattern (or the 'allkeys' flag) is not valid and does not have any effect. Try 'resetkeys' to start with an empty list of patterns")
+)
+
+func errNoPermCmd(name string) error {
+	return fmt.Errorf("NOPERM this user has no permissions to run the '%s' command", name)
+}
+
+// ACL
+func init() {
+	registerCtxCmd("auth", -2, cmdNoAuth|catConnection, authCmd)
+	registerCtxCmd("acl", -2, catAdmin|catDangerous, aclCmd)
+}
+
+// aclCategories are in the order of ACL CAT.
+var aclCategories = []struct {
+	name string
+	flag int
+}{
+	{"keyspace", catKeyspace},
+	{"read", cmdReadOnly},
+	{"write", cmdWrite},
+	{"string", catString},
+	{"list", catList},
+	{"hash", catHash},
+	{"set", catSet},
+	{"sortedset", catSortedSet},
+	{"pubsub", catPubSub},
+	{"admin", catAdmin},
+	{"dangerous", catDangerous},
+	{"connection", catConnection},
+	{"transaction", catTransaction},
+	{"blocking", cmdBlocking},
+}
+
+func lookupCategory(name string) (int, bool) {
+	for _, c := range aclCategories {
+		if c.name == name {
+			return c.flag, true
+		}
+	}
+	return 0, false
+}
+
+// keySpec is the position of the keys in the args: args[first], args[first+step] ...
+// args[last]. last < 0 counts from the end.
+type keySpec struct {
+	first, last, step int
+}
+
+// keySpecs are the commands whose keys are not only args[1]. The commands of the
+// key space and the data types take args[1] as the key by default.
+var keySpecs = map[string]keySpec{
+	"mset":       {1, -1, 2},
+	"msetnx":     {1, -1, 2},
+	"mget":       {1, -1, 1},
+	"sinter":     {1, -1, 1},
+	"sunion":     {1, -1, 1},
+	"sdiff":      {1, -1, 1},
+	"rpoplpush":  {1, 2, 1},
+	"brpoplpush": {1, 2, 1},
+	"blpop":      {1, -2, 1},
+	"brpop":      {1, -2, 1},
+	"watch":      {1, -1, 1},
+	"keys":       {},
+	"dbsize":     {},
+	"swapdb":     {},
+	"flushdb":    {},
+	"flushall":   {},
+	"lock.fence": {},
+}
+
+// keys returns the keys in args.
+func (c *cmd) keys(args []string) []string {
+	spec, ok := keySpecs[c.name]
+	if !ok {
+		if c.flags&catData == 0 {
+			return nil
+		}
+		spec = keySpec{1, 1, 1}
+	}
+	if spec.first <= 0 || spec.first >= len(args) {
+		return nil
+	}
+
+	last := spec.last
+	if last < 0 {
+		last += len(args)
+	}
+	if last >= len(args) {
+		last = len(args) - 1
+	}
+	var keys []string
+	for i := spec.first; i <= last; i += spec.step {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+// User is an ACL user. It is changed in place by ACL SETUSER, so the connections
+// authenticated as the user are affected at once.
+type User struct {
+	name      string
+	enabled   bool
+	nopass    bool     // 任意密码都可以认证
+	passwords []string // sha256 的 hex
+	allKeys   bool
+	keys      []string        // 可以访问的 key 的 pattern
+	cmdRules  []string        // 按顺序生效的 +cmd, -cmd, +@category, -@category
+	allowed   map[string]bool // 由 cmdRules 得出的可执行的命令
+	deleted   bool            // 已被删除, 认证为该用户的连接不能再执行命令
+}
+
+// Name returns the name of the user.
+func (u *User) Name() string {
+	return u.name
+}
+
+func newUser(name string) *User {
+	return &User{name: name, allowed: make(map[string]bool)}
+}
+
+func (u *User) clone() *User {
+	c := *u
+	c.passwords = append([]string(nil), u.passwords...)
+	c.keys = append([]string(nil), u.keys...)
+	c.cmdRules = append([]string(nil), u.cmdRules...)
+	c.allowed = make(map[string]bool, len(u.allowed))
+	for name, ok := range u.allowed {
+		c.allowed[name] = ok
+	}
+	return &c
+}
+
+func hashPassword(pass string) string {
+	sum := sha256.Sum256([]byte(pass))
+	return hex.EncodeToString(sum[:])
+}
+
+func isPasswordHash(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func (u *User) addPassword(hash string) {
+	for _, p := range u.passwords {
+		if p == hash {
+			return
+		}
+	}
+	u.passwords = append(u.passwords, hash)
+	u.nopass = false
+}
+
+func (u *User) removePassword(hash string) error {
+	for i, p := range u.passwords {
+		if p == hash {
+			u.passwords = append(u.passwords[:i], u.passwords[i+1:]...)
+			return nil
+		}
+	}
+	return errACLNoSuchPass
+}
+
+func (u *User) checkPassword(pass string) bool {
+	if u.nopass {
+		return true
+	}
+	hash := hashPassword(pass)
+	for _, p := range u.passwords {
+		if subtle.ConstantTimeCompare([]byte(p), []byte(hash)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// applyRule applies a rule of ACL SETUSER, like redis.
+func (u *User) applyRule(rule string) error {
+	switch lower := strings.ToLower(rule); lower {
+	case "on":
+		u.enabled = true
+	case "off":
+		u.enabled = false
+	case "nopass":
+		u.nopass = true
+		u.passwords = nil
+	case "resetpass":
+		u.nopass = false
+		u.passwords = nil
+	case "allkeys":
+		u.allKeys = true
+		u.keys = nil
+	case "resetkeys":
+		u.allKeys = false
+		u.keys = nil
+	case "allcommands":
+		return u.applyCmdRule("+@all")
+	case "nocommands":
+		return u.applyCmdRule("-@all")
+	case "reset":
+		for _, r := range []string{"resetpass", "resetkeys", "off", "-@all"} {
+			u.applyRule(r)
+		}
+	default:
+		if rule == "" {
+			return errACLSyntax
+		}
+		switch rule[0] {
+		case '>':
+			u.addPassword(hashPassword(rule[1:]))
+		case '<':
+			return u.removePassword(hashPassword(rule[1:]))
+		case '#':
+			if !isPasswordHash(rule[1:]) {
+				return errACLBadHash
+			}
+			u.addPassword(rule[1:])
+		case '!':
+			if !isPasswordHash(rule[1:]) {
+				return errACLBadHash
+			}
+			return u.removePassword(rule[1:])
+		case '~':
+			if rule == "~*" {
+				return u.applyRule("allkeys")
+			}
+			if u.allKeys {
+				return errACLKeysAfterAll
+			}
+			for _, k := range u.keys {
+				if k == rule[1:] {
+					return nil
+				}
+			}
+			u.keys = append(u.keys, rule[1:])
+		case '+', '-':
+			return u.applyCmdRule(lower)
+		default:
+			return errACLSyntax
+		}
+	}
+	return nil
+}
+
+// applyCmdRule allows or disallows the command or the commands of the category.
+func (u *User) applyCmdRule(rule string) error {
+	allow := rule[0] == '+'
+	name := rule[1:]
+	switch {
+	case name == "@all":
+		// 之前的规则都被覆盖
+		u.cmdRules = nil
+		for n := range commands {
+			u.allowed[n] = allow
+		}
+	case strings.HasPrefix(name, "@"):
+		flag, ok := lookupCategory(name[1:])
+		if !ok {
+			return errACLUnknownCmd
+		}
+		for n, c := range commands {
+			if c.(*cmd).flags&flag != 0 {
+				u.allowed[n] = allow
+			}
+		}
+	default:
+		if commands[name] == nil {
+			return errACLUnknownCmd
+		}
+		u.allowed[name] = allow
+	}
+	u.cmdRules = append(u.cmdRules, rule)
+	return nil
+}
+
+// describe returns the rules which recreate the user, like ACL LIST.
+func (u *User) describe() string {
+	rules := []string{"user", u.name, "off"}
+	if u.enabled {
+		rules[2] = "on"
+	}
+	if u.nopass {
+		rules = append(rules, "nopass")
+	}
+	for _, p := range u.passwords {
+		rules = append(rules, "#"+p)
+	}
+	if u.allKeys {
+		rules = append(rules, "~*")
+	}
+	for _, k := range u.keys {
+		rules = append(rules, "~"+k)
+	}
+	return strings.Join(append(rules, u.commandsRule()), " ")
+}
+
+func (u *User) flags() []string {
+	flags := []string{"off"}
+	if u.enabled {
+		flags[0] = "on"
+	}
+	if u.allKeys {
+		flags = append(flags, "allkeys")
+	}
+	if u.nopass {
+		flags = append(flags, "nopass")
+	}
+	return flags
+}
+
+func (u *User) commandsRule() string {
+	if len(u.cmdRules) == 0 {
+		return "-@all"
+	}
+	return strings.Join(u.cmdRules, " ")
+}
+
+// canAccess reports whether the user can access all the keys.
+func (u *User) canAccess(keys []string) bool {
+	if u.allKeys {
+		return true
+	}
+	for _, key := range keys {
+		ok := false
+		for _, pattern := range u.keys {
+			if util.Match(pattern, key) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// ACL is the users of the server. A new connection is authenticated as the default
+// user if it requires no password, otherwise it must AUTH first.
+type ACL struct {
+	mu       sync.RWMutex
+	users    map[string]*User
+	filename string // ACL SAVE 写入的文件, 空表示不使用
+}
+
+// NewACL returns the users with only the default user, which can run all the
+// commands without password.
+func NewACL() *ACL {
+	acl := &ACL{users: make(map[string]*User)}
+	acl.users[DefaultUser] = newDefaultUser()
+	return acl
+}
+
+func newDefaultUser() *User {
+	u := newUser(DefaultUser)
+	for _, rule := range []string{"on", "nopass", "allkeys", "+@all"} {
+		u.applyRule(rule)
+	}
+	return u
+}
+
+// InitialUser returns the user of a new connection, nil if it must AUTH first.
+func (acl *ACL) InitialUser() *User {
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+
+	u := acl.users[DefaultUser]
+	if u.enabled && u.nopass {
+		return u
+	}
+	return nil
+}
+
+// SetUser creates the user if not exists, and applies the rules in order. Nothing
+// changes if any rule is invalid.
+func (acl *ACL) SetUser(name string, rules ...string) error {
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+
+	u, ok := acl.users[name]
+	if !ok {
+		u = newUser(name)
+	}
+	c := u.clone()
+	for _, rule := range rules {
+		if err := c.applyRule(rule); err != nil {
+			return fmt.Errorf("ERR Error in ACL SETUSER modifier '%s': %v", rule, err)
+		}
+	}
+
+	*u = *c
+	acl.users[name] = u
+	return nil
+}
+
+// DelUser deletes the users, and returns the number of users deleted.
+func (acl *ACL) DelUser(names ...string) (int, error) {
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+
+	for _, name := range names {
+		if name == DefaultUser {
+			return 0, ErrDelDefaultUser
+		}
+	}
+	n := 0
+	for _, name := range names {
+		if u, ok := acl.users[name]; ok {
+			u.deleted = true
+			delete(acl.users, name)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Authenticate returns the user if the password is right and the user is enabled.
+func (acl *ACL) Authenticate(name, pass string) (*User, error) {
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+
+	u, ok := acl.users[name]
+	if !ok || !u.enabled || !u.checkPassword(pass) {
+		return nil, ErrWrongPass
+	}
+	return u, nil
+}
+
+// List returns the descriptions of all users, sorted by name.
+func (acl *ACL) List() []string {
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+
+	var lines []string
+	for _, u := range acl.users {
+		lines = append(lines, u.describe())
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// CheckPerm checks whether the user can run the command with args. The arity must
+// be checked before.
+func (acl *ACL) CheckPerm(u *User, args []string) error {
+	c, ok := commands[args[0]].(*cmd)
+	if !ok || c.flags&cmdNoAuth != 0 {
+		return nil
+	}
+
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+	if u == nil || u.deleted {
+		return ErrNoAuth
+	}
+	if !u.allowed[c.name] {
+		return errNoPermCmd(c.name)
+	}
+	if !u.canAccess(c.keys(args)) {
+		return ErrNoPermKeys
+	}
+	return nil
+}
+
+// CheckPerm checks whether the user of the session can run the command. It is called
+// before the command is queued or executed.
+func CheckPerm(s Session, args []string) error {
+	c, ok := commands[args[0]].(*cmd)
+	if !ok || c.checkArity(args) != nil {
+		// 由命令自己回复错误
+		return nil
+	}
+	return s.ACL().CheckPerm(s.User(), args)
+}
+
+// SetFilename sets the file of ACL LOAD and ACL SAVE.
+func (acl *ACL) SetFilename(filename string) {
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+	acl.filename = filename
+}
+
+// Load replaces all users by the file, each line of which is like "user alice on
+// >pass ~* +@all". The default user is created if not in the file. The users already
+// authenticated are changed in place, or deleted if not in the file.
+func (acl *ACL) Load() error {
+	acl.mu.RLock()
+	filename := acl.filename
+	acl.mu.RUnlock()
+	if filename == "" {
+		return ErrNoACLFile
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	users, err := readUsers(file)
+	if err != nil {
+		return fmt.Errorf("ERR %s:%v", filename, err)
+	}
+
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+	for name, u := range acl.users {
+		if nu, ok := users[name]; ok {
+			*u = *nu
+			users[name] = u
+		} else {
+			u.deleted = true
+		}
+	}
+	acl.users = users
+	return nil
+}
+
+func readUsers(r io.Reader) (map[string]*User, error) {
+	users := make(map[string]*User)
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] != "user" || len(fields) < 2 {
+			return nil, fmt.Errorf("%d: should start with user <username>", lineno)
+		}
+		if _, ok := users[fields[1]]; ok {
+			return nil, fmt.Errorf("%d: duplicate user '%s'", lineno, fields[1])
+		}
+		u := newUser(fields[1])
+		for _, rule := range fields[2:] {
+			if err := u.applyRule(rule); err != nil {
+				return nil, fmt.Errorf("%d: error in user rule '%s': %v", lineno, rule, err)
+			}
+		}
+		users[u.name] = u
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if _, ok := users[DefaultUser]; !ok {
+		users[DefaultUser] = newDefaultUser()
+	}
+	return users, nil
+}
+
+// Save writes all users to the file, which is replaced atomically.
+func (acl *ACL) Save() error {
+	acl.mu.RLock()
+	filename := acl.filename
+	acl.mu.RUnlock()
+	if filename == "" {
+		return ErrNoACLFile
+	}
+
+	content := strings.Join(acl.List(), "\n") + "\n"
+	temp, err := ioutil.TempFile(filepath.Dir(filename), "temp-acl-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.WriteString(content); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), filename)
+}
+
+// AUTH [username] password
+func authCmd(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	session := CtxGetSession(ctx)
+	if session == nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrNoSession)
+	}
+	if len(args) > 3 {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrSyntax)
+	}
+
+	name, pass := DefaultUser, args[len(args)-1]
+	if len(args) == 3 {
+		name = args[1]
+	} else if u := session.ACL().InitialUser(); u != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrDefaultNoPass)
+	}
+
+	u, err := session.ACL().Authenticate(name, pass)
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, err)
+	}
+	session.SetUser(u)
+	return proto.NewReply(proto.ReplyKindStatus, "OK", nil)
+}
+
+// ACL SETUSER|GETUSER|DELUSER|LIST|USERS|WHOAMI|CAT|LOAD|SAVE
+func aclCmd(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	session := CtxGetSession(ctx)
+	if session == nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrNoSession)
+	}
+	acl := session.ACL()
+
+	switch sub := strings.ToLower(args[1]); {
+	case sub == "setuser" && len(args) >= 3:
+		err := acl.SetUser(args[2], args[3:]...)
+		return proto.NewReply(proto.ReplyKindStatus, "OK", err)
+	case sub == "getuser" && len(args) == 3:
+		return aclGetUser(acl, args[2])
+	case sub == "deluser" && len(args) >= 3:
+		n, err := acl.DelUser(args[2:]...)
+		return proto.NewReply(proto.ReplyKindInt, n, err)
+	case sub == "list" && len(args) == 2:
+		return proto.NewReply(proto.ReplyKindArrays, stringsToReplies(acl.List()), nil)
+	case sub == "users" && len(args) == 2:
+		var names []string
+		for _, line := range acl.List() {
+			names = append(names, strings.Fields(line)[1])
+		}
+		return proto.NewReply(proto.ReplyKindArrays, stringsToReplies(names), nil)
+	case sub == "whoami" && len(args) == 2:
+		u := session.User()
+		if u == nil {
+			return proto.NewReply(proto.ReplyKindErr, nil, ErrNoAuth)
+		}
+		return proto.NewReply(proto.ReplyKindBlukString, u.Name(), nil)
+	case sub == "cat" && len(args) <= 3:
+		return aclCat(args[2:])
+	case sub == "load" && len(args) == 2:
+		return proto.NewReply(proto.ReplyKindStatus, "OK", acl.Load())
+	case sub == "save" && len(args) == 2:
+		return proto.NewReply(proto.ReplyKindStatus, "OK", acl.Save())
+	case sub == "setuser" || sub == "getuser" || sub == "deluser" || sub == "list" || sub == "users" ||
+		sub == "whoami" || sub == "cat" || sub == "load" || sub == "save":
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrWrongNumArgs)
+	}
+	return proto.NewReply(proto.ReplyKindErr, nil, ErrUnknownSubCmd)
+}
+
+func aclGetUser(acl *ACL, name string) *proto.Reply {
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+
+	u, ok := acl.users[name]
+	if !ok {
+		return proto.NewReply(proto.ReplyKindNull, nil, nil)
+	}
+	keys := u.keys
+	if u.allKeys {
+		keys = []string{"*"}
+	}
+	return proto.NewReply(proto.ReplyKindMap, []interface{}{
+		"flags", proto.NewReply(proto.ReplyKindArrays, stringsToReplies(u.flags()), nil),
+		"passwords", proto.NewReply(proto.ReplyKindArrays, stringsToReplies(u.passwords), nil),
+		"commands", u.commandsRule(),
+		"keys", proto.NewReply(proto.ReplyKindArrays, stringsToReplies(keys), nil),
+	}, nil)
+}
+
+// aclCat replies the categories, or the commands of the category.
+func aclCat(args []string) *proto.Reply {
+	var names []string
+	if len(args) == 0 {
+		for _, c := range aclCategories {
+			names = append(names, c.name)
+		}
+		return proto.NewReply(proto.ReplyKindArrays, stringsToReplies(names), nil)
+	}
+
+	flag, ok := lookupCategory(strings.ToLower(args[0]))
+	if !ok {
+		return proto.NewReply(proto.ReplyKindErr, nil, fmt.Errorf("ERR Unknown category '%s'", args[0]))
+	}
+	for name, c := range commands {
+		if c.(*cmd).flags&flag != 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return proto.NewReply(proto.ReplyKindArrays, stringsToReplies(names), nil)
+}
+
+func stringsToReplies(ss []string) []interface{} {
+	replies := make([]interface{}, len(ss))
+	for i, s := range ss {
+		replies[i] = s
+	}
+	return replies
+}
@@ -0,0 +1,167 @@
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/clovers4/gres/engine"
+	"github.com/clovers4/gres/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestACL_SetUser(t *testing.T) {
+	acl := NewACL()
+	assert.Equal(t, []string{"user default on nopass ~* +@all"}, acl.List())
+
+	assert.Nil(t, acl.SetUser("alice", "on", ">p1", ">p2", "<p1", "~app:*", "~cache:*", "+@read", "-keys", "+set"))
+	assert.Equal(t, "user alice on #"+hashPassword("p2")+" ~app:* ~cache:* +@read -keys +set", acl.List()[0])
+
+	// 出错时不做任何修改
+	assert.EqualError(t, acl.SetUser("alice", "off", "+foo"),
+		"ERR Error in ACL SETUSER modifier '+foo': Unknown command or category name in ACL")
+	assert.EqualError(t, acl.SetUser("alice", "<nope"),
+		"ERR Error in ACL SETUSER modifier '<nope': The password you are trying to remove from the user does not exist")
+	assert.NotNil(t, acl.SetUser("alice", "#abc"))
+	assert.NotNil(t, acl.SetUser("alice", "allkeys", "~foo"))
+	assert.NotNil(t, acl.SetUser("alice", "bar"))
+	u, err := acl.Authenticate("alice", "p2")
+	assert.Nil(t, err)
+	assert.True(t, u.enabled)
+
+	_, err = acl.Authenticate("alice", "p1")
+	assert.Equal(t, ErrWrongPass, err)
+	assert.Nil(t, acl.SetUser("alice", "off"))
+	_, err = acl.Authenticate("alice", "p2")
+	assert.Equal(t, ErrWrongPass, err)
+
+	// +@all 覆盖之前的规则
+	assert.Nil(t, acl.SetUser("alice", "-@write", "+@all", "-flushall"))
+	assert.Equal(t, "+@all -flushall", u.commandsRule())
+	assert.Nil(t, acl.SetUser("alice", "reset"))
+	assert.Equal(t, "user alice off -@all", acl.List()[0])
+}
+
+func TestAuthCmd(t *testing.T) {
+	s := newTestSession(engine.NewDB())
+	assert.Equal(t, ErrDefaultNoPass, s.do("auth", "pass").Err)
+	assert.Equal(t, "default", s.do("acl", "whoami").Val)
+
+	assert.Nil(t, s.acl.SetUser(DefaultUser, "resetpass", ">secret"))
+	s = newTestSessionWithACL(s.db, s.acl)
+	assert.Nil(t, s.User())
+
+	assert.Equal(t, ErrNoAuth, s.do("get", "a").Err)
+	assert.Equal(t, ErrNoAuth, s.do("hello", "3").Err)
+	assert.Equal(t, ErrWrongPass, s.do("auth", "wrong").Err)
+	assert.Equal(t, ErrWrongPass, s.do("auth", "nobody", "secret").Err)
+	assert.Equal(t, "OK", s.do("auth", "secret").Val)
+	assert.Nil(t, s.do("get", "a").Err)
+
+	assert.Nil(t, s.acl.SetUser("bob", "on", ">pw", "allkeys", "+@all"))
+	s = newTestSessionWithACL(s.db, s.acl)
+	assert.Equal(t, proto.ReplyKindMap, int(s.do("hello", "2", "auth", "bob", "pw").Kind))
+	assert.Equal(t, "bob", s.do("acl", "whoami").Val)
+
+	// 删除用户后, 已认证的连接也不能再执行命令
+	assert.Equal(t, 1, s.do("acl", "deluser", "bob", "nobody").Val)
+	assert.Equal(t, ErrNoAuth, s.do("get", "a").Err)
+	assert.Equal(t, "OK", s.do("auth", "secret").Val)
+	assert.Equal(t, ErrDelDefaultUser, s.do("acl", "deluser", "default").Err)
+}
+
+func newTestSessionWithACL(db *engine.DB, acl *ACL) *testSession {
+	s := newTestSession(db)
+	s.acl = acl
+	s.user = acl.InitialUser()
+	return s
+}
+
+func TestACLPerm(t *testing.T) {
+	s := newTestSession(engine.NewDB())
+	assert.Equal(t, "OK", s.do("acl", "setuser", "alice", "on", ">p", "~app:*", "+@read", "+@string", "-getset", "+multi", "+exec").Val)
+	assert.Equal(t, "OK", s.do("auth", "alice", "p").Val)
+
+	assert.Equal(t, "OK", s.do("set", "app:1", "v").Val)
+	assert.Equal(t, []byte("v"), s.do("get", "app:1").Val)
+	assert.Equal(t, ErrNoPermKeys, s.do("get", "other").Err)
+	assert.Equal(t, ErrNoPermKeys, s.do("mset", "app:2", "v", "other", "v").Err)
+	assert.Equal(t, ErrNoPermKeys, s.do("mget", "app:1", "other").Err)
+	assert.EqualError(t, s.do("getset", "app:1", "v").Err, "NOPERM this user has no permissions to run the 'getset' command")
+	assert.EqualError(t, s.do("del", "app:1").Err, "NOPERM this user has no permissions to run the 'del' command")
+	assert.EqualError(t, s.do("acl", "list").Err, "NOPERM this user has no permissions to run the 'acl' command")
+	// 参数个数不对时, 由命令回复错误
+	assert.Equal(t, ErrWrongNumArgs, s.do("get").Err)
+
+	// 没有权限的命令使事务被放弃
+	assert.Equal(t, "OK", s.do("multi").Val)
+	assert.Equal(t, "QUEUED", s.do("get", "app:1").Val)
+	assert.Equal(t, ErrNoPermKeys, s.do("get", "other").Err)
+	assert.Equal(t, ErrExecAbort, s.do("exec").Err)
+
+	// 入队后权限被修改
+	assert.Equal(t, "OK", s.do("multi").Val)
+	assert.Equal(t, "QUEUED", s.do("get", "app:1").Val)
+	assert.Nil(t, s.acl.SetUser("alice", "resetkeys"))
+	reply := s.do("exec")
+	assert.Equal(t, ErrNoPermKeys, reply.Val.([]interface{})[0].(*proto.Reply).Err)
+}
+
+func TestACLCmd(t *testing.T) {
+	s := newTestSession(engine.NewDB())
+	assert.Equal(t, "OK", s.do("acl", "setuser", "alice", "on", "nopass", "~app:*", "+get").Val)
+
+	reply := s.do("acl", "getuser", "alice")
+	assert.Equal(t, proto.ReplyKindMap, int(reply.Kind))
+	vals := reply.Val.([]interface{})
+	assert.Equal(t, "flags", vals[0])
+	assert.Equal(t, []interface{}{"on", "nopass"}, vals[1].(*proto.Reply).Val)
+	assert.Equal(t, "+get", vals[5])
+	assert.Equal(t, []interface{}{"app:*"}, vals[7].(*proto.Reply).Val)
+	assert.Equal(t, proto.ReplyKindNull, int(s.do("acl", "getuser", "nobody").Kind))
+
+	assert.Equal(t, []interface{}{"alice", "default"}, s.do("acl", "users").Val)
+	assert.Equal(t, []interface{}{"user alice on nopass ~app:* +get", "user default on nopass ~* +@all"}, s.do("acl", "list").Val)
+	assert.Contains(t, s.do("acl", "cat").Val, "sortedset")
+	assert.Contains(t, s.do("acl", "cat", "hash").Val, "hget")
+	assert.NotContains(t, s.do("acl", "cat", "read").Val, "set")
+	assert.Equal(t, ErrUnknownSubCmd, s.do("acl", "foo").Err)
+	assert.Equal(t, ErrWrongNumArgs, s.do("acl", "setuser").Err)
+	assert.Equal(t, ErrNoACLFile, s.do("acl", "save").Err)
+}
+
+func TestACL_LoadSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gres-acl")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "users.acl")
+
+	acl := NewACL()
+	acl.SetFilename(filename)
+	assert.Nil(t, acl.SetUser("alice", "on", ">p", "~*", "+@all", "-flushall"))
+	assert.Nil(t, acl.SetUser("bob", "on", ">p"))
+	assert.Nil(t, acl.SetUser(DefaultUser, "resetpass", ">secret"))
+	assert.Nil(t, acl.Save())
+
+	loaded := NewACL()
+	loaded.SetFilename(filename)
+	assert.Nil(t, loaded.Load())
+	assert.Equal(t, acl.List(), loaded.List())
+	assert.Nil(t, loaded.InitialUser())
+
+	// 已认证的用户被原地修改, 不在文件中的用户被删除
+	alice, _ := acl.Authenticate("alice", "p")
+	bob, _ := acl.Authenticate("bob", "p")
+	assert.Nil(t, ioutil.WriteFile(filename, []byte("# users\nuser alice on >p ~* -@all +get\n"), 0600))
+	assert.Nil(t, acl.Load())
+	assert.Nil(t, acl.CheckPerm(alice, []string{"get", "a"}))
+	assert.NotNil(t, acl.CheckPerm(alice, []string{"set", "a", "b"}))
+	assert.Equal(t, ErrNoAuth, acl.CheckPerm(bob, []string{"get", "a"}))
+	// 文件中没有 default 用户时, 使用默认的 default 用户
+	assert.NotNil(t, acl.InitialUser())
+
+	assert.Nil(t, ioutil.WriteFile(filename, []byte("user alice on +foo\n"), 0600))
+	assert.NotNil(t, acl.Load())
+	assert.Nil(t, acl.CheckPerm(alice, []string{"get", "a"}))
+}
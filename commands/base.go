@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
+
 	"github.com/clovers4/gres/engine"
 	"github.com/clovers4/gres/proto"
 )
@@ -13,12 +15,50 @@ var (
 	ErrWrongNumArgs   = errors.New("ERR wrong number of arguments for the command")
 	ErrWrongTypeInt   = errors.New("ERR value is not an integer")
 	ErrInvalidDbIndex = errors.New("ERR invalid DB index")
+	ErrNoSession      = errors.New("ERR the command is only allowed in a connection")
+	ErrNotAllowedInTx = errors.New("ERR Command not allowed inside a transaction")
+	ErrSyntax         = errors.New("ERR syntax error")
+)
+
+// command flags
+const (
+	cmdWrite         = 1 << iota // may modify the dataset, so it is fed to the append-only file
+	cmdReadOnly                  // only reads the dataset
+	cmdDenyOOM                   // may use more memory, so it is rejected when maxmemory is reached
+	cmdTx                        // controls the transaction, so it is never queued and runs without the shared lock
+	cmdNoTx                      // is not allowed inside a transaction
+	cmdPubSub                    // is allowed in the subscribed mode
+	cmdBlocking                  // may block the connection, so it runs without the shared lock and propagates by itself
+	cmdNoAuth                    // is allowed before the connection is authenticated
+	cmdSelfPropagate             // is a write command which propagates its result by itself, such as ID.NEXT
+)
+
+// command categories of ACL, a command may be in several categories. @read, @write
+// and @blocking are the flags above.
+const (
+	catKeyspace = 1 << (iota + 16)
+	catString
+	catList
+	catHash
+	catSet
+	catSortedSet
+	catPubSub
+	catConnection
+	catTransaction
+	catAdmin
+	catDangerous
+
+	catData = catKeyspace | catString | catList | catHash | catSet | catSortedSet // 访问 key 的命令
 )
 
 // commands should be read-only
 var commands map[string]Command
 
-func registerCmd(name string, arity int, do doFunc) {
+func init() {
+	engine.RegisterReplayFunc(replay)
+}
+
+func registerCmd(name string, arity int, flags int, do doFunc) {
 	if commands == nil {
 		commands = make(map[string]Command)
 	}
@@ -26,12 +66,19 @@ func registerCmd(name string, arity int, do doFunc) {
 		panic(fmt.Errorf("cmd %s is already registerd", name))
 	}
 	commands[name] = &cmd{
-		name,
-		arity,
-		do,
+		name:  name,
+		arity: arity,
+		flags: flags,
+		do:    do,
 	}
 }
 
+// registerCtxCmd registers a command which needs the context, such as the session.
+func registerCtxCmd(name string, arity int, flags int, do ctxDoFunc) {
+	registerCmd(name, arity, flags, nil)
+	commands[name].(*cmd).ctxDo = do
+}
+
 func GetCmd(name string) Command {
 	return commands[name]
 }
@@ -42,17 +89,97 @@ type Command interface {
 
 type doFunc func(db *engine.DB, args []string) *proto.Reply
 
+type ctxDoFunc func(ctx context.Context, db *engine.DB, args []string) *proto.Reply
+
 type cmd struct {
 	name  string
 	arity int // Number of arguments, it is possible to use -N to say >= N
+	flags int
 	do    doFunc
+	ctxDo ctxDoFunc
 }
 
 func (c *cmd) Do(ctx context.Context, args []string) *proto.Reply {
+	if err := c.checkArity(args); err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, err)
+	}
+	db := engine.CtxGetDB(ctx)
+	if c.flags&(cmdTx|cmdBlocking) != 0 {
+		return c.exec(ctx, db, args)
+	}
+
+	var reply *proto.Reply
+	db.Shared(func() {
+		if err := c.freeMemoryIfNeeded(db); err != nil {
+			reply = proto.NewReply(proto.ReplyKindErr, nil, err)
+			return
+		}
+		reply = c.call(ctx, db, args)
+		// 命令 push 的元素优先交给阻塞的连接
+		db.ServeBlocked()
+	})
+	return reply
+}
+
+// MayBlock reports whether the command may block the connection, such as BLPOP.
+func MayBlock(name string) bool {
+	c, ok := commands[name].(*cmd)
+	return ok && c.flags&cmdBlocking != 0
+}
+
+func (c *cmd) checkArity(args []string) error {
 	if c.arity > 0 && len(args) != c.arity ||
 		len(args) < -c.arity {
-		return proto.NewReply(proto.ReplyKindErr, nil, ErrWrongNumArgs)
+		return ErrWrongNumArgs
+	}
+	return nil
+}
+
+// freeMemoryIfNeeded evicts keys before a write command. If nothing can be
+// evicted, only the commands which may use more memory are rejected.
+func (c *cmd) freeMemoryIfNeeded(db *engine.DB) error {
+	if c.flags&cmdWrite == 0 {
+		return nil
+	}
+	if err := db.FreeMemoryIfNeeded(); err != nil && c.flags&cmdDenyOOM != 0 {
+		return err
+	}
+	return nil
+}
+
+// call executes the command, and feeds the write command to the append-only file.
+func (c *cmd) call(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	if c.flags&cmdWrite == 0 || c.flags&cmdSelfPropagate != 0 {
+		return c.exec(ctx, db, args)
+	}
+
+	var reply *proto.Reply
+	db.Propagate(args, func() bool {
+		reply = c.exec(ctx, db, args)
+		return reply.Kind != proto.ReplyKindErr
+	})
+	return reply
+}
+
+func (c *cmd) exec(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	if c.ctxDo != nil {
+		return c.ctxDo(ctx, db, args)
 	}
-	db := engine.CtxGetDB(ctx)
 	return c.do(db, args)
 }
+
+// replay executes a command loaded from the append-only file. maxmemory is ignored
+// while loading, like redis does.
+func replay(db *engine.DB, args []string) error {
+	args[0] = strings.ToLower(args[0])
+	c, ok := commands[args[0]].(*cmd)
+	if !ok {
+		return fmt.Errorf("%v: %v", ErrUnknownCmd, args[0])
+	}
+
+	reply := c.call(engine.CtxWithDB(context.Background(), db), db, args)
+	if reply.Kind == proto.ReplyKindErr {
+		return reply.Err
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package commands
+
+import (
+	"context"
+	"strings"
+
+	"github.com/clovers4/gres/engine"
+	"github.com/clovers4/gres/proto"
+)
+
+// CONFIG
+func init() {
+	registerCtxCmd("config", -2, catAdmin|catDangerous, configCmd)
+}
+
+func configCmd(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	session := CtxGetSession(ctx)
+	if session == nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrNoSession)
+	}
+	conf := session.Config()
+
+	switch strings.ToLower(args[1]) {
+	case "get":
+		if len(args) < 3 {
+			return proto.NewReply(proto.ReplyKindErr, nil, ErrWrongNumArgs)
+		}
+		// 多个 pattern 匹配到同一参数时只返回一次
+		seen := make(map[string]bool)
+		var replies []interface{}
+		for _, pattern := range args[2:] {
+			pairs := conf.ConfigGet(strings.ToLower(pattern))
+			for i := 0; i+1 < len(pairs); i += 2 {
+				if !seen[pairs[i]] {
+					seen[pairs[i]] = true
+					replies = append(replies, pairs[i], pairs[i+1])
+				}
+			}
+		}
+		if replies == nil {
+			replies = []interface{}{}
+		}
+		return proto.NewReply(proto.ReplyKindMap, replies, nil)
+	case "set":
+		if len(args) != 4 {
+			return proto.NewReply(proto.ReplyKindErr, nil, ErrWrongNumArgs)
+		}
+		err := conf.ConfigSet(strings.ToLower(args[2]), args[3])
+		return proto.NewReply(proto.ReplyKindStatus, "OK", err)
+	case "resetstat":
+		if len(args) != 2 {
+			return proto.NewReply(proto.ReplyKindErr, nil, ErrWrongNumArgs)
+		}
+		conf.ConfigResetStat()
+		return proto.NewReply(proto.ReplyKindStatus, "OK", nil)
+	case "rewrite":
+		if len(args) != 2 {
+			return proto.NewReply(proto.ReplyKindErr, nil, ErrWrongNumArgs)
+		}
+		err := conf.ConfigRewrite()
+		return proto.NewReply(proto.ReplyKindStatus, "OK", err)
+	}
+	return proto.NewReply(proto.ReplyKindErr, nil, ErrUnknownSubCmd)
+}
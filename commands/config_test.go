@@ -0,0 +1,87 @@
+package commands
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/clovers4/gres/engine"
+	"github.com/clovers4/gres/proto"
+	"github.com/clovers4/gres/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// testConfig has the parameters a and b.
+type testConfig struct {
+	a, b      string
+	resets    int
+	rewritten bool
+}
+
+func (c *testConfig) ConfigGet(pattern string) []string {
+	var pairs []string
+	if util.Match(pattern, "a") {
+		pairs = append(pairs, "a", c.a)
+	}
+	if util.Match(pattern, "b") {
+		pairs = append(pairs, "b", c.b)
+	}
+	return pairs
+}
+
+func (c *testConfig) ConfigSet(name, value string) error {
+	switch name {
+	case "a":
+		c.a = value
+	case "b":
+		c.b = value
+	default:
+		return errors.New("ERR Unknown option")
+	}
+	return nil
+}
+
+func (c *testConfig) ConfigResetStat() { c.resets++ }
+
+func (c *testConfig) ConfigRewrite() error {
+	c.rewritten = true
+	return nil
+}
+
+func TestConfigCmd(t *testing.T) {
+	s := newTestSession(engine.NewDB())
+
+	assert.Equal(t, "OK", s.do("config", "set", "A", "1").Val)
+	assert.Equal(t, "OK", s.do("config", "set", "b", "2").Val)
+	assert.Equal(t, "ERR Unknown option", s.do("config", "set", "c", "3").Err.Error())
+	assert.Equal(t, []interface{}{"a", "1"}, s.do("config", "get", "a").Val)
+	assert.Equal(t, []interface{}{"a", "1", "b", "2"}, s.do("config", "get", "A", "*").Val)
+	assert.Equal(t, []interface{}{}, s.do("config", "get", "c").Val)
+
+	assert.Equal(t, "OK", s.do("config", "resetstat").Val)
+	assert.Equal(t, 1, s.config.resets)
+	assert.Equal(t, "OK", s.do("config", "rewrite").Val)
+	assert.True(t, s.config.rewritten)
+
+	assert.Equal(t, ErrWrongNumArgs, s.do("config", "set", "a").Err)
+	assert.Equal(t, ErrUnknownSubCmd, s.do("config", "foo").Err)
+}
+
+func TestInfoCmd(t *testing.T) {
+	s := newTestSession(engine.NewDB())
+	s.do("set", "a", "1")
+	s.do("expire", "a", "100")
+	s.do("set", "b", "1")
+
+	reply := s.do("info")
+	assert.Equal(t, proto.ReplyKindVerbatim, int(reply.Kind))
+	info := reply.Val.(string)
+	for _, section := range []string{"# Server\r\n", "# Memory\r\n", "# Stats\r\n", "# Keyspace\r\n"} {
+		assert.Contains(t, info, section)
+	}
+
+	info = s.do("info", "STATS", "keyspace").Val.(string)
+	assert.False(t, strings.Contains(info, "# Server"))
+	assert.Contains(t, info, "expired_keys:0\r\n")
+	assert.Contains(t, info, "db0:keys=2,expires=1\r\n")
+}
@@ -0,0 +1,119 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/clovers4/gres/engine"
+	"github.com/clovers4/gres/proto"
+)
+
+// Version is the version of gres, which HELLO replies.
+const Version = "0.1.0"
+
+var (
+	ErrProtoVersion = errors.New("ERR Protocol version is not an integer or out of range")
+	ErrNoProto      = errors.New("NOPROTO unsupported protocol version")
+	ErrWrongPass    = errors.New("WRONGPASS invalid username-password pair or user is disabled")
+)
+
+// CONNECTION
+func init() {
+	registerCtxCmd("select", 2, catConnection, selectCmd)
+	registerCmd("ping", -1, cmdPubSub|catConnection, pingCmd)
+	registerCtxCmd("hello", -1, cmdNoTx|cmdNoAuth|catConnection, helloCmd)
+}
+
+func pingCmd(db *engine.DB, args []string) *proto.Reply {
+	if len(args) > 2 {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrWrongNumArgs)
+	}
+	if len(args) == 2 {
+		return proto.NewReply(proto.ReplyKindBlukString, args[1], nil)
+	}
+	return proto.NewReply(proto.ReplyKindStatus, "PONG", nil)
+}
+
+func selectCmd(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	index, err := strconv.Atoi(args[1])
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrInvalidDbIndex)
+	}
+
+	target, err := db.Select(index)
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, err)
+	}
+
+	session := CtxGetSession(ctx)
+	if session == nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrNoSession)
+	}
+	session.SelectDB(target)
+	return proto.NewReply(proto.ReplyKindStatus, "OK", nil)
+}
+
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+func helloCmd(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	session := CtxGetSession(ctx)
+	if session == nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrNoSession)
+	}
+
+	protover := session.Proto()
+	if len(args) > 1 {
+		v, err := strconv.Atoi(args[1])
+		if err != nil {
+			return proto.NewReply(proto.ReplyKindErr, nil, ErrProtoVersion)
+		}
+		if v != proto.RESP2 && v != proto.RESP3 {
+			return proto.NewReply(proto.ReplyKindErr, nil, ErrNoProto)
+		}
+		protover = v
+	}
+
+	var name *string
+	var user *User
+	for i := 2; i < len(args); i++ {
+		more := len(args) - i - 1
+		switch opt := strings.ToLower(args[i]); {
+		case opt == "auth" && more >= 2:
+			u, err := session.ACL().Authenticate(args[i+1], args[i+2])
+			if err != nil {
+				return proto.NewReply(proto.ReplyKindErr, nil, err)
+			}
+			user = u
+			i += 2
+		case opt == "setname" && more >= 1:
+			name = &args[i+1]
+			i++
+		default:
+			return proto.NewReply(proto.ReplyKindErr, nil, ErrSyntax)
+		}
+	}
+
+	// 未认证时只能通过 AUTH 选项认证
+	if user == nil && session.User() == nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrNoAuth)
+	}
+
+	// 参数都合法之后才修改连接的状态
+	if user != nil {
+		session.SetUser(user)
+	}
+	if name != nil {
+		session.SetName(*name)
+	}
+	session.SetProto(protover)
+	return proto.NewReply(proto.ReplyKindMap, []interface{}{
+		"server", "gres",
+		"version", Version,
+		"proto", proto.NewReply(proto.ReplyKindInt, protover, nil),
+		"id", proto.NewReply(proto.ReplyKindInt, int(session.ID()), nil),
+		"mode", "standalone",
+		"role", "master",
+		"modules", proto.NewReply(proto.ReplyKindArrays, []interface{}{}, nil),
+	}, nil)
+}
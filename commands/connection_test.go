@@ -0,0 +1,33 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/clovers4/gres/engine"
+	"github.com/clovers4/gres/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHello(t *testing.T) {
+	s := newTestSession(engine.NewDB())
+
+	reply := s.do("hello")
+	assert.Equal(t, proto.ReplyKindMap, int(reply.Kind))
+	vals := reply.Val.([]interface{})
+	assert.Equal(t, "proto", vals[4])
+	assert.Equal(t, proto.RESP2, vals[5].(*proto.Reply).Val)
+	assert.Equal(t, proto.RESP2, s.Proto())
+
+	reply = s.do("hello", "3", "auth", "default", "pass", "setname", "foo")
+	vals = reply.Val.([]interface{})
+	assert.Equal(t, proto.RESP3, vals[5].(*proto.Reply).Val)
+	assert.Equal(t, proto.RESP3, s.Proto())
+	assert.Equal(t, "foo", s.name)
+
+	assert.Equal(t, ErrProtoVersion, s.do("hello", "x").Err)
+	assert.Equal(t, ErrNoProto, s.do("hello", "4").Err)
+	assert.Equal(t, ErrWrongPass, s.do("hello", "2", "auth", "foo", "pass").Err)
+	assert.Equal(t, ErrSyntax, s.do("hello", "2", "setname").Err)
+	// 出错时不修改连接的协议
+	assert.Equal(t, proto.RESP3, s.Proto())
+}
@@ -9,29 +9,42 @@ import (
 
 // ZSET
 func init() {
-	registerCmd("hset", 4, hsetCmd) //todo
-	registerCmd("hget", 3, hgetCmd)
-	registerCmd("hdel", -3, hdelCmd)
-	registerCmd("hlen", 2, hlenCmd)
-	registerCmd("hexists", 3, hexistsCmd)
-	registerCmd("hkeys", 2, hkeysCmd)
-	registerCmd("hvals", 2, hvalsCmd)
-	registerCmd("hgetall", 2, hgetallCmd)
-	registerCmd("hincrby", 4, hincrbyCmd)
+	registerCmd("hset", -4, cmdWrite|cmdDenyOOM|catHash, hsetCmd)
+	registerCmd("hget", 3, cmdReadOnly|catHash, hgetCmd)
+	registerCmd("hdel", -3, cmdWrite|catHash, hdelCmd)
+	registerCmd("hlen", 2, cmdReadOnly|catHash, hlenCmd)
+	registerCmd("hexists", 3, cmdReadOnly|catHash, hexistsCmd)
+	registerCmd("hkeys", 2, cmdReadOnly|catHash, hkeysCmd)
+	registerCmd("hvals", 2, cmdReadOnly|catHash, hvalsCmd)
+	registerCmd("hgetall", 2, cmdReadOnly|catHash, hgetallCmd)
+	registerCmd("hincrby", 4, cmdWrite|cmdDenyOOM|catHash, hincrbyCmd)
 }
 
+// HSET key field value [field value ...]
 func hsetCmd(db *engine.DB, args []string) *proto.Reply {
 	key := args[1]
-	field := args[2]
-	valS := args[3]
-
-	if val, ok := util.String2Num(valS); ok {
-		count, err := db.HSet(key, field, val)
-		return proto.NewReply(proto.ReplyKindInt, count, err)
+	if len(args)%2 != 0 {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrWrongNumArgs)
 	}
 
-	count, err := db.HSet(key, field, valS)
-	return proto.NewReply(proto.ReplyKindInt, count, err)
+	total := 0
+	for i := 2; i < len(args); i += 2 {
+		field := args[i]
+		valS := args[i+1]
+
+		var count int
+		var err error
+		if val, ok := util.String2Num(valS); ok {
+			count, err = db.HSet(key, field, val)
+		} else {
+			count, err = db.HSet(key, field, valS)
+		}
+		if err != nil {
+			return proto.NewReply(proto.ReplyKindErr, nil, err)
+		}
+		total += count
+	}
+	return proto.NewReply(proto.ReplyKindInt, total, nil)
 }
 
 func hgetCmd(db *engine.DB, args []string) *proto.Reply {
@@ -86,7 +99,7 @@ func hvalsCmd(db *engine.DB, args []string) *proto.Reply {
 func hgetallCmd(db *engine.DB, args []string) *proto.Reply {
 	key := args[1]
 	vals, err := db.HGetAll(key)
-	return proto.NewReply(proto.ReplyKindArrays, vals, err)
+	return proto.NewReply(proto.ReplyKindMap, vals, err)
 }
 
 func hincrbyCmd(db *engine.DB, args []string) *proto.Reply {
@@ -0,0 +1,174 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/clovers4/gres/engine"
+	"github.com/clovers4/gres/engine/object/idgen"
+	"github.com/clovers4/gres/errs"
+	"github.com/clovers4/gres/proto"
+	"github.com/clovers4/gres/util"
+)
+
+// 一次 ID.BATCH 最多发放的 id 个数
+const maxIDBatch = 10000
+
+var (
+	ErrInvalidEpoch = errors.New("ERR the epoch must be between 0 and the current time")
+	ErrInvalidBatch = fmt.Errorf("ERR the count must be between 1 and %d", maxIDBatch)
+)
+
+// IDGEN
+func init() {
+	registerCmd("id.create", -3, cmdWrite|cmdDenyOOM|catKeyspace, idCreateCmd)
+	registerCtxCmd("id.next", 2, cmdWrite|cmdSelfPropagate|catKeyspace, idNextCmd)
+	registerCtxCmd("id.batch", 3, cmdWrite|cmdSelfPropagate|catKeyspace, idBatchCmd)
+	registerCmd("id.setlast", 3, cmdWrite|catKeyspace, idSetLastCmd)
+}
+
+// ID.CREATE key SNOWFLAKE node [EPOCH unix-time-milliseconds] [LAST id]
+// ID.CREATE key SEGMENT [START id] [STEP step] [LAST id]
+func idCreateCmd(db *engine.DB, args []string) *proto.Reply {
+	g, err := parseIDGen(args)
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, err)
+	}
+	return proto.NewReply(proto.ReplyKindInt, boolToInt(db.IDCreate(args[1], g)), nil)
+}
+
+// parseIDGen parses the id generator of ID.CREATE. LAST continues after the id,
+// such as the one written by the aof rewrite.
+func parseIDGen(args []string) (*idgen.IDGen, error) {
+	kind := strings.ToLower(args[2])
+	i := 3
+	var node int64
+	if kind == "snowflake" {
+		if i == len(args) {
+			return nil, ErrSyntax
+		}
+		n, err := strconv.ParseInt(args[i], 10, 64)
+		if err != nil {
+			return nil, errs.ErrIsNotInt
+		}
+		node = n
+		i++
+	} else if kind != "segment" {
+		return nil, ErrSyntax
+	}
+
+	opts := map[string]int64{}
+	for ; i < len(args); i += 2 {
+		opt := strings.ToLower(args[i])
+		switch {
+		case kind == "snowflake" && (opt == "epoch" || opt == "last"):
+		case kind == "segment" && (opt == "start" || opt == "step" || opt == "last"):
+		default:
+			return nil, ErrSyntax
+		}
+		if _, ok := opts[opt]; ok || i+1 == len(args) {
+			return nil, ErrSyntax
+		}
+		n, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil {
+			return nil, errs.ErrIsNotInt
+		}
+		opts[opt] = n
+	}
+
+	var g *idgen.IDGen
+	var err error
+	if kind == "snowflake" {
+		epoch, ok := opts["epoch"]
+		if !ok {
+			epoch = idgen.DefaultEpoch
+		}
+		if epoch < 0 || epoch > util.NowMs() {
+			return nil, ErrInvalidEpoch
+		}
+		g, err = idgen.NewSnowflake(node, epoch)
+	} else {
+		start, hasStart := opts["start"]
+		if !hasStart {
+			start = 1
+		}
+		step, ok := opts["step"]
+		if !ok {
+			step = 1
+		}
+		// START 与 LAST 都指定了从哪里开始, 只能有一个
+		if _, ok := opts["last"]; ok && hasStart {
+			return nil, ErrSyntax
+		}
+		g, err = idgen.NewSegment(start, step)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if last, ok := opts["last"]; ok {
+		g.SetLast(last)
+	}
+	return g, nil
+}
+
+// ID.NEXT key
+func idNextCmd(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	ids, err := idNext(ctx, db, args[1], 1)
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, err)
+	}
+	return proto.NewReply(proto.ReplyKindInt, int(ids[0]), nil)
+}
+
+// ID.BATCH key count
+func idBatchCmd(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	count, err := strconv.Atoi(args[2])
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, errs.ErrIsNotInt)
+	}
+	if count <= 0 || count > maxIDBatch {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrInvalidBatch)
+	}
+
+	ids, err := idNext(ctx, db, args[1], count)
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, err)
+	}
+	vals := make([]interface{}, len(ids))
+	for i, id := range ids {
+		vals[i] = proto.NewReply(proto.ReplyKindInt, int(id), nil)
+	}
+	return proto.NewReply(proto.ReplyKindArrays, vals, nil)
+}
+
+// idNext generates n ids of key, and propagates the last one as ID.SETLAST. The
+// snowflake ids depend on the clock, so the command is not replayed as is.
+func idNext(ctx context.Context, db *engine.DB, key string, n int) ([]int64, error) {
+	var ids []int64
+	var err error
+	// 生成之后才知道 last, Propagate 在 fn 返回后才写入 args
+	args := []string{"id.setlast", key, ""}
+	db.Propagate(args, func() bool {
+		ids, err = db.IDNext(key, n, engine.CtxGetNow(ctx))
+		if err != nil {
+			return false
+		}
+		args[2] = strconv.FormatInt(ids[len(ids)-1], 10)
+		return true
+	})
+	return ids, err
+}
+
+// ID.SETLAST key id
+// 将最近发放的 id 提高到 id, 只增不减; 用于传播 ID.NEXT 与 ID.BATCH 的结果
+func idSetLastCmd(db *engine.DB, args []string) *proto.Reply {
+	last, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, errs.ErrIsNotInt)
+	}
+	ok, err := db.IDSetLast(args[1], last)
+	return proto.NewReply(proto.ReplyKindInt, boolToInt(ok), err)
+}
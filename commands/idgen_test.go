@@ -0,0 +1,109 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/clovers4/gres/engine"
+	"github.com/clovers4/gres/engine/object/idgen"
+	"github.com/clovers4/gres/errs"
+	"github.com/clovers4/gres/proto"
+	"github.com/clovers4/gres/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIDCmds(t *testing.T) {
+	s := newTestSession(engine.NewDB())
+
+	assert.Equal(t, 1, s.do("id.create", "seq", "segment", "start", "100", "step", "10").Val)
+	assert.Equal(t, 0, s.do("id.create", "seq", "segment").Val)
+	assert.Equal(t, 100, s.do("id.next", "seq").Val)
+	reply := s.do("id.batch", "seq", "3")
+	assert.Equal(t, proto.ReplyKindArrays, int(reply.Kind))
+	var ids []int
+	for _, v := range reply.Val.([]interface{}) {
+		ids = append(ids, v.(*proto.Reply).Val.(int))
+	}
+	assert.Equal(t, []int{110, 120, 130}, ids)
+	assert.Equal(t, "idgen", s.do("type", "seq").Val)
+
+	// LAST 从指定的 id 之后继续
+	assert.Equal(t, 1, s.do("id.create", "last", "SEGMENT", "LAST", "7").Val)
+	assert.Equal(t, 8, s.do("id.next", "last").Val)
+
+	assert.Equal(t, 1, s.do("id.create", "sf", "snowflake", "5").Val)
+	first := s.do("id.next", "sf").Val.(int)
+	assert.Equal(t, 5, first>>12&idgen.MaxNode)
+	assert.True(t, s.do("id.next", "sf").Val.(int) > first)
+	assert.Equal(t, 1, s.do("id.create", "sf2", "snowflake", "0", "epoch", "0", "last", "1").Val)
+
+	assert.Equal(t, ErrSyntax, s.do("id.create", "x", "uuid").Err)
+	assert.Equal(t, ErrSyntax, s.do("id.create", "x", "snowflake").Err)
+	assert.Equal(t, ErrSyntax, s.do("id.create", "x", "snowflake", "1", "step", "1").Err)
+	assert.Equal(t, ErrSyntax, s.do("id.create", "x", "segment", "start", "1", "last", "1").Err)
+	assert.Equal(t, ErrSyntax, s.do("id.create", "x", "segment", "step").Err)
+	assert.Equal(t, errs.ErrIsNotInt, s.do("id.create", "x", "segment", "step", "x").Err)
+	assert.Equal(t, idgen.ErrNode, s.do("id.create", "x", "snowflake", "1024").Err)
+	assert.Equal(t, idgen.ErrStep, s.do("id.create", "x", "segment", "step", "0").Err)
+	assert.Equal(t, ErrInvalidEpoch, s.do("id.create", "x", "snowflake", "1", "epoch", "99999999999999").Err)
+	assert.Equal(t, engine.ErrNoIDGen, s.do("id.next", "x").Err)
+	assert.Equal(t, ErrInvalidBatch, s.do("id.batch", "seq", "0").Err)
+	assert.Equal(t, errs.ErrIsNotInt, s.do("id.batch", "seq", "x").Err)
+
+	// 用完后不再发放
+	assert.Equal(t, 1, s.do("id.create", "max", "segment", "last", "9223372036854775806").Val)
+	assert.Equal(t, 9223372036854775807, s.do("id.next", "max").Val)
+	assert.Equal(t, idgen.ErrOverflow, s.do("id.next", "max").Err)
+
+	// ID.SETLAST 只增不减
+	assert.Equal(t, 1, s.do("id.setlast", "seq", "200").Val)
+	assert.Equal(t, 0, s.do("id.setlast", "seq", "100").Val)
+	assert.Equal(t, 210, s.do("id.next", "seq").Val)
+	assert.Equal(t, errs.ErrIsNotInt, s.do("id.setlast", "seq", "x").Err)
+	assert.Equal(t, engine.ErrNoIDGen, s.do("id.setlast", "x", "1").Err)
+}
+
+func TestIDCmds_Propagate(t *testing.T) {
+	primary := engine.NewDB()
+	s := newTestSession(primary)
+	assert.Equal(t, 1, s.do("id.create", "sf", "snowflake", "1").Val)
+
+	var snapshot bytes.Buffer
+	stream, err := primary.SyncReplica(&snapshot, engine.DefaultReplicaBufferLimit)
+	assert.Nil(t, err)
+	defer stream.Close()
+	reply := s.do("id.batch", "sf", "100")
+	vals := reply.Val.([]interface{})
+	last := vals[len(vals)-1].(*proto.Reply).Val.(int)
+
+	// 传播的是最后一个 id, 而不是依赖时钟的命令
+	data, err := stream.Read()
+	assert.Nil(t, err)
+	replica := engine.NewDB()
+	assert.Nil(t, replica.Load(&snapshot))
+	applier := replica.NewReplicaApplier()
+	rd := proto.NewReader(bytes.NewReader(data))
+	var cmds [][]string
+	for {
+		args, err := rd.ReadCommand()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		cmds = append(cmds, args)
+		assert.Nil(t, applier.Apply(args))
+	}
+	assert.Equal(t, [][]string{{"select", "0"}, {"id.setlast", "sf", strconv.Itoa(last)}}, cmds)
+
+	// 相同的时间下, replica 与 primary 发放相同的 id
+	now := util.NowMs()
+	ctx := engine.CtxWithNow(engine.CtxWithDB(context.Background(), primary), now)
+	want := GetCmd("id.next").Do(ctx, []string{"id.next", "sf"}).Val.(int)
+	ctx = engine.CtxWithNow(engine.CtxWithDB(context.Background(), replica), now)
+	got := GetCmd("id.next").Do(ctx, []string{"id.next", "sf"}).Val.(int)
+	assert.Equal(t, want, got)
+	assert.True(t, got > last)
+}
@@ -0,0 +1,128 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/clovers4/gres/engine"
+	"github.com/clovers4/gres/proto"
+)
+
+// INFO
+func init() {
+	registerCtxCmd("info", -1, catDangerous, infoCmd)
+}
+
+// infoSection writes the fields of a section of INFO.
+type infoSection struct {
+	name  string
+	write func(ctx context.Context, db *engine.DB, b *strings.Builder)
+}
+
+// infoSections are in the order of the reply.
+var infoSections = []infoSection{
+	{"Server", infoServer},
+	{"Memory", infoMemory},
+	{"Stats", infoStats},
+	{"Replication", infoReplication},
+	{"Raft", infoRaft},
+	{"Keyspace", infoKeyspace},
+}
+
+// infoCmd replies the sections, or all of them if no section is given. In RESP3 the
+// reply is a verbatim string.
+func infoCmd(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	all := len(args) == 1
+	wanted := make(map[string]bool, len(args)-1)
+	for _, arg := range args[1:] {
+		switch s := strings.ToLower(arg); s {
+		case "all", "default", "everything":
+			all = true
+		default:
+			wanted[s] = true
+		}
+	}
+
+	var b strings.Builder
+	for _, section := range infoSections {
+		if !all && !wanted[strings.ToLower(section.name)] {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		fmt.Fprintf(&b, "# %s\r\n", section.name)
+		section.write(ctx, db, &b)
+	}
+	return proto.NewReply(proto.ReplyKindVerbatim, b.String(), nil)
+}
+
+func infoField(b *strings.Builder, name string, val interface{}) {
+	fmt.Fprintf(b, "%s:%v\r\n", name, val)
+}
+
+func infoServer(ctx context.Context, db *engine.DB, b *strings.Builder) {
+	infoField(b, "gres_version", Version)
+	infoField(b, "os", runtime.GOOS+" "+runtime.GOARCH)
+	infoField(b, "go_version", runtime.Version())
+	infoField(b, "process_id", os.Getpid())
+}
+
+func infoMemory(ctx context.Context, db *engine.DB, b *strings.Builder) {
+	infoField(b, "used_memory", db.UsedMemory())
+}
+
+func infoStats(ctx context.Context, db *engine.DB, b *strings.Builder) {
+	stats := db.ExpireStats()
+	infoField(b, "expired_keys", stats.ExpiredKeys)
+	infoField(b, "expired_stale_perc", fmt.Sprintf("%.2f", stats.StalePercent))
+	infoField(b, "expired_time_cap_reached_count", stats.TimeCapReachedCount)
+	infoField(b, "expire_cycle_cpu_milliseconds", int64(stats.CycleTime)/1e6)
+	infoField(b, "evicted_keys", db.EvictedKeys())
+}
+
+// infoReplication writes the role of the server, the replicas and the offsets.
+func infoReplication(ctx context.Context, db *engine.DB, b *strings.Builder) {
+	session := CtxGetSession(ctx)
+	if session == nil {
+		return
+	}
+	fields := session.Replication().Info()
+	for i := 0; i+1 < len(fields); i += 2 {
+		infoField(b, fields[i], fields[i+1])
+	}
+}
+
+// infoRaft writes the state of the raft node, if raft mode is enabled.
+func infoRaft(ctx context.Context, db *engine.DB, b *strings.Builder) {
+	session := CtxGetSession(ctx)
+	if session == nil {
+		return
+	}
+	r := session.Raft()
+	if r == nil {
+		infoField(b, "raft_enabled", 0)
+		return
+	}
+	infoField(b, "raft_enabled", 1)
+	fields := r.Info()
+	for i := 0; i+1 < len(fields); i += 2 {
+		infoField(b, fields[i], fields[i+1])
+	}
+}
+
+// infoKeyspace writes the dbs which are not empty.
+func infoKeyspace(ctx context.Context, db *engine.DB, b *strings.Builder) {
+	for i := 0; i < db.Dbnum(); i++ {
+		d, err := db.Select(i)
+		if err != nil {
+			continue
+		}
+		if keys := d.DbSize(); keys > 0 {
+			fmt.Fprintf(b, "db%d:keys=%d,expires=%d\r\n", i, keys, d.ExpiresSize())
+		}
+	}
+}
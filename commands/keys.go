@@ -1,22 +1,41 @@
 package commands
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
 	"github.com/clovers4/gres/engine"
 	"github.com/clovers4/gres/errs"
 	"github.com/clovers4/gres/proto"
 	"github.com/clovers4/gres/util"
 )
 
+var (
+	ErrExpireNXConflict = errors.New("ERR NX and XX, GT or LT options at the same time are not compatible")
+	ErrExpireGTLT       = errors.New("ERR GT and LT options at the same time are not compatible")
+)
+
 // KEYS
 func init() {
-	registerCmd("quit", 1, quitCmd)
-	registerCmd("expire", 3, expireCmd)
-	registerCmd("ttl", 2, ttlCmd)
-	registerCmd("dbsize", 1, dbsizeCmd)
-	registerCmd("exists", 2, existsCmd)
-	registerCmd("del", 2, delCmd)
-	registerCmd("type", 2, typeCmd)
-	registerCmd("keys", 2, keysCmd)
+	registerCmd("quit", 1, catConnection, quitCmd)
+	registerCmd("expire", -3, cmdWrite|catKeyspace, expireCmd)
+	registerCmd("pexpire", -3, cmdWrite|catKeyspace, pexpireCmd)
+	registerCmd("expireat", -3, cmdWrite|catKeyspace, expireatCmd)
+	registerCmd("pexpireat", -3, cmdWrite|catKeyspace, pexpireatCmd)
+	registerCmd("persist", 2, cmdWrite|catKeyspace, persistCmd)
+	registerCmd("ttl", 2, cmdReadOnly|catKeyspace, ttlCmd)
+	registerCmd("pttl", 2, cmdReadOnly|catKeyspace, pttlCmd)
+	registerCmd("expiretime", 2, cmdReadOnly|catKeyspace, expiretimeCmd)
+	registerCmd("pexpiretime", 2, cmdReadOnly|catKeyspace, pexpiretimeCmd)
+	registerCmd("dbsize", 1, cmdReadOnly|catKeyspace, dbsizeCmd)
+	registerCmd("exists", 2, cmdReadOnly|catKeyspace, existsCmd)
+	registerCmd("del", 2, cmdWrite|catKeyspace, delCmd)
+	registerCmd("type", 2, cmdReadOnly|catKeyspace, typeCmd)
+	registerCmd("keys", 2, cmdReadOnly|catKeyspace|catDangerous, keysCmd)
+	registerCmd("move", 3, cmdWrite|catKeyspace, moveCmd)
 }
 
 func quitCmd(db *engine.DB, args []string) *proto.Reply {
@@ -24,16 +43,90 @@ func quitCmd(db *engine.DB, args []string) *proto.Reply {
 }
 
 func expireCmd(db *engine.DB, args []string) *proto.Reply {
-	key := args[1]
-	secondsS := args[2]
-	var seconds int
-	var err error
+	return expireGeneric(db, args, "ex")
+}
+
+func pexpireCmd(db *engine.DB, args []string) *proto.Reply {
+	return expireGeneric(db, args, "px")
+}
+
+func expireatCmd(db *engine.DB, args []string) *proto.Reply {
+	return expireGeneric(db, args, "exat")
+}
+
+func pexpireatCmd(db *engine.DB, args []string) *proto.Reply {
+	return expireGeneric(db, args, "pxat")
+}
 
-	if seconds, err = util.String2Int(secondsS); err != nil {
+// expireGeneric implements EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT, the unit of
+// the time is like the options of SET.
+func expireGeneric(db *engine.DB, args []string, unit string) *proto.Reply {
+	key := args[1]
+	n, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
 		return proto.NewReply(proto.ReplyKindErr, nil, errs.ErrIsNotInt)
 	}
+	cond, err := parseExpireCond(args[3:])
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, err)
+	}
+
+	at, ok := unixMs(unit, n, util.NowMs())
+	if !ok {
+		return proto.NewReply(proto.ReplyKindErr, nil, fmt.Errorf("ERR invalid expire time in '%s' command", args[0]))
+	}
+	if db.PExpireAt(key, at, cond) {
+		return proto.NewReply(proto.ReplyKindInt, 1, nil)
+	}
+	return proto.NewReply(proto.ReplyKindInt, 0, nil)
+}
+
+func parseExpireCond(opts []string) (engine.ExpireCond, error) {
+	cond := engine.ExpireAlways
+	for _, opt := range opts {
+		switch strings.ToLower(opt) {
+		case "nx":
+			cond |= engine.ExpireNX
+		case "xx":
+			cond |= engine.ExpireXX
+		case "gt":
+			cond |= engine.ExpireGT
+		case "lt":
+			cond |= engine.ExpireLT
+		default:
+			return cond, fmt.Errorf("ERR Unsupported option %s", opt)
+		}
+	}
+
+	if cond&engine.ExpireNX != 0 && cond != engine.ExpireNX {
+		return cond, ErrExpireNXConflict
+	}
+	if cond&engine.ExpireGT != 0 && cond&engine.ExpireLT != 0 {
+		return cond, ErrExpireGTLT
+	}
+	return cond, nil
+}
 
-	if db.Expire(key, seconds) {
+// unixMs converts the time n in the unit, which is "ex", "px", "exat" or "pxat",
+// into unix milliseconds. It reports false if the result overflows.
+func unixMs(unit string, n int64, nowMs int64) (int64, bool) {
+	if unit == "ex" || unit == "exat" {
+		if n > math.MaxInt64/1000 || n < math.MinInt64/1000 {
+			return 0, false
+		}
+		n *= 1000
+	}
+	if unit == "ex" || unit == "px" {
+		if n > math.MaxInt64-nowMs {
+			return 0, false
+		}
+		n += nowMs
+	}
+	return n, true
+}
+
+func persistCmd(db *engine.DB, args []string) *proto.Reply {
+	if db.Persist(args[1]) {
 		return proto.NewReply(proto.ReplyKindInt, 1, nil)
 	}
 	return proto.NewReply(proto.ReplyKindInt, 0, nil)
@@ -46,6 +139,18 @@ func ttlCmd(db *engine.DB, args []string) *proto.Reply {
 	return proto.NewReply(proto.ReplyKindInt, ttl, nil)
 }
 
+func pttlCmd(db *engine.DB, args []string) *proto.Reply {
+	return proto.NewReply(proto.ReplyKindInt, int(db.PTtl(args[1])), nil)
+}
+
+func expiretimeCmd(db *engine.DB, args []string) *proto.Reply {
+	return proto.NewReply(proto.ReplyKindInt, int(db.ExpireTime(args[1])), nil)
+}
+
+func pexpiretimeCmd(db *engine.DB, args []string) *proto.Reply {
+	return proto.NewReply(proto.ReplyKindInt, int(db.PExpireTime(args[1])), nil)
+}
+
 func dbsizeCmd(db *engine.DB, args []string) *proto.Reply {
 	len := db.DbSize()
 	return proto.NewReply(proto.ReplyKindInt, len, nil)
@@ -81,3 +186,20 @@ func keysCmd(db *engine.DB, args []string) *proto.Reply {
 	}
 	return proto.NewReply(proto.ReplyKindArrays, is, err)
 }
+
+func moveCmd(db *engine.DB, args []string) *proto.Reply {
+	key := args[1]
+	index, err := strconv.Atoi(args[2])
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrInvalidDbIndex)
+	}
+
+	moved, err := db.Move(key, index)
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, err)
+	}
+	if moved {
+		return proto.NewReply(proto.ReplyKindInt, 1, nil)
+	}
+	return proto.NewReply(proto.ReplyKindInt, 0, nil)
+}
@@ -0,0 +1,55 @@
+package commands
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/clovers4/gres/engine"
+	"github.com/clovers4/gres/errs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpireCmds(t *testing.T) {
+	s := newTestSession(engine.NewDB())
+
+	assert.Equal(t, 0, s.do("expire", "a", "100").Val)
+	assert.Equal(t, -2, s.do("pttl", "a").Val)
+	assert.Equal(t, -2, s.do("expiretime", "a").Val)
+
+	s.do("set", "a", "1")
+	assert.Equal(t, -1, s.do("ttl", "a").Val)
+	assert.Equal(t, -1, s.do("pexpiretime", "a").Val)
+	assert.Equal(t, 0, s.do("persist", "a").Val)
+
+	assert.Equal(t, 1, s.do("pexpire", "a", "100000").Val)
+	assert.Equal(t, 100, s.do("ttl", "a").Val)
+	pttl := s.do("pttl", "a").Val.(int)
+	assert.True(t, pttl > 99000 && pttl <= 100000, pttl)
+
+	// NX XX GT LT
+	assert.Equal(t, 0, s.do("expire", "a", "200", "nx").Val)
+	assert.Equal(t, 0, s.do("expire", "a", "50", "GT").Val)
+	assert.Equal(t, 1, s.do("expire", "a", "200", "xx", "gt").Val)
+	assert.Equal(t, 0, s.do("expire", "a", "300", "lt").Val)
+	assert.Equal(t, 200, s.do("ttl", "a").Val)
+	assert.Equal(t, ErrExpireNXConflict, s.do("expire", "a", "1", "nx", "xx").Err)
+	assert.Equal(t, ErrExpireGTLT, s.do("expire", "a", "1", "gt", "lt").Err)
+	assert.Equal(t, "ERR Unsupported option foo", s.do("expire", "a", "1", "foo").Err.Error())
+	assert.Equal(t, errs.ErrIsNotInt, s.do("expire", "a", "x").Err)
+	assert.Equal(t, "ERR invalid expire time in 'expire' command", s.do("expire", "a", "9223372036854775807").Err.Error())
+
+	at := time.Now().Unix() + 1000
+	assert.Equal(t, 1, s.do("expireat", "a", strconv.FormatInt(at, 10)).Val)
+	assert.Equal(t, int(at), s.do("expiretime", "a").Val)
+	assert.Equal(t, int(at*1000), s.do("pexpiretime", "a").Val)
+	assert.Equal(t, 1, s.do("pexpireat", "a", strconv.FormatInt(at*1000+1, 10)).Val)
+	assert.Equal(t, int(at*1000+1), s.do("pexpiretime", "a").Val)
+
+	assert.Equal(t, 1, s.do("persist", "a").Val)
+	assert.Equal(t, -1, s.do("ttl", "a").Val)
+
+	// 过期时间已过, key 被删除
+	assert.Equal(t, 1, s.do("expire", "a", "-1").Val)
+	assert.Equal(t, 0, s.do("exists", "a").Val)
+}
@@ -1,22 +1,36 @@
 package commands
 
 import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
 	"github.com/clovers4/gres/engine"
 	"github.com/clovers4/gres/errs"
 	"github.com/clovers4/gres/proto"
 	"github.com/clovers4/gres/util"
 )
 
+var (
+	ErrTimeoutNotFloat = errors.New("ERR timeout is not a float or out of range")
+	ErrTimeoutNegative = errors.New("ERR timeout is negative")
+)
+
 // LIST
 func init() {
-	registerCmd("lpush", -2, lpushCmd)
-	registerCmd("rpush", -3, rpushCmd)
-	registerCmd("lpop", 2, lpopCmd)
-	registerCmd("rpop", 2, rpopCmd)
-
-	registerCmd("lrange", 4, lrangeCmd)
-	registerCmd("lindex", 3, lindexCmd)
-	registerCmd("lset", 4, lsetCmd)
+	registerCmd("lpush", -2, cmdWrite|cmdDenyOOM|catList, lpushCmd)
+	registerCmd("rpush", -3, cmdWrite|cmdDenyOOM|catList, rpushCmd)
+	registerCmd("lpop", 2, cmdWrite|catList, lpopCmd)
+	registerCmd("rpop", 2, cmdWrite|catList, rpopCmd)
+	registerCmd("rpoplpush", 3, cmdWrite|cmdDenyOOM|catList, rpoplpushCmd)
+	registerCtxCmd("blpop", -3, cmdBlocking|catList, blpopCmd)
+	registerCtxCmd("brpop", -3, cmdBlocking|catList, brpopCmd)
+	registerCtxCmd("brpoplpush", 4, cmdBlocking|catList, brpoplpushCmd)
+
+	registerCmd("lrange", 4, cmdReadOnly|catList, lrangeCmd)
+	registerCmd("lindex", 3, cmdReadOnly|catList, lindexCmd)
+	registerCmd("lset", 4, cmdWrite|cmdDenyOOM|catList, lsetCmd)
 }
 
 func lpushCmd(db *engine.DB, args []string) *proto.Reply {
@@ -67,6 +81,133 @@ func rpopCmd(db *engine.DB, args []string) *proto.Reply {
 	return proto.NewReply(proto.ReplyKindBlukString, oldVal, err)
 }
 
+func rpoplpushCmd(db *engine.DB, args []string) *proto.Reply {
+	val, err := db.RPopLPush(args[1], args[2])
+	return proto.NewReply(proto.ReplyKindBlukString, val, err)
+}
+
+func blpopCmd(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	return bpop(ctx, db, args, "lpop", db.LPop)
+}
+
+func brpopCmd(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	return bpop(ctx, db, args, "rpop", db.RPop)
+}
+
+// bpop pops from the first non-empty list of the keys, or blocks until an element
+// is pushed. It is propagated as the pop which is actually executed.
+func bpop(ctx context.Context, db *engine.DB, args []string, popCmd string, pop func(key string) (interface{}, error)) *proto.Reply {
+	keys := args[1 : len(args)-1]
+	timeout, err := parseTimeout(args[len(args)-1])
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, err)
+	}
+
+	var reply *proto.Reply
+	serve := func(key string) (bool, error) {
+		var val interface{}
+		var err error
+		db.Propagate([]string{popCmd, key}, func() bool {
+			val, err = pop(key)
+			return err == nil && val != nil
+		})
+		if err != nil || val == nil {
+			return false, err
+		}
+		reply = proto.NewReply(proto.ReplyKindArrays, []interface{}{key, val}, nil)
+		return true, nil
+	}
+
+	served, err := block(ctx, db, keys, timeout, serve)
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, err)
+	}
+	if !served {
+		return proto.NewReply(proto.ReplyKindArrays, nil, nil)
+	}
+	return reply
+}
+
+func brpoplpushCmd(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	src, dst := args[1], args[2]
+	timeout, err := parseTimeout(args[3])
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, err)
+	}
+
+	var reply *proto.Reply
+	serve := func(key string) (bool, error) {
+		var val interface{}
+		var err error
+		db.Propagate([]string{"rpoplpush", src, dst}, func() bool {
+			val, err = db.RPopLPush(src, dst)
+			return err == nil && val != nil
+		})
+		if err != nil || val == nil {
+			return false, err
+		}
+		reply = proto.NewReply(proto.ReplyKindBlukString, val, nil)
+		return true, nil
+	}
+
+	served, err := block(ctx, db, []string{src}, timeout, serve)
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, err)
+	}
+	if !served {
+		return proto.NewReply(proto.ReplyKindBlukString, nil, nil)
+	}
+	return reply
+}
+
+// block serves the connection at once if possible, otherwise waits until it is
+// served, the timeout is reached or the connection is closed. 0 timeout means
+// waiting forever. In EXEC it never waits, like redis does.
+func block(ctx context.Context, db *engine.DB, keys []string, timeout time.Duration, serve engine.BlockServeFunc) (bool, error) {
+	if inExec(ctx) {
+		b, err := db.Block(keys, serve)
+		if err != nil || b == nil {
+			return err == nil, err
+		}
+		return b.Unblock(), nil
+	}
+
+	var b *engine.Blocked
+	var err error
+	db.Shared(func() {
+		b, err = db.Block(keys, serve)
+		db.ServeBlocked()
+	})
+	if err != nil || b == nil {
+		return err == nil, err
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-b.Done():
+	case <-expired:
+	case <-ctx.Done():
+	}
+	return b.Unblock(), nil
+}
+
+// parseTimeout parses the timeout in seconds, which can be a float.
+func parseTimeout(s string) (time.Duration, error) {
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, ErrTimeoutNotFloat
+	}
+	if secs < 0 {
+		return 0, ErrTimeoutNegative
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
 func lrangeCmd(db *engine.DB, args []string) *proto.Reply {
 	key := args[1]
 	startS := args[2]
@@ -0,0 +1,58 @@
+package commands
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/clovers4/gres/engine"
+	"github.com/clovers4/gres/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockingPop(t *testing.T) {
+	db := engine.NewDB()
+	s := newTestSession(db)
+
+	s.do("rpush", "b", "B1", "B2")
+	assert.Equal(t, []interface{}{"b", "B1"}, s.do("blpop", "a", "b", "0").Val)
+	assert.Equal(t, []interface{}{"b", "B2"}, s.do("brpop", "a", "b", "0").Val)
+
+	// 超时
+	reply := s.do("blpop", "a", "0.01")
+	assert.Equal(t, proto.ReplyKindArrays, int(reply.Kind))
+	assert.Nil(t, reply.Val)
+	assert.Equal(t, ErrTimeoutNegative, s.do("blpop", "a", "-1").Err)
+	assert.Equal(t, ErrTimeoutNotFloat, s.do("blpop", "a", "x").Err)
+
+	// 被其他连接 push 的元素唤醒, push 先于阻塞时立即返回
+	done := make(chan *proto.Reply)
+	go func() {
+		done <- s.do("brpoplpush", "a", "c", "0")
+	}()
+	time.Sleep(10 * time.Millisecond)
+	newTestSession(db).do("lpush", "a", "A")
+	reply = <-done
+	assert.Equal(t, "A", reply.Val)
+	val, _ := db.LPop("c")
+	assert.Equal(t, "A", val)
+}
+
+func TestBlockingPop_Canceled(t *testing.T) {
+	db := engine.NewDB()
+	s := newTestSession(db)
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	done := make(chan *proto.Reply)
+	go func() {
+		done <- GetCmd("blpop").Do(ctx, []string{"blpop", "a", "0"})
+	}()
+	cancel()
+	assert.Nil(t, (<-done).Val)
+
+	// 事务中不阻塞
+	s.do("multi")
+	s.do("blpop", "a", "0")
+	reply := s.do("exec")
+	assert.Nil(t, reply.Val.([]interface{})[0].(*proto.Reply).Val)
+}
@@ -0,0 +1,80 @@
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clovers4/gres/engine"
+	"github.com/clovers4/gres/errs"
+	"github.com/clovers4/gres/proto"
+)
+
+// LOCK
+func init() {
+	registerCmd("lock.acquire", 5, cmdWrite|cmdDenyOOM|catKeyspace, lockAcquireCmd)
+	registerCmd("lock.release", 3, cmdWrite|catKeyspace, lockReleaseCmd)
+	registerCmd("lock.extend", 5, cmdWrite|catKeyspace, lockExtendCmd)
+	registerCmd("lock.fence", 1, cmdReadOnly|catKeyspace, lockFenceCmd)
+}
+
+// LOCK.ACQUIRE key owner EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds
+func lockAcquireCmd(db *engine.DB, args []string) *proto.Reply {
+	at, err := parseLease(args)
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, err)
+	}
+
+	token, err := db.LockAcquire(args[1], args[2], at)
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, err)
+	}
+	// 被其他 owner 持有
+	if token == 0 {
+		return proto.NewReply(proto.ReplyKindNull, nil, nil)
+	}
+	return proto.NewReply(proto.ReplyKindInt, int(token), nil)
+}
+
+// LOCK.RELEASE key owner
+func lockReleaseCmd(db *engine.DB, args []string) *proto.Reply {
+	ok, err := db.LockRelease(args[1], args[2])
+	return proto.NewReply(proto.ReplyKindInt, boolToInt(ok), err)
+}
+
+// LOCK.EXTEND key owner EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds
+func lockExtendCmd(db *engine.DB, args []string) *proto.Reply {
+	at, err := parseLease(args)
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, err)
+	}
+
+	ok, err := db.LockExtend(args[1], args[2], at)
+	return proto.NewReply(proto.ReplyKindInt, boolToInt(ok), err)
+}
+
+// LOCK.FENCE
+// 返回最近发放的 fencing token
+func lockFenceCmd(db *engine.DB, args []string) *proto.Reply {
+	return proto.NewReply(proto.ReplyKindInt, int(db.LockFence()), nil)
+}
+
+// parseLease parses the lease args[3] args[4] of the lock into unix milliseconds.
+func parseLease(args []string) (int64, error) {
+	unit := strings.ToLower(args[3])
+	switch unit {
+	case "ex", "px", "exat", "pxat":
+	default:
+		return 0, ErrSyntax
+	}
+	n, err := strconv.ParseInt(args[4], 10, 64)
+	if err != nil {
+		return 0, errs.ErrIsNotInt
+	}
+	at, ok := expireAtMs(unit, n, time.Now())
+	if !ok {
+		return 0, fmt.Errorf("ERR invalid expire time in '%s' command", args[0])
+	}
+	return at, nil
+}
@@ -0,0 +1,43 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/clovers4/gres/engine"
+	"github.com/clovers4/gres/errs"
+	"github.com/clovers4/gres/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockCmds(t *testing.T) {
+	s := newTestSession(engine.NewDB())
+
+	assert.Equal(t, 1, s.do("lock.acquire", "l", "a", "EX", "100").Val)
+	assert.Equal(t, 100, s.do("ttl", "l").Val)
+	assert.Equal(t, proto.ReplyKindNull, int(s.do("lock.acquire", "l", "b", "px", "1000").Kind))
+	assert.Equal(t, 1, s.do("lock.acquire", "l", "a", "ex", "200").Val)
+	assert.Equal(t, 200, s.do("ttl", "l").Val)
+
+	assert.Equal(t, 0, s.do("lock.extend", "l", "b", "ex", "300").Val)
+	assert.Equal(t, 1, s.do("lock.extend", "l", "a", "ex", "300").Val)
+	assert.Equal(t, 300, s.do("ttl", "l").Val)
+
+	assert.Equal(t, 0, s.do("lock.release", "l", "b").Val)
+	assert.Equal(t, 1, s.do("lock.release", "l", "a").Val)
+	assert.Equal(t, 0, s.do("exists", "l").Val)
+	assert.Equal(t, 2, s.do("lock.acquire", "l", "b", "pxat", "99999999999999").Val)
+
+	assert.Equal(t, 2, s.do("lock.fence").Val)
+	assert.Equal(t, 3, s.do("lock.acquire", "m", "7", "ex", "100").Val)
+	assert.Equal(t, 3, s.do("lock.fence").Val)
+	assert.Equal(t, "lock", s.do("type", "m").Val)
+	assert.Equal(t, 0, s.do("lock.release", "m", "007").Val)
+	assert.Equal(t, engine.ErrWrongTypeOps, s.do("hset", "m", "token", "1").Err)
+
+	assert.Equal(t, ErrSyntax, s.do("lock.acquire", "l", "a", "nx", "100").Err)
+	assert.Equal(t, errs.ErrIsNotInt, s.do("lock.acquire", "l", "a", "ex", "x").Err)
+	assert.Equal(t, "ERR invalid expire time in 'lock.extend' command", s.do("lock.extend", "l", "a", "ex", "0").Err.Error())
+	assert.Equal(t, ErrWrongNumArgs, s.do("lock.fence", "10").Err)
+	s.do("set", "s", "v")
+	assert.Equal(t, engine.ErrWrongTypeOps, s.do("lock.acquire", "s", "a", "ex", "100").Err)
+}
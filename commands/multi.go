@@ -0,0 +1,239 @@
+package commands
+
+import (
+	"context"
+	"errors"
+
+	"github.com/clovers4/gres/engine"
+	"github.com/clovers4/gres/proto"
+)
+
+var (
+	ErrMultiNested         = errors.New("ERR MULTI calls can not be nested")
+	ErrExecWithoutMulti    = errors.New("ERR EXEC without MULTI")
+	ErrDiscardWithoutMulti = errors.New("ERR DISCARD without MULTI")
+	ErrWatchInMulti        = errors.New("ERR WATCH inside MULTI is not allowed")
+	ErrExecAbort           = errors.New("EXECABORT Transaction discarded because of previous errors")
+)
+
+// MULTI
+func init() {
+	registerCtxCmd("multi", 1, cmdTx|catTransaction, multiCmd)
+	registerCtxCmd("exec", 1, cmdTx|catTransaction, execCmd)
+	registerCtxCmd("discard", 1, cmdTx|catTransaction, discardCmd)
+	registerCtxCmd("watch", -2, cmdTx|catTransaction, watchCmd)
+	registerCtxCmd("unwatch", 1, cmdTx|catTransaction, unwatchCmd)
+}
+
+const ctxExec = "exec"
+
+func inExec(ctx context.Context) bool {
+	return ctx.Value(ctxExec) != nil
+}
+
+// Tx is the transaction state of a connection. Between MULTI and EXEC the
+// commands are queued, then executed atomically by EXEC.
+type Tx struct {
+	multi   bool
+	aborted bool // 入队时出错, EXEC 时放弃整个事务
+	queued  [][]string
+	watched []watched
+}
+
+type watched struct {
+	db      *engine.DB
+	key     string
+	version uint64
+}
+
+// InMulti reports whether the commands should be queued.
+func (tx *Tx) InMulti() bool {
+	return tx.multi
+}
+
+// Queueable reports whether the command is queued in a transaction, instead of executed.
+func Queueable(name string) bool {
+	c, ok := commands[name].(*cmd)
+	return !ok || c.flags&cmdTx == 0
+}
+
+// SubscribedAllowed reports whether the command can be executed in the subscribed mode.
+func SubscribedAllowed(name string) bool {
+	c, ok := commands[name].(*cmd)
+	return ok && c.flags&cmdPubSub != 0
+}
+
+// Queue checks the command and queues it. Once a command fails to be queued, the
+// whole transaction is discarded by EXEC.
+func (tx *Tx) Queue(db *engine.DB, args []string) *proto.Reply {
+	c, ok := commands[args[0]].(*cmd)
+	if !ok {
+		tx.aborted = true
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrUnknownCmd)
+	}
+
+	err := c.checkArity(args)
+	if err == nil && c.flags&cmdNoTx != 0 {
+		err = ErrNotAllowedInTx
+	}
+	if err == nil {
+		db.Shared(func() {
+			err = c.freeMemoryIfNeeded(db)
+		})
+	}
+	if err != nil {
+		tx.aborted = true
+		return proto.NewReply(proto.ReplyKindErr, nil, err)
+	}
+
+	tx.queued = append(tx.queued, args)
+	return proto.NewReply(proto.ReplyKindStatus, "QUEUED", nil)
+}
+
+// Reject replies err for a command which fails to be queued, such as without
+// permission, then the whole transaction is discarded by EXEC.
+func (tx *Tx) Reject(err error) *proto.Reply {
+	tx.aborted = true
+	return proto.NewReply(proto.ReplyKindErr, nil, err)
+}
+
+func (tx *Tx) watch(db *engine.DB, key string) {
+	for _, w := range tx.watched {
+		if w.db == db && w.key == key {
+			return
+		}
+	}
+	tx.watched = append(tx.watched, watched{
+		db:      db,
+		key:     key,
+		version: db.Watch(key),
+	})
+}
+
+func (tx *Tx) unwatch() {
+	for _, w := range tx.watched {
+		w.db.Unwatch(w.key)
+	}
+	tx.watched = nil
+}
+
+// touched reports whether any watched key was modified after WATCH.
+func (tx *Tx) touched() bool {
+	for _, w := range tx.watched {
+		if w.db.WatchedVersion(w.key) != w.version {
+			return true
+		}
+	}
+	return false
+}
+
+// Reset ends the transaction and unwatches all keys. It must be called when the
+// connection is closed.
+func (tx *Tx) Reset() {
+	tx.unwatch()
+	tx.multi = false
+	tx.aborted = false
+	tx.queued = nil
+}
+
+func multiCmd(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	session := CtxGetSession(ctx)
+	if session == nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrNoSession)
+	}
+
+	tx := session.Tx()
+	if tx.multi {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrMultiNested)
+	}
+	tx.multi = true
+	return proto.NewReply(proto.ReplyKindStatus, "OK", nil)
+}
+
+func execCmd(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	session := CtxGetSession(ctx)
+	if session == nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrNoSession)
+	}
+
+	tx := session.Tx()
+	if !tx.multi {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrExecWithoutMulti)
+	}
+	defer tx.Reset()
+	if tx.aborted {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrExecAbort)
+	}
+
+	// 事务中的阻塞命令不会阻塞
+	ctx = context.WithValue(ctx, ctxExec, true)
+
+	var reply *proto.Reply
+	db.Exclusive(func() {
+		// watch 的 key 已被修改, 放弃事务
+		if tx.touched() {
+			reply = proto.NewReply(proto.ReplyKindArrays, nil, nil)
+			return
+		}
+
+		replies := make([]interface{}, 0, len(tx.queued))
+		for _, args := range tx.queued {
+			// 入队之后用户的权限可能被修改
+			if err := CheckPerm(session, args); err != nil {
+				replies = append(replies, proto.NewReply(proto.ReplyKindErr, nil, err))
+				continue
+			}
+			c := commands[args[0]].(*cmd)
+			replies = append(replies, c.call(ctx, db, args))
+
+			// SELECT 会修改连接所选的 db
+			if cur := session.DB(); cur != db {
+				db = cur
+				ctx = engine.CtxWithDB(ctx, db)
+			}
+		}
+		db.ServeBlocked()
+		reply = proto.NewReply(proto.ReplyKindArrays, replies, nil)
+	})
+	return reply
+}
+
+func discardCmd(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	session := CtxGetSession(ctx)
+	if session == nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrNoSession)
+	}
+
+	tx := session.Tx()
+	if !tx.multi {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrDiscardWithoutMulti)
+	}
+	tx.Reset()
+	return proto.NewReply(proto.ReplyKindStatus, "OK", nil)
+}
+
+func watchCmd(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	session := CtxGetSession(ctx)
+	if session == nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrNoSession)
+	}
+
+	tx := session.Tx()
+	if tx.multi {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrWatchInMulti)
+	}
+	for _, key := range args[1:] {
+		tx.watch(db, key)
+	}
+	return proto.NewReply(proto.ReplyKindStatus, "OK", nil)
+}
+
+func unwatchCmd(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	session := CtxGetSession(ctx)
+	if session == nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrNoSession)
+	}
+
+	session.Tx().unwatch()
+	return proto.NewReply(proto.ReplyKindStatus, "OK", nil)
+}
@@ -0,0 +1,171 @@
+package commands
+
+import (
+	"context"
+	"testing"
+
+	"github.com/clovers4/gres/engine"
+	"github.com/clovers4/gres/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+type testSession struct {
+	ctx context.Context
+	db  *engine.DB
+	tx  Tx
+
+	channels []string
+	pubsub   testPubSub
+	config   testConfig
+	repl     testReplication
+	raft     Raft
+	protover int
+	name     string
+	acl      *ACL
+	user     *User
+}
+
+func newTestSession(db *engine.DB) *testSession {
+	s := &testSession{db: db, acl: NewACL()}
+	s.user = s.acl.InitialUser()
+	s.ctx = CtxWithSession(engine.CtxWithDB(context.Background(), db), s)
+	return s
+}
+
+func (s *testSession) DB() *engine.DB { return s.db }
+
+func (s *testSession) SelectDB(db *engine.DB) {
+	s.db = db
+	s.ctx = engine.CtxWithDB(s.ctx, db)
+}
+
+func (s *testSession) Tx() *Tx { return &s.tx }
+
+func (s *testSession) ID() int64 { return 1 }
+
+func (s *testSession) Proto() int {
+	if s.protover == 0 {
+		return proto.RESP2
+	}
+	return s.protover
+}
+
+func (s *testSession) SetProto(protover int) { s.protover = protover }
+
+func (s *testSession) SetName(name string) { s.name = name }
+
+func (s *testSession) User() *User { return s.user }
+
+func (s *testSession) SetUser(u *User) { s.user = u }
+
+func (s *testSession) ACL() *ACL { return s.acl }
+
+func (s *testSession) Subscribe(channels ...string) {
+	s.channels = append(s.channels, channels...)
+}
+
+func (s *testSession) Unsubscribe(channels ...string) { s.channels = nil }
+
+func (s *testSession) PSubscribe(patterns ...string) {}
+
+func (s *testSession) PUnsubscribe(patterns ...string) {}
+
+func (s *testSession) PubSub() PubSub { return s.pubsub }
+
+func (s *testSession) Config() Config { return &s.config }
+
+func (s *testSession) Replication() Replication { return &s.repl }
+
+func (s *testSession) Raft() Raft { return s.raft }
+
+// do executes args like gres.Client.Interact.
+func (s *testSession) do(args ...string) *proto.Reply {
+	if err := CheckPerm(s, args); err != nil {
+		if s.tx.InMulti() && Queueable(args[0]) {
+			return s.tx.Reject(err)
+		}
+		return proto.NewReply(proto.ReplyKindErr, nil, err)
+	}
+	if s.tx.InMulti() && Queueable(args[0]) {
+		return s.tx.Queue(s.db, args)
+	}
+	c := GetCmd(args[0])
+	if c == nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrUnknownCmd)
+	}
+	return c.Do(s.ctx, args)
+}
+
+func TestMulti(t *testing.T) {
+	db := engine.NewDB(engine.DbnumOption(2))
+	s := newTestSession(db)
+
+	assert.Equal(t, "OK", s.do("multi").Val)
+	assert.Equal(t, ErrMultiNested, s.do("multi").Err)
+	assert.Equal(t, "QUEUED", s.do("set", "a", "A").Val)
+	assert.Equal(t, "QUEUED", s.do("select", "1").Val)
+	assert.Equal(t, "QUEUED", s.do("set", "a", "B").Val)
+	assert.False(t, db.Exists("a"))
+
+	reply := s.do("exec")
+	assert.Equal(t, proto.ReplyKindArrays, int(reply.Kind))
+	assert.Equal(t, 3, len(reply.Val.([]interface{})))
+	val, _ := db.Get("a")
+	assert.Equal(t, []byte("A"), val)
+	db1, _ := db.Select(1)
+	val, _ = db1.Get("a")
+	assert.Equal(t, []byte("B"), val)
+	assert.Equal(t, db1, s.DB())
+
+	assert.Equal(t, ErrExecWithoutMulti, s.do("exec").Err)
+}
+
+func TestMulti_Abort(t *testing.T) {
+	db := engine.NewDB()
+	s := newTestSession(db)
+
+	s.do("multi")
+	s.do("set", "a", "A")
+	assert.Equal(t, proto.ReplyKindErr, int(s.do("set", "a").Kind))
+	assert.Equal(t, ErrExecAbort, s.do("exec").Err)
+	assert.False(t, db.Exists("a"))
+
+	s.do("multi")
+	s.do("set", "a", "A")
+	assert.Equal(t, "OK", s.do("discard").Val)
+	assert.False(t, db.Exists("a"))
+}
+
+func TestWatch(t *testing.T) {
+	db := engine.NewDB()
+	s := newTestSession(db)
+	other := newTestSession(db)
+
+	// watch 的 key 被其他连接修改, 事务放弃
+	s.do("watch", "a")
+	other.do("set", "a", "X")
+	s.do("multi")
+	s.do("set", "a", "A")
+	reply := s.do("exec")
+	assert.Equal(t, proto.ReplyKindArrays, int(reply.Kind))
+	assert.Nil(t, reply.Val)
+	val, _ := db.Get("a")
+	assert.Equal(t, []byte("X"), val)
+
+	// EXEC 之后不再 watch
+	s.do("multi")
+	s.do("set", "a", "A")
+	assert.NotNil(t, s.do("exec").Val)
+	val, _ = db.Get("a")
+	assert.Equal(t, []byte("A"), val)
+
+	s.do("watch", "a")
+	s.do("multi")
+	assert.Equal(t, ErrWatchInMulti, s.do("watch", "a").Err)
+	s.do("discard")
+	s.do("unwatch")
+	other.do("set", "a", "Y")
+	s.do("multi")
+	s.do("set", "a", "A")
+	assert.NotNil(t, s.do("exec").Val)
+}
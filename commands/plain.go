@@ -1,33 +1,152 @@
 package commands
 
 import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/clovers4/gres/engine"
 	"github.com/clovers4/gres/errs"
 	"github.com/clovers4/gres/proto"
 	"github.com/clovers4/gres/util"
 )
 
+var (
+	ErrInvalidOffset = errors.New("ERR offset is out of range")
+)
+
 // PLAIN
 func init() {
-	registerCmd("set", 3, setCmd) // todo:
-	registerCmd("get", 2, getCmd)
-	registerCmd("getset", 3, getsetCmd)
+	registerCmd("set", -3, cmdWrite|cmdDenyOOM|catString, setCmd)
+	registerCmd("setnx", 3, cmdWrite|cmdDenyOOM|catString, setnxCmd)
+	registerCmd("setex", 4, cmdWrite|cmdDenyOOM|catString, setexCmd)
+	registerCmd("psetex", 4, cmdWrite|cmdDenyOOM|catString, psetexCmd)
+	registerCmd("get", 2, cmdReadOnly|catString, getCmd)
+	registerCmd("getset", 3, cmdWrite|cmdDenyOOM|catString, getsetCmd)
 
-	registerCmd("incr", 2, incrCmd)
-	registerCmd("incrby", 3, incrbyCmd)
-	registerCmd("decr", 2, decrCmd)
-	registerCmd("decrby", 3, decrbyCmd)
+	registerCmd("strlen", 2, cmdReadOnly|catString, strlenCmd)
+	registerCmd("append", 3, cmdWrite|cmdDenyOOM|catString, appendCmd)
+	registerCmd("setrange", 4, cmdWrite|cmdDenyOOM|catString, setrangeCmd)
+	registerCmd("getrange", 4, cmdReadOnly|catString, getrangeCmd)
+
+	registerCmd("incr", 2, cmdWrite|cmdDenyOOM|catString, incrCmd)
+	registerCmd("incrby", 3, cmdWrite|cmdDenyOOM|catString, incrbyCmd)
+	registerCmd("incrbyfloat", 3, cmdWrite|cmdDenyOOM|catString, incrbyfloatCmd)
+	registerCmd("decr", 2, cmdWrite|cmdDenyOOM|catString, decrCmd)
+	registerCmd("decrby", 3, cmdWrite|cmdDenyOOM|catString, decrbyCmd)
+	registerCmd("mset", -3, cmdWrite|cmdDenyOOM|catString, msetCmd)
+	registerCmd("msetnx", -3, cmdWrite|cmdDenyOOM|catString, msetnxCmd)
+	registerCmd("mget", -2, cmdReadOnly|catString, mgetCmd)
 }
 
+// SET key value [NX | XX] [GET] [EX seconds | PX milliseconds |
+// EXAT unix-time-seconds | PXAT unix-time-milliseconds | KEEPTTL]
 func setCmd(db *engine.DB, args []string) *proto.Reply {
 	key := args[1]
 	val := args[2]
 
-	if valNum, ok := util.String2Num(val); ok {
-		err := db.Set(key, valNum)
-		return proto.NewReply(proto.ReplyKindStatus, "OK", err)
+	opts, err := parseSetOptions(args)
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, err)
+	}
+
+	ok, old, err := db.SetWithOptions(key, []byte(val), opts)
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, err)
+	}
+	if opts.Get {
+		return proto.NewReply(proto.ReplyKindBlukString, old, nil)
+	}
+	if !ok {
+		return proto.NewReply(proto.ReplyKindNull, nil, nil)
+	}
+	return proto.NewReply(proto.ReplyKindStatus, "OK", nil)
+}
+
+func parseSetOptions(args []string) (engine.SetOptions, error) {
+	var opts engine.SetOptions
+	expire := "" // 已指定的过期选项, 只能有一个
+	for i := 3; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+		switch opt {
+		case "nx":
+			opts.NX = true
+		case "xx":
+			opts.XX = true
+		case "get":
+			opts.Get = true
+		case "keepttl":
+			if expire != "" {
+				return opts, ErrSyntax
+			}
+			expire = opt
+			opts.KeepTTL = true
+		case "ex", "px", "exat", "pxat":
+			if expire != "" || i+1 == len(args) {
+				return opts, ErrSyntax
+			}
+			expire = opt
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return opts, errs.ErrIsNotInt
+			}
+			at, ok := expireAtMs(opt, n, time.Now())
+			if !ok {
+				return opts, fmt.Errorf("ERR invalid expire time in '%s' command", args[0])
+			}
+			opts.ExpireAt = at
+		default:
+			return opts, ErrSyntax
+		}
+	}
+	if opts.NX && opts.XX {
+		return opts, ErrSyntax
+	}
+	return opts, nil
+}
+
+// expireAtMs converts the argument of EX, PX, EXAT or PXAT into unix milliseconds.
+// It reports false if n is not positive or the result overflows.
+func expireAtMs(opt string, n int64, now time.Time) (int64, bool) {
+	if n <= 0 {
+		return 0, false
+	}
+	return unixMs(opt, n, util.UnixMs(now))
+}
+
+func setnxCmd(db *engine.DB, args []string) *proto.Reply {
+	key := args[1]
+	val := args[2]
+
+	ok, err := db.SetNX(key, []byte(val))
+	return proto.NewReply(proto.ReplyKindInt, boolToInt(ok), err)
+}
+
+func setexCmd(db *engine.DB, args []string) *proto.Reply {
+	return setexGeneric(db, args, time.Second)
+}
+
+func psetexCmd(db *engine.DB, args []string) *proto.Reply {
+	return setexGeneric(db, args, time.Millisecond)
+}
+
+// SETEX key seconds value, PSETEX key milliseconds value
+func setexGeneric(db *engine.DB, args []string, unit time.Duration) *proto.Reply {
+	key := args[1]
+	val := args[3]
+
+	ttl, err := util.String2Int(args[2])
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, errs.ErrIsNotInt)
 	}
-	err := db.Set(key, val)
+	if ttl <= 0 {
+		return proto.NewReply(proto.ReplyKindErr, nil, fmt.Errorf("ERR invalid expire time in '%s' command", args[0]))
+	}
+
+	err = db.SetEx(key, []byte(val), time.Duration(ttl)*unit)
 	return proto.NewReply(proto.ReplyKindStatus, "OK", err)
 }
 
@@ -42,14 +161,57 @@ func getsetCmd(db *engine.DB, args []string) *proto.Reply {
 	key := args[1]
 	val := args[2]
 
-	if valNum, ok := util.String2Num(val); ok {
-		oldVal, err := db.GetSet(key, valNum)
-		return proto.NewReply(proto.ReplyKindBlukString, oldVal, err)
-	}
-	oldVal, err := db.GetSet(key, val)
+	oldVal, err := db.GetSet(key, []byte(val))
 	return proto.NewReply(proto.ReplyKindBlukString, oldVal, err)
 }
 
+func strlenCmd(db *engine.DB, args []string) *proto.Reply {
+	key := args[1]
+
+	n, err := db.StrLen(key)
+	return proto.NewReply(proto.ReplyKindInt, n, err)
+}
+
+func appendCmd(db *engine.DB, args []string) *proto.Reply {
+	key := args[1]
+	val := args[2]
+
+	n, err := db.Append(key, []byte(val))
+	return proto.NewReply(proto.ReplyKindInt, n, err)
+}
+
+func setrangeCmd(db *engine.DB, args []string) *proto.Reply {
+	key := args[1]
+	val := args[3]
+
+	offset, err := util.String2Int(args[2])
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, errs.ErrIsNotInt)
+	}
+	if offset < 0 {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrInvalidOffset)
+	}
+
+	n, err := db.SetRange(key, offset, []byte(val))
+	return proto.NewReply(proto.ReplyKindInt, n, err)
+}
+
+func getrangeCmd(db *engine.DB, args []string) *proto.Reply {
+	key := args[1]
+
+	start, err := util.String2Int(args[2])
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, errs.ErrIsNotInt)
+	}
+	end, err := util.String2Int(args[3])
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, errs.ErrIsNotInt)
+	}
+
+	val, err := db.GetRange(key, start, end)
+	return proto.NewReply(proto.ReplyKindBlukString, val, err)
+}
+
 func incrCmd(db *engine.DB, args []string) *proto.Reply {
 	key := args[1]
 	val, err := db.Incr(key)
@@ -87,3 +249,63 @@ func decrbyCmd(db *engine.DB, args []string) *proto.Reply {
 	afterVal, err := db.DecrBy(key, valInt)
 	return proto.NewReply(proto.ReplyKindInt, afterVal, err)
 }
+
+func incrbyfloatCmd(db *engine.DB, args []string) *proto.Reply {
+	key := args[1]
+
+	incr, err := strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, errs.ErrIsNotFloat)
+	}
+
+	val, err := db.IncrByFloat(key, incr)
+	return proto.NewReply(proto.ReplyKindBlukString, val, err)
+}
+
+// MSET key value [key value ...]
+func msetCmd(db *engine.DB, args []string) *proto.Reply {
+	keys, vals, err := parseKeyValues(args[1:])
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, err)
+	}
+
+	err = db.MSet(keys, vals)
+	return proto.NewReply(proto.ReplyKindStatus, "OK", err)
+}
+
+// MSETNX key value [key value ...]
+func msetnxCmd(db *engine.DB, args []string) *proto.Reply {
+	keys, vals, err := parseKeyValues(args[1:])
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, err)
+	}
+
+	ok, err := db.MSetNX(keys, vals)
+	return proto.NewReply(proto.ReplyKindInt, boolToInt(ok), err)
+}
+
+func mgetCmd(db *engine.DB, args []string) *proto.Reply {
+	vals := db.MGet(args[1:]...)
+	return proto.NewReply(proto.ReplyKindArrays, vals, nil)
+}
+
+func parseKeyValues(args []string) ([]string, [][]byte, error) {
+	if len(args)%2 != 0 {
+		return nil, nil, ErrWrongNumArgs
+	}
+
+	keys := make([]string, 0, len(args)/2)
+	vals := make([][]byte, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		keys = append(keys, args[i])
+		vals = append(vals, []byte(args[i+1]))
+	}
+	return keys, vals, nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
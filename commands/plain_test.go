@@ -0,0 +1,62 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/clovers4/gres/engine"
+	"github.com/clovers4/gres/errs"
+	"github.com/clovers4/gres/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStringCmds(t *testing.T) {
+	s := newTestSession(engine.NewDB())
+
+	// 值原样保存, 不会被转换成数字
+	assert.Equal(t, "OK", s.do("set", "a", "007").Val)
+	assert.Equal(t, []byte("007"), s.do("get", "a").Val)
+	assert.Equal(t, errs.ErrIsNotInt, s.do("incr", "a").Err)
+
+	assert.Equal(t, 1, s.do("setnx", "b", "1").Val)
+	assert.Equal(t, 0, s.do("setnx", "b", "2").Val)
+	assert.Equal(t, 3, s.do("append", "b", "23").Val)
+	assert.Equal(t, 3, s.do("strlen", "b").Val)
+	assert.Equal(t, []byte("23"), s.do("getrange", "b", "1", "-1").Val)
+	assert.Equal(t, 5, s.do("setrange", "b", "3", "45").Val)
+	assert.Equal(t, ErrInvalidOffset, s.do("setrange", "b", "-1", "x").Err)
+	assert.Equal(t, []byte("12346.5"), s.do("incrbyfloat", "b", "1.5").Val)
+	assert.Equal(t, errs.ErrIsNotFloat, s.do("incrbyfloat", "b", "x").Err)
+
+	assert.Equal(t, "OK", s.do("setex", "c", "10", "v").Val)
+	assert.Equal(t, "OK", s.do("psetex", "c", "10000", "v").Val)
+	assert.Equal(t, "ERR invalid expire time in 'setex' command", s.do("setex", "c", "0", "v").Err.Error())
+
+	assert.Equal(t, "OK", s.do("mset", "k1", "v1", "k2", "v2").Val)
+	assert.Equal(t, ErrWrongNumArgs, s.do("mset", "k1", "v1", "k2").Err)
+	assert.Equal(t, 0, s.do("msetnx", "k2", "x", "k3", "x").Val)
+	assert.Equal(t, 1, s.do("msetnx", "k3", "v3").Val)
+	assert.Equal(t, []interface{}{[]byte("v1"), nil, []byte("v3")}, s.do("mget", "k1", "none", "k3").Val)
+}
+
+func TestSetOptions(t *testing.T) {
+	s := newTestSession(engine.NewDB())
+
+	assert.Equal(t, "OK", s.do("set", "a", "1", "EX", "100").Val)
+	assert.Equal(t, 100, s.do("ttl", "a").Val)
+	assert.Equal(t, proto.ReplyKindNull, int(s.do("set", "a", "2", "nx").Kind))
+	assert.Equal(t, []byte("1"), s.do("set", "a", "2", "xx", "keepttl", "get").Val)
+	assert.Equal(t, 100, s.do("ttl", "a").Val)
+	assert.Equal(t, "OK", s.do("set", "a", "3", "px", "5000").Val)
+	assert.True(t, s.do("ttl", "a").Val.(int) <= 5)
+	assert.Nil(t, s.do("set", "b", "1", "get").Val)
+
+	assert.Equal(t, ErrSyntax, s.do("set", "a", "1", "nx", "xx").Err)
+	assert.Equal(t, ErrSyntax, s.do("set", "a", "1", "ex", "10", "px", "10").Err)
+	assert.Equal(t, ErrSyntax, s.do("set", "a", "1", "ex", "10", "keepttl").Err)
+	assert.Equal(t, ErrSyntax, s.do("set", "a", "1", "ex").Err)
+	assert.Equal(t, ErrSyntax, s.do("set", "a", "1", "foo").Err)
+	assert.Equal(t, errs.ErrIsNotInt, s.do("set", "a", "1", "ex", "x").Err)
+	assert.Equal(t, "ERR invalid expire time in 'set' command", s.do("set", "a", "1", "exat", "0").Err.Error())
+	assert.Equal(t, "ERR invalid expire time in 'set' command", s.do("set", "a", "1", "ex", "9223372036854775807").Err.Error())
+	assert.Equal(t, []byte("3"), s.do("get", "a").Val)
+}
@@ -0,0 +1,107 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/clovers4/gres/engine"
+	"github.com/clovers4/gres/proto"
+)
+
+var (
+	ErrUnknownSubCmd = errors.New("ERR unknown subcommand")
+)
+
+// PUBSUB
+func init() {
+	registerCtxCmd("subscribe", -2, cmdPubSub|cmdNoTx|catPubSub, subscribeCmd)
+	registerCtxCmd("unsubscribe", -1, cmdPubSub|cmdNoTx|catPubSub, unsubscribeCmd)
+	registerCtxCmd("psubscribe", -2, cmdPubSub|cmdNoTx|catPubSub, psubscribeCmd)
+	registerCtxCmd("punsubscribe", -1, cmdPubSub|cmdNoTx|catPubSub, punsubscribeCmd)
+	registerCtxCmd("publish", 3, catPubSub, publishCmd)
+	registerCtxCmd("pubsub", -2, catPubSub, pubsubCmd)
+}
+
+// subscribeCmd replies nothing, the replies are pushed by the session.
+func subscribeCmd(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	session := CtxGetSession(ctx)
+	if session == nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrNoSession)
+	}
+	session.Subscribe(args[1:]...)
+	return nil
+}
+
+func unsubscribeCmd(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	session := CtxGetSession(ctx)
+	if session == nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrNoSession)
+	}
+	session.Unsubscribe(args[1:]...)
+	return nil
+}
+
+func psubscribeCmd(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	session := CtxGetSession(ctx)
+	if session == nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrNoSession)
+	}
+	session.PSubscribe(args[1:]...)
+	return nil
+}
+
+func punsubscribeCmd(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	session := CtxGetSession(ctx)
+	if session == nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrNoSession)
+	}
+	session.PUnsubscribe(args[1:]...)
+	return nil
+}
+
+func publishCmd(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	session := CtxGetSession(ctx)
+	if session == nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrNoSession)
+	}
+	n := session.PubSub().Publish(args[1], args[2])
+	return proto.NewReply(proto.ReplyKindInt, n, nil)
+}
+
+func pubsubCmd(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	session := CtxGetSession(ctx)
+	if session == nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrNoSession)
+	}
+	ps := session.PubSub()
+
+	switch strings.ToLower(args[1]) {
+	case "channels":
+		if len(args) > 3 {
+			return proto.NewReply(proto.ReplyKindErr, nil, ErrWrongNumArgs)
+		}
+		var pattern string
+		if len(args) == 3 {
+			pattern = args[2]
+		}
+		channels := ps.Channels(pattern)
+		replies := make([]interface{}, 0, len(channels))
+		for _, channel := range channels {
+			replies = append(replies, channel)
+		}
+		return proto.NewReply(proto.ReplyKindArrays, replies, nil)
+	case "numsub":
+		replies := make([]interface{}, 0, 2*len(args[2:]))
+		for _, channel := range args[2:] {
+			replies = append(replies, channel, proto.NewReply(proto.ReplyKindInt, ps.NumSub(channel), nil))
+		}
+		return proto.NewReply(proto.ReplyKindArrays, replies, nil)
+	case "numpat":
+		if len(args) != 2 {
+			return proto.NewReply(proto.ReplyKindErr, nil, ErrWrongNumArgs)
+		}
+		return proto.NewReply(proto.ReplyKindInt, ps.NumPat(), nil)
+	}
+	return proto.NewReply(proto.ReplyKindErr, nil, ErrUnknownSubCmd)
+}
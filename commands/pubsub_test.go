@@ -0,0 +1,65 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/clovers4/gres/engine"
+	"github.com/clovers4/gres/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+// testPubSub maps a channel to the number of its subscribers.
+type testPubSub map[string]int
+
+func (ps testPubSub) Publish(channel, message string) int { return ps[channel] }
+
+func (ps testPubSub) Channels(pattern string) []string {
+	var channels []string
+	for channel := range ps {
+		if pattern == "" || pattern == channel {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+func (ps testPubSub) NumSub(channel string) int { return ps[channel] }
+
+func (ps testPubSub) NumPat() int { return 0 }
+
+func TestSubscribe(t *testing.T) {
+	s := newTestSession(engine.NewDB())
+
+	assert.Nil(t, s.do("subscribe", "a", "b"))
+	assert.Equal(t, []string{"a", "b"}, s.channels)
+	assert.Nil(t, s.do("unsubscribe"))
+	assert.Nil(t, s.channels)
+
+	// 事务中不能订阅
+	assert.Equal(t, "OK", s.do("multi").Val)
+	assert.Equal(t, ErrNotAllowedInTx, s.do("subscribe", "a").Err)
+	assert.Equal(t, ErrExecAbort, s.do("exec").Err)
+
+	assert.True(t, SubscribedAllowed("psubscribe"))
+	assert.True(t, SubscribedAllowed("ping"))
+	assert.False(t, SubscribedAllowed("publish"))
+}
+
+func TestPublish(t *testing.T) {
+	s := newTestSession(engine.NewDB())
+	s.pubsub = testPubSub{"a": 2}
+
+	assert.Equal(t, 2, s.do("publish", "a", "hello").Val)
+	assert.Equal(t, 0, s.do("publish", "b", "hello").Val)
+
+	assert.Equal(t, []interface{}{"a"}, s.do("pubsub", "channels").Val)
+	reply := s.do("pubsub", "numsub", "a", "b")
+	assert.Equal(t, proto.ReplyKindArrays, int(reply.Kind))
+	vals := reply.Val.([]interface{})
+	assert.Equal(t, "a", vals[0])
+	assert.Equal(t, 2, vals[1].(*proto.Reply).Val)
+	assert.Equal(t, "b", vals[2])
+	assert.Equal(t, 0, vals[3].(*proto.Reply).Val)
+	assert.Equal(t, 0, s.do("pubsub", "numpat").Val)
+	assert.Equal(t, ErrUnknownSubCmd, s.do("pubsub", "foo").Err)
+}
@@ -0,0 +1,81 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/clovers4/gres/engine"
+	"github.com/clovers4/gres/proto"
+)
+
+var (
+	ErrRaftDisabled    = errors.New("ERR raft mode is not enabled")
+	ErrRaftUnsupported = errors.New("ERR the command is not supported in raft mode")
+)
+
+// RAFT
+func init() {
+	registerCtxCmd("raft", -2, cmdNoTx|catAdmin|catDangerous, raftCmd)
+}
+
+// AccessesKeys reports whether the command reads or writes the keys, which goes
+// through the leader in raft mode.
+func AccessesKeys(name string) bool {
+	c, ok := commands[name].(*cmd)
+	return ok && c.flags&catData != 0
+}
+
+// RAFT MESSAGE payload | RAFT ADDNODE id | RAFT REMOVENODE id | RAFT NODES | RAFT STATUS
+func raftCmd(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	session := CtxGetSession(ctx)
+	if session == nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrNoSession)
+	}
+	r := session.Raft()
+	if r == nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrRaftDisabled)
+	}
+
+	switch strings.ToLower(args[1]) {
+	case "message":
+		if len(args) != 3 {
+			return proto.NewReply(proto.ReplyKindErr, nil, ErrWrongNumArgs)
+		}
+		resp, err := r.Message(args[2])
+		return proto.NewReply(proto.ReplyKindBlukString, resp, err)
+	case "addnode", "removenode":
+		if len(args) != 3 {
+			return proto.NewReply(proto.ReplyKindErr, nil, ErrWrongNumArgs)
+		}
+		var err error
+		if strings.ToLower(args[1]) == "addnode" {
+			err = r.AddNode(ctx, args[2])
+		} else {
+			err = r.RemoveNode(ctx, args[2])
+		}
+		return proto.NewReply(proto.ReplyKindStatus, "OK", err)
+	case "nodes":
+		if len(args) != 2 {
+			return proto.NewReply(proto.ReplyKindErr, nil, ErrWrongNumArgs)
+		}
+		var nodes []interface{}
+		for _, id := range r.Nodes() {
+			nodes = append(nodes, id)
+		}
+		if nodes == nil {
+			nodes = []interface{}{}
+		}
+		return proto.NewReply(proto.ReplyKindArrays, nodes, nil)
+	case "status":
+		if len(args) != 2 {
+			return proto.NewReply(proto.ReplyKindErr, nil, ErrWrongNumArgs)
+		}
+		var fields []interface{}
+		for _, f := range r.Info() {
+			fields = append(fields, f)
+		}
+		return proto.NewReply(proto.ReplyKindMap, fields, nil)
+	}
+	return proto.NewReply(proto.ReplyKindErr, nil, ErrUnknownSubCmd)
+}
@@ -0,0 +1,69 @@
+package commands
+
+import (
+	"context"
+	"testing"
+
+	"github.com/clovers4/gres/engine"
+	"github.com/clovers4/gres/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+type testRaft struct {
+	nodes []string
+}
+
+func (r *testRaft) Message(payload string) (string, error) {
+	return "re:" + payload, nil
+}
+
+func (r *testRaft) AddNode(ctx context.Context, id string) error {
+	r.nodes = append(r.nodes, id)
+	return nil
+}
+
+func (r *testRaft) RemoveNode(ctx context.Context, id string) error {
+	for i, n := range r.nodes {
+		if n == id {
+			r.nodes = append(r.nodes[:i], r.nodes[i+1:]...)
+			return nil
+		}
+	}
+	return ErrSyntax
+}
+
+func (r *testRaft) Nodes() []string { return r.nodes }
+
+func (r *testRaft) Info() []string {
+	return []string{"raft_state", "leader"}
+}
+
+func TestRaftCmd(t *testing.T) {
+	s := newTestSession(engine.NewDB())
+	assert.Equal(t, ErrRaftDisabled, s.do("raft", "nodes").Err)
+	assert.Contains(t, s.do("info", "raft").Val, "raft_enabled:0\r\n")
+
+	s.raft = &testRaft{nodes: []string{"a"}}
+	assert.Equal(t, "re:msg", s.do("raft", "message", "msg").Val)
+	assert.Equal(t, "OK", s.do("raft", "addnode", "b").Val)
+	assert.Equal(t, []interface{}{"a", "b"}, s.do("raft", "NODES").Val)
+	assert.Equal(t, "OK", s.do("raft", "removenode", "a").Val)
+	assert.Equal(t, ErrSyntax, s.do("raft", "removenode", "a").Err)
+	assert.Equal(t, []interface{}{"b"}, s.do("raft", "nodes").Val)
+
+	reply := s.do("raft", "status")
+	assert.Equal(t, proto.ReplyKindMap, int(reply.Kind))
+	assert.Equal(t, []interface{}{"raft_state", "leader"}, reply.Val)
+	assert.Contains(t, s.do("info", "raft").Val, "raft_enabled:1\r\nraft_state:leader\r\n")
+
+	assert.Equal(t, ErrWrongNumArgs, s.do("raft", "addnode").Err)
+	assert.Equal(t, ErrUnknownSubCmd, s.do("raft", "foo").Err)
+}
+
+func TestAccessesKeys(t *testing.T) {
+	assert.True(t, AccessesKeys("get"))
+	assert.True(t, AccessesKeys("blpop"))
+	assert.False(t, AccessesKeys("ping"))
+	assert.False(t, AccessesKeys("raft"))
+	assert.False(t, AccessesKeys("nosuchcmd"))
+}
@@ -0,0 +1,124 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clovers4/gres/engine"
+	"github.com/clovers4/gres/proto"
+)
+
+var (
+	ErrReadOnlyReplica = errors.New("READONLY You can't write against a read only replica.")
+	ErrInvalidPort     = errors.New("ERR Invalid master port")
+)
+
+// REPLICATION
+func init() {
+	registerCtxCmd("replicaof", 3, cmdNoTx|catAdmin|catDangerous, replicaofCmd)
+	registerCtxCmd("slaveof", 3, cmdNoTx|catAdmin|catDangerous, replicaofCmd)
+	registerCtxCmd("role", 1, catAdmin|catDangerous, roleCmd)
+	registerCtxCmd("replconf", -1, cmdNoTx|catAdmin|catDangerous, replconfCmd)
+	registerCtxCmd("psync", 3, cmdNoTx|catAdmin|catDangerous, syncCmd)
+	registerCtxCmd("sync", 1, cmdNoTx|catAdmin|catDangerous, syncCmd)
+	registerCtxCmd("wait", 3, cmdNoTx|cmdBlocking|catConnection, waitCmd)
+}
+
+// IsWrite reports whether the command may modify the dataset, which is rejected
+// by a read-only replica. The blocking commands of the data types, such as BLPOP,
+// propagate by themselves, but are writes too.
+func IsWrite(name string) bool {
+	c, ok := commands[name].(*cmd)
+	return ok && (c.flags&cmdWrite != 0 || c.flags&cmdBlocking != 0 && c.flags&catData != 0)
+}
+
+// REPLICAOF host port | REPLICAOF NO ONE
+func replicaofCmd(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	session := CtxGetSession(ctx)
+	if session == nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrNoSession)
+	}
+
+	host, port := args[1], args[2]
+	if strings.EqualFold(host, "no") && strings.EqualFold(port, "one") {
+		host, port = "", ""
+	} else if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrInvalidPort)
+	}
+	err := session.Replication().ReplicaOf(host, port)
+	return proto.NewReply(proto.ReplyKindStatus, "OK", err)
+}
+
+func roleCmd(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	session := CtxGetSession(ctx)
+	if session == nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrNoSession)
+	}
+	return proto.NewReply(proto.ReplyKindArrays, session.Replication().Role(), nil)
+}
+
+// REPLCONF option value [option value ...], sent by the replica before PSYNC.
+func replconfCmd(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	session := CtxGetSession(ctx)
+	if session == nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrNoSession)
+	}
+	if len(args)%2 == 0 {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrSyntax)
+	}
+
+	for i := 1; i < len(args); i += 2 {
+		err := session.Replication().ReplConf(session.ID(), strings.ToLower(args[i]), args[i+1])
+		if err != nil {
+			return proto.NewReply(proto.ReplyKindErr, nil, err)
+		}
+	}
+	return proto.NewReply(proto.ReplyKindStatus, "OK", nil)
+}
+
+// PSYNC replid offset | SYNC. SYNC always gets a full sync. The reply is written by
+// the server, with the snapshot or the commands to continue from.
+func syncCmd(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	session := CtxGetSession(ctx)
+	if session == nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrNoSession)
+	}
+
+	replID, offset := "?", int64(-1)
+	if len(args) == 3 {
+		var err error
+		if offset, err = strconv.ParseInt(args[2], 10, 64); err != nil {
+			return proto.NewReply(proto.ReplyKindErr, nil, ErrWrongTypeInt)
+		}
+		replID = args[1]
+	}
+	if err := session.Replication().Sync(session.ID(), replID, offset); err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, err)
+	}
+	return nil
+}
+
+// WAIT numreplicas timeout, in milliseconds.
+func waitCmd(ctx context.Context, db *engine.DB, args []string) *proto.Reply {
+	session := CtxGetSession(ctx)
+	if session == nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrNoSession)
+	}
+	numReplicas, err := strconv.Atoi(args[1])
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrWrongTypeInt)
+	}
+	timeout, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrWrongTypeInt)
+	}
+	if timeout < 0 {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrTimeoutNegative)
+	}
+
+	n, err := session.Replication().Wait(ctx, numReplicas, time.Duration(timeout)*time.Millisecond)
+	return proto.NewReply(proto.ReplyKindInt, n, err)
+}
@@ -0,0 +1,103 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/clovers4/gres/engine"
+	"github.com/clovers4/gres/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+type testReplication struct {
+	primary string
+	conf    map[string]string
+	synced  int64
+	psync   []interface{}
+	waited  time.Duration
+}
+
+func (r *testReplication) ReplicaOf(host, port string) error {
+	r.primary = host + ":" + port
+	return nil
+}
+
+func (r *testReplication) Role() []interface{} {
+	return []interface{}{"master", int64(0), []interface{}{}}
+}
+
+func (r *testReplication) ReplConf(id int64, option, value string) error {
+	if option != "listening-port" {
+		return errors.New("ERR Unrecognized REPLCONF option")
+	}
+	if r.conf == nil {
+		r.conf = make(map[string]string)
+	}
+	r.conf[option] = value
+	return nil
+}
+
+func (r *testReplication) Sync(id int64, replID string, offset int64) error {
+	r.synced = id
+	r.psync = []interface{}{replID, offset}
+	return nil
+}
+
+func (r *testReplication) Wait(ctx context.Context, numReplicas int, timeout time.Duration) (int, error) {
+	r.waited = timeout
+	return 0, nil
+}
+
+func (r *testReplication) Info() []string {
+	return []string{"role", "master", "connected_slaves", "0"}
+}
+
+func TestReplicationCmd(t *testing.T) {
+	s := newTestSession(engine.NewDB())
+
+	assert.Equal(t, "OK", s.do("replicaof", "127.0.0.1", "6379").Val)
+	assert.Equal(t, "127.0.0.1:6379", s.repl.primary)
+	assert.Equal(t, "OK", s.do("slaveof", "NO", "one").Val)
+	assert.Equal(t, ":", s.repl.primary)
+	assert.Equal(t, ErrInvalidPort, s.do("replicaof", "127.0.0.1", "port").Err)
+	assert.Equal(t, ErrInvalidPort, s.do("replicaof", "127.0.0.1", "0").Err)
+
+	reply := s.do("role")
+	assert.Equal(t, proto.ReplyKindArrays, int(reply.Kind))
+	assert.Equal(t, "master", reply.Val.([]interface{})[0])
+
+	assert.Equal(t, "OK", s.do("replconf", "listening-port", "6380").Val)
+	assert.Equal(t, "6380", s.repl.conf["listening-port"])
+	assert.Equal(t, ErrSyntax, s.do("replconf", "listening-port").Err)
+	assert.NotNil(t, s.do("replconf", "foo", "bar").Err)
+
+	// 回复由服务器与快照一起写入
+	assert.Nil(t, s.do("psync", "?", "-1"))
+	assert.Equal(t, s.ID(), s.repl.synced)
+	assert.Nil(t, s.do("psync", "abc", "101"))
+	assert.Equal(t, []interface{}{"abc", int64(101)}, s.repl.psync)
+	assert.Equal(t, ErrWrongTypeInt, s.do("psync", "abc", "x").Err)
+	assert.Nil(t, s.do("sync"))
+	assert.Equal(t, []interface{}{"?", int64(-1)}, s.repl.psync)
+
+	assert.Equal(t, 0, s.do("wait", "1", "100").Val)
+	assert.Equal(t, 100*time.Millisecond, s.repl.waited)
+	assert.Equal(t, ErrTimeoutNegative, s.do("wait", "1", "-1").Err)
+	assert.Equal(t, ErrWrongTypeInt, s.do("wait", "x", "0").Err)
+
+	assert.Contains(t, s.do("info", "replication").Val, "# Replication\r\nrole:master\r\nconnected_slaves:0\r\n")
+
+	s.do("multi")
+	assert.Equal(t, ErrNotAllowedInTx, s.do("replicaof", "no", "one").Err)
+}
+
+func TestIsWrite(t *testing.T) {
+	assert.True(t, IsWrite("set"))
+	assert.True(t, IsWrite("blpop"))
+	assert.False(t, IsWrite("get"))
+	assert.False(t, IsWrite("wait"))
+	assert.False(t, IsWrite("replicaof"))
+	assert.False(t, IsWrite("nosuchcmd"))
+}
@@ -0,0 +1,45 @@
+package commands
+
+import (
+	"strconv"
+
+	"github.com/clovers4/gres/engine"
+	"github.com/clovers4/gres/proto"
+)
+
+// SERVER
+func init() {
+	registerCmd("bgrewriteaof", 1, catAdmin|catDangerous, bgrewriteaofCmd)
+	registerCmd("swapdb", 3, cmdWrite|catKeyspace|catDangerous, swapdbCmd)
+	registerCmd("flushdb", 1, cmdWrite|catKeyspace|catDangerous, flushdbCmd)
+	registerCmd("flushall", 1, cmdWrite|catKeyspace|catDangerous, flushallCmd)
+}
+
+func bgrewriteaofCmd(db *engine.DB, args []string) *proto.Reply {
+	err := db.BgRewriteAppendOnly()
+	return proto.NewReply(proto.ReplyKindStatus, "Background append only file rewriting started", err)
+}
+
+func swapdbCmd(db *engine.DB, args []string) *proto.Reply {
+	i, err := strconv.Atoi(args[1])
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrInvalidDbIndex)
+	}
+	j, err := strconv.Atoi(args[2])
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrInvalidDbIndex)
+	}
+
+	err = db.SwapDB(i, j)
+	return proto.NewReply(proto.ReplyKindStatus, "OK", err)
+}
+
+func flushdbCmd(db *engine.DB, args []string) *proto.Reply {
+	db.FlushDB()
+	return proto.NewReply(proto.ReplyKindStatus, "OK", nil)
+}
+
+func flushallCmd(db *engine.DB, args []string) *proto.Reply {
+	db.FlushAll()
+	return proto.NewReply(proto.ReplyKindStatus, "OK", nil)
+}
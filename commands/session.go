@@ -0,0 +1,123 @@
+package commands
+
+import (
+	"context"
+	"time"
+
+	"github.com/clovers4/gres/engine"
+)
+
+// Session is the state of a connection, which some commands change.
+type Session interface {
+	// DB returns the db selected by the connection.
+	DB() *engine.DB
+	// SelectDB changes the db used by the following commands of the connection.
+	SelectDB(db *engine.DB)
+	// Tx returns the transaction state of the connection.
+	Tx() *Tx
+	// ID returns the unique id of the connection.
+	ID() int64
+	// Proto returns the version of the protocol used by the connection, RESP2 by default.
+	Proto() int
+	// SetProto changes the protocol of the connection, including the reply of the current command.
+	SetProto(protover int)
+	// SetName sets the name of the connection.
+	SetName(name string)
+	// User returns the user authenticated by the connection, nil if it must AUTH first.
+	User() *User
+	// SetUser changes the user of the connection after AUTH.
+	SetUser(u *User)
+	// ACL returns the users shared by all connections.
+	ACL() *ACL
+
+	// Subscribe, Unsubscribe, PSubscribe and PUnsubscribe change the
+	// subscriptions of the connection. The replies are pushed to the
+	// connection in order with the messages, so the commands reply nothing.
+	Subscribe(channels ...string)
+	Unsubscribe(channels ...string)
+	PSubscribe(patterns ...string)
+	PUnsubscribe(patterns ...string)
+	// PubSub returns the channel registry shared by all connections.
+	PubSub() PubSub
+	// Config returns the configuration of the server.
+	Config() Config
+	// Replication returns the replication state of the server.
+	Replication() Replication
+	// Raft returns the raft node of the server, nil if raft mode is not enabled.
+	Raft() Raft
+}
+
+// Config is the configuration of the server, which is read and changed at runtime.
+type Config interface {
+	// ConfigGet returns the names and values of the parameters matching pattern.
+	ConfigGet(pattern string) []string
+	// ConfigSet changes the parameter, which takes effect at once.
+	ConfigSet(name, value string) error
+	// ConfigResetStat resets the statistics reported by INFO.
+	ConfigResetStat()
+	// ConfigRewrite writes the current configuration back to the config file.
+	ConfigRewrite() error
+}
+
+// Replication is the replication state of the server.
+type Replication interface {
+	// ReplicaOf makes the server a replica of the primary at host:port, which syncs
+	// in background, or a primary again if host is empty.
+	ReplicaOf(host, port string) error
+	// Role returns the reply of ROLE.
+	Role() []interface{}
+	// ReplConf sets an option, such as listening-port, of the replica connected by
+	// the session id.
+	ReplConf(id int64, option, value string) error
+	// Sync turns the connection of the session id into a replica after the command.
+	// The replica continues from offset of the stream replID if the backlog still
+	// covers it, or receives a snapshot, then the write commands after it.
+	Sync(id int64, replID string, offset int64) error
+	// Wait blocks until numReplicas replicas acknowledged the write commands before
+	// it, or timeout, 0 means no timeout. It returns the number of them.
+	Wait(ctx context.Context, numReplicas int, timeout time.Duration) (int, error)
+	// Info returns the names and values of the fields of INFO replication.
+	Info() []string
+}
+
+// Raft is the raft node of the server. The write commands go through its log, and
+// the reads of the keys are confirmed by the leader.
+type Raft interface {
+	// Message handles a message of the raft protocol from another node, and returns the reply.
+	Message(payload string) (string, error)
+	// AddNode adds the node of id, the address of the server, to the members.
+	AddNode(ctx context.Context, id string) error
+	// RemoveNode removes the node of id from the members.
+	RemoveNode(ctx context.Context, id string) error
+	// Nodes returns the ids of the members.
+	Nodes() []string
+	// Info returns the names and values of the fields of INFO raft.
+	Info() []string
+}
+
+// PubSub is the channel registry.
+type PubSub interface {
+	// Publish returns the number of clients received the message.
+	Publish(channel, message string) int
+	// Channels returns the channels having subscribers and matching pattern,
+	// or all of them if pattern is empty.
+	Channels(pattern string) []string
+	// NumSub returns the number of subscribers of the channel.
+	NumSub(channel string) int
+	// NumPat returns the number of subscriptions to patterns.
+	NumPat() int
+}
+
+const ctxSession = "session"
+
+func CtxWithSession(ctx context.Context, s Session) context.Context {
+	return context.WithValue(ctx, ctxSession, s)
+}
+
+func CtxGetSession(ctx context.Context) Session {
+	v := ctx.Value(ctxSession)
+	if v == nil {
+		return nil
+	}
+	return v.(Session)
+}
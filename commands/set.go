@@ -8,14 +8,14 @@ import (
 
 // SET
 func init() {
-	registerCmd("sadd", -3, saddCmd)
-	registerCmd("srem", -3, sremCmd)
-	registerCmd("scard", 2, scardCmd)
-	registerCmd("sismember", 3, sismemberCmd)
-	registerCmd("smembers", 2, smembersCmd)
-	registerCmd("sinter", -3, sinterCmd)
-	registerCmd("sunion", -3, sunionCmd)
-	registerCmd("sdiff", -3, sdiffCmd)
+	registerCmd("sadd", -3, cmdWrite|cmdDenyOOM|catSet, saddCmd)
+	registerCmd("srem", -3, cmdWrite|catSet, sremCmd)
+	registerCmd("scard", 2, cmdReadOnly|catSet, scardCmd)
+	registerCmd("sismember", 3, cmdReadOnly|catSet, sismemberCmd)
+	registerCmd("smembers", 2, cmdReadOnly|catSet, smembersCmd)
+	registerCmd("sinter", -3, cmdReadOnly|catSet, sinterCmd)
+	registerCmd("sunion", -3, cmdReadOnly|catSet, sunionCmd)
+	registerCmd("sdiff", -3, cmdReadOnly|catSet, sdiffCmd)
 }
 
 func saddCmd(db *engine.DB, args []string) *proto.Reply {
@@ -77,20 +77,20 @@ func sismemberCmd(db *engine.DB, args []string) *proto.Reply {
 func smembersCmd(db *engine.DB, args []string) *proto.Reply {
 	key := args[1]
 	vals, err := db.SMembers(key)
-	return proto.NewReply(proto.ReplyKindArrays, vals, err)
+	return proto.NewReply(proto.ReplyKindSet, vals, err)
 }
 
 func sinterCmd(db *engine.DB, args []string) *proto.Reply {
 	vals, err := db.SInter(args[1:]...)
-	return proto.NewReply(proto.ReplyKindArrays, vals, err)
+	return proto.NewReply(proto.ReplyKindSet, vals, err)
 }
 
 func sunionCmd(db *engine.DB, args []string) *proto.Reply {
 	vals, err := db.SUnion(args[1:]...)
-	return proto.NewReply(proto.ReplyKindArrays, vals, err)
+	return proto.NewReply(proto.ReplyKindSet, vals, err)
 }
 
 func sdiffCmd(db *engine.DB, args []string) *proto.Reply {
 	vals, err := db.SDiff(args[1:]...)
-	return proto.NewReply(proto.ReplyKindArrays, vals, err)
+	return proto.NewReply(proto.ReplyKindSet, vals, err)
 }
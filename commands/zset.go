@@ -10,28 +10,41 @@ import (
 
 // ZSET
 func init() {
-	registerCmd("zadd", 4, zaddCmd) //todo
-	registerCmd("zcard", 2, zcardCmd)
-	registerCmd("zscore", 3, zscoreCmd)
-	registerCmd("zrank", 3, zrankCmd)
-	registerCmd("zrem", -3, zremCmd)
-	registerCmd("zincrby", 4, zincrbyCmd)
-	registerCmd("zrange", -4, zrangeCmd)
+	registerCmd("zadd", -4, cmdWrite|cmdDenyOOM|catSortedSet, zaddCmd)
+	registerCmd("zcard", 2, cmdReadOnly|catSortedSet, zcardCmd)
+	registerCmd("zscore", 3, cmdReadOnly|catSortedSet, zscoreCmd)
+	registerCmd("zrank", 3, cmdReadOnly|catSortedSet, zrankCmd)
+	registerCmd("zrem", -3, cmdWrite|catSortedSet, zremCmd)
+	registerCmd("zincrby", 4, cmdWrite|cmdDenyOOM|catSortedSet, zincrbyCmd)
+	registerCmd("zrange", -4, cmdReadOnly|catSortedSet, zrangeCmd)
 }
 
+// ZADD key score member [score member ...]
 func zaddCmd(db *engine.DB, args []string) *proto.Reply {
 	key := args[1]
-	scoreS := args[2]
-	member := args[3]
+	if len(args)%2 != 0 {
+		return proto.NewReply(proto.ReplyKindErr, nil, ErrWrongNumArgs)
+	}
 
-	var score float64
-	var err error
-	if score, err = util.String2Float(scoreS); err != nil {
-		return proto.NewReply(proto.ReplyKindErr, nil, errs.ErrIsNotFloat)
+	// 先检查所有 score, 避免只添加了一部分
+	scores := make([]float64, 0, (len(args)-2)/2)
+	for i := 2; i < len(args); i += 2 {
+		score, err := util.String2Float(args[i])
+		if err != nil {
+			return proto.NewReply(proto.ReplyKindErr, nil, errs.ErrIsNotFloat)
+		}
+		scores = append(scores, score)
 	}
 
-	count, err := db.ZAdd(key, score, member)
-	return proto.NewReply(proto.ReplyKindInt, count, err)
+	total := 0
+	for i, score := range scores {
+		count, err := db.ZAdd(key, score, args[3+2*i])
+		if err != nil {
+			return proto.NewReply(proto.ReplyKindErr, nil, err)
+		}
+		total += count
+	}
+	return proto.NewReply(proto.ReplyKindInt, total, nil)
 }
 
 func zcardCmd(db *engine.DB, args []string) *proto.Reply {
@@ -47,7 +60,7 @@ func zscoreCmd(db *engine.DB, args []string) *proto.Reply {
 	if score == nil {
 		return proto.NewReply(proto.ReplyKindBlukString, nil, err)
 	}
-	return proto.NewReply(proto.ReplyKindBlukString, score, err)
+	return proto.NewReply(proto.ReplyKindDouble, *score, err)
 }
 
 func zrankCmd(db *engine.DB, args []string) *proto.Reply {
@@ -80,7 +93,7 @@ func zincrbyCmd(db *engine.DB, args []string) *proto.Reply {
 	}
 
 	count, err := db.ZIncrBy(key, score, member)
-	return proto.NewReply(proto.ReplyKindBlukString, count, err)
+	return proto.NewReply(proto.ReplyKindDouble, count, err)
 }
 
 func zrangeCmd(db *engine.DB, args []string) *proto.Reply {
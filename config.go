@@ -0,0 +1,610 @@
+package gres
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clovers4/gres/commands"
+	"github.com/clovers4/gres/engine"
+	"github.com/clovers4/gres/util"
+)
+
+var ErrNoConfigFile = errors.New("ERR The server is running without a config file")
+
+// configParam is a parameter of the config file, which is also read by CONFIG GET
+// and changed by CONFIG SET.
+type configParam struct {
+	name string
+	get  func(opts *serverOptions) string
+	set  func(opts *serverOptions, val string) error
+	// apply applies the changed opts to the running server, nil if the parameter
+	// can only be set on startup.
+	apply func(srv *Server, opts *serverOptions) error
+}
+
+// configParams are in the order of CONFIG GET and CONFIG REWRITE.
+var configParams = []*configParam{
+	{
+		name: "port",
+		get:  func(opts *serverOptions) string { return strconv.Itoa(opts.port) },
+		set: func(opts *serverOptions, val string) error {
+			return parseInt(val, 0, 65535, &opts.port)
+		},
+	},
+	{
+		name: "bind",
+		get:  func(opts *serverOptions) string { return opts.bind },
+		set: func(opts *serverOptions, val string) error {
+			if len(strings.Fields(val)) == 0 {
+				return errors.New("at least one address is required")
+			}
+			opts.bind = strings.Join(strings.Fields(val), " ")
+			return nil
+		},
+	},
+	stringParam("unixsocket", func(opts *serverOptions) *string { return &opts.unixSocket }),
+	{
+		name: "unixsocketperm",
+		get:  func(opts *serverOptions) string { return strconv.FormatUint(uint64(opts.unixSocketPerm), 8) },
+		set: func(opts *serverOptions, val string) error {
+			return parsePerm(val, &opts.unixSocketPerm)
+		},
+	},
+	{
+		name: "tls-port",
+		get:  func(opts *serverOptions) string { return strconv.Itoa(opts.tlsPort) },
+		set: func(opts *serverOptions, val string) error {
+			return parseInt(val, 0, 65535, &opts.tlsPort)
+		},
+	},
+	stringParam("tls-cert-file", func(opts *serverOptions) *string { return &opts.tlsCertFile }),
+	stringParam("tls-key-file", func(opts *serverOptions) *string { return &opts.tlsKeyFile }),
+	stringParam("tls-ca-cert-file", func(opts *serverOptions) *string { return &opts.tlsCACertFile }),
+	{
+		name: "tls-auth-clients",
+		get:  func(opts *serverOptions) string { return string(opts.tlsAuthClients) },
+		set: func(opts *serverOptions, val string) (err error) {
+			opts.tlsAuthClients, err = parseTLSAuthClients(val)
+			return err
+		},
+	},
+	{
+		name: "tls-min-version",
+		get:  func(opts *serverOptions) string { return util.FormatTLSVersion(opts.tlsMinVersion) },
+		set: func(opts *serverOptions, val string) (err error) {
+			opts.tlsMinVersion, err = util.ParseTLSVersion(val)
+			return err
+		},
+	},
+	{
+		name: "databases",
+		get:  func(opts *serverOptions) string { return strconv.Itoa(opts.dbnum) },
+		set: func(opts *serverOptions, val string) error {
+			return parseInt(val, 1, 1<<20, &opts.dbnum)
+		},
+	},
+	{
+		name: "dir",
+		get: func(opts *serverOptions) string {
+			if opts.dir == "" {
+				dir, _ := os.Getwd()
+				return dir
+			}
+			return opts.dir
+		},
+		set: func(opts *serverOptions, val string) error {
+			opts.dir = val
+			return nil
+		},
+	},
+	{
+		name: "dbfilename",
+		get:  func(opts *serverOptions) string { return opts.dbFilename },
+		set: func(opts *serverOptions, val string) error {
+			if err := engine.ValidateDBFilename(val); err != nil {
+				return err
+			}
+			opts.dbFilename = val
+			return nil
+		},
+	},
+	{
+		name: "save-interval",
+		get:  func(opts *serverOptions) string { return strconv.Itoa(int(opts.persistTime / time.Second)) },
+		set: func(opts *serverOptions, val string) error {
+			var seconds int
+			if err := parseInt(val, 1, 1<<30, &seconds); err != nil {
+				return err
+			}
+			opts.persistTime = time.Duration(seconds) * time.Second
+			return nil
+		},
+		apply: func(srv *Server, opts *serverOptions) error {
+			return srv.db.SetPersistTime(opts.persistTime)
+		},
+	},
+	{
+		name: "appendonly",
+		get:  func(opts *serverOptions) string { return formatBool(opts.appendOnly) },
+		set: func(opts *serverOptions, val string) error {
+			return parseBool(val, &opts.appendOnly)
+		},
+	},
+	{
+		name: "appendfsync",
+		get:  func(opts *serverOptions) string { return opts.appendFsync.String() },
+		set: func(opts *serverOptions, val string) (err error) {
+			opts.appendFsync, err = engine.ParseAppendFsync(val)
+			return err
+		},
+		apply: func(srv *Server, opts *serverOptions) error {
+			srv.db.SetAppendFsync(opts.appendFsync)
+			return nil
+		},
+	},
+	{
+		name: "maxmemory",
+		get:  func(opts *serverOptions) string { return strconv.FormatUint(opts.maxMemory, 10) },
+		set: func(opts *serverOptions, val string) (err error) {
+			opts.maxMemory, err = util.ParseMemory(val)
+			return err
+		},
+		apply: func(srv *Server, opts *serverOptions) error {
+			setMemoryLimit(opts.maxMemory)
+			srv.db.SetMaxMemory(opts.maxMemory)
+			return nil
+		},
+	},
+	{
+		name: "maxmemory-policy",
+		get:  func(opts *serverOptions) string { return opts.maxMemoryPolicy.String() },
+		set: func(opts *serverOptions, val string) (err error) {
+			opts.maxMemoryPolicy, err = engine.ParseEvictPolicy(val)
+			return err
+		},
+		apply: func(srv *Server, opts *serverOptions) error {
+			srv.db.SetMaxMemoryPolicy(opts.maxMemoryPolicy)
+			return nil
+		},
+	},
+	{
+		name: "maxmemory-samples",
+		get:  func(opts *serverOptions) string { return strconv.Itoa(opts.maxMemorySamples) },
+		set: func(opts *serverOptions, val string) error {
+			return parseInt(val, 1, 64, &opts.maxMemorySamples)
+		},
+		apply: func(srv *Server, opts *serverOptions) error {
+			srv.db.SetMaxMemorySamples(opts.maxMemorySamples)
+			return nil
+		},
+	},
+	{
+		name: "notify-keyspace-events",
+		get:  func(opts *serverOptions) string { return opts.notifyClasses.String() },
+		set: func(opts *serverOptions, val string) (err error) {
+			opts.notifyClasses, err = engine.ParseNotifyClasses(val)
+			return err
+		},
+		apply: func(srv *Server, opts *serverOptions) error {
+			srv.db.SetNotifyKeyspaceEvents(opts.notifyClasses)
+			return nil
+		},
+	},
+	activeExpireParam("active-expire-hz", func(c *engine.ActiveExpireConfig) *int { return &c.Hz }),
+	activeExpireParam("active-expire-keys-per-loop", func(c *engine.ActiveExpireConfig) *int { return &c.KeysPerLoop }),
+	activeExpireParam("active-expire-acceptable-stale", func(c *engine.ActiveExpireConfig) *int { return &c.AcceptableStale }),
+	activeExpireParam("active-expire-cycle-percent", func(c *engine.ActiveExpireConfig) *int { return &c.CyclePercent }),
+	{
+		name: "loglevel",
+		get:  func(opts *serverOptions) string { return opts.logLevel.String() },
+		set: func(opts *serverOptions, val string) error {
+			return opts.logLevel.UnmarshalText([]byte(val))
+		},
+		apply: func(srv *Server, opts *serverOptions) error {
+			srv.logLevel.SetLevel(opts.logLevel)
+			return nil
+		},
+	},
+	{
+		name: "requirepass",
+		get:  func(opts *serverOptions) string { return opts.requirePass },
+		set: func(opts *serverOptions, val string) error {
+			opts.requirePass = val
+			return nil
+		},
+		apply: func(srv *Server, opts *serverOptions) error {
+			return setRequirePass(srv.acl, opts.requirePass)
+		},
+	},
+	stringParam("aclfile", func(opts *serverOptions) *string { return &opts.aclFile }),
+	{
+		// 运行中由 REPLICAOF 修改
+		name: "replicaof",
+		get:  func(opts *serverOptions) string { return opts.replicaOf },
+		set: func(opts *serverOptions, val string) error {
+			host, port, err := parseReplicaOf(val)
+			if err != nil {
+				return err
+			}
+			opts.replicaOf = strings.TrimSpace(host + " " + port)
+			return nil
+		},
+	},
+	mutableStringParam("masteruser", func(opts *serverOptions) *string { return &opts.masterUser }),
+	mutableStringParam("masterauth", func(opts *serverOptions) *string { return &opts.masterAuth }),
+	{
+		name: "replica-read-only",
+		get:  func(opts *serverOptions) string { return formatBool(opts.replicaReadOnly) },
+		set: func(opts *serverOptions, val string) error {
+			return parseBool(val, &opts.replicaReadOnly)
+		},
+		apply: func(srv *Server, opts *serverOptions) error { return nil },
+	},
+	{
+		name: "repl-backlog-size",
+		get:  func(opts *serverOptions) string { return strconv.Itoa(opts.replBacklogSize) },
+		set: func(opts *serverOptions, val string) error {
+			size, err := util.ParseMemory(val)
+			if err != nil {
+				return err
+			}
+			if size < 16*engine.KB || size > math.MaxInt32 {
+				return fmt.Errorf("argument must be between %d and %d inclusive", 16*engine.KB, math.MaxInt32)
+			}
+			opts.replBacklogSize = int(size)
+			return nil
+		},
+		apply: func(srv *Server, opts *serverOptions) error {
+			return srv.db.SetReplBacklogSize(opts.replBacklogSize)
+		},
+	},
+	{
+		name: "raft-enabled",
+		get:  func(opts *serverOptions) string { return formatBool(opts.raftEnabled) },
+		set: func(opts *serverOptions, val string) error {
+			return parseBool(val, &opts.raftEnabled)
+		},
+	},
+	stringParam("raft-id", func(opts *serverOptions) *string { return &opts.raftID }),
+	{
+		name: "raft-peers",
+		get:  func(opts *serverOptions) string { return opts.raftPeers },
+		set: func(opts *serverOptions, val string) error {
+			opts.raftPeers = strings.Join(strings.Fields(val), " ")
+			return nil
+		},
+	},
+	{
+		name: "raft-snapshot-entries",
+		get:  func(opts *serverOptions) string { return strconv.Itoa(opts.raftSnapEntries) },
+		set: func(opts *serverOptions, val string) error {
+			return parseInt(val, 1, math.MaxInt32, &opts.raftSnapEntries)
+		},
+	},
+	{
+		name: "logfile",
+		get:  func(opts *serverOptions) string { return opts.logFile },
+		set: func(opts *serverOptions, val string) error {
+			opts.logFile = val
+			return nil
+		},
+	},
+}
+
+// stringParam returns an immutable parameter whose value is used as is, field returns
+// the parameter in the opts.
+func stringParam(name string, field func(opts *serverOptions) *string) *configParam {
+	return &configParam{
+		name: name,
+		get:  func(opts *serverOptions) string { return *field(opts) },
+		set: func(opts *serverOptions, val string) error {
+			*field(opts) = val
+			return nil
+		},
+	}
+}
+
+// mutableStringParam is like stringParam, but can be changed by CONFIG SET. The
+// value is read from the opts when it is used.
+func mutableStringParam(name string, field func(opts *serverOptions) *string) *configParam {
+	p := stringParam(name, field)
+	p.apply = func(srv *Server, opts *serverOptions) error { return nil }
+	return p
+}
+
+// activeExpireParam returns a parameter of the active expire cycle, field returns
+// the parameter in the config.
+func activeExpireParam(name string, field func(c *engine.ActiveExpireConfig) *int) *configParam {
+	return &configParam{
+		name: name,
+		get:  func(opts *serverOptions) string { return strconv.Itoa(*field(&opts.activeExpire)) },
+		set: func(opts *serverOptions, val string) error {
+			return parseInt(val, 0, 1<<30, field(&opts.activeExpire))
+		},
+		apply: func(srv *Server, opts *serverOptions) error {
+			return srv.db.UpdateActiveExpire(func(conf *engine.ActiveExpireConfig) {
+				*conf = opts.activeExpire
+			})
+		},
+	}
+}
+
+func lookupConfigParam(name string) *configParam {
+	for _, p := range configParams {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func parseInt(val string, min, max int, n *int) error {
+	v, err := strconv.Atoi(val)
+	if err != nil {
+		return errors.New("argument couldn't be parsed into an integer")
+	}
+	if v < min || v > max {
+		return fmt.Errorf("argument must be between %d and %d inclusive", min, max)
+	}
+	*n = v
+	return nil
+}
+
+// parsePerm parses the permission of a file in octal, such as 700.
+func parsePerm(val string, perm *os.FileMode) error {
+	v, err := strconv.ParseUint(val, 8, 32)
+	if err != nil || v > 0777 {
+		return errors.New("argument must be an octal permission, such as 700")
+	}
+	*perm = os.FileMode(v)
+	return nil
+}
+
+func parseBool(val string, b *bool) error {
+	switch strings.ToLower(val) {
+	case "yes":
+		*b = true
+	case "no":
+		*b = false
+	default:
+		return errors.New("argument must be 'yes' or 'no'")
+	}
+	return nil
+}
+
+func formatBool(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// setRequirePass sets the only password of the default user, or no password is
+// required if pass is empty.
+func setRequirePass(acl *commands.ACL, pass string) error {
+	if pass == "" {
+		return acl.SetUser(commands.DefaultUser, "nopass")
+	}
+	return acl.SetUser(commands.DefaultUser, "resetpass", ">"+pass)
+}
+
+// setMemoryLimit makes the GC more aggressive near maxmemory, so that the heap
+// statistics are close to the memory really used.
+func setMemoryLimit(maxMemory uint64) {
+	if maxMemory > 0 {
+		debug.SetMemoryLimit(int64(maxMemory))
+	} else {
+		debug.SetMemoryLimit(math.MaxInt64)
+	}
+}
+
+// readConfigFile reads the config file like redis.conf, each line of which is a
+// parameter and its value, such as "maxmemory 100mb". The lines starting with #
+// are comments.
+func (opt *serverOptions) readConfigFile() error {
+	if opt.configFile == "" {
+		return nil
+	}
+	// 相对路径以启动时的当前目录为准, 不受 dir 影响
+	filename, err := filepath.Abs(opt.configFile)
+	if err != nil {
+		return err
+	}
+	opt.configFile = filename
+
+	file, err := os.Open(opt.configFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		args, err := splitConfigLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", opt.configFile, lineno, err)
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		name := strings.ToLower(args[0])
+		p := lookupConfigParam(name)
+		if p == nil {
+			return fmt.Errorf("%s:%d: unknown parameter '%s'", opt.configFile, lineno, args[0])
+		}
+		if err := p.set(opt, strings.Join(args[1:], " ")); err != nil {
+			return fmt.Errorf("%s:%d: invalid argument for '%s': %v", opt.configFile, lineno, name, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// splitConfigLine splits a line of the config file into arguments, an argument
+// can be quoted like "hello world". Comments and blank lines have no argument.
+func splitConfigLine(line string) ([]string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return nil, nil
+	}
+
+	var args []string
+	for line != "" {
+		if line[0] != '"' {
+			i := strings.IndexAny(line, " \t")
+			if i < 0 {
+				i = len(line)
+			}
+			args = append(args, line[:i])
+			line = strings.TrimLeft(line[i:], " \t")
+			continue
+		}
+
+		// 找到未被转义的右引号
+		end := -1
+		for i := 1; i < len(line); i++ {
+			if line[i] == '\\' {
+				i++
+			} else if line[i] == '"' {
+				end = i
+				break
+			}
+		}
+		if end < 0 {
+			return nil, errors.New("unbalanced quotes")
+		}
+		arg, err := strconv.Unquote(line[:end+1])
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		line = line[end+1:]
+		if line != "" && line[0] != ' ' && line[0] != '\t' {
+			return nil, errors.New("closing quote must be followed by a space")
+		}
+		line = strings.TrimLeft(line, " \t")
+	}
+	return args, nil
+}
+
+// quoteConfigArg quotes the value written to the config file if needed.
+func quoteConfigArg(val string) string {
+	if val == "" || strings.ContainsAny(val, " \t") || strconv.Quote(val) != `"`+val+`"` {
+		return strconv.Quote(val)
+	}
+	return val
+}
+
+// ConfigGet implements commands.Config.
+func (srv *Server) ConfigGet(pattern string) []string {
+	srv.configLock.Lock()
+	defer srv.configLock.Unlock()
+
+	var pairs []string
+	for _, p := range configParams {
+		if util.Match(pattern, p.name) {
+			pairs = append(pairs, p.name, p.get(&srv.opts))
+		}
+	}
+	return pairs
+}
+
+// ConfigSet implements commands.Config.
+func (srv *Server) ConfigSet(name, value string) error {
+	p := lookupConfigParam(name)
+	if p == nil {
+		return fmt.Errorf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", name)
+	}
+	// 没有 apply 的参数(如 port, bind, dir, appendonly)只在启动时生效, 修改后 CONFIG GET
+	// 与正在使用的值不一致, 因此拒绝
+	if p.apply == nil {
+		return fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - can't set immutable config", name)
+	}
+
+	srv.configLock.Lock()
+	defer srv.configLock.Unlock()
+
+	// 应用成功后才修改当前的配置
+	opts := srv.opts
+	if err := p.set(&opts, value); err != nil {
+		return fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - %v", name, err)
+	}
+	if err := p.apply(srv, &opts); err != nil {
+		return fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - %v", name, err)
+	}
+	srv.opts = opts
+	return nil
+}
+
+// ConfigResetStat implements commands.Config.
+func (srv *Server) ConfigResetStat() {
+	srv.db.ResetStats()
+}
+
+// ConfigRewrite implements commands.Config. Like redis, the comments and the order
+// of the lines are kept, the parameters in the file are updated in place, and the
+// other ones different from the defaults are appended.
+func (srv *Server) ConfigRewrite() error {
+	srv.configLock.Lock()
+	defer srv.configLock.Unlock()
+
+	filename := srv.opts.configFile
+	if filename == "" {
+		return ErrNoConfigFile
+	}
+	content, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var lines []string
+	written := make(map[string]bool)
+	if len(content) > 0 {
+		for _, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+			args, err := splitConfigLine(line)
+			var p *configParam
+			if err == nil && len(args) > 0 {
+				p = lookupConfigParam(strings.ToLower(args[0]))
+			}
+			if p == nil {
+				lines = append(lines, line)
+				continue
+			}
+			// 重复的参数只保留第一个
+			if !written[p.name] {
+				written[p.name] = true
+				lines = append(lines, p.name+" "+quoteConfigArg(p.get(&srv.opts)))
+			}
+		}
+	}
+
+	defaults := defaultServerOptions
+	generated := false
+	for _, p := range configParams {
+		val := p.get(&srv.opts)
+		if written[p.name] || val == p.get(&defaults) {
+			continue
+		}
+		if !generated {
+			lines = append(lines, "# Generated by CONFIG REWRITE")
+			generated = true
+		}
+		lines = append(lines, p.name+" "+quoteConfigArg(val))
+	}
+
+	// 先写入临时文件再替换, 避免写到一半时留下不完整的配置
+	temp := filename + ".tmp"
+	if err := ioutil.WriteFile(temp, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(temp, filename)
+}
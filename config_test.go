@@ -0,0 +1,193 @@
+package gres
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/clovers4/gres/commands"
+	"github.com/clovers4/gres/engine"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func newConfigServer(opts serverOptions) *Server {
+	return &Server{
+		opts:     opts,
+		db:       engine.NewDB(),
+		acl:      commands.NewACL(),
+		log:      zap.NewNop(),
+		logLevel: zap.NewAtomicLevelAt(opts.logLevel),
+	}
+}
+
+func TestSplitConfigLine(t *testing.T) {
+	cases := []struct {
+		line string
+		args []string
+	}{
+		{"", nil},
+		{"  # comment", nil},
+		{"port 6380", []string{"port", "6380"}},
+		{"\tbind  127.0.0.1 ::1 ", []string{"bind", "127.0.0.1", "::1"}},
+		{`logfile "my log.txt"`, []string{"logfile", "my log.txt"}},
+		{`notify-keyspace-events ""`, []string{"notify-keyspace-events", ""}},
+		{`dir "a\"b"`, []string{"dir", `a"b`}},
+	}
+	for _, c := range cases {
+		args, err := splitConfigLine(c.line)
+		assert.Nil(t, err, c.line)
+		assert.Equal(t, c.args, args, c.line)
+	}
+
+	_, err := splitConfigLine(`dir "abc`)
+	assert.NotNil(t, err)
+	_, err = splitConfigLine(`dir "a"b`)
+	assert.NotNil(t, err)
+}
+
+func TestReadConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gres-config")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "gres.conf")
+	assert.Nil(t, ioutil.WriteFile(filename, []byte(`# gres config
+port 6380
+bind 127.0.0.1   ::1
+dbfilename gres-6380
+MAXMEMORY 100mb
+maxmemory-policy allkeys-lru
+save-interval 5
+appendonly yes
+active-expire-hz 20
+loglevel debug
+logfile "gres log.txt"
+`), 0644))
+
+	opts := defaultServerOptions
+	opts.configFile = filename
+	assert.Nil(t, opts.readConfigFile())
+	assert.Equal(t, 6380, opts.port)
+	assert.Equal(t, "127.0.0.1 ::1", opts.bind)
+	assert.Equal(t, "gres-6380", opts.dbFilename)
+	assert.Equal(t, uint64(100*1024*1024), opts.maxMemory)
+	assert.Equal(t, engine.EvictAllKeysLRU, opts.maxMemoryPolicy)
+	assert.Equal(t, 5*time.Second, opts.persistTime)
+	assert.True(t, opts.appendOnly)
+	assert.Equal(t, 20, opts.activeExpire.Hz)
+	assert.Equal(t, zapcore.DebugLevel, opts.logLevel)
+	assert.Equal(t, "gres log.txt", opts.logFile)
+
+	assert.Nil(t, ioutil.WriteFile(filename, []byte("port 6380\nunknown 1\n"), 0644))
+	opts = defaultServerOptions
+	opts.configFile = filename
+	assert.EqualError(t, opts.readConfigFile(), filename+":2: unknown parameter 'unknown'")
+
+	assert.Nil(t, ioutil.WriteFile(filename, []byte("port x\n"), 0644))
+	assert.NotNil(t, opts.readConfigFile())
+	assert.Nil(t, ioutil.WriteFile(filename, []byte("dbfilename a/b\n"), 0644))
+	assert.NotNil(t, opts.readConfigFile())
+}
+
+func TestServer_LoadACL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gres-config")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// 相对路径的 aclfile 在配置文件所在目录
+	opts := defaultServerOptions
+	opts.configFile = filepath.Join(dir, "gres.conf")
+	opts.aclFile = "users.acl"
+	srv := newConfigServer(opts)
+	assert.Nil(t, srv.loadACL())
+	assert.Nil(t, srv.acl.SetUser("alice", "on", ">p", "~*", "+@all"))
+	assert.Nil(t, srv.acl.Save())
+	_, err = os.Stat(filepath.Join(dir, "users.acl"))
+	assert.Nil(t, err)
+
+	opts.requirePass = "secret"
+	srv = newConfigServer(opts)
+	assert.Nil(t, srv.loadACL())
+	_, err = srv.acl.Authenticate("alice", "p")
+	assert.Nil(t, err)
+	assert.Nil(t, srv.acl.InitialUser())
+}
+
+func TestServer_ListenAddrs(t *testing.T) {
+	opts := defaultServerOptions
+	opts.port = 6380
+	opts.bind = "127.0.0.1 ::1 *"
+	srv := newConfigServer(opts)
+	assert.Equal(t, []string{"127.0.0.1:6380", "[::1]:6380", ":6380"}, srv.listenAddrs())
+}
+
+func TestServer_ConfigSet(t *testing.T) {
+	srv := newConfigServer(defaultServerOptions)
+
+	assert.Equal(t, []string{"maxmemory", "0", "maxmemory-policy", "noeviction", "maxmemory-samples", "5"}, srv.ConfigGet("maxmemory*"))
+	assert.Nil(t, srv.ConfigSet("maxmemory-policy", "allkeys-lfu"))
+	assert.Equal(t, []string{"maxmemory-policy", "allkeys-lfu"}, srv.ConfigGet("maxmemory-policy"))
+
+	assert.Nil(t, srv.ConfigSet("active-expire-keys-per-loop", "50"))
+	assert.Equal(t, 50, srv.db.ActiveExpire().KeysPerLoop)
+	// engine 拒绝时不修改配置
+	assert.NotNil(t, srv.ConfigSet("active-expire-hz", "0"))
+	assert.Equal(t, []string{"active-expire-hz", "10"}, srv.ConfigGet("active-expire-hz"))
+
+	assert.Nil(t, srv.ConfigSet("requirepass", "secret"))
+	assert.Nil(t, srv.acl.InitialUser())
+	_, err := srv.acl.Authenticate(commands.DefaultUser, "secret")
+	assert.Nil(t, err)
+	assert.Nil(t, srv.ConfigSet("requirepass", ""))
+	assert.NotNil(t, srv.acl.InitialUser())
+
+	assert.Nil(t, srv.ConfigSet("loglevel", "error"))
+	assert.Equal(t, zapcore.ErrorLevel, srv.logLevel.Level())
+
+	// 只能在启动时设置的参数, 拒绝修改, CONFIG GET 仍然是正在使用的值
+	for _, pair := range [][2]string{{"port", "6380"}, {"bind", "0.0.0.0"}, {"dir", "/tmp"}, {"appendonly", "yes"}} {
+		val := srv.ConfigGet(pair[0])
+		assert.EqualError(t, srv.ConfigSet(pair[0], pair[1]), fmt.Sprintf(
+			"ERR CONFIG SET failed (possibly related to argument '%s') - can't set immutable config", pair[0]))
+		assert.Equal(t, val, srv.ConfigGet(pair[0]))
+	}
+	assert.EqualError(t, srv.ConfigSet("maxmemory-samples", "x"),
+		"ERR CONFIG SET failed (possibly related to argument 'maxmemory-samples') - argument couldn't be parsed into an integer")
+	assert.EqualError(t, srv.ConfigSet("foo", "1"),
+		"ERR Unknown option or number of arguments for CONFIG SET - 'foo'")
+}
+
+func TestServer_ConfigRewrite(t *testing.T) {
+	srv := newConfigServer(defaultServerOptions)
+	assert.Equal(t, ErrNoConfigFile, srv.ConfigRewrite())
+
+	dir, err := ioutil.TempDir("", "gres-config")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "gres.conf")
+	assert.Nil(t, ioutil.WriteFile(filename, []byte("# my port\nport 6380\nloglevel debug\n\nloglevel warn\n"), 0644))
+	opts := defaultServerOptions
+	opts.configFile = filename
+	assert.Nil(t, opts.readConfigFile())
+
+	srv = newConfigServer(opts)
+	assert.Nil(t, srv.ConfigSet("loglevel", "error"))
+	assert.Nil(t, srv.ConfigSet("notify-keyspace-events", "Ex"))
+	assert.Nil(t, srv.ConfigRewrite())
+
+	content, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, "# my port\nport 6380\nloglevel error\n\n# Generated by CONFIG REWRITE\nnotify-keyspace-events xE\n", string(content))
+
+	// 重写后的文件可以读回同样的配置
+	newOpts := defaultServerOptions
+	newOpts.configFile = filename
+	assert.Nil(t, newOpts.readConfigFile())
+	assert.Equal(t, srv.ConfigGet("*"), newConfigServer(newOpts).ConfigGet("*"))
+}
@@ -0,0 +1,580 @@
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clovers4/gres/proto"
+	"github.com/clovers4/gres/util"
+	"go.uber.org/zap"
+)
+
+// AppendFsync is the fsync policy of the append-only file.
+type AppendFsync uint8
+
+func (f AppendFsync) String() string {
+	return AppendFsyncs[f]
+}
+
+const (
+	AppendFsyncAlways   AppendFsync = iota // fsync after every write command
+	AppendFsyncEverySec                    // fsync once per second in background
+	AppendFsyncNo                          // let the OS decide when to flush
+)
+
+var AppendFsyncs = map[AppendFsync]string{
+	AppendFsyncAlways:   "always",
+	AppendFsyncEverySec: "everysec",
+	AppendFsyncNo:       "no",
+}
+
+func ParseAppendFsync(s string) (AppendFsync, error) {
+	for f, name := range AppendFsyncs {
+		if strings.ToLower(s) == name {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid appendfsync policy: %v", s)
+}
+
+const (
+	AofSuffix = ".aof"
+
+	// aof 重写生成的基础文件, 与 .db 快照地位相同
+	BaseAofSuffix = ".base.aof"
+)
+
+// ReplayFunc executes one command read back from the append-only file.
+type ReplayFunc func(db *DB, args []string) error
+
+// replayFunc is installed by the commands package, because engine does not
+// know the command table.
+var replayFunc ReplayFunc
+
+func RegisterReplayFunc(fn ReplayFunc) {
+	replayFunc = fn
+}
+
+// cmdStream encodes the write commands in the format of the append-only file, which
+// is also streamed to the replicas. A select is written before the command of another
+// db, and a transaction is wrapped by multi and exec.
+type cmdStream struct {
+	wr       *proto.Writer
+	selected int  // 上一条命令所在的 db, -1 表示未知
+	txBegin  bool // 事务已开始, 在第一条写命令前写入 multi
+	inTx     bool // 已写入 multi, 事务结束时写入 exec
+}
+
+// write encodes the command, and reports whether it should be flushed now. The commands
+// of a transaction are flushed together at exec.
+func (s *cmdStream) write(index int, vals []interface{}) (bool, error) {
+	if s.txBegin {
+		if err := s.wr.ReplyArrays([]interface{}{"multi"}); err != nil {
+			return false, err
+		}
+		s.txBegin = false
+		s.inTx = true
+	}
+	if s.selected != index {
+		if err := s.wr.ReplyArrays([]interface{}{"select", index}); err != nil {
+			return false, err
+		}
+		s.selected = index
+	}
+	if err := s.wr.ReplyArrays(vals); err != nil {
+		return false, err
+	}
+	return !s.inTx, nil
+}
+
+// beginTx starts a transaction. multi is written lazily, so a transaction
+// without any write leaves nothing in the stream.
+func (s *cmdStream) beginTx() {
+	s.txBegin = true
+}
+
+// endTx ends the transaction, and reports whether exec is written and should be flushed.
+func (s *cmdStream) endTx() (bool, error) {
+	s.txBegin = false
+	if !s.inTx {
+		return false, nil
+	}
+	s.inTx = false
+	return true, s.wr.ReplyArrays([]interface{}{"exec"})
+}
+
+// aof 记录快照之后的所有写命令.
+// 每个快照 gres_<stamp>.db 对应一个分段 gres_<stamp>.aof, 加载时先读快照, 再重放其后的分段.
+// 命令所在的 db 与上一条不同时, 先写入一条 select.
+type aof struct {
+	cmdStream
+	fsync    AppendFsync
+	filename string
+	file     *os.File
+
+	mu     sync.Mutex
+	closed bool
+	log    *zap.Logger
+}
+
+func openAof(filename string, fsync AppendFsync, log *zap.Logger) (*aof, error) {
+	a := &aof{
+		fsync: fsync,
+		log:   log,
+	}
+	if err := a.open(filename); err != nil {
+		return nil, err
+	}
+
+	go a.syncBackground()
+	return a, nil
+}
+
+func (a *aof) open(filename string) error {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	a.filename = filename
+	a.file = file
+	a.wr = proto.NewWriter(file)
+	// 续写已有的分段时, 不知道其最后所在的 db
+	a.selected = -1
+	return nil
+}
+
+// rotate closes the current segment and starts a new one of filename.
+func (a *aof) rotate(filename string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	old := a.file
+	if err := a.open(filename); err != nil {
+		return err
+	}
+
+	if err := old.Sync(); err != nil {
+		a.log.Error("[aof rotate] Sync", zap.String("err", err.Error()))
+	}
+	return old.Close()
+}
+
+func (a *aof) feed(index int, vals []interface{}) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return nil
+	}
+	flush, err := a.write(index, vals)
+	if err != nil || !flush {
+		return err
+	}
+	return a.flush()
+}
+
+func (a *aof) flush() error {
+	if err := a.wr.Flush(); err != nil {
+		return err
+	}
+	if a.fsync == AppendFsyncAlways {
+		return a.file.Sync()
+	}
+	return nil
+}
+
+func (a *aof) beginTx() {
+	a.mu.Lock()
+	a.cmdStream.beginTx()
+	a.mu.Unlock()
+}
+
+func (a *aof) endTx() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		a.txBegin, a.inTx = false, false
+		return nil
+	}
+	ended, err := a.cmdStream.endTx()
+	if err != nil || !ended {
+		return err
+	}
+	return a.flush()
+}
+
+func (a *aof) syncBackground() {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		<-t.C
+		a.mu.Lock()
+		if a.closed {
+			a.mu.Unlock()
+			return
+		}
+		// fsync 策略可能在运行中修改
+		if a.fsync == AppendFsyncEverySec {
+			if err := a.file.Sync(); err != nil {
+				a.log.Error("[aof syncBackground] Sync", zap.String("err", err.Error()))
+			}
+		}
+		a.mu.Unlock()
+	}
+}
+
+func (a *aof) close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+	if err := a.file.Sync(); err != nil {
+		return err
+	}
+	return a.file.Close()
+}
+
+func (a *aof) setFsync(fsync AppendFsync) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.fsync = fsync
+}
+
+// absExpireArgs converts args to vals written to the append-only file. The relative
+// expire time goes wrong when replayed, so it is converted to unix milliseconds.
+// The options of EXPIRE are kept, a command whose condition fails is a no-op when
+// replayed as well.
+func absExpireArgs(args []string, now int64) []interface{} {
+	vals := make([]interface{}, len(args))
+	for i, arg := range args {
+		vals[i] = arg
+	}
+
+	switch cmd := strings.ToLower(args[0]); cmd {
+	case "expire", "pexpire", "expireat", "pexpireat":
+		if len(args) < 3 {
+			break
+		}
+		if t, ok := absExpireTime(cmd, args[2], now); ok {
+			vals = append([]interface{}{"pexpireat", args[1], t}, vals[3:]...)
+		}
+	case "setex", "psetex":
+		if len(args) != 4 {
+			break
+		}
+		unit := "ex"
+		if cmd == "psetex" {
+			unit = "px"
+		}
+		if t, ok := absExpireTime(unit, args[2], now); ok {
+			vals = []interface{}{"set", args[1], args[3], "pxat", t}
+		}
+	case "set", "lock.acquire", "lock.extend":
+		for i := 3; i < len(args)-1; i++ {
+			opt := strings.ToLower(args[i])
+			if opt != "ex" && opt != "px" && opt != "exat" {
+				continue
+			}
+			if t, ok := absExpireTime(opt, args[i+1], now); ok {
+				vals[i], vals[i+1] = "pxat", t
+			}
+			i++
+		}
+	}
+	return vals
+}
+
+// AbsExpireArgs converts the relative expire time of args to unix milliseconds like
+// the append-only file, so that the command has the same effect when it is executed
+// later.
+func AbsExpireArgs(args []string) []string {
+	vals := absExpireArgs(args, util.NowMs())
+	res := make([]string, len(vals))
+	for i, v := range vals {
+		switch v := v.(type) {
+		case string:
+			res[i] = v
+		case int64:
+			res[i] = strconv.FormatInt(v, 10)
+		}
+	}
+	return res
+}
+
+// absExpireTime converts the expire time n of the command or option unit to unix
+// milliseconds.
+func absExpireTime(unit string, n string, now int64) (int64, bool) {
+	t, err := strconv.ParseInt(n, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	switch unit {
+	case "expire", "ex":
+		return now + t*1000, true
+	case "pexpire", "px":
+		return now + t, true
+	case "expireat", "exat":
+		return t * 1000, true
+	default:
+		return t, true
+	}
+}
+
+// SetAppendFsync changes the fsync policy of the append-only file.
+func (db *DB) SetAppendFsync(fsync AppendFsync) {
+	root := db.root
+	root.confLock.Lock()
+	defer root.confLock.Unlock()
+
+	root.appendFsync = fsync
+	if root.aof != nil {
+		root.aof.setFsync(fsync)
+	}
+}
+
+// Propagate runs fn, which executes one write command, and appends args to the
+// append-only file and the stream of the replicas when fn reports success. The
+// caller must run it inside Shared or Exclusive, so that Save cannot start in the
+// middle, and every write lands either in the snapshot or in the segment after it,
+// not both. fn and the appending run under one lock, so the commands are appended
+// in the order they are executed.
+func (db *DB) Propagate(args []string, fn func() bool) {
+	root := db.root
+	// Shared 中 replica 不会开始同步, 无需传播时不必串行执行
+	if root.aof == nil && !root.repl.streaming() {
+		fn()
+		return
+	}
+
+	// 相对的过期时间按命令执行的时间转换
+	now := util.NowMs()
+	root.propagateMu.Lock()
+	defer root.propagateMu.Unlock()
+	if !fn() {
+		return
+	}
+
+	vals := absExpireArgs(args, now)
+	if root.aof != nil {
+		if err := root.aof.feed(db.index, vals); err != nil {
+			db.log.Error("[DB Propagate] feed", zap.String("err", err.Error()))
+		}
+	}
+	if err := root.repl.feed(db.index, vals); err != nil {
+		db.log.Error("[DB Propagate] repl.feed", zap.String("err", err.Error()))
+	}
+}
+
+// Shared runs fn, which executes one command, concurrently with other commands.
+func (db *DB) Shared(fn func()) {
+	db.root.cmdLock.RLock()
+	defer db.root.cmdLock.RUnlock()
+	fn()
+}
+
+// Exclusive runs fn while no other command runs, so the commands executed by fn,
+// such as a transaction, are atomic. Their records in the append-only file and the
+// stream of the replicas are wrapped by multi and exec, so a transaction is never
+// partially replayed.
+func (db *DB) Exclusive(fn func()) {
+	root := db.root
+	root.cmdLock.Lock()
+	defer root.cmdLock.Unlock()
+
+	root.repl.beginTx()
+	defer func() {
+		if err := root.repl.endTx(); err != nil {
+			db.log.Error("[DB Exclusive] repl.endTx", zap.String("err", err.Error()))
+		}
+	}()
+	if root.aof != nil {
+		root.aof.beginTx()
+		defer func() {
+			if err := root.aof.endTx(); err != nil {
+				db.log.Error("[DB Exclusive] endTx", zap.String("err", err.Error()))
+			}
+		}()
+	}
+	fn()
+}
+
+// aofStamps returns the stamps of segments which were written after the snapshot stamp.
+func (db *DB) aofStamps(since int64) ([]int64, error) {
+	filenames, err := db.listFiles(AofSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	var stamps []int64
+	for _, filename := range filenames {
+		stamp, err := db.parseStamp(filename, AofSuffix)
+		if err != nil {
+			continue
+		}
+		if stamp >= since {
+			stamps = append(stamps, stamp)
+		}
+	}
+	sort.Slice(stamps, func(i, j int) bool { return stamps[i] < stamps[j] })
+	return stamps, nil
+}
+
+func (db *DB) loadAppendOnly() error {
+	stamps, err := db.aofStamps(db.root.stamp)
+	if err != nil {
+		return err
+	}
+
+	for _, stamp := range stamps {
+		if err := db.replayAppendOnly(db.filePath(stamp, AofSuffix)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *DB) replayAppendOnly(filename string) error {
+	if replayFunc == nil {
+		return fmt.Errorf("no replay func registered, cannot load %v", filename)
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	rd := proto.NewReader(bufio.NewReader(file))
+	count := 0
+	selected := db.root
+	var (
+		inTx    bool
+		pending [][]string // 事务中的命令, 读到 exec 后才执行
+	)
+	apply := func(args []string) error {
+		if strings.ToLower(args[0]) == "select" && len(args) == 2 {
+			index, err := strconv.Atoi(args[1])
+			if err == nil {
+				selected, err = db.Select(index)
+			}
+			if err != nil {
+				return fmt.Errorf("unexpected select in %v: %v", filename, err)
+			}
+			return nil
+		}
+		if ok, err := selected.replayLockRecord(args); ok {
+			if err != nil {
+				db.log.Warn("[DB replayAppendOnly] replayLockRecord", zap.Strings("args", args), zap.String("err", err.Error()))
+			}
+			count++
+			return nil
+		}
+		if err := replayFunc(selected, args); err != nil {
+			db.log.Warn("[DB replayAppendOnly] replay", zap.Strings("args", args), zap.String("err", err.Error()))
+		}
+		count++
+		return nil
+	}
+
+	for {
+		v, err := rd.ReadReply()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// 写入最后一条命令时宕机, 文件尾部可能不完整; 保留已读取的部分
+			db.log.Warn("[DB replayAppendOnly] truncated", zap.String("file", filename), zap.String("err", err.Error()))
+			break
+		}
+
+		args, ok := recordArgs(v)
+		if !ok || len(args) == 0 {
+			return fmt.Errorf("unexpected record in %v: %v", filename, v)
+		}
+
+		switch strings.ToLower(args[0]) {
+		case "multi":
+			inTx = true
+			pending = pending[:0]
+			continue
+		case "exec":
+			inTx = false
+			for _, args := range pending {
+				if err := apply(args); err != nil {
+					return err
+				}
+			}
+			pending = pending[:0]
+			continue
+		}
+		if inTx {
+			pending = append(pending, args)
+			continue
+		}
+		if err := apply(args); err != nil {
+			return err
+		}
+	}
+
+	// 未写完的事务不执行
+	if inTx {
+		db.log.Warn("[DB replayAppendOnly] discard unfinished transaction", zap.String("file", filename), zap.Int("commands", len(pending)))
+	}
+
+	db.log.Info("[DB replayAppendOnly] finished", zap.String("file", filename), zap.Int("commands", count))
+	return nil
+}
+
+// removeOldAppendOnly removes the segments and rewritten bases which are already
+// covered by the base file of stamp.
+func (db *DB) removeOldAppendOnly(stamp int64) {
+	filenames, err := db.listFiles(AofSuffix)
+	if err != nil {
+		db.log.Error("[DB removeOldAppendOnly] listFiles", zap.String("err", err.Error()))
+		return
+	}
+
+	for _, filename := range filenames {
+		suffix := AofSuffix
+		if strings.HasSuffix(filename, BaseAofSuffix) {
+			suffix = BaseAofSuffix
+		}
+		s, err := db.parseStamp(filename, suffix)
+		if err != nil || s >= stamp {
+			continue
+		}
+		if err := os.Remove(filename); err != nil {
+			db.log.Error("[DB removeOldAppendOnly] Remove", zap.String("err", err.Error()))
+		}
+	}
+}
+
+// recordArgs converts a record of the append-only file, which is an array of
+// bulk strings, to the args of a command.
+func recordArgs(v interface{}) ([]string, bool) {
+	vals, ok := v.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	args := make([]string, 0, len(vals))
+	for _, val := range vals {
+		s, ok := val.(string)
+		if !ok {
+			return nil, false
+		}
+		args = append(args, s)
+	}
+	return args, true
+}
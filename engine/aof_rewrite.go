@@ -0,0 +1,149 @@
+package engine
+
+import (
+	"errors"
+	"io"
+
+	"github.com/clovers4/gres/engine/object"
+	"github.com/clovers4/gres/engine/object/idgen"
+	"github.com/clovers4/gres/proto"
+	"github.com/clovers4/gres/util"
+	"go.uber.org/zap"
+)
+
+// 重写时每条命令最多携带的元素个数, 避免单条命令过大
+const aofRewriteItemsPerCmd = 64
+
+var ErrAppendOnlyDisabled = errors.New("the append only file is disabled")
+
+// RewriteAppendOnly compacts the append-only file. It writes the shortest command
+// sequence which reproduces the dataset as a new base, then drops the old base and segments.
+func (db *DB) RewriteAppendOnly() error {
+	if db.root.aof == nil {
+		return ErrAppendOnlyDisabled
+	}
+	return db.dump(BaseAofSuffix, rewriteAppendOnly)
+}
+
+// BgRewriteAppendOnly runs RewriteAppendOnly in background.
+func (db *DB) BgRewriteAppendOnly() error {
+	if db.root.aof == nil {
+		return ErrAppendOnlyDisabled
+	}
+
+	go func() {
+		if err := db.RewriteAppendOnly(); err != nil {
+			db.log.Error("[DB BgRewriteAppendOnly] RewriteAppendOnly", zap.String("err", err.Error()))
+		} else {
+			db.log.Info("[DB BgRewriteAppendOnly] RewriteAppendOnly success")
+		}
+	}()
+	return nil
+}
+
+func rewriteAppendOnly(wr io.Writer, snaps []snapshot, fence int64) error {
+	w := proto.NewWriter(wr)
+	// 恢复锁的 fencing token, 使之后发放的 token 仍然递增
+	if fence > 0 {
+		if err := w.ReplyArrays([]interface{}{lockFenceRecord, fence}); err != nil {
+			return err
+		}
+	}
+	for i, snap := range snaps {
+		if snap.dataMap.Count() == 0 {
+			continue
+		}
+		if err := w.ReplyArrays([]interface{}{"select", i}); err != nil {
+			return err
+		}
+		if err := rewriteSnapshot(w, snap); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+func rewriteSnapshot(w *proto.Writer, snap snapshot) error {
+	now := util.NowMs()
+
+	var err error
+	snap.dataMap.ForEachRead(func(key string, val interface{}) {
+		if err != nil {
+			return
+		}
+
+		// 已过期但尚未删除的 key 无需写入
+		t, expire := snap.expireList.Get(key)
+		if expire && t <= now {
+			return
+		}
+
+		if err = rewriteObject(w, key, val.(*object.Object)); err != nil {
+			return
+		}
+		if expire {
+			err = w.ReplyArrays([]interface{}{"pexpireat", key, t})
+		}
+	})
+	return err
+}
+
+func rewriteObject(w *proto.Writer, key string, obj *object.Object) error {
+	switch obj.Kind() {
+	case object.ObjPlain:
+		p, _ := obj.Plain()
+		return w.ReplyArrays([]interface{}{"set", key, p.Bytes()})
+	case object.ObjList:
+		ls, _ := obj.List()
+		var vals []interface{}
+		for n := ls.Front(); n != nil; n = n.Next() {
+			vals = append(vals, n.Val())
+		}
+		return rewriteItems(w, "rpush", key, vals, 1)
+	case object.ObjSet:
+		s, _ := obj.Set()
+		return rewriteItems(w, "sadd", key, s.Vals(), 1)
+	case object.ObjZset:
+		zs, _ := obj.ZSet()
+		var vals []interface{}
+		for n := zs.GetNodeByRank(0); n != nil; n = n.Next() {
+			vals = append(vals, n.Score(), n.Val())
+		}
+		return rewriteItems(w, "zadd", key, vals, 2)
+	case object.ObjHash:
+		h, _ := obj.Hash()
+		return rewriteItems(w, "hset", key, h.KeyVals(), 2)
+	case object.ObjIDGen:
+		// 从最近发放的 id 继续, 重放后不会发放更小的 id
+		g, _ := obj.IDGen()
+		if g.Kind() == idgen.Snowflake {
+			return w.ReplyArrays([]interface{}{"id.create", key, "snowflake", g.Node(), "epoch", g.Epoch(), "last", g.Last()})
+		}
+		return w.ReplyArrays([]interface{}{"id.create", key, "segment", "step", g.Step(), "last", g.Last()})
+	case object.ObjLock:
+		// 恢复获取时发放的 token, 租期由之后的 pexpireat 恢复
+		l, _ := obj.Lock()
+		return w.ReplyArrays([]interface{}{lockRestoreRecord, key, l.Owner(), l.Token()})
+	}
+	return nil
+}
+
+// rewriteItems writes vals as several commands, each has at most
+// aofRewriteItemsPerCmd items. An item consists of step vals, such as field and value.
+func rewriteItems(w *proto.Writer, name, key string, vals []interface{}, step int) error {
+	batch := aofRewriteItemsPerCmd * step
+	for start := 0; start < len(vals); start += batch {
+		end := start + batch
+		if end > len(vals) {
+			end = len(vals)
+		}
+
+		args := make([]interface{}, 0, 2+end-start)
+		args = append(args, name, key)
+		args = append(args, vals[start:end]...)
+		if err := w.ReplyArrays(args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,369 @@
+package engine
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clovers4/gres/engine/object/idgen"
+	"github.com/clovers4/gres/proto"
+	"github.com/clovers4/gres/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// testReplay stands in for the commands package, which engine cannot import.
+func testReplay(db *DB, args []string) error {
+	switch args[0] {
+	case "set":
+		return db.Set(args[1], []byte(args[2]))
+	case "del":
+		db.Del(args[1:]...)
+	case "expireat":
+		unix, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return err
+		}
+		db.ExpireAt(args[1], unix)
+	case "pexpireat":
+		ms, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return err
+		}
+		cond := ExpireAlways
+		if len(args) > 3 && strings.ToLower(args[3]) == "nx" {
+			cond = ExpireNX
+		}
+		db.PExpireAt(args[1], ms, cond)
+	}
+	return nil
+}
+
+func TestDB_AppendOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gres-aof")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	RegisterReplayFunc(testReplay)
+	db := NewDB(AppendOnlyOption(true), AppendFsyncOption(AppendFsyncAlways))
+	assert.Nil(t, db.openAppendOnly())
+
+	for _, args := range [][]string{
+		{"set", "a", "A"},
+		{"set", "b", "B"},
+		{"expire", "b", "100"},
+		{"del", "a"},
+	} {
+		args := args
+		db.Propagate(args, func() bool {
+			return testReplay(db, args) == nil
+		})
+	}
+	assert.Nil(t, db.aof.close())
+
+	newDB := NewDB(AppendOnlyOption(true))
+	assert.Nil(t, newDB.ReadFromFile())
+
+	val, err := newDB.Get("a")
+	assert.Nil(t, err)
+	assert.Nil(t, val)
+
+	val, err = newDB.Get("b")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("B"), val)
+
+	ttl := newDB.Ttl("b")
+	assert.True(t, ttl > 0 && ttl <= 100)
+}
+
+func TestDB_AppendOnlyExpireCond(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gres-aof")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	RegisterReplayFunc(testReplay)
+	db := NewDB(AppendOnlyOption(true), AppendFsyncOption(AppendFsyncAlways))
+	assert.Nil(t, db.openAppendOnly())
+
+	// 条件不满足的 EXPIRE 重放时也不修改过期时间
+	for _, args := range [][]string{
+		{"set", "a", "A"},
+		{"pexpireat", "a", strconv.FormatInt(util.NowMs()+100000, 10)},
+		{"expire", "a", "5", "NX"},
+	} {
+		args := args
+		db.Propagate(args, func() bool {
+			return testReplay(db, args) == nil
+		})
+	}
+	assert.Nil(t, db.aof.close())
+
+	newDB := NewDB(AppendOnlyOption(true))
+	assert.Nil(t, newDB.ReadFromFile())
+	ttl := newDB.Ttl("a")
+	assert.True(t, ttl > 5 && ttl <= 100, ttl)
+}
+
+func TestDB_PropagateOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gres-aof")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	RegisterReplayFunc(testReplay)
+	db := NewDB(AppendOnlyOption(true))
+	assert.Nil(t, db.openAppendOnly())
+
+	// 第一个命令执行后尚未写入 aof 时, 第二个命令不能先执行并写入
+	entered, proceed := make(chan struct{}), make(chan struct{})
+	done := make(chan struct{}, 2)
+	go func() {
+		db.Propagate([]string{"set", "k", "1"}, func() bool {
+			db.Set("k", []byte("1"))
+			close(entered)
+			<-proceed
+			return true
+		})
+		done <- struct{}{}
+	}()
+	<-entered
+	go func() {
+		db.Propagate([]string{"set", "k", "2"}, func() bool {
+			db.Set("k", []byte("2"))
+			return true
+		})
+		done <- struct{}{}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(proceed)
+	<-done
+	<-done
+
+	// 相对的过期时间按执行前的时间转换, 而不是写入 aof 的时间
+	before := util.NowMs()
+	db.Propagate([]string{"pexpire", "k", "100000"}, func() bool {
+		time.Sleep(50 * time.Millisecond)
+		return db.PExpireAt("k", before+100000, ExpireAlways)
+	})
+	assert.Nil(t, db.aof.close())
+
+	val, _ := db.Get("k")
+	newDB := NewDB(AppendOnlyOption(true))
+	assert.Nil(t, newDB.ReadFromFile())
+	replayed, _ := newDB.Get("k")
+	assert.Equal(t, val, replayed)
+	at := newDB.expireTime("k")
+	assert.True(t, at > 0 && at < before+100000+50, at-before)
+}
+
+func TestAbsExpireArgs(t *testing.T) {
+	now := int64(1600000000000)
+	cases := []struct {
+		args []string
+		vals []interface{}
+	}{
+		{[]string{"expire", "k", "10", "NX"}, []interface{}{"pexpireat", "k", now + 10000, "NX"}},
+		{[]string{"PEXPIRE", "k", "10"}, []interface{}{"pexpireat", "k", now + 10}},
+		{[]string{"expireat", "k", "10"}, []interface{}{"pexpireat", "k", int64(10000)}},
+		{[]string{"pexpireat", "k", "10", "gt"}, []interface{}{"pexpireat", "k", int64(10), "gt"}},
+		{[]string{"setex", "k", "10", "v"}, []interface{}{"set", "k", "v", "pxat", now + 10000}},
+		{[]string{"psetex", "k", "10", "v"}, []interface{}{"set", "k", "v", "pxat", now + 10}},
+		{[]string{"set", "k", "v", "nx", "EX", "10", "get"}, []interface{}{"set", "k", "v", "nx", "pxat", now + 10000, "get"}},
+		{[]string{"set", "k", "px", "keepttl"}, []interface{}{"set", "k", "px", "keepttl"}},
+		{[]string{"lock.acquire", "k", "o", "PX", "10"}, []interface{}{"lock.acquire", "k", "o", "pxat", now + 10}},
+		{[]string{"lock.extend", "k", "o", "ex", "10"}, []interface{}{"lock.extend", "k", "o", "pxat", now + 10000}},
+		{[]string{"del", "k"}, []interface{}{"del", "k"}},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.vals, absExpireArgs(c.args, now), c.args)
+	}
+
+	assert.Equal(t, []string{"pexpireat", "k", "10000", "NX"}, AbsExpireArgs([]string{"expireat", "k", "10", "NX"}))
+	assert.Equal(t, []string{"del", "k"}, AbsExpireArgs([]string{"del", "k"}))
+}
+
+func TestParseAppendFsync(t *testing.T) {
+	fsync, err := ParseAppendFsync("EverySec")
+	assert.Nil(t, err)
+	assert.Equal(t, AppendFsyncEverySec, fsync)
+
+	_, err = ParseAppendFsync("sometimes")
+	assert.NotNil(t, err)
+}
+
+func TestDB_RewriteAppendOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gres-aof")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	db := NewDB(AppendOnlyOption(true))
+	assert.Nil(t, db.openAppendOnly())
+	defer db.aof.close()
+
+	db.Set("plain", []byte("P"))
+	db.Expire("plain", 100)
+	db.RPush("list", "A", "B", "C")
+	db.SAdd("set", "S")
+	db.ZAdd("zset", 1.5, "Z")
+	db.HSet("hash", "F", "H")
+	for i := 0; i < aofRewriteItemsPerCmd+1; i++ {
+		db.RPush("long", i)
+	}
+	db.LockAcquire("lock", "007", util.NowMs()+100*1000)
+	for i := 0; i < 4; i++ {
+		db.LockAcquire("tmp", "a", util.NowMs()+100*1000)
+		db.LockRelease("tmp", "a")
+	}
+	g, _ := idgen.NewSegment(1, 2)
+	db.IDCreate("id", g)
+	db.IDNext("id", 2, util.NowMs())
+
+	assert.Nil(t, db.RewriteAppendOnly())
+
+	bases, err := db.listFiles(BaseAofSuffix)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(bases))
+	segments, err := db.aofStamps(0)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{db.stamp}, segments)
+
+	file, err := os.Open(bases[0])
+	assert.Nil(t, err)
+	defer file.Close()
+
+	var cmds []string
+	rd := proto.NewReader(file)
+	for {
+		v, err := rd.ReadReply()
+		if err != nil {
+			break
+		}
+		args, _ := recordArgs(v)
+		if len(args) > 2 && args[1] == "long" {
+			cmds = append(cmds, fmt.Sprintf("%v %v %v", args[0], args[1], len(args)-2))
+			continue
+		}
+		cmds = append(cmds, strings.Join(args, " "))
+	}
+	sort.Strings(cmds)
+
+	assert.Equal(t, 13, len(cmds))
+	assert.Contains(t, cmds, "lock.setfence 5")
+	assert.Contains(t, cmds, "lock.restore lock 007 1")
+	assert.Contains(t, cmds, "id.create id segment step 2 last 3")
+	assert.Contains(t, cmds, "select 0")
+	assert.Contains(t, cmds, "set plain P")
+	assert.Contains(t, cmds, "rpush list A B C")
+	assert.Contains(t, cmds, "sadd set S")
+	assert.Contains(t, cmds, "zadd zset 1.5 Z")
+	assert.Contains(t, cmds, "hset hash F H")
+	assert.Contains(t, cmds, fmt.Sprintf("rpush long %v", aofRewriteItemsPerCmd))
+	assert.Contains(t, cmds, "rpush long 1")
+
+	// 重放后锁的 owner, token 与租期不变, 之后发放的 token 仍然递增
+	RegisterReplayFunc(testReplay)
+	newDB := NewDB(AppendOnlyOption(true))
+	assert.Nil(t, newDB.ReadFromFile())
+	assert.Equal(t, "lock", newDB.Type("lock"))
+	ttl := newDB.Ttl("lock")
+	assert.True(t, ttl > 0 && ttl <= 100)
+	token, _ := newDB.LockAcquire("lock", "007", util.NowMs()+100*1000)
+	assert.Equal(t, int64(1), token)
+	token, _ = newDB.LockAcquire("lock", "7", util.NowMs()+100*1000)
+	assert.Equal(t, int64(0), token)
+	token, _ = newDB.LockAcquire("other", "a", util.NowMs()+100*1000)
+	assert.Equal(t, int64(6), token)
+}
+
+func TestDB_AppendOnlySelect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gres-aof")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	RegisterReplayFunc(testReplay)
+	db := NewDB(AppendOnlyOption(true), DbnumOption(4))
+	assert.Nil(t, db.openAppendOnly())
+	db2, _ := db.Select(2)
+
+	for _, d := range []*DB{db2, db, db2} {
+		d := d
+		args := []string{"set", fmt.Sprint("key", d.Index()), "V"}
+		d.Propagate(args, func() bool {
+			return testReplay(d, args) == nil
+		})
+	}
+	assert.Nil(t, db.aof.close())
+
+	newDB := NewDB(AppendOnlyOption(true), DbnumOption(4))
+	assert.Nil(t, newDB.ReadFromFile())
+	newDB2, _ := newDB.Select(2)
+	assert.True(t, newDB.Exists("key0"))
+	assert.True(t, newDB2.Exists("key2"))
+	assert.Equal(t, 1, newDB.DbSize())
+	assert.Equal(t, 1, newDB2.DbSize())
+}
+
+func TestDB_AppendOnlyTx(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gres-aof")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	RegisterReplayFunc(testReplay)
+	db := NewDB(AppendOnlyOption(true))
+	assert.Nil(t, db.openAppendOnly())
+
+	feed := func(args ...string) {
+		db.Propagate(args, func() bool {
+			return testReplay(db, args) == nil
+		})
+	}
+	db.Exclusive(func() {
+		feed("set", "a", "A")
+		feed("set", "b", "B")
+	})
+	// 没有写命令的事务不会留下记录
+	db.Exclusive(func() {})
+	assert.Nil(t, db.aof.close())
+
+	// 模拟写入事务时宕机
+	file, err := os.OpenFile(db.aof.filename, os.O_WRONLY|os.O_APPEND, 0666)
+	assert.Nil(t, err)
+	w := proto.NewWriter(file)
+	assert.Nil(t, w.ReplyArrays([]interface{}{"multi"}))
+	assert.Nil(t, w.ReplyArrays([]interface{}{"set", "c", "C"}))
+	assert.Nil(t, w.Flush())
+	assert.Nil(t, file.Close())
+
+	newDB := NewDB(AppendOnlyOption(true))
+	assert.Nil(t, newDB.ReadFromFile())
+	assert.True(t, newDB.Exists("a"))
+	assert.True(t, newDB.Exists("b"))
+	assert.False(t, newDB.Exists("c"))
+}
@@ -0,0 +1,201 @@
+package engine
+
+import (
+	"container/list"
+	"sync/atomic"
+)
+
+// BlockServeFunc pops an element of key for a blocked client. It reports whether
+// an element was popped, and returns an error such as ErrWrongTypeOps.
+// It is called with the shared lock held, so it can propagate the pop.
+type BlockServeFunc func(key string) (bool, error)
+
+// Blocked is a client blocked by BLPOP and so on, which waits for any of keys.
+type Blocked struct {
+	db     *DB
+	keys   []string
+	elems  []*list.Element // 在每个 key 的等待队列中的位置
+	serve  BlockServeFunc
+	served bool
+	done   chan struct{}
+}
+
+type readyKey struct {
+	db  *DB
+	key string
+	all bool // db 中所有被阻塞的 key, 如 SWAPDB 之后
+}
+
+// Block serves the client at once if any of keys has elements. Otherwise it
+// blocks the client on keys, until an element is pushed to one of them and the
+// client is the first one waiting for it. A nil Blocked is returned if the
+// client has been served.
+func (db *DB) Block(keys []string, serve BlockServeFunc) (*Blocked, error) {
+	root := db.root
+	// 先计数, 之后的 push 都会标记 key, 避免检查与加入等待队列之间的 push 被遗漏
+	atomic.AddInt32(&root.blocked, 1)
+
+	root.blockLock.Lock()
+	defer root.blockLock.Unlock()
+
+	for _, key := range keys {
+		ok, err := serve(key)
+		if err != nil || ok {
+			atomic.AddInt32(&root.blocked, -1)
+			return nil, err
+		}
+	}
+
+	b := &Blocked{
+		db:    db,
+		serve: serve,
+		done:  make(chan struct{}),
+	}
+	if db.blockingKeys == nil {
+		db.blockingKeys = make(map[string]*list.List)
+	}
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		q, ok := db.blockingKeys[key]
+		if !ok {
+			q = list.New()
+			db.blockingKeys[key] = q
+		}
+		b.keys = append(b.keys, key)
+		b.elems = append(b.elems, q.PushBack(b))
+	}
+	return b, nil
+}
+
+// Done is closed when the client is served.
+func (b *Blocked) Done() <-chan struct{} {
+	return b.done
+}
+
+// Unblock stops waiting, such as on timeout, and reports whether the client
+// has been served before.
+func (b *Blocked) Unblock() bool {
+	root := b.db.root
+	root.blockLock.Lock()
+	defer root.blockLock.Unlock()
+
+	if b.served {
+		return true
+	}
+	b.removeLocked()
+	return false
+}
+
+func (b *Blocked) removeLocked() {
+	for i, key := range b.keys {
+		q := b.db.blockingKeys[key]
+		q.Remove(b.elems[i])
+		if q.Len() == 0 {
+			delete(b.db.blockingKeys, key)
+		}
+	}
+	b.elems = nil
+	atomic.AddInt32(&b.db.root.blocked, -1)
+}
+
+// signalReady marks key as having new elements, so the clients blocked on it are
+// served by ServeBlocked after the command.
+func (db *DB) signalReady(key string) {
+	db.signal(readyKey{db: db, key: key})
+}
+
+func (db *DB) signal(r readyKey) {
+	root := db.root
+	if atomic.LoadInt32(&root.blocked) == 0 {
+		return
+	}
+
+	root.readyLock.Lock()
+	defer root.readyLock.Unlock()
+	if root.readySet == nil {
+		root.readySet = make(map[readyKey]struct{})
+	}
+	if _, ok := root.readySet[r]; ok {
+		return
+	}
+	root.readySet[r] = struct{}{}
+	root.readyKeys = append(root.readyKeys, r)
+	atomic.AddInt32(&root.readyNum, 1)
+}
+
+func (db *DB) popReady() (readyKey, bool) {
+	root := db.root
+	root.readyLock.Lock()
+	defer root.readyLock.Unlock()
+
+	if len(root.readyKeys) == 0 {
+		return readyKey{}, false
+	}
+	r := root.readyKeys[0]
+	root.readyKeys = root.readyKeys[1:]
+	delete(root.readySet, r)
+	atomic.AddInt32(&root.readyNum, -1)
+	return r, true
+}
+
+// ServeBlocked serves the clients blocked on the keys which got new elements, in
+// the order they were blocked. It must be called after a command which may push
+// elements, with the shared or exclusive lock held.
+func (db *DB) ServeBlocked() {
+	root := db.root
+	if atomic.LoadInt32(&root.readyNum) == 0 {
+		return
+	}
+
+	root.blockLock.Lock()
+	defer root.blockLock.Unlock()
+
+	// 服务过程中可能 push 新的元素, 如 BRPOPLPUSH, 直到没有 key 需要处理
+	for {
+		r, ok := root.popReady()
+		if !ok {
+			return
+		}
+		if !r.all {
+			r.db.serveBlockedLocked(r.key)
+			continue
+		}
+		keys := make([]string, 0, len(r.db.blockingKeys))
+		for key := range r.db.blockingKeys {
+			keys = append(keys, key)
+		}
+		for _, key := range keys {
+			r.db.serveBlockedLocked(key)
+		}
+	}
+}
+
+func (db *DB) serveBlockedLocked(key string) {
+	q, ok := db.blockingKeys[key]
+	if !ok {
+		return
+	}
+
+	for e := q.Front(); e != nil; {
+		next := e.Next()
+		b := e.Value.(*Blocked)
+		if ok, _ := b.serve(key); ok {
+			b.served = true
+			b.removeLocked()
+			close(b.done)
+		}
+		e = next
+	}
+}
+
+// signalBlockedKeys marks all the keys which have blocked clients, such as after
+// SWAPDB changes the data of db. The keys are collected by ServeBlocked, so it does
+// not take the blockLock, which ServeBlocked holds while it propagates the pops.
+func (db *DB) signalBlockedKeys() {
+	db.signal(readyKey{db: db, all: true})
+}
@@ -0,0 +1,122 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// lpopServe pops key into *got for a blocked client.
+func lpopServe(db *DB, got *[]interface{}) BlockServeFunc {
+	return func(key string) (bool, error) {
+		val, err := db.LPop(key)
+		if err != nil || val == nil {
+			return false, err
+		}
+		*got = append(*got, val)
+		return true, nil
+	}
+}
+
+func TestDB_Block(t *testing.T) {
+	db := NewDB()
+	db.RPush("a", "A")
+
+	// 有元素时立即返回
+	var got []interface{}
+	b, err := db.Block([]string{"b", "a"}, lpopServe(db, &got))
+	assert.Nil(t, err)
+	assert.Nil(t, b)
+	assert.Equal(t, []interface{}{"A"}, got)
+
+	db.Set("s", []byte("S"))
+	_, err = db.Block([]string{"s"}, lpopServe(db, &got))
+	assert.Equal(t, ErrWrongTypeOps, err)
+	assert.Equal(t, int32(0), db.blocked)
+}
+
+func TestDB_BlockFIFO(t *testing.T) {
+	db := NewDB()
+	var first, second []interface{}
+	b1, _ := db.Block([]string{"a"}, lpopServe(db, &first))
+	b2, _ := db.Block([]string{"b", "a"}, lpopServe(db, &second))
+	assert.Equal(t, int32(2), db.blocked)
+
+	// 先阻塞的连接先被服务
+	db.RPush("a", "A1")
+	db.ServeBlocked()
+	<-b1.Done()
+	assert.True(t, b1.Unblock())
+	assert.Equal(t, []interface{}{"A1"}, first)
+	assert.Empty(t, second)
+
+	db.RPush("a", "A2", "A3")
+	db.ServeBlocked()
+	<-b2.Done()
+	assert.Equal(t, []interface{}{"A2"}, second)
+	assert.Equal(t, 1, db.Del("a"))
+	assert.Equal(t, int32(0), db.blocked)
+	assert.Empty(t, db.blockingKeys)
+}
+
+func TestDB_BlockUnblock(t *testing.T) {
+	db := NewDB()
+	var got []interface{}
+	b, _ := db.Block([]string{"a", "a"}, lpopServe(db, &got))
+	assert.False(t, b.Unblock())
+	assert.Empty(t, db.blockingKeys)
+
+	db.RPush("a", "A")
+	db.ServeBlocked()
+	assert.Empty(t, got)
+	assert.True(t, db.Exists("a"))
+}
+
+func TestDB_BlockSwapDB(t *testing.T) {
+	db := NewDB(DbnumOption(2))
+	other, _ := db.Select(1)
+	other.RPush("a", "A")
+
+	var got []interface{}
+	b, _ := db.Block([]string{"a"}, lpopServe(db, &got))
+	assert.Nil(t, db.SwapDB(0, 1))
+	db.ServeBlocked()
+	<-b.Done()
+	assert.Equal(t, []interface{}{"A"}, got)
+}
+
+func TestDB_BlockSwapDBConcurrent(t *testing.T) {
+	db := NewDB(DbnumOption(2))
+	entered, proceed := make(chan struct{}), make(chan struct{})
+	serve := func(key string) (bool, error) {
+		close(entered)
+		<-proceed
+		val, err := db.LPop(key)
+		return val != nil, err
+	}
+
+	// BLPOP 持有 blockLock 时 SWAPDB 开始执行, 两者不会互相等待
+	done := make(chan struct{}, 2)
+	go func() {
+		if b, _ := db.Block([]string{"q"}, serve); b != nil {
+			b.Unblock()
+		}
+		done <- struct{}{}
+	}()
+	<-entered
+	go func() {
+		db.SwapDB(0, 1)
+		done <- struct{}{}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(proceed)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("deadlock")
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/clovers4/gres/util"
 	fnv2 "hash/fnv"
 	"io"
+	"math/rand"
 	"sort"
 	"sync"
 )
@@ -75,6 +76,56 @@ func (cm *CMap) Remove(key string) (v interface{}, ok bool) {
 	return v, ok
 }
 
+// Locked accesses the keys whose segments are locked by LockKeys, without locking.
+type Locked struct {
+	cm *CMap
+}
+
+func (l Locked) Get(key string) (val interface{}, existed bool) {
+	val, existed = l.cm.getSegment(key).items[key]
+	return val, existed
+}
+
+func (l Locked) Set(key string, value interface{}) {
+	l.cm.getSegment(key).items[key] = value
+}
+
+func (l Locked) Remove(key string) {
+	delete(l.cm.getSegment(key).items, key)
+}
+
+// LockKeys locks the segments of keys in every map, so that the keys are read and
+// written atomically through the returned Locked, until unlock is called. The keys
+// must not be accessed by the other methods before unlock. To avoid deadlock, the
+// segments are locked in order, and several maps must be given in the same order.
+func LockKeys(keys []string, maps ...*CMap) (locked []Locked, unlock func()) {
+	var idxs []int
+	seen := make(map[int]bool, len(keys))
+	for _, key := range keys {
+		i := int(hash(key) % uint32(defaultSegmentCount))
+		if !seen[i] {
+			seen[i] = true
+			idxs = append(idxs, i)
+		}
+	}
+	sort.Ints(idxs)
+
+	locked = make([]Locked, len(maps))
+	for j, cm := range maps {
+		locked[j] = Locked{cm: cm}
+		for _, i := range idxs {
+			cm.segments[i].Lock()
+		}
+	}
+	return locked, func() {
+		for j := len(maps) - 1; j >= 0; j-- {
+			for _, i := range idxs {
+				maps[j].segments[i].Unlock()
+			}
+		}
+	}
+}
+
 func (cm *CMap) ForEachRead(fn func(key string, val interface{})) {
 	for _, seg := range cm.segments {
 		seg.RLock()
@@ -85,6 +136,28 @@ func (cm *CMap) ForEachRead(fn func(key string, val interface{})) {
 	}
 }
 
+// Sample calls fn with at most n elements. It starts from a random segment and
+// relies on the random iteration order of go map, so the elements are roughly random.
+func (cm *CMap) Sample(n int, fn func(key string, val interface{})) {
+	// 每个 segment 只取一部分, 使样本分散在多个 segment 中
+	perSegment := n/4 + 1
+	start := rand.Intn(len(cm.segments))
+	for i := 0; i < len(cm.segments) && n > 0; i++ {
+		seg := cm.segments[(start+i)%len(cm.segments)]
+		seg.RLock()
+		taken := 0
+		for k, v := range seg.items {
+			if taken == perSegment || n == 0 {
+				break
+			}
+			fn(k, v)
+			taken++
+			n--
+		}
+		seg.RUnlock()
+	}
+}
+
 // Count returns amount of elements in CMap.
 // But the count is not very accurate.
 func (cm *CMap) Count() int {
@@ -97,8 +97,8 @@ func TestCMap_Count_ConcurrentSuccess(t *testing.T) {
 
 func TestCMap_Marshal(t *testing.T) {
 	cm := New()
-	cm.Set("plain-A", object.PlainObject("A-and"))
-	cm.Set("plain-B", object.PlainObject("B-bb"))
+	cm.Set("plain-A", object.PlainObject([]byte("A-and")))
+	cm.Set("plain-B", object.PlainObject([]byte("B-bb")))
 
 	lsObj := object.ListObject()
 	ls, _ := lsObj.List()
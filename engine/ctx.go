@@ -1,8 +1,15 @@
 package engine
 
-import "context"
+import (
+	"context"
 
-const ctxDB = "db"
+	"github.com/clovers4/gres/util"
+)
+
+const (
+	ctxDB  = "db"
+	ctxNow = "now"
+)
 
 func CtxWithDB(ctx context.Context, db *DB) context.Context {
 	return context.WithValue(ctx, ctxDB, db)
@@ -15,3 +22,19 @@ func CtxGetDB(ctx context.Context) *DB {
 	}
 	return v.(*DB)
 }
+
+// CtxWithNow sets the time of the command to now, the unix time in milliseconds,
+// such as the time of the leader in the raft log, so that every node gets the same
+// result.
+func CtxWithNow(ctx context.Context, now int64) context.Context {
+	return context.WithValue(ctx, ctxNow, now)
+}
+
+// CtxGetNow returns the time of the command set by CtxWithNow, or the current time.
+func CtxGetNow(ctx context.Context) int64 {
+	v := ctx.Value(ctxNow)
+	if v == nil {
+		return util.NowMs()
+	}
+	return v.(int64)
+}
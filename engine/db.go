@@ -2,13 +2,18 @@ package engine
 
 import (
 	"bufio"
+	"container/list"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/clovers4/gres/engine/cmap"
@@ -32,32 +37,88 @@ var (
 	ErrUnsupportedVersion = errors.New("the version is unsupported")
 
 	// commands
-	ErrWrongTypeOps = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	ErrWrongTypeOps      = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	ErrDBIndexOutOfRange = errors.New("DB index is out of range")
+	ErrSameDB            = errors.New("source and destination objects are the same")
 )
 
 const (
-	FilenamePrefix = "gres_"
-	FilenameFormat = FilenamePrefix + "%v.db"
-	FilenameRegex  = FilenamePrefix + "*"
-	GRES           = "GRES"
-	DBVersion      = "0.0.1"
+	// 快照, aof 等文件名为 <dbfilename>_<stamp><suffix>, 如 gres_<stamp>.db
+	DefaultDBFilename  = "gres"
+	DBSuffix           = ".db"
+	TempFilenamePrefix = "temp-"
+	GRES               = "GRES"
+	DBVersion          = "0.0.5" // 0.0.5 起, 末尾保存锁的 fencing token
+	dbVersionMs        = "0.0.4" // 0.0.4 起, 过期时间精确到毫秒, 仍然可以读取
+	dbVersionBytes     = "0.0.3" // 0.0.3 起, string 按字节存储; 过期时间为秒, 仍然可以读取
+	dbVersionTyped     = "0.0.2" // 0.0.2 起, 文件中包含多个 db; string 按 go 的类型存储, 仍然可以读取
+	dbVersionSingle    = "0.0.1" // 只有一个 db 的老版本, 仍然可以读取
+
+	DefaultDbnum = 16
 )
 
+// DB is one numbered keyspace. All the dbs of a server are created together by NewDB,
+// and the one numbered 0 is the root, which holds the state they share, such as
+// persistence, the append-only file and eviction.
 type DB struct {
+	index int   // 编号
+	root  *DB   // 编号为 0 的 db
+	dbs   []*DB // 所有的 db, 只有 root 使用
+	dbnum int
+
 	persist     bool          // 是否要持久化
 	persistTime time.Duration // [persist策略] 每隔多久执行一次持久化
+	confLock    sync.RWMutex  // 保护运行中可修改的 persistTime, appendFsync 与 notifyClasses
 
-	doExpireTime       time.Duration // [expire策略] 每隔多久执行一次 doExpire
-	doExpireMinNum     int           // [expire策略] 执行 expire 最少个数
-	doExpireMinPercent float64       // [expire策略] 执行 expire 最小百分比
+	activeExpire ActiveExpireConfig // [expire策略] active expire cycle 的参数, 只有 root 使用
+	expireLock   sync.RWMutex       // 保护 activeExpire 与 expireStats
+	expireStats  ExpireStats
+	expiredKeys  uint64
 
-	filename string
-	fileLock *flock.Flock
+	dir        string // 数据目录, 空表示当前目录
+	dbFilename string // 数据文件名的前缀
+	filename   string
+	stamp      int64 // 当前快照的时间戳, 其后的写命令记录在同一时间戳的 aof 分段中
+	fileLock   *flock.Flock
 
-	dataMap    *cmap.CMap // 正常情况下, 往该 map 中进行存取
-	expireList *zset.ZSet // 实现过期功能. k=key(string),v=time(unixtime-int64)
+	appendOnly  bool         // 是否开启 aof
+	appendFsync AppendFsync  // [aof策略] fsync 时机
+	aof         *aof         // 当前写入的 aof 分段
+	cmdLock     sync.RWMutex // 命令执行时持有读锁; Save 切换 aof 分段与 EXEC 持有写锁, 使其不会与其他命令交错
+	propagateMu sync.Mutex   // 写命令的执行与传播在同一把锁中, 使 aof 与 replica 中的顺序与执行顺序相同
+
+	repl *replication // 向 replica 传播写命令, 只有 root 使用
+
+	fence int64 // [lock] 最近发放的 fencing token, 只增不减, 只有 root 使用
+
+	watchLock   sync.Mutex
+	watchedKeys map[string]*watchedKey // 被 WATCH 的 key
+	watching    int32                  // 被 WATCH 的 key 的个数
 
-	onSave          bool // 持久化中
+	dataMap    *cmap.CMap // 正常情况下, 往该 map 中进行存取
+	expireList *zset.ZSet // 实现过期功能. k=key(string),v=time(unix 毫秒-int64)
+
+	maxMemory        uint64        // [evict策略] 内存上限, 0 表示不限制
+	maxMemoryPolicy  EvictPolicy   // [evict策略] 达到上限后如何逐出
+	maxMemorySamples int           // [evict策略] 每次逐出时采样的 key 个数
+	memoryUsage      func() uint64 // 当前使用的内存
+	meter            memoryMeter
+	evictLock        sync.Mutex // 同一时间只能有一个逐出
+	evictedKeys      uint64
+
+	notifyClasses NotifyClass // [notify] 开启的 keyspace 事件
+	notifyFunc    NotifyFunc  // [notify] 将事件发布到频道
+
+	blockLock    sync.Mutex
+	blockingKeys map[string]*list.List // key -> 按阻塞先后排列的 *Blocked
+	blocked      int32                 // 阻塞中的连接个数, 只有 root 使用
+	readyLock    sync.Mutex
+	readySet     map[readyKey]struct{}
+	readyKeys    []readyKey // 有新元素, 需要服务阻塞连接的 key
+	readyNum     int32
+
+	saveLock        sync.Mutex // 同一时间只能有一个持久化
+	onSave          bool       // 持久化中
 	dirtyLock       sync.RWMutex
 	dirtyDataMap    *cmap.CMap // 持久化中, 新数据存入该 map
 	dirtyExpireList *zset.ZSet // 持久化中, 新数据存入该 map
@@ -73,6 +134,119 @@ func PersistOption(persist bool) dbOption {
 	}
 }
 
+// PersistTimeOption sets how often the dataset is saved.
+func PersistTimeOption(d time.Duration) dbOption {
+	return func(db *DB) {
+		db.persistTime = d
+	}
+}
+
+func AppendOnlyOption(appendOnly bool) dbOption {
+	return func(db *DB) {
+		db.appendOnly = appendOnly
+	}
+}
+
+func AppendFsyncOption(fsync AppendFsync) dbOption {
+	return func(db *DB) {
+		db.appendFsync = fsync
+	}
+}
+
+// MaxMemoryOption sets the memory limit in bytes, 0 means no limit.
+func MaxMemoryOption(bytes uint64) dbOption {
+	return func(db *DB) {
+		db.maxMemory = bytes
+	}
+}
+
+func MaxMemoryPolicyOption(policy EvictPolicy) dbOption {
+	return func(db *DB) {
+		db.maxMemoryPolicy = policy
+	}
+}
+
+func MaxMemorySamplesOption(samples int) dbOption {
+	return func(db *DB) {
+		db.maxMemorySamples = samples
+	}
+}
+
+// NotifyKeyspaceEventsOption sets the classes of the keyspace events to publish.
+func NotifyKeyspaceEventsOption(classes NotifyClass) dbOption {
+	return func(db *DB) {
+		db.notifyClasses = classes
+	}
+}
+
+// NotifyFuncOption sets how the keyspace events are published, such as by the
+// pub/sub of the server.
+func NotifyFuncOption(fn NotifyFunc) dbOption {
+	return func(db *DB) {
+		db.notifyFunc = fn
+	}
+}
+
+// ActiveExpireHzOption sets how many times the active expire cycle runs per second.
+func ActiveExpireHzOption(hz int) dbOption {
+	return func(db *DB) {
+		db.activeExpire.Hz = hz
+	}
+}
+
+// ActiveExpireKeysPerLoopOption sets the number of keys checked in each loop of
+// the active expire cycle.
+func ActiveExpireKeysPerLoopOption(n int) dbOption {
+	return func(db *DB) {
+		db.activeExpire.KeysPerLoop = n
+	}
+}
+
+// ActiveExpireAcceptableStaleOption sets the percent of expired keys in a loop,
+// above which the active expire cycle loops again.
+func ActiveExpireAcceptableStaleOption(percent int) dbOption {
+	return func(db *DB) {
+		db.activeExpire.AcceptableStale = percent
+	}
+}
+
+// ActiveExpireCyclePercentOption sets the time limit of the active expire cycle,
+// as the percent of the time between two cycles.
+func ActiveExpireCyclePercentOption(percent int) dbOption {
+	return func(db *DB) {
+		db.activeExpire.CyclePercent = percent
+	}
+}
+
+// DirOption sets the directory of the snapshots, the append-only files and the lock file.
+func DirOption(dir string) dbOption {
+	return func(db *DB) {
+		db.dir = dir
+	}
+}
+
+// DBFilenameOption sets the prefix of the data files, such as gres for gres_<stamp>.db,
+// so that several servers can share one directory.
+func DBFilenameOption(name string) dbOption {
+	return func(db *DB) {
+		db.dbFilename = name
+	}
+}
+
+// DbnumOption sets the number of dbs.
+func DbnumOption(n int) dbOption {
+	return func(db *DB) {
+		db.dbnum = n
+	}
+}
+
+// ReplBacklogSizeOption sets the bytes of the replication backlog.
+func ReplBacklogSizeOption(bytes int) dbOption {
+	return func(db *DB) {
+		db.repl.backlogSize = bytes
+	}
+}
+
 func LogOption(log *zap.Logger) dbOption {
 	return func(db *DB) {
 		db.log = log
@@ -87,12 +261,18 @@ func NewDB(ops ...dbOption) *DB {
 
 	db := &DB{
 		persist:     false,
-		persistTime: 1 * time.Second, // default
+		persistTime: 1 * time.Second,   // default
+		dbFilename:  DefaultDBFilename, // default
+
+		activeExpire: DefaultActiveExpireConfig, // default
+
+		appendFsync: AppendFsyncEverySec, // default
 
-		doExpireTime:       1 * time.Second, // default
-		doExpireMinNum:     100,             // default
-		doExpireMinPercent: 0.20,            // default
+		maxMemoryPolicy:  EvictNoEviction, // default
+		maxMemorySamples: 5,               // default
 
+		dbnum:      DefaultDbnum, // default
+		repl:       newReplication(),
 		dataMap:    cmap.New(),
 		expireList: zset.New(),
 		log:        log,
@@ -100,6 +280,29 @@ func NewDB(ops ...dbOption) *DB {
 	for _, op := range ops {
 		op(db)
 	}
+	db.memoryUsage = db.meter.used
+	if db.dbnum < 1 {
+		panic(fmt.Errorf("invalid dbnum: %v", db.dbnum))
+	}
+	if err := db.activeExpire.validate(); err != nil {
+		panic(err)
+	}
+	if db.persistTime <= 0 {
+		panic(fmt.Errorf("invalid persist time: %v", db.persistTime))
+	}
+	if db.repl.backlogSize <= 0 {
+		panic(fmt.Errorf("invalid backlog size: %v", db.repl.backlogSize))
+	}
+	if err := ValidateDBFilename(db.dbFilename); err != nil {
+		panic(err)
+	}
+
+	db.root = db
+	db.dbs = make([]*DB, db.dbnum)
+	db.dbs[0] = db
+	for i := 1; i < db.dbnum; i++ {
+		db.dbs[i] = db.newSibling(i)
+	}
 
 	if db.persist {
 		if err := db.keepOneProcess(); err != nil {
@@ -108,14 +311,52 @@ func NewDB(ops ...dbOption) *DB {
 		if err := db.ReadFromFile(); err != nil {
 			panic(err)
 		}
+		if db.appendOnly {
+			if err := db.openAppendOnly(); err != nil {
+				panic(err)
+			}
+		}
 		go db.SaveBackground()
 	}
 
-	go db.DoExpireBackground()
+	for _, d := range db.dbs {
+		go d.DoExpireBackground()
+	}
 
 	return db
 }
 
+// newSibling creates the db numbered index, which shares the state of root.
+func (db *DB) newSibling(index int) *DB {
+	return &DB{
+		index: index,
+		root:  db,
+		dbnum: db.dbnum,
+
+		dataMap:    cmap.New(),
+		expireList: zset.New(),
+		log:        db.log,
+	}
+}
+
+// Index returns the number of db.
+func (db *DB) Index() int {
+	return db.index
+}
+
+// Dbnum returns the number of dbs.
+func (db *DB) Dbnum() int {
+	return db.dbnum
+}
+
+// Select returns the db numbered index.
+func (db *DB) Select(index int) (*DB, error) {
+	if index < 0 || index >= len(db.root.dbs) {
+		return nil, ErrDBIndexOutOfRange
+	}
+	return db.root.dbs[index], nil
+}
+
 func (db *DB) set(key string, obj *object.Object) *object.Object {
 	db.dirtyLock.RLock()
 	defer db.dirtyLock.RUnlock()
@@ -124,6 +365,8 @@ func (db *DB) set(key string, obj *object.Object) *object.Object {
 }
 
 func (db *DB) setLocked(key string, obj *object.Object) *object.Object {
+	db.touch(key)
+
 	targetMap := db.dataMap
 	if db.onSave {
 		targetMap = db.dirtyDataMap
@@ -146,11 +389,16 @@ func (db *DB) removeLocked(key string) *object.Object {
 	if db.onSave {
 		// 设置 Expunged 作为空标志位
 		if oldValue, existed := db.dirtyDataMap.Set(key, object.Expunged); existed {
+			if oldValue == object.Expunged {
+				return nil
+			}
+			db.touch(key)
 			return oldValue.(*object.Object)
 		}
 
 		// 从 dataMap 读取原始值
 		if oldValue, existed := db.dataMap.Get(key); existed {
+			db.touch(key)
 			return oldValue.(*object.Object)
 		}
 		return nil
@@ -158,6 +406,7 @@ func (db *DB) removeLocked(key string) *object.Object {
 
 	// 非持久化中
 	if oldValue, existed := db.dataMap.Remove(key); existed {
+		db.touch(key)
 		return oldValue.(*object.Object)
 	}
 	return nil
@@ -167,15 +416,21 @@ func (db *DB) get(key string) *object.Object {
 	db.dirtyLock.RLock()
 	defer db.dirtyLock.RUnlock()
 
-	return db.getLocked(key)
+	obj := db.getLocked(key)
+	if obj != nil {
+		obj.Touch(time.Now())
+	}
+	return obj
 }
 
 func (db *DB) getLocked(key string) *object.Object {
+	// 已过期的 key 视为不存在
+	if db.expireIfNeededLocked(key) {
+		return nil
+	}
+
 	// 持久化中
 	if db.onSave {
-		// use the side effect, if key is expired, then kv will be nil
-		db.ttlLocked(key)
-
 		// 先从 dirtyDataMap 读, 若为 Expunged, 则认为已删除
 		if oldValue, existed := db.dirtyDataMap.Get(key); existed {
 			if oldValue == object.Expunged {
@@ -193,6 +448,30 @@ func (db *DB) getLocked(key string) *object.Object {
 	return v.(*object.Object)
 }
 
+// getForWrite returns the object which is safe to modify in place. During Save the
+// object in dataMap is being persisted, so it is copied into dirtyDataMap first.
+// The caller marks the key as modified by touch only after it is changed.
+func (db *DB) getForWrite(key string) *object.Object {
+	db.dirtyLock.RLock()
+	defer db.dirtyLock.RUnlock()
+
+	obj := db.getLocked(key)
+	if obj == nil {
+		return nil
+	}
+	obj.Touch(time.Now())
+	if !db.onSave {
+		return obj
+	}
+
+	if v, existed := db.dirtyDataMap.Get(key); existed && v == obj {
+		return obj
+	}
+	obj = obj.Clone()
+	db.dirtyDataMap.Set(key, obj)
+	return obj
+}
+
 // heavy ops, only for test, keys command....
 func (db *DB) forEachRead(fn func(key string, val interface{})) {
 	db.dirtyLock.RLock()
@@ -210,32 +489,50 @@ func (db *DB) forEachReadLocked(fn func(key string, val interface{})) {
 	db.dataMap.ForEachRead(fn)
 }
 
-func (db *DB) setExpire(key string, seconds int) bool {
+func (db *DB) setExpireAt(key string, endTime int64, cond ExpireCond) bool {
 	db.dirtyLock.RLock()
 	defer db.dirtyLock.RUnlock()
-	return db.setExpireLocked(key, seconds)
+	return db.setExpireAtLocked(key, endTime, cond)
 }
 
-func (db *DB) setExpireLocked(key string, seconds int) bool {
-	// 若传入 0 秒, 则直接删除
-	if seconds == 0 {
-		if db.removeLocked(key) == nil {
-			return false
-		}
-		return true
+// setExpireAtLocked sets the expire time of key as unix milliseconds if cond is
+// satisfied, a key whose expire time has passed is deleted at once.
+func (db *DB) setExpireAtLocked(key string, endTime int64, cond ExpireCond) bool {
+	if db.getLocked(key) == nil {
+		return false
 	}
 
-	if db.getLocked(key) == nil {
+	t, ok := db.expireTimeLocked(key)
+	if cond&ExpireNX != 0 && ok || cond&ExpireXX != 0 && !ok {
+		return false
+	}
+	// 没有过期时间视为永不过期
+	if cond&ExpireGT != 0 && (!ok || endTime <= t) || cond&ExpireLT != 0 && ok && endTime >= t {
 		return false
 	}
 
+	// 若已过期, 则直接删除
+	if endTime <= util.NowMs() {
+		db.removeLocked(key)
+		db.removeExpireLocked(key)
+		db.notify(NotifyGeneric, "del", key)
+		return true
+	}
+
+	db.addExpireLocked(key, endTime)
+	db.notify(NotifyGeneric, "expire", key)
+	return true
+}
+
+// addExpireLocked sets the expire time of an existing key.
+func (db *DB) addExpireLocked(key string, endTime int64) {
 	targetList := db.expireList
 	if db.onSave {
 		targetList = db.dirtyExpireList
 	}
 
-	endTime := time.Now().Add(time.Duration(seconds) * time.Second).Unix()
-	return targetList.Add(endTime, key)
+	targetList.Add(endTime, key)
+	db.touch(key)
 }
 
 func (db *DB) removeExpire(key string) bool {
@@ -249,6 +546,7 @@ func (db *DB) removeExpireLocked(key string) bool {
 	if db.onSave {
 		// 设置 -1 作为空标志位
 		if notExist := db.dirtyExpireList.Add(-1, key); !notExist {
+			db.touch(key)
 			return true
 		}
 		return false
@@ -256,123 +554,83 @@ func (db *DB) removeExpireLocked(key string) bool {
 
 	// 非持久化中
 	if _, existed := db.expireList.Delete(key); existed {
+		db.touch(key)
 		return true
 	}
 	return false
 }
 
-func (db *DB) ttl(key string) int64 {
+// expireTime returns the expire time of key as unix milliseconds, -2 if key does
+// not exist, or -1 if key has no expire time.
+func (db *DB) expireTime(key string) int64 {
 	db.dirtyLock.RLock()
 	defer db.dirtyLock.RUnlock()
-	return db.ttlLocked(key)
+
+	if db.getLocked(key) == nil {
+		return -2 // key 不存在
+	}
+
+	t, ok := db.expireTimeLocked(key)
+	if !ok {
+		return -1 // key 存在但无 expire 记录
+	}
+	return t
 }
 
-func (db *DB) ttlLocked(key string) int64 {
-	// 持久化中
+// expireTimeLocked returns the expire time of key, without checking whether the key exists.
+func (db *DB) expireTimeLocked(key string) (int64, bool) {
+	// 持久化中, 先从 dirtyExpireList 读, 若为 -1, 则认为 expire 记录已删除
 	if db.onSave {
-		// 先从 dirtyExpireList 读, 若为 Expunged, 则认为 expire 记录已删除
 		if t, existed := db.dirtyExpireList.Get(key); existed {
 			if t == -1 {
-				// 再查看 dataMap 是否有数据
-				if db.getLocked(key) == nil {
-					return -2 // key 不存在但无 expire 记录
-				} else {
-					return -1 // key 存在但无 expire 记录
-				}
-			}
-
-			now := time.Now().Unix()
-			if now >= t {
-				// 说明已过期
-				db.removeExpireLocked(key)
-				db.removeLocked(key)
-				return -2
+				return 0, false
 			}
-			return t - now
+			return t, true
 		}
 	}
 
-	// 非持久化中默认读 dataMap; 持久化中, 若 dirtyDataMap 无数据, 则从 dataMap 读取
-	t, ok := db.expireList.Get(key)
-	if !ok {
-		if db.getLocked(key) == nil {
-			return -2 // key 不存在但无 expire 记录
-		} else {
-			return -1 // key 存在但无 expire 记录
-		}
-	}
-	now := time.Now().Unix()
-	if now >= t {
-		// 说明已过期
-		db.removeExpireLocked(key)
-		db.removeLocked(key)
-		return -2
-	}
-	return t - now
+	// 非持久化中默认读 expireList; 持久化中, 若 dirtyExpireList 无数据, 则从 expireList 读取
+	return db.expireList.Get(key)
 }
 
-func (db *DB) DoExpireBackground() {
-	t := time.NewTicker(db.doExpireTime)
-	for {
-		<-t.C
-		db.doExpire()
-	}
-}
-
-func (db *DB) doExpire() {
-	db.dirtyLock.RLock()
-
-	now := time.Now().Unix()
-	target := db.expireList
-
-	// 持久化中
-	if db.onSave {
-		target = db.dirtyExpireList
+// expireIfNeededLocked removes the key if it is expired, and reports whether it was removed.
+func (db *DB) expireIfNeededLocked(key string) bool {
+	t, ok := db.expireTimeLocked(key)
+	if !ok || util.NowMs() < t {
+		return false
 	}
 
-	target.RLock()
-	n := target.GetNodeLeastScore(0) // ignore -1
-	loop := 0
-	minNum := int(float64(target.Length()) * db.doExpireMinPercent)
-	if db.doExpireMinNum < minNum {
-		minNum = db.doExpireMinNum
+	// 说明已过期
+	db.removeExpireLocked(key)
+	if db.removeLocked(key) != nil {
+		atomic.AddUint64(&db.root.expiredKeys, 1)
+		db.notify(NotifyExpired, "expired", key)
 	}
-	needDels := make([]string, 0, minNum)
-
-	for {
-		if n == nil {
-			break
-		}
-		if loop > minNum {
-			break
-		}
-
-		// 已过期
-		if now >= n.Score() {
-			needDels = append(needDels, n.Val())
-		} else {
-			break
-		}
-
-		n = n.Next()
-	}
-	target.RUnlock()
-	db.dirtyLock.RUnlock()
+	return true
+}
 
-	for _, key := range needDels {
-		db.dirtyLock.RLock()
-		db.removeExpire(key)
-		db.remove(key)
-		db.dirtyLock.RUnlock()
+// SetPersistTime changes how often the dataset is saved, which takes effect after
+// the next save.
+func (db *DB) SetPersistTime(d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("invalid persist time: %v", d)
 	}
 
-	db.log.Debug("[DB doExpire] finished", zap.String("now", time.Now().String()))
+	root := db.root
+	root.confLock.Lock()
+	defer root.confLock.Unlock()
+	root.persistTime = d
+	return nil
 }
 
 func (db *DB) SaveBackground() {
-	t := time.NewTicker(db.persistTime)
+	root := db.root
 	for {
-		<-t.C
+		root.confLock.RLock()
+		d := root.persistTime
+		root.confLock.RUnlock()
+
+		time.Sleep(d)
 		if err := db.Save(); err != nil {
 			db.log.Error("[DB SaveBackground] Save", zap.String("err", err.Error()))
 		} else {
@@ -383,51 +641,142 @@ func (db *DB) SaveBackground() {
 
 // 保证即使持久化过程中断电, 本地文件保存的数据仍具有一致性,
 func (db *DB) Save() error {
-	db.dirtyLock.Lock()
-	db.onSave = true
-	db.dirtyDataMap = cmap.New()
-	db.dirtyExpireList = zset.New()
-
-	// open file
-	newFilename := fmt.Sprintf(FilenameFormat, time.Now().Unix())
-	newFile, err := os.OpenFile(newFilename, os.O_CREATE, 0666)
+	return db.dump(DBSuffix, save)
+}
+
+// snapshot is the data of one db when the persistence starts. It is not modified
+// until the persistence finishes, because new writes go to the dirty maps.
+type snapshot struct {
+	dataMap    *cmap.CMap
+	expireList *zset.ZSet
+}
+
+// dump writes a new base file of all dbs through write, then swaps it in place of the old one.
+// The name of the base file ends with suffix.
+func (db *DB) dump(suffix string, write func(w io.Writer, snaps []snapshot, fence int64) error) error {
+	root := db.root
+	root.saveLock.Lock()
+	defer root.saveLock.Unlock()
+
+	// 阻止写命令执行, 使快照与 aof 分段的切换点一致
+	root.cmdLock.Lock()
+
+	// open file. 使用纳秒, 避免同一秒内的两次持久化覆盖同一文件
+	stamp := time.Now().UnixNano()
+	newFilename := root.filePath(stamp, suffix)
+	tempFilename := filepath.Join(filepath.Dir(newFilename), TempFilenamePrefix+filepath.Base(newFilename))
+	newFile, err := os.OpenFile(tempFilename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err == nil && root.aof != nil {
+		// 此后的写命令记录到新的分段
+		if err = root.aof.rotate(root.filePath(stamp, AofSuffix)); err != nil {
+			newFile.Close()
+			os.Remove(tempFilename)
+		}
+	}
 	if err != nil {
-		db.onSave = false
-		db.dirtyDataMap = nil
-		db.dirtyLock.Unlock()
+		root.cmdLock.Unlock()
 		return err
 	}
 	defer newFile.Close()
-	db.dirtyLock.Unlock()
 
-	// save data to new file
-	err = db.save(newFile)
+	snaps, fence := root.freeze()
+	root.cmdLock.Unlock()
+
+	// save data to new file, 写完后再改名, 使新文件原子地生效
+	err = write(newFile, snaps, fence)
+	if err == nil {
+		err = newFile.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tempFilename, newFilename)
+	}
 
-	// end save
-	db.dirtyLock.Lock()
-	db.dataMap.AddCMap(db.dirtyDataMap)       // flush dirtyDataMap to dataMap: 需要放在持久化完成之后. 此时, db 的 set/get 无法使用，直到完成
-	db.expireList.AddZSet(db.dirtyExpireList) // flush dirtyExpireList to expireList: 需要放在持久化完成之后. 此时, db 的 expire/... 无法使用，直到完成
-	db.onSave = false
-	db.dirtyDataMap = nil
-	db.dirtyExpireList = nil
+	root.unfreeze()
+
+	if err != nil {
+		// 保留老文件, 新的 aof 分段仍可在老文件之上重放
+		if err := os.Remove(tempFilename); err != nil && !os.IsNotExist(err) {
+			root.log.Error("[DB dump] Remove", zap.String("err", err.Error()))
+		}
+		return err
+	}
 
 	// 删除老文件
-	if db.filename != "" {
-		if err := os.Remove(db.filename); err != nil {
-			db.log.Error("[DB Save] Remove", zap.String("err", err.Error()))
+	if root.filename != "" {
+		if err := os.Remove(root.filename); err != nil {
+			root.log.Error("[DB dump] Remove", zap.String("err", err.Error()))
 		}
 	}
+	if root.aof != nil {
+		root.removeOldAppendOnly(stamp)
+	}
+
+	root.filename = newFilename
+	root.stamp = stamp
+	return nil
+}
+
+// freeze takes a snapshot of all dbs and the fencing token, the writes after it go to
+// the dirty maps until unfreeze. The caller must hold the saveLock and the cmdLock.
+func (db *DB) freeze() ([]snapshot, int64) {
+	root := db.root
+	snaps := make([]snapshot, len(root.dbs))
+	for i, d := range root.dbs {
+		d.dirtyLock.Lock()
+		d.onSave = true
+		d.dirtyDataMap = cmap.New()
+		d.dirtyExpireList = zset.New()
+		snaps[i] = snapshot{dataMap: d.dataMap, expireList: d.expireList}
+		d.dirtyLock.Unlock()
+	}
+	return snaps, atomic.LoadInt64(&root.fence)
+}
+
+// unfreeze merges the writes during the snapshot back. The caller must hold the saveLock.
+func (db *DB) unfreeze() {
+	root := db.root
+	// end save. 合并期间不能执行写命令, 否则 SWAPDB 可能交换一个已合并和一个未合并的 db
+	root.cmdLock.Lock()
+	defer root.cmdLock.Unlock()
+	for _, d := range root.dbs {
+		d.dirtyLock.Lock()
+		d.dataMap.AddCMap(d.dirtyDataMap)       // flush dirtyDataMap to dataMap: 需要放在持久化完成之后. 此时, db 的 set/get 无法使用，直到完成
+		d.expireList.AddZSet(d.dirtyExpireList) // flush dirtyExpireList to expireList: 需要放在持久化完成之后. 此时, db 的 expire/... 无法使用，直到完成
+		d.onSave = false
+		d.dirtyDataMap = nil
+		d.dirtyExpireList = nil
+		d.dirtyLock.Unlock()
+	}
+}
+
+// saveTo writes a snapshot of all dbs to w like Save, without touching the files.
+// start is called at the moment of the snapshot, while no command runs.
+func (db *DB) saveTo(w io.Writer, start func()) error {
+	root := db.root
+	root.saveLock.Lock()
+	defer root.saveLock.Unlock()
+
+	root.cmdLock.Lock()
+	snaps, fence := root.freeze()
+	start()
+	root.cmdLock.Unlock()
 
-	db.filename = newFilename
-	db.dirtyLock.Unlock()
+	err := save(w, snaps, fence)
+	root.unfreeze()
 	return err
 }
 
-func (db *DB) save(file *os.File) error {
+// WriteSnapshot writes a snapshot of all dbs to w in the format of the snapshot file,
+// which is read by Load.
+func (db *DB) WriteSnapshot(w io.Writer) error {
+	return db.root.saveTo(w, func() {})
+}
+
+func save(wr io.Writer, snaps []snapshot, fence int64) error {
 	var err error
 
 	// write dataMap to file. Even if failed, needs to write dirtyDataMap to dataMap
-	w := NewCRCWriter(bufio.NewWriter(file))
+	w := NewCRCWriter(bufio.NewWriter(wr))
 
 	// write constant "GRES" and DB_VERSION
 	if err := util.Write(w, GRES); err != nil {
@@ -439,13 +788,36 @@ func (db *DB) save(file *os.File) error {
 		return err
 	}
 
-	// write data
-	if err = db.dataMap.Marshal(w); err != nil {
+	// write the number of dbs which are not empty
+	var indexes []int64
+	for i, snap := range snaps {
+		if snap.dataMap.Count() > 0 {
+			indexes = append(indexes, int64(i))
+		}
+	}
+	if err = util.Write(w, int64(len(indexes))); err != nil {
 		return err
 	}
 
-	// write expire
-	if err = db.expireList.Marshal(w); err != nil {
+	for _, index := range indexes {
+		// write db index
+		if err = util.Write(w, index); err != nil {
+			return err
+		}
+
+		// write data
+		if err = snaps[index].dataMap.Marshal(w); err != nil {
+			return err
+		}
+
+		// write expire
+		if err = snaps[index].expireList.Marshal(w); err != nil {
+			return err
+		}
+	}
+
+	// write fencing token
+	if err = util.Write(w, fence); err != nil {
 		return err
 	}
 
@@ -462,14 +834,19 @@ func (db *DB) save(file *os.File) error {
 }
 
 func (db *DB) readFromFile(filename string) error {
-	var err error
 	file, err := os.Open(filename)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	r := NewCRCReader(bufio.NewReader(file))
+	return db.readSnapshot(bufio.NewReader(file))
+}
+
+// readSnapshot reads the dbs written by save.
+func (db *DB) readSnapshot(rd *bufio.Reader) error {
+	var err error
+	r := NewCRCReader(rd)
 
 	// read header
 	var gresFlag string
@@ -483,18 +860,45 @@ func (db *DB) readFromFile(filename string) error {
 	var dbVersion string
 	if err := util.Read(r, &dbVersion); err != nil {
 		return err
-	} else if dbVersion != DBVersion {
-		return ErrUnsupportedVersion
 	}
 
-	// read dataMap
-	if err = db.dataMap.Unmarshal(r); err != nil {
-		return err
-	}
+	switch dbVersion {
+	case dbVersionSingle:
+		// 老版本只有一个 db, 读入 0 号 db
+		if err = db.root.readDB(r, false); err != nil {
+			return err
+		}
+	case DBVersion, dbVersionMs, dbVersionBytes, dbVersionTyped:
+		var count int64
+		if err = util.Read(r, &count); err != nil {
+			return err
+		}
 
-	// write expire
-	if err = db.expireList.Unmarshal(r); err != nil {
-		return err
+		for i := int64(0); i < count; i++ {
+			var index int64
+			if err = util.Read(r, &index); err != nil {
+				return err
+			}
+
+			target, err := db.Select(int(index))
+			if err != nil {
+				return fmt.Errorf("%v: %v, the dbnum may be too small", err, index)
+			}
+			if err = target.readDB(r, dbVersion == DBVersion || dbVersion == dbVersionMs); err != nil {
+				return err
+			}
+		}
+
+		// 老版本没有 fencing token, 保持当前值
+		if dbVersion == DBVersion {
+			var fence int64
+			if err = util.Read(r, &fence); err != nil {
+				return err
+			}
+			atomic.StoreInt64(&db.root.fence, fence)
+		}
+	default:
+		return ErrUnsupportedVersion
 	}
 
 	// read crc and check whether is equal to the expect
@@ -506,62 +910,161 @@ func (db *DB) readFromFile(filename string) error {
 	return nil
 }
 
+// Load replaces the data of all dbs with the snapshot read from r, which is written
+// by SyncReplica. The data is saved after loading, so that the files on disk are
+// replaced too. The replication stream restarts with a new id.
+func (db *DB) Load(r io.Reader) error {
+	root := db.root
+	root.saveLock.Lock()
+	root.cmdLock.Lock()
+	root.FlushAll()
+	err := root.readSnapshot(bufio.NewReader(r))
+	if err != nil {
+		// 不保留读取了一半的数据
+		root.FlushAll()
+	}
+	// 数据集被替换, 之前的复制流不再有效
+	root.repl.reset()
+	root.cmdLock.Unlock()
+	root.saveLock.Unlock()
+
+	if err != nil || !root.persist {
+		return err
+	}
+	return root.Save()
+}
+
+// readDB reads a db written by save, msExpire reports whether the expire time
+// is in milliseconds.
+func (db *DB) readDB(r io.Reader, msExpire bool) error {
+	// read dataMap
+	if err := db.dataMap.Unmarshal(r); err != nil {
+		return err
+	}
+
+	// read expire
+	if msExpire {
+		return db.expireList.Unmarshal(r)
+	}
+
+	// 老版本的过期时间为秒
+	expireList := zset.New()
+	if err := expireList.Unmarshal(r); err != nil {
+		return err
+	}
+	for n := expireList.GetNodeByRank(0); n != nil; n = n.Next() {
+		db.expireList.Add(n.Score()*1000, n.Val())
+	}
+	return nil
+}
+
 func (db *DB) ReadFromFile() error {
 	var err error
-	filenames, err := filepath.Glob(FilenameRegex)
+	root := db.root
+	filenames, err := root.listFiles(DBSuffix)
 	if err != nil {
 		return err
 	}
 
+	// aof 重写后, 基础文件也可能是命令格式
+	if root.appendOnly {
+		bases, err := root.listFiles(BaseAofSuffix)
+		if err != nil {
+			return err
+		}
+		filenames = append(filenames, bases...)
+	}
+
 	// 如果持久化过程中断电 or 其他极端情况, 可能出现多个 .db 文件
-	var stamps []int
+	stamps := make(map[string]int64, len(filenames))
 	for i, filename := range filenames {
-		if len(filename) < len(FilenamePrefix) {
-			continue
+		stamp, err := root.parseStamp(filename, DBSuffix)
+		if strings.HasSuffix(filename, BaseAofSuffix) {
+			stamp, err = root.parseStamp(filename, BaseAofSuffix)
 		}
-
-		num, err := strconv.Atoi(filename[len(FilenamePrefix) : len(filename)-3])
 		if err != nil {
-			db.log.Error("[DB ReadFromFile] read stamp", zap.String("err", err.Error()))
+			root.log.Error("[DB ReadFromFile] read stamp", zap.String("err", err.Error()))
 			if i == len(filenames)-1 && len(stamps) == 0 {
 				return err
 			}
+			continue
 		}
-		stamps = append(stamps, num)
+		stamps[filename] = stamp
 	}
 
 	// 从最新的开始读取; 不做删除操作, 以便保留手动修复文件的可能性
-	sort.Sort(sort.Reverse(sort.IntSlice(stamps)))
-	for i, stamp := range stamps {
-		filename := fmt.Sprintf(FilenameFormat, stamp)
-		if err = db.readFromFile(filename); err != nil {
-			db.log.Error("[DB ReadFromFile] readFromFile", zap.String("err", err.Error()))
-			if i < len(stamps)-1 {
+	sort.Slice(filenames, func(i, j int) bool { return stamps[filenames[i]] > stamps[filenames[j]] })
+	for i, filename := range filenames {
+		stamp, ok := stamps[filename]
+		if !ok {
+			continue
+		}
+
+		if strings.HasSuffix(filename, BaseAofSuffix) {
+			err = root.replayAppendOnly(filename)
+		} else {
+			err = root.readFromFile(filename)
+		}
+		if err != nil {
+			root.log.Error("[DB ReadFromFile] readFromFile", zap.String("err", err.Error()))
+			if i < len(filenames)-1 {
 				continue
 			}
 			return err
 		} else {
-			db.filename = filename
+			root.filename = filename
+			root.stamp = stamp
 			break
 		}
 	}
+
+	// 在快照之上重放其后的写命令
+	if root.appendOnly {
+		return root.loadAppendOnly()
+	}
 	return nil
 }
 
+// openAppendOnly continues the latest segment, or starts one for the loaded snapshot.
+func (db *DB) openAppendOnly() error {
+	root := db.root
+	stamps, err := root.aofStamps(root.stamp)
+	if err != nil {
+		return err
+	}
+
+	stamp := root.stamp
+	if len(stamps) > 0 {
+		stamp = stamps[len(stamps)-1]
+	}
+	root.aof, err = openAof(root.filePath(stamp, AofSuffix), root.appendFsync, root.log)
+	return err
+}
+
 func (db *DB) Close() error {
 	var err error
-	db.doExpire()
-	err = db.Save()
+	root := db.root
+	for _, d := range root.dbs {
+		d.doExpire()
+	}
+	err = root.Save()
 
-	if db.persist {
-		db.endKeepOneProcess()
+	if root.aof != nil {
+		if err := root.aof.close(); err != nil {
+			root.log.Error("[DB Close] aof.close", zap.String("err", err.Error()))
+		}
+	}
+
+	if root.persist {
+		root.endKeepOneProcess()
 	}
 
 	return err
 }
 
 func (db *DB) keepOneProcess() error {
-	db.fileLock = flock.New("GRES_LOCK")
+	// 默认为 GRES_LOCK, 不同的 dbfilename 使用不同的锁
+	db.fileLock = flock.New(filepath.Join(db.dir, strings.ToUpper(db.dbFilename)+"_LOCK"))
 	ok, err := db.fileLock.TryLock()
 	if !ok {
 		return fmt.Errorf("GRES is already boost")
@@ -569,6 +1072,54 @@ func (db *DB) keepOneProcess() error {
 	return err
 }
 
+// ValidateDBFilename checks name is a valid prefix of the data files.
+func ValidateDBFilename(name string) error {
+	if name == "" || strings.ContainsAny(name, "/\\*?[") || name == "." || name == ".." {
+		return fmt.Errorf("invalid dbfilename: %q", name)
+	}
+	return nil
+}
+
+// filePath returns the path of the data file of stamp, such as <dir>/gres_<stamp>.db.
+func (db *DB) filePath(stamp int64, suffix string) string {
+	root := db.root
+	return filepath.Join(root.dir, fmt.Sprintf("%s_%d%s", root.dbFilename, stamp, suffix))
+}
+
+// listFiles returns the paths of the data files in dir whose names end with suffix.
+// A .base.aof file also ends with .aof.
+func (db *DB) listFiles(suffix string) ([]string, error) {
+	root := db.root
+	dir := root.dir
+	if dir == "" {
+		dir = "."
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := root.dbFilename + "_"
+	var filenames []string
+	for _, info := range infos {
+		name := info.Name()
+		if !info.IsDir() && strings.HasPrefix(name, prefix) && strings.HasSuffix(name, suffix) {
+			filenames = append(filenames, filepath.Join(root.dir, name))
+		}
+	}
+	return filenames, nil
+}
+
+// parseStamp returns the stamp in the name of the data file.
+func (db *DB) parseStamp(filename, suffix string) (int64, error) {
+	name := filepath.Base(filename)
+	prefix := db.root.dbFilename + "_"
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) || len(name) < len(prefix)+len(suffix) {
+		return 0, fmt.Errorf("unexpected filename: %v", filename)
+	}
+	return strconv.ParseInt(name[len(prefix):len(name)-len(suffix)], 10, 64)
+}
+
 func (db *DB) endKeepOneProcess() error {
 	return db.fileLock.Unlock()
 }
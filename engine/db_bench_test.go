@@ -34,7 +34,7 @@ func TestDB(t *testing.T) {
 			goG.Wait()
 			for i := 0; i < ops; i++ {
 				key := strconv.FormatInt(int64(i), 10)
-				val := key
+				val := []byte(key)
 				db.Set(key, val)
 			}
 			endG.Done()
@@ -69,7 +69,7 @@ func TestDB2(t *testing.T) {
 			goG.Wait()
 			for i := 0; i < ops; i++ {
 				key := strconv.FormatInt(int64(i), 10)
-				val := key
+				val := []byte(key)
 				db.Set(key, val)
 			}
 			endG.Done()
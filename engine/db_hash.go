@@ -9,7 +9,7 @@ import (
 //   Hash
 // ========
 func (db *DB) HSet(key string, filed string, val interface{}) (int, error) {
-	obj := db.get(key)
+	obj := db.getForWrite(key)
 	if obj == nil {
 		obj = object.HashObject()
 		db.set(key, obj)
@@ -20,6 +20,8 @@ func (db *DB) HSet(key string, filed string, val interface{}) (int, error) {
 		return 0, ErrWrongTypeOps
 	}
 	_, existed := h.Set(filed, val)
+	db.touch(key)
+	db.notify(NotifyHash, "hset", key)
 	if existed {
 		return 0, nil
 	}
@@ -41,7 +43,7 @@ func (db *DB) HGet(key string, field string) (interface{}, error) {
 }
 
 func (db *DB) HDel(key string, field ...string) (int, error) {
-	obj := db.get(key)
+	obj := db.getForWrite(key)
 	if obj == nil {
 		return 0, nil
 	}
@@ -58,8 +60,13 @@ func (db *DB) HDel(key string, field ...string) (int, error) {
 			count++
 		}
 	}
+	if count > 0 {
+		db.touch(key)
+		db.notify(NotifyHash, "hdel", key)
+	}
 	if h.Length() == 0 {
 		db.remove(key)
+		db.notify(NotifyGeneric, "del", key)
 	}
 	return count, nil
 }
@@ -131,7 +138,7 @@ func (db *DB) HGetAll(key string) ([]interface{}, error) {
 }
 
 func (db *DB) HIncrBy(key string, field string, increment int) (int, error) {
-	obj := db.get(key)
+	obj := db.getForWrite(key)
 	if obj == nil {
 		obj = object.HashObject()
 		db.set(key, obj)
@@ -158,6 +165,8 @@ func (db *DB) HIncrBy(key string, field string, increment int) (int, error) {
 	}
 	afterVal := util.ShrinkNum(afterInt)
 	h.Set(field, afterVal)
+	db.touch(key)
+	db.notify(NotifyHash, "hincrby", key)
 	return afterInt, nil
 }
 
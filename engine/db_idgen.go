@@ -0,0 +1,100 @@
+package engine
+
+import (
+	"errors"
+
+	"github.com/clovers4/gres/engine/object"
+	"github.com/clovers4/gres/engine/object/idgen"
+	"github.com/clovers4/gres/util"
+)
+
+var ErrNoIDGen = errors.New("ERR no such id generator")
+
+// =========
+//   IDGen
+// =========
+
+// IDCreate creates the id generator g at key if key does not exist, and reports
+// whether it is created.
+func (db *DB) IDCreate(key string, g *idgen.IDGen) bool {
+	db.dirtyLock.RLock()
+	defer db.dirtyLock.RUnlock()
+
+	locked, unlock := db.lockKeys([]string{key})
+	if db.lookupLocked(locked, key, util.NowMs()) != nil {
+		unlock()
+		return false
+	}
+	locked[0].Set(key, object.IDGenObject(g))
+	db.removeExpireLocked(key)
+	db.touch(key)
+	unlock()
+
+	db.notify(NotifyGeneric, "id.create", key)
+	return true
+}
+
+// IDNext returns n new ids of the id generator of key, which are greater than all
+// the ids it has generated. now is the unix time in milliseconds of the snowflake
+// ids. The generator is a part of the snapshot, so the ids keep increasing after
+// restarts.
+func (db *DB) IDNext(key string, n int, now int64) ([]int64, error) {
+	var ids []int64
+	err := db.updateIDGen(key, func(g *idgen.IDGen) (err error) {
+		ids, err = g.Next(n, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	db.notify(NotifyGeneric, "id.next", key)
+	return ids, nil
+}
+
+// IDSetLast raises the last id of the id generator of key to last, and reports
+// whether it is raised, such as when the ids generated by IDNext are replayed. The
+// last id never goes back, so the ids generated are still unique.
+func (db *DB) IDSetLast(key string, last int64) (bool, error) {
+	raised := false
+	err := db.updateIDGen(key, func(g *idgen.IDGen) error {
+		if last > g.Last() {
+			g.SetLast(last)
+			raised = true
+		}
+		return nil
+	})
+	if err != nil || !raised {
+		return false, err
+	}
+
+	db.notify(NotifyGeneric, "id.setlast", key)
+	return true, nil
+}
+
+// updateIDGen runs update on the id generator of key.
+func (db *DB) updateIDGen(key string, update func(g *idgen.IDGen) error) error {
+	db.dirtyLock.RLock()
+	defer db.dirtyLock.RUnlock()
+
+	locked, unlock := db.lockKeys([]string{key})
+	defer unlock()
+	obj := db.lookupLocked(locked, key, util.NowMs())
+	if obj == nil {
+		return ErrNoIDGen
+	}
+	if _, ok := obj.IDGen(); !ok {
+		return ErrWrongTypeOps
+	}
+	// 持久化中, 快照中的对象不能修改, 先复制到 dirtyDataMap
+	if v, _ := locked[0].Get(key); db.onSave && v != obj {
+		obj = obj.Clone()
+		locked[0].Set(key, obj)
+	}
+	g, _ := obj.IDGen()
+	if err := update(g); err != nil {
+		return err
+	}
+	db.touch(key)
+	return nil
+}
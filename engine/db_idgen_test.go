@@ -0,0 +1,85 @@
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/clovers4/gres/engine/object"
+	"github.com/clovers4/gres/engine/object/idgen"
+	"github.com/clovers4/gres/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_IDGen(t *testing.T) {
+	db := NewDB()
+	g, _ := idgen.NewSegment(1, 1)
+	assert.True(t, db.IDCreate("seq", g))
+	g, _ = idgen.NewSegment(100, 1)
+	assert.False(t, db.IDCreate("seq", g))
+	assert.Equal(t, "idgen", db.Type("seq"))
+
+	ids, err := db.IDNext("seq", 1, util.NowMs())
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{1}, ids)
+	ids, _ = db.IDNext("seq", 3, util.NowMs())
+	assert.Equal(t, []int64{2, 3, 4}, ids)
+
+	g, _ = idgen.NewSnowflake(1, idgen.DefaultEpoch)
+	db.IDCreate("sf", g)
+	ids, _ = db.IDNext("sf", 5000, util.NowMs())
+	for i := 1; i < len(ids); i++ {
+		assert.True(t, ids[i] > ids[i-1])
+	}
+
+	// last 只增不减
+	ok, err := db.IDSetLast("seq", 10)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, _ = db.IDSetLast("seq", 8)
+	assert.False(t, ok)
+	ids, _ = db.IDNext("seq", 1, util.NowMs())
+	assert.Equal(t, []int64{11}, ids)
+	_, err = db.IDSetLast("none", 1)
+	assert.Equal(t, ErrNoIDGen, err)
+
+	_, err = db.IDNext("none", 1, util.NowMs())
+	assert.Equal(t, ErrNoIDGen, err)
+	db.Set("s", []byte("v"))
+	_, err = db.IDNext("s", 1, util.NowMs())
+	assert.Equal(t, ErrWrongTypeOps, err)
+
+	// 持久化中不修改快照中的对象
+	snaps, _ := db.freeze()
+	ids, _ = db.IDNext("seq", 1, util.NowMs())
+	assert.Equal(t, []int64{12}, ids)
+	obj, _ := snaps[0].dataMap.Get("seq")
+	assert.Equal(t, "[idgen] {segment step=1 last=11}", obj.(*object.Object).String())
+	db.unfreeze()
+	ids, _ = db.IDNext("seq", 1, util.NowMs())
+	assert.Equal(t, []int64{13}, ids)
+}
+
+func TestDB_IDGenSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gres-db")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	db := NewDB(DirOption(dir))
+	g, _ := idgen.NewSegment(1, 10)
+	db.IDCreate("seq", g)
+	db.IDNext("seq", 3, util.NowMs())
+	g, _ = idgen.NewSnowflake(2, util.NowMs())
+	db.IDCreate("sf", g)
+	sf, _ := db.IDNext("sf", 10, util.NowMs())
+	assert.Nil(t, db.Save())
+
+	// 重启后从快照中的最后一个 id 继续
+	newDB := NewDB(DirOption(dir))
+	assert.Nil(t, newDB.ReadFromFile())
+	ids, err := newDB.IDNext("seq", 1, util.NowMs())
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{31}, ids)
+	ids, _ = newDB.IDNext("sf", 1, util.NowMs())
+	assert.True(t, ids[0] > sf[len(sf)-1])
+}
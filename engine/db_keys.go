@@ -1,8 +1,10 @@
 package engine
 
 import (
+	"github.com/clovers4/gres/engine/cmap"
 	"github.com/clovers4/gres/engine/object"
 	"github.com/clovers4/gres/util"
+	"github.com/clovers4/gres/zset"
 )
 
 // DbSize cannot get really correct count because of the concurrence.
@@ -16,12 +18,58 @@ func (db *DB) DbSize() int {
 	return db.dataMap.Count()
 }
 
+// ExpiresSize returns the number of keys with an expire time, which is not really
+// correct during Save.
+func (db *DB) ExpiresSize() int {
+	db.dirtyLock.RLock()
+	defer db.dirtyLock.RUnlock()
+
+	if db.onSave {
+		return db.expireList.Length() + db.dirtyExpireList.Length()
+	}
+	return db.expireList.Length()
+}
+
 func (db *DB) Exists(key string) bool {
 	return db.get(key) != nil
 }
 
+// Ttl returns the remaining time to live of key in seconds, -2 if key does not
+// exist, or -1 if key has no expire time.
 func (db *DB) Ttl(key string) int {
-	return int(db.ttl(key))
+	ttl := db.PTtl(key)
+	if ttl < 0 {
+		return int(ttl)
+	}
+	// 与 redis 相同, 四舍五入到秒
+	return int((ttl + 500) / 1000)
+}
+
+// PTtl is like Ttl, but in milliseconds.
+func (db *DB) PTtl(key string) int64 {
+	t := db.expireTime(key)
+	if t < 0 {
+		return t
+	}
+	if ttl := t - util.NowMs(); ttl > 0 {
+		return ttl
+	}
+	return 0
+}
+
+// ExpireTime returns the expire time of key as unix seconds, -2 if key does not
+// exist, or -1 if key has no expire time.
+func (db *DB) ExpireTime(key string) int64 {
+	t := db.expireTime(key)
+	if t < 0 {
+		return t
+	}
+	return t / 1000
+}
+
+// PExpireTime is like ExpireTime, but in milliseconds.
+func (db *DB) PExpireTime(key string) int64 {
+	return db.expireTime(key)
 }
 
 // if return true, the db has old value, otherwise, the db do not has the old kv.
@@ -29,14 +77,58 @@ func (db *DB) Del(key ...string) int {
 	count := 0
 	for _, k := range key {
 		if db.remove(k) != nil {
+			db.notify(NotifyGeneric, "del", k)
 			count++
 		}
 	}
 	return count
 }
 
+// ExpireCond is the condition on the current expire time of a key, under which
+// the new expire time is set, like the options of EXPIRE. The conditions can be
+// combined, such as ExpireXX | ExpireLT.
+type ExpireCond int
+
+const (
+	ExpireNX ExpireCond = 1 << iota // 只在 key 没有过期时间时设置
+	ExpireXX                        // 只在 key 已有过期时间时设置
+	ExpireGT                        // 只在新的过期时间更晚时设置, 没有过期时间视为永不过期
+	ExpireLT                        // 只在新的过期时间更早时设置
+
+	ExpireAlways ExpireCond = 0
+)
+
 func (db *DB) Expire(key string, seconds int) bool {
-	return db.setExpire(key, seconds)
+	return db.PExpireAt(key, util.NowMs()+int64(seconds)*1000, ExpireAlways)
+}
+
+// ExpireAt sets the expire time of key as unix timestamp in seconds.
+func (db *DB) ExpireAt(key string, unix int64) bool {
+	return db.PExpireAt(key, unix*1000, ExpireAlways)
+}
+
+// PExpireAt sets the expire time of key as unix timestamp in milliseconds, if
+// cond is satisfied. A key whose expire time has passed is deleted at once.
+// It reports whether the expire time was set.
+func (db *DB) PExpireAt(key string, ms int64, cond ExpireCond) bool {
+	return db.setExpireAt(key, ms, cond)
+}
+
+// Persist removes the expire time of key, and reports whether it had one.
+func (db *DB) Persist(key string) bool {
+	db.dirtyLock.RLock()
+	defer db.dirtyLock.RUnlock()
+
+	if db.getLocked(key) == nil {
+		return false
+	}
+	// 持久化中 removeExpireLocked 的返回值不可靠, 先确认有过期时间
+	if _, ok := db.expireTimeLocked(key); !ok {
+		return false
+	}
+	db.removeExpireLocked(key)
+	db.notify(NotifyGeneric, "persist", key)
+	return true
 }
 
 func (db *DB) Type(key string) string {
@@ -67,3 +159,122 @@ func (db *DB) Keys(pattern string) ([]string, error) {
 	}
 	return ks, nil
 }
+
+// Move moves key to the db numbered index, together with its expire time.
+// It returns false if key does not exist, or already exists in the target db.
+func (db *DB) Move(key string, index int) (bool, error) {
+	target, err := db.Select(index)
+	if err != nil {
+		return false, err
+	}
+	if target == db {
+		return false, ErrSameDB
+	}
+
+	// 按编号顺序加锁, 避免死锁
+	first, second := db, target
+	if first.index > second.index {
+		first, second = second, first
+	}
+	first.dirtyLock.RLock()
+	defer first.dirtyLock.RUnlock()
+	second.dirtyLock.RLock()
+	defer second.dirtyLock.RUnlock()
+
+	obj := db.getLocked(key)
+	if obj == nil || target.getLocked(key) != nil {
+		return false, nil
+	}
+	// 持久化中, 原对象仍在被写入文件, 不能在目标 db 中被原地修改
+	if db.onSave {
+		obj = obj.Clone()
+	}
+
+	t, expire := db.expireTimeLocked(key)
+	target.setLocked(key, obj)
+	if expire {
+		target.addExpireLocked(key, t)
+	}
+	db.removeExpireLocked(key)
+	db.removeLocked(key)
+	db.notify(NotifyGeneric, "move_from", key)
+	target.notify(NotifyGeneric, "move_to", key)
+	target.signalReady(key)
+	return true, nil
+}
+
+// SwapDB swaps the data of the dbs numbered i and j, so that the connections
+// which selected one of them see the data of the other immediately.
+func (db *DB) SwapDB(i, j int) error {
+	a, err := db.Select(i)
+	if err != nil {
+		return err
+	}
+	b, err := db.Select(j)
+	if err != nil {
+		return err
+	}
+	if a == b {
+		return nil
+	}
+
+	a.swapLocked(b)
+	// 阻塞在交换后的 db 上的连接可能可以被服务. 由 ServeBlocked 在持有 blockLock 时取出这些 key,
+	// 此处不持有 dirtyLock 也不加 blockLock, 避免与 Block 和 ServeBlocked 死锁
+	a.signalBlockedKeys()
+	b.signalBlockedKeys()
+	return nil
+}
+
+// swapLocked swaps the data of db and other with both dirtyLocks held.
+func (db *DB) swapLocked(other *DB) {
+	a, b := db, other
+	// 按编号顺序加锁, 避免死锁
+	if a.index > b.index {
+		a, b = b, a
+	}
+	a.dirtyLock.Lock()
+	defer a.dirtyLock.Unlock()
+	b.dirtyLock.Lock()
+	defer b.dirtyLock.Unlock()
+
+	// 持久化中的数据一并交换, 两个 db 的 onSave 一定相同
+	a.dataMap, b.dataMap = b.dataMap, a.dataMap
+	a.expireList, b.expireList = b.expireList, a.expireList
+	a.dirtyDataMap, b.dirtyDataMap = b.dirtyDataMap, a.dirtyDataMap
+	a.dirtyExpireList, b.dirtyExpireList = b.dirtyExpireList, a.dirtyExpireList
+	a.touchAll()
+	b.touchAll()
+}
+
+// FlushDB removes all keys of db.
+func (db *DB) FlushDB() {
+	db.dirtyLock.Lock()
+	defer db.dirtyLock.Unlock()
+
+	db.touchAll()
+	if !db.onSave {
+		db.dataMap = cmap.New()
+		db.expireList = zset.New()
+		return
+	}
+
+	// 持久化中 dataMap 不能修改, 将其中所有 key 标记为已删除
+	db.dirtyDataMap = cmap.New()
+	db.dirtyExpireList = zset.New()
+	db.dataMap.ForEachRead(func(key string, val interface{}) {
+		db.dirtyDataMap.Set(key, object.Expunged)
+	})
+	db.expireList.RLock()
+	for n := db.expireList.GetNodeByRank(0); n != nil; n = n.Next() {
+		db.dirtyExpireList.Add(-1, n.Val())
+	}
+	db.expireList.RUnlock()
+}
+
+// FlushAll removes all keys of all dbs.
+func (db *DB) FlushAll() {
+	for _, d := range db.root.dbs {
+		d.FlushDB()
+	}
+}
@@ -12,7 +12,7 @@ import (
 //   List
 // ========
 func (db *DB) LPush(key string, val ...interface{}) (int, error) {
-	obj := db.get(key)
+	obj := db.getForWrite(key)
 	if obj == nil {
 		obj = object.ListObject()
 		db.set(key, obj)
@@ -26,11 +26,14 @@ func (db *DB) LPush(key string, val ...interface{}) (int, error) {
 	for _, v := range val {
 		ls.LPush(v)
 	}
+	db.touch(key)
+	db.notify(NotifyList, "lpush", key)
+	db.signalReady(key)
 	return ls.Length(), nil
 }
 
 func (db *DB) RPush(key string, val ...interface{}) (int, error) {
-	obj := db.get(key)
+	obj := db.getForWrite(key)
 	if obj == nil {
 		obj = object.ListObject()
 		db.set(key, obj)
@@ -43,11 +46,14 @@ func (db *DB) RPush(key string, val ...interface{}) (int, error) {
 	for _, v := range val {
 		ls.RPush(v)
 	}
+	db.touch(key)
+	db.notify(NotifyList, "rpush", key)
+	db.signalReady(key)
 	return ls.Length(), nil
 }
 
 func (db *DB) LPop(key string) (interface{}, error) {
-	obj := db.get(key)
+	obj := db.getForWrite(key)
 	if obj == nil {
 		return nil, nil
 	}
@@ -57,15 +63,18 @@ func (db *DB) LPop(key string) (interface{}, error) {
 		return nil, ErrWrongTypeOps
 	}
 	old := ls.LPop()
+	db.touch(key)
+	db.notify(NotifyList, "lpop", key)
 
 	if ls.Length() == 0 {
 		db.remove(key)
+		db.notify(NotifyGeneric, "del", key)
 	}
 	return old, nil
 }
 
 func (db *DB) RPop(key string) (interface{}, error) {
-	obj := db.get(key)
+	obj := db.getForWrite(key)
 	if obj == nil {
 		return nil, nil
 	}
@@ -75,13 +84,34 @@ func (db *DB) RPop(key string) (interface{}, error) {
 		return nil, ErrWrongTypeOps
 	}
 	old := ls.RPop()
+	db.touch(key)
+	db.notify(NotifyList, "rpop", key)
 
 	if ls.Length() == 0 {
 		db.remove(key)
+		db.notify(NotifyGeneric, "del", key)
 	}
 	return old, nil
 }
 
+// RPopLPush pops the last element of src, and pushes it to the head of dst.
+func (db *DB) RPopLPush(src, dst string) (interface{}, error) {
+	if obj := db.get(dst); obj != nil {
+		if _, ok := obj.List(); !ok {
+			return nil, ErrWrongTypeOps
+		}
+	}
+
+	val, err := db.RPop(src)
+	if err != nil || val == nil {
+		return nil, err
+	}
+	if _, err := db.LPush(dst, val); err != nil {
+		return nil, err
+	}
+	return val, nil
+}
+
 func (db *DB) LRange(key string, start, end int) ([]interface{}, error) {
 	obj := db.get(key)
 	if obj == nil {
@@ -125,7 +155,7 @@ func (db *DB) LIndex(key string, index int) (interface{}, error) {
 }
 
 func (db *DB) LSet(key string, index int, newVal interface{}) (interface{}, error) {
-	obj := db.get(key)
+	obj := db.getForWrite(key)
 	if obj == nil {
 		return nil, fmt.Errorf("no such key")
 	}
@@ -140,5 +170,7 @@ func (db *DB) LSet(key string, index int, newVal interface{}) (interface{}, erro
 		return nil, fmt.Errorf("index out of range")
 	}
 	old := n.SetVal(newVal)
+	db.touch(key)
+	db.notify(NotifyList, "lset", key)
 	return old, nil
 }
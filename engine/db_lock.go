@@ -0,0 +1,194 @@
+package engine
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/clovers4/gres/engine/cmap"
+	"github.com/clovers4/gres/engine/object"
+	"github.com/clovers4/gres/engine/object/lock"
+	"github.com/clovers4/gres/util"
+)
+
+// 重写的 aof 中恢复锁的记录, 只在 replayAppendOnly 中处理, 客户端无法执行
+const (
+	lockFenceRecord   = "lock.setfence" // lock.setfence token
+	lockRestoreRecord = "lock.restore"  // lock.restore key owner token
+)
+
+var ErrFenceOverflow = errors.New("ERR the fencing token is exhausted")
+
+// ========
+//   Lock
+// ========
+
+// LockAcquire acquires the lock of key for owner until expireAt, the unix time in
+// milliseconds, and returns its fencing token. The tokens of all the locks increase
+// monotonically, also across restarts. If owner holds the lock already, the lease
+// is renewed and the token is unchanged. It returns 0 if another owner holds it.
+// The lease is the expire time of key, the lock is deleted by expiration after it.
+func (db *DB) LockAcquire(key, owner string, expireAt int64) (int64, error) {
+	db.dirtyLock.RLock()
+	defer db.dirtyLock.RUnlock()
+
+	locked, unlock := db.lockKeys([]string{key})
+	if obj := db.lookupLocked(locked, key, util.NowMs()); obj != nil {
+		l, ok := obj.Lock()
+		if !ok {
+			unlock()
+			return 0, ErrWrongTypeOps
+		}
+		if !l.HeldBy(owner) {
+			unlock()
+			return 0, nil
+		}
+		db.addExpireLocked(key, expireAt)
+		unlock()
+
+		db.notify(NotifyGeneric, "expire", key)
+		return l.Token(), nil
+	}
+
+	token, err := db.nextFence()
+	if err != nil {
+		unlock()
+		return 0, err
+	}
+	locked[0].Set(key, object.LockObject(lock.New(owner, token)))
+	db.addExpireLocked(key, expireAt)
+	unlock()
+
+	db.notify(NotifyGeneric, "lock.acquire", key)
+	db.notify(NotifyGeneric, "expire", key)
+	return token, nil
+}
+
+// LockRelease deletes the lock of key if it is held by owner, and reports whether
+// it is released.
+func (db *DB) LockRelease(key, owner string) (bool, error) {
+	db.dirtyLock.RLock()
+	defer db.dirtyLock.RUnlock()
+
+	locked, unlock := db.lockKeys([]string{key})
+	if ok, err := db.lockHeldLocked(locked, key, owner); !ok {
+		unlock()
+		return false, err
+	}
+	db.removeKeyLocked(locked, key)
+	db.removeExpireLocked(key)
+	unlock()
+
+	db.notify(NotifyGeneric, "lock.release", key)
+	return true, nil
+}
+
+// LockExtend changes the lease of the lock of key to expireAt if it is held by owner,
+// and reports whether it is changed. A lock which has expired cannot be extended.
+func (db *DB) LockExtend(key, owner string, expireAt int64) (bool, error) {
+	db.dirtyLock.RLock()
+	defer db.dirtyLock.RUnlock()
+
+	locked, unlock := db.lockKeys([]string{key})
+	if ok, err := db.lockHeldLocked(locked, key, owner); !ok {
+		unlock()
+		return false, err
+	}
+	db.addExpireLocked(key, expireAt)
+	unlock()
+
+	db.notify(NotifyGeneric, "expire", key)
+	return true, nil
+}
+
+// LockFence returns the last fencing token issued.
+func (db *DB) LockFence() int64 {
+	return atomic.LoadInt64(&db.root.fence)
+}
+
+// nextFence issues a new fencing token, which is greater than all the issued ones.
+func (db *DB) nextFence() (int64, error) {
+	root := db.root
+	for {
+		fence := atomic.LoadInt64(&root.fence)
+		if fence == math.MaxInt64 {
+			return 0, ErrFenceOverflow
+		}
+		if atomic.CompareAndSwapInt64(&root.fence, fence, fence+1) {
+			return fence + 1, nil
+		}
+	}
+}
+
+// raiseFence raises the last fencing token to n if it is smaller.
+func (db *DB) raiseFence(n int64) {
+	root := db.root
+	for {
+		fence := atomic.LoadInt64(&root.fence)
+		if fence >= n || atomic.CompareAndSwapInt64(&root.fence, fence, n) {
+			return
+		}
+	}
+}
+
+// restoreLock sets the lock of key held by owner with the fencing token, such as
+// when the rewritten append-only file is loaded.
+func (db *DB) restoreLock(key, owner string, token int64) {
+	db.dirtyLock.RLock()
+	defer db.dirtyLock.RUnlock()
+
+	locked, unlock := db.lockKeys([]string{key})
+	locked[0].Set(key, object.LockObject(lock.New(owner, token)))
+	db.removeExpireLocked(key)
+	db.touch(key)
+	unlock()
+	db.raiseFence(token)
+}
+
+// replayLockRecord applies the record of the rewritten append-only file which
+// restores the locks, and reports whether args is such a record.
+func (db *DB) replayLockRecord(args []string) (bool, error) {
+	switch {
+	case args[0] == lockFenceRecord && len(args) == 2:
+		token, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return true, err
+		}
+		db.raiseFence(token)
+		return true, nil
+	case args[0] == lockRestoreRecord && len(args) == 4:
+		token, err := strconv.ParseInt(args[3], 10, 64)
+		if err != nil {
+			return true, err
+		}
+		db.restoreLock(args[1], args[2], token)
+		return true, nil
+	}
+	return false, nil
+}
+
+// lockHeldLocked reports whether the lock of key is held by owner, through the maps
+// locked by lockKeys.
+func (db *DB) lockHeldLocked(locked []cmap.Locked, key, owner string) (bool, error) {
+	obj := db.lookupLocked(locked, key, util.NowMs())
+	if obj == nil {
+		return false, nil
+	}
+	l, ok := obj.Lock()
+	if !ok {
+		return false, ErrWrongTypeOps
+	}
+	return l.HeldBy(owner), nil
+}
+
+// removeKeyLocked deletes key through the maps locked by lockKeys.
+func (db *DB) removeKeyLocked(locked []cmap.Locked, key string) {
+	if db.onSave {
+		// 持久化中, 设置 Expunged 作为空标志位
+		locked[0].Set(key, object.Expunged)
+	} else {
+		locked[0].Remove(key)
+	}
+	db.touch(key)
+}
@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/clovers4/gres/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Lock(t *testing.T) {
+	db := NewDB()
+	nowMs := util.NowMs()
+
+	token, err := db.LockAcquire("l", "a", nowMs+100*1000)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), token)
+	assert.Equal(t, 100, db.Ttl("l"))
+
+	// 被其他 owner 持有时获取失败, 持有者重复获取则续租, token 不变
+	token, _ = db.LockAcquire("l", "b", nowMs+100*1000)
+	assert.Equal(t, int64(0), token)
+	token, _ = db.LockAcquire("l", "a", nowMs+200*1000)
+	assert.Equal(t, int64(1), token)
+	assert.Equal(t, 200, db.Ttl("l"))
+
+	ok, err := db.LockExtend("l", "b", nowMs+300*1000)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, _ = db.LockExtend("l", "a", nowMs+300*1000)
+	assert.True(t, ok)
+	assert.Equal(t, 300, db.Ttl("l"))
+
+	// 只有持有者可以释放
+	ok, _ = db.LockRelease("l", "b")
+	assert.False(t, ok)
+	ok, _ = db.LockRelease("l", "a")
+	assert.True(t, ok)
+	assert.False(t, db.Exists("l"))
+	ok, _ = db.LockRelease("l", "a")
+	assert.False(t, ok)
+
+	// 租期已过, 其他 owner 获取到更大的 token
+	db.LockAcquire("l", "a", nowMs-1)
+	ok, _ = db.LockExtend("l", "a", nowMs+100*1000)
+	assert.False(t, ok)
+	token, _ = db.LockAcquire("l", "b", nowMs+100*1000)
+	assert.Equal(t, int64(3), token)
+
+	// owner 按字节比较
+	token, _ = db.LockAcquire("n", "7", nowMs+100*1000)
+	assert.Equal(t, int64(4), token)
+	token, _ = db.LockAcquire("n", "007", nowMs+100*1000)
+	assert.Equal(t, int64(0), token)
+	ok, _ = db.LockRelease("n", "7.0")
+	assert.False(t, ok)
+	ok, _ = db.LockRelease("n", "7")
+	assert.True(t, ok)
+
+	// 锁有单独的类型, 不能被当作 hash 修改
+	db.LockAcquire("t", "a", nowMs+100*1000)
+	assert.Equal(t, "lock", db.Type("t"))
+	_, err = db.HSet("t", "token", "1")
+	assert.Equal(t, ErrWrongTypeOps, err)
+
+	db.Set("s", []byte("v"))
+	_, err = db.LockAcquire("s", "a", nowMs+100*1000)
+	assert.Equal(t, ErrWrongTypeOps, err)
+	db.HSet("h", "owner", "a")
+	_, err = db.LockRelease("h", "a")
+	assert.Equal(t, ErrWrongTypeOps, err)
+
+	// token 只增不减, 用完时获取失败
+	assert.Equal(t, int64(5), db.LockFence())
+	db.raiseFence(2)
+	assert.Equal(t, int64(5), db.LockFence())
+	db.raiseFence(math.MaxInt64)
+	_, err = db.LockAcquire("m", "a", nowMs+100*1000)
+	assert.Equal(t, ErrFenceOverflow, err)
+	assert.False(t, db.Exists("m"))
+}
+
+func TestDB_LockOnSave(t *testing.T) {
+	db := NewDB()
+	db.LockAcquire("l", "a", util.NowMs()+100*1000)
+
+	snaps, fence := db.freeze()
+	assert.Equal(t, int64(1), fence)
+	ok, _ := db.LockRelease("l", "a")
+	assert.True(t, ok)
+	assert.False(t, db.Exists("l"))
+	token, _ := db.LockAcquire("k", "a", util.NowMs()+100*1000)
+	assert.Equal(t, int64(2), token)
+
+	// 快照中保留释放前的锁与当时的 token
+	var buf bytes.Buffer
+	assert.Nil(t, save(&buf, snaps, fence))
+	db.unfreeze()
+	assert.False(t, db.Exists("l"))
+	assert.True(t, db.Exists("k"))
+
+	newDB := NewDB()
+	assert.Nil(t, newDB.Load(&buf))
+	assert.True(t, newDB.Exists("l"))
+	assert.Equal(t, int64(1), newDB.LockFence())
+}
+
+func TestDB_LockFenceSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gres-db")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// 锁释放后 token 仍然保存, 重启后继续递增
+	db := NewDB(DirOption(dir))
+	for i := 0; i < 3; i++ {
+		db.LockAcquire("l", "a", util.NowMs()+100*1000)
+		db.LockRelease("l", "a")
+	}
+	assert.Nil(t, db.Save())
+
+	newDB := NewDB(DirOption(dir))
+	assert.Nil(t, newDB.ReadFromFile())
+	assert.Equal(t, int64(3), newDB.LockFence())
+	token, _ := newDB.LockAcquire("l", "b", util.NowMs()+100*1000)
+	assert.Equal(t, int64(4), token)
+}
@@ -2,31 +2,42 @@ package engine
 
 import (
 	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/clovers4/gres/engine/cmap"
 	"github.com/clovers4/gres/engine/object"
+	"github.com/clovers4/gres/engine/object/plain"
+	"github.com/clovers4/gres/errs"
 	"github.com/clovers4/gres/util"
 )
 
 var (
-	ErrOutOfRange = errors.New("the value will be out of range")
+	ErrOutOfRange        = errors.New("the value will be out of range")
+	ErrStringTooLong     = errors.New("string exceeds maximum allowed size (proto-max-bulk-len)")
+	ErrNaNOrInfinity     = errors.New("increment would produce NaN or Infinity")
+	ErrKeyValuesNotMatch = errors.New("the number of keys and values are not equal")
 )
 
+// 与 redis 的 proto-max-bulk-len 相同
+const maxStringLen = 512 * MB
+
 // ==============================
 //            Plain(String)
 // ==============================
-func (db *DB) Set(key string, val interface{}) error {
-	// 若是数字, 将数字调节到合适的大小以节省内存
-	num, err := util.IntX2Int(val)
-	if err == nil {
-		val = util.ShrinkNum(num)
-	}
 
+// Set sets key to the binary-safe string val, which is owned by the db afterwards.
+func (db *DB) Set(key string, val []byte) error {
 	obj := object.PlainObject(val)
 	db.set(key, obj)
 	db.removeExpireLocked(key)
+	db.notify(NotifyString, "set", key)
 	return nil
 }
 
-func (db *DB) Get(key string) (val interface{}, err error) {
+// Get returns nil if key does not exist. The returned bytes must not be modified.
+func (db *DB) Get(key string) (val []byte, err error) {
 	obj := db.get(key)
 	if obj == nil {
 		return nil, nil
@@ -35,10 +46,10 @@ func (db *DB) Get(key string) (val interface{}, err error) {
 	if !ok {
 		return nil, ErrWrongTypeOps
 	}
-	return p.Val(), nil
+	return p.Bytes(), nil
 }
 
-func (db *DB) GetSet(key string, val interface{}) (oldVal interface{}, err error) {
+func (db *DB) GetSet(key string, val []byte) (oldVal []byte, err error) {
 	oldVal, err = db.Get(key)
 	if err != nil {
 		return nil, err
@@ -54,37 +65,358 @@ func (db *DB) Incr(key string) (int, error) {
 // we think num is always int, and do not use uint.
 // 返回结果为计算后的值
 func (db *DB) IncrBy(key string, num int) (int, error) {
+	var after int
+	err := db.updatePlain(key, func(p *plain.Plain) (*object.Object, error) {
+		old := int64(0)
+		if p != nil {
+			// 只有 int 编码的字符串才是整数, 如 "012" 不是
+			var ok bool
+			if old, ok = p.Int(); !ok {
+				return nil, errs.ErrIsNotInt
+			}
+		}
+		var ok bool
+		if after, ok = util.Add(int(old), num); !ok {
+			return nil, ErrOutOfRange
+		}
+		return object.IntObject(int64(after)), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	db.notify(NotifyString, "incrby", key)
+	return after, nil
+}
+
+func (db *DB) DecrBy(key string, num int) (int, error) {
+	return db.IncrBy(key, -num)
+}
+
+func (db *DB) Decr(key string) (int, error) {
+	return db.IncrBy(key, -1)
+}
+
+// SetNX sets key only if it does not exist, and reports whether it was set.
+func (db *DB) SetNX(key string, val []byte) (bool, error) {
+	return db.mset([]string{key}, [][]byte{val}, true), nil
+}
+
+// SetEx sets key and its time to live.
+func (db *DB) SetEx(key string, val []byte, ttl time.Duration) error {
+	db.dirtyLock.RLock()
+	defer db.dirtyLock.RUnlock()
+
+	db.setLocked(key, object.PlainObject(val))
+	db.notify(NotifyString, "set", key)
+	// 过期时间精确到毫秒, 不足一毫秒的部分向上取整
+	ms := int64((ttl + time.Millisecond - 1) / time.Millisecond)
+	db.setExpireAtLocked(key, util.NowMs()+ms, ExpireAlways)
+	return nil
+}
+
+// MSet sets the keys to the vals atomically, the other commands see all or none of them.
+func (db *DB) MSet(keys []string, vals [][]byte) error {
+	if len(keys) != len(vals) {
+		return ErrKeyValuesNotMatch
+	}
+	db.mset(keys, vals, false)
+	return nil
+}
+
+// MSetNX sets the keys to the vals atomically, only if none of the keys exists.
+func (db *DB) MSetNX(keys []string, vals [][]byte) (bool, error) {
+	if len(keys) != len(vals) {
+		return false, ErrKeyValuesNotMatch
+	}
+	return db.mset(keys, vals, true), nil
+}
+
+// mset locks the keys, so the keys are checked and set atomically.
+func (db *DB) mset(keys []string, vals [][]byte, nx bool) bool {
+	db.dirtyLock.RLock()
+	defer db.dirtyLock.RUnlock()
+
+	locked, unlock := db.lockKeys(keys)
+	if nx {
+		now := util.NowMs()
+		for _, key := range keys {
+			if db.lookupLocked(locked, key, now) != nil {
+				unlock()
+				return false
+			}
+		}
+	}
+	for i, key := range keys {
+		locked[0].Set(key, object.PlainObject(vals[i]))
+		db.removeExpireLocked(key)
+		db.touch(key)
+	}
+	unlock()
+
+	for _, key := range keys {
+		db.notify(NotifyString, "set", key)
+	}
+	return true
+}
+
+// SetOptions are the options of SET.
+type SetOptions struct {
+	NX       bool  // 只在 key 不存在时设置
+	XX       bool  // 只在 key 存在时设置
+	KeepTTL  bool  // 保留原来的过期时间
+	Get      bool  // 返回原来的值
+	ExpireAt int64 // 过期时间, unix 毫秒, 0 表示不过期
+}
+
+// SetWithOptions sets key like SET with options. The check of NX and XX, the value
+// and the expire time are applied atomically. It reports whether key was set, and
+// returns the old value if opts.Get, in which case key must hold a string.
+func (db *DB) SetWithOptions(key string, val []byte, opts SetOptions) (bool, []byte, error) {
+	db.dirtyLock.RLock()
+	defer db.dirtyLock.RUnlock()
+
+	locked, unlock := db.lockKeys([]string{key})
+	oldObj := db.lookupLocked(locked, key, util.NowMs())
+	var old []byte
+	if opts.Get && oldObj != nil {
+		p, ok := oldObj.Plain()
+		if !ok {
+			unlock()
+			return false, nil, ErrWrongTypeOps
+		}
+		old = p.Bytes()
+	}
+	if opts.NX && oldObj != nil || opts.XX && oldObj == nil {
+		unlock()
+		return false, old, nil
+	}
+
+	locked[0].Set(key, object.PlainObject(val))
+	if opts.ExpireAt > 0 {
+		db.addExpireLocked(key, opts.ExpireAt)
+	} else if !opts.KeepTTL {
+		db.removeExpireLocked(key)
+	}
+	db.touch(key)
+	unlock()
+
+	db.notify(NotifyString, "set", key)
+	if opts.ExpireAt > 0 {
+		db.notify(NotifyGeneric, "expire", key)
+	}
+	return true, old, nil
+}
+
+// lockKeys locks the cmap segments of keys, so that the keys are checked and set
+// atomically, though they are in different segments. dirtyLock must be held, and
+// the keys are set through locked[0].
+func (db *DB) lockKeys(keys []string) (locked []cmap.Locked, unlock func()) {
+	// 持久化中写入 dirtyDataMap, 与 AddCMap 相同先锁 dirtyDataMap
+	if db.onSave {
+		return cmap.LockKeys(keys, db.dirtyDataMap, db.dataMap)
+	}
+	return cmap.LockKeys(keys, db.dataMap)
+}
+
+// lookupLocked gets key through the maps locked by lockKeys, now is the unix time
+// in milliseconds. An expired key is regarded as not existing, and will be overwritten.
+func (db *DB) lookupLocked(locked []cmap.Locked, key string, now int64) *object.Object {
+	v, found := locked[0].Get(key)
+	if !found && db.onSave {
+		v, found = locked[1].Get(key)
+	}
+	if !found || v == object.Expunged {
+		return nil
+	}
+
+	if t, ok := db.expireTimeLocked(key); ok && now >= t {
+		return nil
+	}
+	return v.(*object.Object)
+}
+
+// MGet returns the values of keys, a value is nil if the key does not exist or
+// is not a string.
+func (db *DB) MGet(keys ...string) []interface{} {
+	vals := make([]interface{}, len(keys))
+	for i, key := range keys {
+		val, err := db.Get(key)
+		if err == nil && val != nil {
+			vals[i] = val
+		}
+	}
+	return vals
+}
+
+// Append appends val to the string of key, and returns the length after appending.
+// The key is created if it does not exist.
+func (db *DB) Append(key string, val []byte) (int, error) {
+	var n int
+	err := db.updatePlain(key, func(p *plain.Plain) (*object.Object, error) {
+		if p == nil {
+			n = len(val)
+			return object.PlainObject(val), nil
+		}
+		if p.Len()+len(val) > maxStringLen {
+			return nil, ErrStringTooLong
+		}
+		// 读者与快照持有的是原长度的切片, 在其后追加不影响它们; 同一个 key 的写入已串行
+		b := append(p.Bytes(), val...)
+		n = len(b)
+		return object.PlainObject(b), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	db.notify(NotifyString, "append", key)
+	return n, nil
+}
+
+// StrLen returns the length of the string of key, or 0 if key does not exist.
+func (db *DB) StrLen(key string) (int, error) {
 	obj := db.get(key)
 	if obj == nil {
-		obj = object.PlainObject(int8(0))
-		db.set(key, obj)
+		return 0, nil
 	}
-
 	p, ok := obj.Plain()
 	if !ok {
 		return 0, ErrWrongTypeOps
 	}
+	return p.Len(), nil
+}
+
+// SetRange overwrites the string of key from offset with val, and returns the length
+// after overwriting. The string is padded with zero bytes if it is shorter than offset.
+func (db *DB) SetRange(key string, offset int, val []byte) (int, error) {
+	if offset < 0 {
+		return 0, ErrOutOfRange
+	}
 
-	oldVal := p.Val()
-	old, err := util.IntX2Int(oldVal)
+	var n int
+	changed := false
+	err := db.updatePlain(key, func(p *plain.Plain) (*object.Object, error) {
+		// 与 redis 相同, 空字符串不会创建或修改 key
+		if len(val) == 0 {
+			if p != nil {
+				n = p.Len()
+			}
+			return nil, nil
+		}
+		if offset > maxStringLen-len(val) {
+			return nil, ErrStringTooLong
+		}
+		// SetRange 复制一份再修改, 读者持有的切片不变
+		np := plain.New(nil)
+		if p != nil {
+			np.SetBytes(p.Bytes())
+		}
+		n = np.SetRange(offset, val)
+		changed = true
+		return object.PlainObject(np.Bytes()), nil
+	})
 	if err != nil {
 		return 0, err
 	}
+	if changed {
+		db.notify(NotifyString, "setrange", key)
+	}
+	return n, nil
+}
 
-	afterInt, ok := util.Add(old, num)
-	if !ok {
-		return 0, ErrOutOfRange
+// GetRange returns the substring of key between start and end, both are included.
+// Negative offsets count from the end of the string, -1 is the last byte.
+func (db *DB) GetRange(key string, start, end int) ([]byte, error) {
+	val, err := db.Get(key)
+	if err != nil {
+		return nil, err
 	}
 
-	afterVal := util.ShrinkNum(afterInt)
-	p.SetVal(afterVal)
-	return afterInt, nil
+	n := len(val)
+	if start < 0 {
+		start += n
+	}
+	if end < 0 {
+		end += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= n {
+		end = n - 1
+	}
+	if n == 0 || start > end {
+		return []byte{}, nil
+	}
+	return val[start : end+1], nil
 }
 
-func (db *DB) DecrBy(key string, num int) (int, error) {
-	return db.IncrBy(key, -num)
+// IncrByFloat adds incr to the float of key, and returns the result formatted like
+// redis, such as "10.5" or "5000", without exponent.
+func (db *DB) IncrByFloat(key string, incr float64) ([]byte, error) {
+	var b []byte
+	err := db.updatePlain(key, func(p *plain.Plain) (*object.Object, error) {
+		old := float64(0)
+		if p != nil {
+			var err error
+			if old, err = parseFloat(p.Bytes()); err != nil {
+				return nil, err
+			}
+		}
+
+		after := old + incr
+		if math.IsNaN(after) || math.IsInf(after, 0) {
+			return nil, ErrNaNOrInfinity
+		}
+		b = strconv.AppendFloat(nil, after, 'f', -1, 64)
+		return object.PlainObject(b), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	db.notify(NotifyString, "incrbyfloat", key)
+	return b, nil
 }
 
-func (db *DB) Decr(key string) (int, error) {
-	return db.IncrBy(key, -1)
+// updatePlain replaces the string of key by update under the lock of key, so the
+// writes of the same key are serialized. The string is never modified in place,
+// the readers and the snapshot keep the old one. update gets nil if key does not
+// exist, and returns the new string, or nil if nothing changes.
+func (db *DB) updatePlain(key string, update func(p *plain.Plain) (*object.Object, error)) error {
+	db.dirtyLock.RLock()
+	defer db.dirtyLock.RUnlock()
+
+	locked, unlock := db.lockKeys([]string{key})
+	defer unlock()
+	var p *plain.Plain
+	if obj := db.lookupLocked(locked, key, util.NowMs()); obj != nil {
+		var ok bool
+		if p, ok = obj.Plain(); !ok {
+			return ErrWrongTypeOps
+		}
+	}
+
+	obj, err := update(p)
+	if err != nil || obj == nil {
+		return err
+	}
+	// 已过期但尚未删除的 key 被覆盖, 过期时间不再有效
+	if p == nil {
+		db.removeExpireLocked(key)
+	}
+	locked[0].Set(key, obj)
+	db.touch(key)
+	return nil
+}
+
+// parseFloat parses a float like redis, which rejects spaces, NaN and Infinity.
+func parseFloat(b []byte) (float64, error) {
+	s := util.BytesToString(b)
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, errs.ErrIsNotFloat
+	}
+	return f, nil
 }
@@ -9,7 +9,7 @@ import (
 //    Set
 // =========
 func (db *DB) SAdd(key string, val ...interface{}) (int, error) {
-	obj := db.get(key)
+	obj := db.getForWrite(key)
 	if obj == nil {
 		obj = object.SetObject()
 		db.set(key, obj)
@@ -25,11 +25,15 @@ func (db *DB) SAdd(key string, val ...interface{}) (int, error) {
 			count++
 		}
 	}
+	if count > 0 {
+		db.touch(key)
+		db.notify(NotifySet, "sadd", key)
+	}
 	return count, nil
 }
 
 func (db *DB) SRem(key string, val ...interface{}) (int, error) {
-	obj := db.get(key)
+	obj := db.getForWrite(key)
 	if obj == nil {
 		return 0, nil
 	}
@@ -46,8 +50,13 @@ func (db *DB) SRem(key string, val ...interface{}) (int, error) {
 			count++
 		}
 	}
+	if count > 0 {
+		db.touch(key)
+		db.notify(NotifySet, "srem", key)
+	}
 	if set.Length() == 0 {
 		db.remove(key)
+		db.notify(NotifyGeneric, "del", key)
 	}
 	return count, nil
 }
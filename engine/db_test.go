@@ -2,14 +2,22 @@ package engine
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
+	"io/ioutil"
+	"math"
 	"os"
+	"path/filepath"
 	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
-	"github.com/clovers4/gres/engine/object/plain"
+	"github.com/clovers4/gres/engine/cmap"
+	"github.com/clovers4/gres/errs"
 	"github.com/clovers4/gres/util"
+	"github.com/clovers4/gres/zset"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -19,8 +27,7 @@ func array2String(vals []interface{}, needSort bool) string {
 
 	var sVal []string
 	for _, val := range vals {
-		p := plain.New(val)
-		sVal = append(sVal, p.String())
+		sVal = append(sVal, fmt.Sprintf("%v", val))
 	}
 
 	if needSort {
@@ -51,9 +58,9 @@ func TestFile(t *testing.T) {
 func TestDB_Save(t *testing.T) {
 	db := NewDB(PersistOption(true))
 
-	db.Set("string-A", "A1")
-	db.Set("string-A", "A1-0")
-	db.Set("string-B", int32(32))
+	db.Set("string-A", []byte("A1"))
+	db.Set("string-A", []byte("A1-0"))
+	db.Set("string-B", []byte("32"))
 
 	db.RPush("list-A", "B")
 	db.RPush("list-A", "C")
@@ -98,48 +105,61 @@ func TestDB_Plain(t *testing.T) {
 
 	val, err = db.Get("b")
 	assert.Nil(t, err)
-	assert.Equal(t, nil, val)
+	assert.Nil(t, val)
 
-	db.Set("b", int64(32))
+	db.Set("b", []byte("32"))
 	val, err = db.Get("b")
-	assert.Equal(t, int8(32), val)
+	assert.Equal(t, []byte("32"), val)
 	assert.Nil(t, err)
 
 	val, err = db.IncrBy("b", 5)
-	assert.Equal(t, int8(37), val)
+	assert.Equal(t, 37, val)
 	assert.Nil(t, err)
 
 	val, err = db.Get("b")
-	assert.Equal(t, int8(37), val)
+	assert.Equal(t, []byte("37"), val)
 	assert.Nil(t, err)
 
 	val, err = db.Incr("b")
-	assert.Equal(t, int8(38), val)
+	assert.Equal(t, 38, val)
 	assert.Nil(t, err)
 
 	val, err = db.Incr("c")
-	assert.Equal(t, int8(1), val)
+	assert.Equal(t, 1, val)
 	assert.Nil(t, err)
 
 	val, err = db.DecrBy("b", 4)
-	assert.Equal(t, int8(34), val)
+	assert.Equal(t, 34, val)
 	assert.Nil(t, err)
 
 	val, err = db.Decr("b")
-	assert.Equal(t, int8(33), val)
+	assert.Equal(t, 33, val)
 	assert.Nil(t, err)
 
 	val, err = db.Get("b")
-	assert.Equal(t, int8(33), val)
+	assert.Equal(t, []byte("33"), val)
 	assert.Nil(t, err)
 
-	val, err = db.GetSet("b", "ABC")
-	assert.Equal(t, int8(33), val)
+	val, err = db.GetSet("b", []byte("ABC"))
+	assert.Equal(t, []byte("33"), val)
 	assert.Nil(t, err)
 
 	val, err = db.Get("b")
-	assert.Equal(t, "ABC", val)
+	assert.Equal(t, []byte("ABC"), val)
 	assert.Nil(t, err)
+
+	// 非规范形式的数字与二进制数据原样保存
+	for _, v := range []string{"007", "+1", "1.50", "", "\x00\xff\r\n"} {
+		db.Set("b", []byte(v))
+		val, err = db.Get("b")
+		assert.Equal(t, []byte(v), val)
+		assert.Nil(t, err)
+	}
+	_, err = db.Incr("b")
+	assert.Equal(t, errs.ErrIsNotInt, err)
+	db.Set("b", []byte("007"))
+	_, err = db.Incr("b")
+	assert.Equal(t, errs.ErrIsNotInt, err)
 }
 
 func TestDB_Plain2(t *testing.T) {
@@ -149,11 +169,11 @@ func TestDB_Plain2(t *testing.T) {
 
 	val, err = db.Get("b")
 	assert.Nil(t, err)
-	assert.Equal(t, nil, val)
+	assert.Nil(t, val)
 
-	db.Set("b", int64(32))
+	db.Set("b", []byte("32"))
 	val, err = db.Get("b")
-	assert.Equal(t, int8(32), val)
+	assert.Equal(t, []byte("32"), val)
 	assert.Nil(t, err)
 
 	val, err = db.IncrBy("b", 5)
@@ -172,9 +192,248 @@ func TestDB_Plain2(t *testing.T) {
 	assert.Equal(t, 33, val)
 	assert.Nil(t, err)
 
-	val, err = db.GetSet("b", "ABC")
-	assert.Equal(t, int8(33), val)
+	val, err = db.GetSet("b", []byte("ABC"))
+	assert.Equal(t, []byte("33"), val)
+	assert.Nil(t, err)
+}
+
+func TestDB_PlainFamily(t *testing.T) {
+	db := NewDB()
+
+	ok, err := db.SetNX("a", []byte("1"))
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	ok, _ = db.SetNX("a", []byte("2"))
+	assert.False(t, ok)
+
+	// append & strlen
+	n, err := db.Append("a", []byte("23"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	n, _ = db.Append("b", []byte("Hello"))
+	assert.Equal(t, 5, n)
+	n, _ = db.StrLen("b")
+	assert.Equal(t, 5, n)
+	n, _ = db.StrLen("none")
+	assert.Equal(t, 0, n)
+	val, _ := db.Incr("a")
+	assert.Equal(t, 124, val)
+
+	// setrange & getrange
+	n, _ = db.SetRange("b", 7, []byte("World"))
+	assert.Equal(t, 12, n)
+	b, _ := db.Get("b")
+	assert.Equal(t, []byte("Hello\x00\x00World"), b)
+	n, _ = db.SetRange("none", 0, nil)
+	assert.Equal(t, 0, n)
+	assert.False(t, db.Exists("none"))
+	b, _ = db.GetRange("b", 0, 4)
+	assert.Equal(t, []byte("Hello"), b)
+	b, _ = db.GetRange("b", -5, -1)
+	assert.Equal(t, []byte("World"), b)
+	b, _ = db.GetRange("b", 5, 3)
+	assert.Equal(t, []byte{}, b)
+	b, _ = db.GetRange("none", 0, -1)
+	assert.Equal(t, []byte{}, b)
+
+	// incrbyfloat
+	db.Set("f", []byte("10.50"))
+	b, err = db.IncrByFloat("f", 0.1)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("10.6"), b)
+	db.Set("f", []byte("5.0e3"))
+	b, _ = db.IncrByFloat("f", 2.0e2)
+	assert.Equal(t, []byte("5200"), b)
+	_, err = db.IncrByFloat("b", 1)
+	assert.Equal(t, errs.ErrIsNotFloat, err)
+	_, err = db.IncrByFloat("nan", math.Inf(1))
+	assert.Equal(t, ErrNaNOrInfinity, err)
+	assert.False(t, db.Exists("nan"))
+
+	// mset & msetnx & mget
+	assert.Nil(t, db.MSet([]string{"k1", "k2"}, [][]byte{[]byte("v1"), []byte("v2")}))
+	ok, _ = db.MSetNX([]string{"k2", "k3"}, [][]byte{[]byte("x"), []byte("x")})
+	assert.False(t, ok)
+	assert.False(t, db.Exists("k3"))
+	ok, _ = db.MSetNX([]string{"k3", "k4"}, [][]byte{[]byte("v3"), []byte("v4")})
+	assert.True(t, ok)
+	db.HSet("h", "f", "v")
+	assert.Equal(t, []interface{}{[]byte("v1"), nil, []byte("v4"), nil}, db.MGet("k1", "none", "k4", "h"))
+
+	// setex
+	assert.Nil(t, db.SetEx("e", []byte("v"), 1500*time.Millisecond))
+	ttl := db.Ttl("e")
+	assert.True(t, ttl > 0 && ttl <= 2)
+	db.Expire("k1", 100)
+	db.MSet([]string{"k1"}, [][]byte{[]byte("v")})
+	assert.Equal(t, -1, db.Ttl("k1"))
+}
+
+func TestDB_SetWithOptions(t *testing.T) {
+	db := NewDB()
+	nowMs := time.Now().UnixNano() / int64(time.Millisecond)
+
+	ok, _, err := db.SetWithOptions("a", []byte("1"), SetOptions{XX: true})
 	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.False(t, db.Exists("a"))
+
+	ok, _, _ = db.SetWithOptions("a", []byte("1"), SetOptions{NX: true, ExpireAt: nowMs + 100*1000})
+	assert.True(t, ok)
+	assert.Equal(t, 100, db.Ttl("a"))
+
+	ok, old, _ := db.SetWithOptions("a", []byte("2"), SetOptions{NX: true, Get: true})
+	assert.False(t, ok)
+	assert.Equal(t, []byte("1"), old)
+
+	// KEEPTTL 保留过期时间, 否则清除
+	ok, old, _ = db.SetWithOptions("a", []byte("3"), SetOptions{XX: true, KeepTTL: true, Get: true})
+	assert.True(t, ok)
+	assert.Equal(t, []byte("1"), old)
+	assert.Equal(t, 100, db.Ttl("a"))
+	db.SetWithOptions("a", []byte("4"), SetOptions{})
+	assert.Equal(t, -1, db.Ttl("a"))
+
+	// 过期时间已过, key 立即过期
+	db.SetWithOptions("a", []byte("5"), SetOptions{ExpireAt: nowMs - 1000})
+	assert.False(t, db.Exists("a"))
+
+	db.HSet("h", "f", "v")
+	_, _, err = db.SetWithOptions("h", []byte("v"), SetOptions{Get: true})
+	assert.Equal(t, ErrWrongTypeOps, err)
+	assert.Equal(t, "hash", db.Type("h"))
+}
+
+func TestDB_PExpireAt(t *testing.T) {
+	db := NewDB()
+	nowMs := time.Now().UnixNano() / int64(time.Millisecond)
+
+	assert.False(t, db.PExpireAt("a", nowMs+1000, ExpireAlways))
+	assert.Equal(t, int64(-2), db.PTtl("a"))
+	assert.Equal(t, int64(-2), db.ExpireTime("a"))
+
+	db.Set("a", []byte("1"))
+	assert.Equal(t, int64(-1), db.PTtl("a"))
+	assert.Equal(t, int64(-1), db.PExpireTime("a"))
+	assert.False(t, db.Persist("a"))
+
+	// 没有过期时间: XX 和 GT 不设置, LT 设置
+	assert.False(t, db.PExpireAt("a", nowMs+100000, ExpireXX))
+	assert.False(t, db.PExpireAt("a", nowMs+100000, ExpireGT))
+	assert.False(t, db.PExpireAt("a", nowMs+100000, ExpireXX|ExpireLT))
+	assert.True(t, db.PExpireAt("a", nowMs+100000, ExpireLT))
+	assert.Equal(t, nowMs+100000, db.PExpireTime("a"))
+	assert.Equal(t, (nowMs+100000)/1000, db.ExpireTime("a"))
+	ttl := db.PTtl("a")
+	assert.True(t, ttl > 99000 && ttl <= 100000, ttl)
+	assert.Equal(t, 100, db.Ttl("a"))
+
+	// 已有过期时间
+	assert.False(t, db.PExpireAt("a", nowMs+200000, ExpireNX))
+	assert.False(t, db.PExpireAt("a", nowMs+200000, ExpireLT))
+	assert.False(t, db.PExpireAt("a", nowMs+50000, ExpireGT))
+	assert.True(t, db.PExpireAt("a", nowMs+200000, ExpireXX|ExpireGT))
+	assert.True(t, db.PExpireAt("a", nowMs+2200, ExpireLT))
+	assert.Equal(t, 2, db.Ttl("a"))
+
+	assert.True(t, db.Persist("a"))
+	assert.Equal(t, int64(-1), db.PTtl("a"))
+
+	// 过期时间已过, key 立即删除
+	assert.True(t, db.PExpireAt("a", nowMs-1, ExpireAlways))
+	assert.False(t, db.Exists("a"))
+
+	// 毫秒级的过期
+	db.Set("b", []byte("1"))
+	assert.True(t, db.PExpireAt("b", nowMs+50, ExpireAlways))
+	assert.True(t, db.Exists("b"))
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, db.Exists("b"))
+}
+
+func TestDB_ReadDB_SecondExpire(t *testing.T) {
+	db := NewDB()
+	db.Set("a", []byte("1"))
+	db.Set("b", []byte("2"))
+	buf := new(bytes.Buffer)
+	assert.Nil(t, db.dataMap.Marshal(buf))
+
+	// 0.0.4 之前的过期时间为秒
+	unix := time.Now().Unix() + 100
+	expireList := zset.New()
+	expireList.Add(unix, "a")
+	assert.Nil(t, expireList.Marshal(buf))
+
+	newDB := NewDB()
+	assert.Nil(t, newDB.readDB(buf, false))
+	assert.Equal(t, unix*1000, newDB.PExpireTime("a"))
+	assert.Equal(t, int64(-1), newDB.PExpireTime("b"))
+}
+
+func TestDB_MSetNX_Concurrent(t *testing.T) {
+	db := NewDB()
+	keys := make([]string, 16)
+	for i := range keys {
+		keys[i] = fmt.Sprint("key-", i)
+	}
+
+	// 多个 segment 中的 key, 同时只有一个连接能全部设置
+	const n = 8
+	var wg sync.WaitGroup
+	var count int32
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			vals := make([][]byte, len(keys))
+			for j := range vals {
+				vals[j] = []byte(fmt.Sprint(i))
+			}
+			if ok, _ := db.MSetNX(keys, vals); ok {
+				atomic.AddInt32(&count, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), count)
+
+	vals := db.MGet(keys...)
+	for _, v := range vals {
+		assert.Equal(t, vals[0], v)
+	}
+}
+
+func TestDB_Plain_Concurrent(t *testing.T) {
+	db := NewDB()
+
+	// 同一个 key 的修改串行执行, 不会丢失; 读者看到的值不会被修改
+	const n, times = 8, 100
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < times; j++ {
+				db.Append("a", []byte("x"))
+				db.IncrBy("i", 1)
+				db.IncrByFloat("f", 0.5)
+				db.SetRange("r", j, []byte("y"))
+				if val, _ := db.Get("a"); len(val) > 0 {
+					assert.Equal(t, byte('x'), val[len(val)-1])
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	n1, _ := db.StrLen("a")
+	assert.Equal(t, n*times, n1)
+	i, _ := db.Get("i")
+	assert.Equal(t, []byte(fmt.Sprint(n*times)), i)
+	f, _ := db.Get("f")
+	assert.Equal(t, []byte(fmt.Sprint(n*times/2)), f)
+	r, _ := db.StrLen("r")
+	assert.Equal(t, times, r)
 }
 
 func TestDB_Hash(t *testing.T) {
@@ -453,12 +712,12 @@ func TestDB_Expire(t *testing.T) {
 	var err error
 	var ok bool
 
-	err = db.Set("A", "S-1")
+	err = db.Set("A", []byte("S-1"))
 	assert.Nil(t, err)
 
 	v, err = db.Get("A")
 	assert.Nil(t, err)
-	assert.Equal(t, "S-1", v)
+	assert.Equal(t, []byte("S-1"), v)
 
 	ok = db.Expire("A", 1)
 	assert.Equal(t, true, ok)
@@ -470,7 +729,7 @@ func TestDB_Expire(t *testing.T) {
 
 	v, err = db.Get("A")
 	assert.Nil(t, err)
-	//assert.Equal(t, "S-1", v)
+	//assert.Equal(t, []byte("S-1"), v)
 
 	db.Del("A")
 
@@ -478,14 +737,14 @@ func TestDB_Expire(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Nil(t, v)
 
-	err = db.Set("B", "S-2")
+	err = db.Set("B", []byte("S-2"))
 	assert.Nil(t, err)
 
 	v, err = db.Get("B")
 	assert.Nil(t, err)
-	assert.Equal(t, "S-2", v)
+	assert.Equal(t, []byte("S-2"), v)
 
-	err = db.Set("C", "S-3")
+	err = db.Set("C", []byte("S-3"))
 	assert.Nil(t, err)
 
 	ok = db.Expire("C", 110)
@@ -521,3 +780,138 @@ func TestDB_Expire2(t *testing.T) {
 
 	time.Sleep(2 * time.Second)
 }
+
+func TestDB_Select(t *testing.T) {
+	db := NewDB(DbnumOption(4))
+
+	db1, err := db.Select(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, db1.Index())
+	_, err = db.Select(4)
+	assert.Equal(t, ErrDBIndexOutOfRange, err)
+
+	db.Set("A", []byte("0"))
+	db1.Set("A", []byte("1"))
+	val, _ := db.Get("A")
+	assert.Equal(t, []byte("0"), val)
+	val, _ = db1.Get("A")
+	assert.Equal(t, []byte("1"), val)
+
+	// move
+	db.Set("B", []byte("0"))
+	db.Expire("B", 100)
+	moved, err := db.Move("B", 1)
+	assert.Nil(t, err)
+	assert.True(t, moved)
+	assert.False(t, db.Exists("B"))
+	assert.True(t, db1.Ttl("B") > 0)
+
+	moved, err = db.Move("A", 1)
+	assert.Nil(t, err)
+	assert.False(t, moved)
+	_, err = db.Move("A", 0)
+	assert.Equal(t, ErrSameDB, err)
+
+	// swapdb
+	assert.Nil(t, db.SwapDB(0, 1))
+	assert.Equal(t, 2, db.DbSize())
+	assert.Equal(t, 1, db1.DbSize())
+	val, _ = db.Get("A")
+	assert.Equal(t, []byte("1"), val)
+
+	// flushdb & flushall
+	db.FlushDB()
+	assert.Equal(t, 0, db.DbSize())
+	assert.Equal(t, 1, db1.DbSize())
+	db.FlushAll()
+	assert.Equal(t, 0, db1.DbSize())
+}
+
+func TestDB_SaveMultiDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gres-db")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	db := NewDB(DbnumOption(4))
+	db3, _ := db.Select(3)
+	db.Set("A", []byte("0"))
+	db3.Set("A", []byte("3"))
+	db3.Expire("A", 100)
+	assert.Nil(t, db.Save())
+
+	newDB := NewDB(DbnumOption(4))
+	assert.Nil(t, newDB.ReadFromFile())
+	newDB3, _ := newDB.Select(3)
+	val, _ := newDB.Get("A")
+	assert.Equal(t, []byte("0"), val)
+	val, _ = newDB3.Get("A")
+	assert.Equal(t, []byte("3"), val)
+	assert.True(t, newDB3.Ttl("A") > 0)
+
+	// dbnum 太小时无法读取
+	assert.NotNil(t, NewDB(DbnumOption(2)).ReadFromFile())
+}
+
+func TestDB_DirOption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gres-db")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// 两个 server 共用同一目录, 不改变当前目录
+	db1 := NewDB(DirOption(dir), DBFilenameOption("one"))
+	db2 := NewDB(DirOption(dir), DBFilenameOption("two"))
+	db1.Set("A", []byte("1"))
+	db2.Set("A", []byte("2"))
+	assert.Nil(t, db1.Save())
+	assert.Nil(t, db2.Save())
+	assert.Equal(t, filepath.Join(dir, fmt.Sprintf("one_%d.db", db1.stamp)), db1.filename)
+
+	files, err := db1.listFiles(DBSuffix)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{db1.filename}, files)
+
+	newDB := NewDB(DirOption(dir), DBFilenameOption("two"))
+	assert.Nil(t, newDB.ReadFromFile())
+	val, _ := newDB.Get("A")
+	assert.Equal(t, []byte("2"), val)
+
+	assert.Nil(t, db1.keepOneProcess())
+	_, err = os.Stat(filepath.Join(dir, "ONE_LOCK"))
+	assert.Nil(t, err)
+	assert.Nil(t, db1.endKeepOneProcess())
+
+	assert.Nil(t, ValidateDBFilename("gres-6380"))
+	assert.NotNil(t, ValidateDBFilename(""))
+	assert.NotNil(t, ValidateDBFilename("a/b"))
+	assert.NotNil(t, ValidateDBFilename("gres*"))
+}
+
+func TestDB_FlushDBOnSave(t *testing.T) {
+	db := NewDB()
+	db.Set("A", []byte("A"))
+	db.Expire("A", 100)
+
+	db.dirtyLock.Lock()
+	db.onSave = true
+	db.dirtyDataMap = cmap.New()
+	db.dirtyExpireList = zset.New()
+	db.dirtyLock.Unlock()
+
+	db.Set("B", []byte("B"))
+	db.FlushDB()
+	assert.False(t, db.Exists("A"))
+	assert.False(t, db.Exists("B"))
+	assert.Equal(t, -2, db.Ttl("A"))
+
+	db.dirtyLock.Lock()
+	db.dataMap.AddCMap(db.dirtyDataMap)
+	db.expireList.AddZSet(db.dirtyExpireList)
+	db.onSave = false
+	db.dirtyLock.Unlock()
+	assert.Equal(t, 0, db.DbSize())
+	assert.Equal(t, 0, db.expireList.Length())
+}
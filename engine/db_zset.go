@@ -8,7 +8,7 @@ import (
 //   ZSet
 // ========
 func (db *DB) ZAdd(key string, score float64, member string) (int, error) {
-	obj := db.get(key)
+	obj := db.getForWrite(key)
 	if obj == nil {
 		obj = object.ZSetObject()
 		db.set(key, obj)
@@ -18,7 +18,10 @@ func (db *DB) ZAdd(key string, score float64, member string) (int, error) {
 	if !ok {
 		return 0, ErrWrongTypeOps
 	}
-	if zs.Add(score, member) {
+	added := zs.Add(score, member)
+	db.touch(key)
+	db.notify(NotifyZSet, "zadd", key)
+	if added {
 		return 1, nil
 	}
 	return 0, nil
@@ -73,7 +76,7 @@ func (db *DB) ZRank(key, member string) (*int, error) {
 }
 
 func (db *DB) ZRem(key string, member ...string) (int, error) {
-	obj := db.get(key)
+	obj := db.getForWrite(key)
 	if obj == nil {
 		return 0, nil
 	}
@@ -90,15 +93,20 @@ func (db *DB) ZRem(key string, member ...string) (int, error) {
 		}
 	}
 
+	if count > 0 {
+		db.touch(key)
+		db.notify(NotifyZSet, "zrem", key)
+	}
 	if zs.Length() == 0 {
 		db.remove(key)
+		db.notify(NotifyGeneric, "del", key)
 	}
 	return count, nil
 }
 
 // todo: panic
 func (db *DB) ZIncrBy(key string, increment float64, member string) (float64, error) {
-	obj := db.get(key)
+	obj := db.getForWrite(key)
 	if obj == nil {
 		obj = object.ZSetObject()
 		db.set(key, obj)
@@ -111,6 +119,8 @@ func (db *DB) ZIncrBy(key string, increment float64, member string) (float64, er
 
 	score, _ := zs.Get(member) // if not existed, the score is 0
 	zs.Add(score+increment, member)
+	db.touch(key)
+	db.notify(NotifyZSet, "zincr", key)
 	return score + increment, nil
 }
 
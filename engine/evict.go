@@ -0,0 +1,261 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime/metrics"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/clovers4/gres/zset"
+)
+
+// EvictPolicy decides which keys are evicted when the used memory reaches maxmemory.
+type EvictPolicy uint8
+
+func (p EvictPolicy) String() string {
+	return EvictPolicies[p]
+}
+
+const (
+	EvictNoEviction     EvictPolicy = iota // reject the write commands instead of evicting
+	EvictAllKeysLRU                        // evict the least recently used keys
+	EvictVolatileLRU                       // evict the least recently used keys with an expire set
+	EvictAllKeysLFU                        // evict the least frequently used keys
+	EvictVolatileLFU                       // evict the least frequently used keys with an expire set
+	EvictAllKeysRandom                     // evict random keys
+	EvictVolatileRandom                    // evict random keys with an expire set
+	EvictVolatileTTL                       // evict the keys with the nearest expire time
+)
+
+var EvictPolicies = map[EvictPolicy]string{
+	EvictNoEviction:     "noeviction",
+	EvictAllKeysLRU:     "allkeys-lru",
+	EvictVolatileLRU:    "volatile-lru",
+	EvictAllKeysLFU:     "allkeys-lfu",
+	EvictVolatileLFU:    "volatile-lfu",
+	EvictAllKeysRandom:  "allkeys-random",
+	EvictVolatileRandom: "volatile-random",
+	EvictVolatileTTL:    "volatile-ttl",
+}
+
+func ParseEvictPolicy(s string) (EvictPolicy, error) {
+	for p, name := range EvictPolicies {
+		if strings.ToLower(s) == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid maxmemory policy: %v", s)
+}
+
+func (p EvictPolicy) volatile() bool {
+	return p == EvictVolatileLRU || p == EvictVolatileLFU || p == EvictVolatileRandom || p == EvictVolatileTTL
+}
+
+var ErrOOM = errors.New("OOM command not allowed when used memory > 'maxmemory'")
+
+// 每个 key 在 dataMap 中的额外开销, 用于估算逐出释放的内存
+const keyOverhead = 64
+
+// memoryMeter reports the used memory of the process.
+//
+// 被逐出的对象要到下一次 GC 才会真正释放, 因此在此之前需要把已逐出的大小扣除,
+// 否则会一直逐出, 直到 GC 发生.
+type memoryMeter struct {
+	mu       sync.Mutex
+	gcCycles uint64
+	released uint64 // 上一次 GC 之后逐出的字节数
+}
+
+func (m *memoryMeter) used() uint64 {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/heap/objects:bytes"},
+		{Name: "/gc/cycles/total:gc-cycles"},
+	}
+	metrics.Read(samples)
+	heap := samples[0].Value.Uint64()
+	cycles := samples[1].Value.Uint64()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if cycles != m.gcCycles {
+		m.gcCycles = cycles
+		m.released = 0
+	}
+	if heap < m.released {
+		return 0
+	}
+	return heap - m.released
+}
+
+func (m *memoryMeter) release(n uint64) {
+	m.mu.Lock()
+	m.released += n
+	m.mu.Unlock()
+}
+
+// UsedMemory returns the bytes used by the dataset, as far as the runtime can tell.
+func (db *DB) UsedMemory() uint64 {
+	return db.root.memoryUsage()
+}
+
+// EvictedKeys returns the number of keys evicted because of maxmemory.
+func (db *DB) EvictedKeys() uint64 {
+	return atomic.LoadUint64(&db.root.evictedKeys)
+}
+
+// SetMaxMemory changes the memory limit in bytes, 0 means no limit.
+func (db *DB) SetMaxMemory(bytes uint64) {
+	atomic.StoreUint64(&db.root.maxMemory, bytes)
+}
+
+// SetMaxMemoryPolicy changes how to evict keys when maxmemory is reached.
+func (db *DB) SetMaxMemoryPolicy(policy EvictPolicy) {
+	root := db.root
+	root.evictLock.Lock()
+	defer root.evictLock.Unlock()
+	root.maxMemoryPolicy = policy
+}
+
+// SetMaxMemorySamples changes the number of keys sampled for each eviction.
+func (db *DB) SetMaxMemorySamples(samples int) {
+	root := db.root
+	root.evictLock.Lock()
+	defer root.evictLock.Unlock()
+	root.maxMemorySamples = samples
+}
+
+// FreeMemoryIfNeeded evicts keys of all dbs according to the maxmemory policy until
+// the used memory is under maxmemory. It returns ErrOOM if nothing more can be evicted.
+func (db *DB) FreeMemoryIfNeeded() error {
+	root := db.root
+	maxMemory := atomic.LoadUint64(&root.maxMemory)
+	if maxMemory == 0 {
+		return nil
+	}
+
+	root.evictLock.Lock()
+	defer root.evictLock.Unlock()
+
+	for root.memoryUsage() > maxMemory {
+		if root.maxMemoryPolicy == EvictNoEviction {
+			return ErrOOM
+		}
+
+		// 每个 db 各自采样, 从中选出最应该被逐出的 key
+		var (
+			best      *DB
+			bestKey   string
+			bestScore int64
+		)
+		now := time.Now()
+		for _, d := range root.dbs {
+			key, score, ok := d.evictionCandidate(now)
+			if ok && (best == nil || score > bestScore) {
+				best, bestKey, bestScore = d, key, score
+			}
+		}
+		if best == nil {
+			return ErrOOM
+		}
+		best.evict(bestKey)
+	}
+	return nil
+}
+
+// evict removes key, and logs it as a del command so that the append-only file
+// stays consistent with the dataset.
+func (db *DB) evict(key string) {
+	root := db.root
+	db.Propagate([]string{"del", key}, func() bool {
+		db.dirtyLock.RLock()
+		defer db.dirtyLock.RUnlock()
+
+		obj := db.getLocked(key)
+		if obj == nil {
+			return false
+		}
+		db.removeExpireLocked(key)
+		db.removeLocked(key)
+
+		root.meter.release(uint64(len(key) + keyOverhead + obj.Size()))
+		atomic.AddUint64(&root.evictedKeys, 1)
+		db.notify(NotifyEvicted, "evicted", key)
+		return true
+	})
+}
+
+// evictionCandidate samples maxmemory-samples keys of db and returns the best one
+// to evict. The larger the score is, the more it should be evicted.
+func (db *DB) evictionCandidate(now time.Time) (key string, score int64, found bool) {
+	policy := db.root.maxMemoryPolicy
+	samples := db.root.maxMemorySamples
+
+	db.dirtyLock.RLock()
+	defer db.dirtyLock.RUnlock()
+
+	var keys []string
+	collect := func(key string, _ interface{}) {
+		keys = append(keys, key)
+	}
+	if policy.volatile() {
+		if db.onSave {
+			sampleExpires(db.dirtyExpireList, samples, collect)
+		}
+		sampleExpires(db.expireList, samples, collect)
+	} else {
+		if db.onSave {
+			db.dirtyDataMap.Sample(samples, collect)
+		}
+		db.dataMap.Sample(samples, collect)
+	}
+
+	for _, k := range keys {
+		// 样本可能已被删除或已过期, 以当前数据为准
+		obj := db.getLocked(k)
+		if obj == nil {
+			continue
+		}
+
+		var s int64
+		switch policy {
+		case EvictAllKeysLRU, EvictVolatileLRU:
+			s = int64(obj.IdleTime(now))
+		case EvictAllKeysLFU, EvictVolatileLFU:
+			s = 255 - int64(obj.LFUCount(now))
+		case EvictVolatileTTL:
+			t, _ := db.expireTimeLocked(k)
+			s = -t
+		default:
+			s = rand.Int63()
+		}
+
+		if !found || s > score {
+			key, score, found = k, s, true
+		}
+	}
+	return key, score, found
+}
+
+// sampleExpires calls fn with at most n random keys which have an expire set.
+func sampleExpires(zs *zset.ZSet, n int, fn func(key string, _ interface{})) {
+	length := zs.Length()
+	if length == 0 {
+		return
+	}
+
+	zs.RLock()
+	defer zs.RUnlock()
+	for i := 0; i < n; i++ {
+		// 长度可能已变化, 超出范围时 node 为 nil
+		node := zs.GetNodeByRank(rand.Intn(length))
+		// dirtyExpireList 中的 -1 表示已删除
+		if node != nil && node.Score() != -1 {
+			fn(node.Val(), nil)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newEvictDB returns a db whose used memory is 100 bytes per key.
+func newEvictDB(policy EvictPolicy, maxKeys int) *DB {
+	db := NewDB(MaxMemoryOption(uint64(maxKeys*100)), MaxMemoryPolicyOption(policy))
+	db.memoryUsage = func() uint64 {
+		return uint64(db.DbSize() * 100)
+	}
+	return db
+}
+
+func TestDB_FreeMemoryIfNeeded(t *testing.T) {
+	for _, policy := range []EvictPolicy{EvictAllKeysLRU, EvictAllKeysLFU, EvictAllKeysRandom} {
+		db := newEvictDB(policy, 10)
+		for i := 0; i < 20; i++ {
+			assert.Nil(t, db.Set(fmt.Sprint(i), []byte(fmt.Sprint(i))))
+		}
+
+		assert.Nil(t, db.FreeMemoryIfNeeded(), policy.String())
+		assert.Equal(t, 10, db.DbSize(), policy.String())
+		assert.Equal(t, uint64(10), db.EvictedKeys(), policy.String())
+	}
+}
+
+func TestDB_FreeMemoryIfNeeded_Volatile(t *testing.T) {
+	for _, policy := range []EvictPolicy{EvictVolatileLRU, EvictVolatileLFU, EvictVolatileRandom, EvictVolatileTTL} {
+		db := newEvictDB(policy, 17)
+		for i := 0; i < 20; i++ {
+			assert.Nil(t, db.Set(fmt.Sprint(i), []byte(fmt.Sprint(i))))
+		}
+		for i := 0; i < 5; i++ {
+			db.Expire(fmt.Sprint(i), 100+i)
+		}
+
+		assert.Nil(t, db.FreeMemoryIfNeeded(), policy.String())
+		assert.Equal(t, 17, db.DbSize(), policy.String())
+		// 只有设置了过期时间的 key 会被逐出
+		for i := 5; i < 20; i++ {
+			assert.True(t, db.Exists(fmt.Sprint(i)), policy.String())
+		}
+		if policy == EvictVolatileTTL {
+			for i := 3; i < 5; i++ {
+				assert.True(t, db.Exists(fmt.Sprint(i)))
+			}
+		}
+
+		// 没有可逐出的 key
+		db.maxMemory = 100
+		assert.Equal(t, ErrOOM, db.FreeMemoryIfNeeded(), policy.String())
+		assert.Equal(t, 15, db.DbSize(), policy.String())
+	}
+}
+
+func TestDB_FreeMemoryIfNeeded_NoEviction(t *testing.T) {
+	db := newEvictDB(EvictNoEviction, 1)
+	assert.Nil(t, db.Set("a", []byte("A")))
+	assert.Nil(t, db.FreeMemoryIfNeeded())
+
+	assert.Nil(t, db.Set("b", []byte("B")))
+	assert.Equal(t, ErrOOM, db.FreeMemoryIfNeeded())
+	assert.Equal(t, 2, db.DbSize())
+}
+
+func TestParseEvictPolicy(t *testing.T) {
+	policy, err := ParseEvictPolicy("AllKeys-LRU")
+	assert.Nil(t, err)
+	assert.Equal(t, EvictAllKeysLRU, policy)
+
+	_, err = ParseEvictPolicy("lru")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,206 @@
+package engine
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/clovers4/gres/util"
+	"go.uber.org/zap"
+)
+
+// ActiveExpireConfig is the parameters of the active expire cycle, which deletes
+// the expired keys that are never accessed again, like redis does.
+type ActiveExpireConfig struct {
+	Hz              int // 每秒执行的次数
+	KeysPerLoop     int // 每轮检查的 key 个数
+	AcceptableStale int // 一轮中过期 key 的百分比超过该值, 则继续下一轮
+	CyclePercent    int // 每次执行的时间上限, 为执行间隔的百分比
+}
+
+// DefaultActiveExpireConfig is the parameters of the active expire cycle by default.
+var DefaultActiveExpireConfig = ActiveExpireConfig{
+	Hz:              10,
+	KeysPerLoop:     20,
+	AcceptableStale: 10,
+	CyclePercent:    25,
+}
+
+func (c ActiveExpireConfig) validate() error {
+	switch {
+	case c.Hz < 1 || c.Hz > 500:
+		return fmt.Errorf("invalid active expire hz: %v, should be in [1, 500]", c.Hz)
+	case c.KeysPerLoop < 1:
+		return fmt.Errorf("invalid active expire keys per loop: %v, should be positive", c.KeysPerLoop)
+	case c.AcceptableStale < 0 || c.AcceptableStale > 100:
+		return fmt.Errorf("invalid active expire acceptable stale: %v, should be in [0, 100]", c.AcceptableStale)
+	case c.CyclePercent < 1 || c.CyclePercent > 100:
+		return fmt.Errorf("invalid active expire cycle percent: %v, should be in [1, 100]", c.CyclePercent)
+	}
+	return nil
+}
+
+// interval returns the time between two cycles.
+func (c ActiveExpireConfig) interval() time.Duration {
+	return time.Second / time.Duration(c.Hz)
+}
+
+// timeLimit returns the max time of a cycle.
+func (c ActiveExpireConfig) timeLimit() time.Duration {
+	return c.interval() * time.Duration(c.CyclePercent) / 100
+}
+
+// ActiveExpire returns the parameters of the active expire cycle.
+func (db *DB) ActiveExpire() ActiveExpireConfig {
+	root := db.root
+	root.expireLock.RLock()
+	defer root.expireLock.RUnlock()
+	return root.activeExpire
+}
+
+// UpdateActiveExpire changes the parameters of the active expire cycle by update,
+// which take effect from the next cycle. Nothing changes if the result is invalid.
+func (db *DB) UpdateActiveExpire(update func(conf *ActiveExpireConfig)) error {
+	root := db.root
+	root.expireLock.Lock()
+	defer root.expireLock.Unlock()
+
+	conf := root.activeExpire
+	update(&conf)
+	if err := conf.validate(); err != nil {
+		return err
+	}
+	root.activeExpire = conf
+	return nil
+}
+
+// ExpireStats is the statistics of expiration of all dbs.
+type ExpireStats struct {
+	ExpiredKeys         uint64        // 因过期而删除的 key 个数
+	StalePercent        float64       // 估计的已过期但尚未删除的 key 的百分比
+	TimeCapReachedCount uint64        // 因达到时间上限而中止的 cycle 个数
+	CycleTime           time.Duration // active expire cycle 累计耗时
+}
+
+// ExpireStats returns the statistics of expiration.
+func (db *DB) ExpireStats() ExpireStats {
+	root := db.root
+	root.expireLock.RLock()
+	defer root.expireLock.RUnlock()
+
+	stats := root.expireStats
+	stats.ExpiredKeys = atomic.LoadUint64(&root.expiredKeys)
+	return stats
+}
+
+// ResetStats resets the statistics of expiration and eviction.
+func (db *DB) ResetStats() {
+	root := db.root
+	root.expireLock.Lock()
+	defer root.expireLock.Unlock()
+
+	root.expireStats = ExpireStats{}
+	atomic.StoreUint64(&root.expiredKeys, 0)
+	atomic.StoreUint64(&root.evictedKeys, 0)
+}
+
+func (db *DB) DoExpireBackground() {
+	for {
+		time.Sleep(db.ActiveExpire().interval())
+		db.doExpire()
+	}
+}
+
+// doExpire is the active expire cycle. Each loop checks KeysPerLoop keys with the
+// least expire time and deletes the expired ones. It loops again while more than
+// AcceptableStale percent of the checked keys were expired, until the time limit.
+func (db *DB) doExpire() {
+	conf := db.ActiveExpire()
+	start := time.Now()
+	limit := conf.timeLimit()
+
+	var sampled, expired int
+	timeCapReached := false
+	for {
+		s, e := db.expireLoop(conf.KeysPerLoop)
+		sampled += s
+		expired += e
+
+		// 过期的 key 不多, 留给下一次 cycle
+		if s == 0 || e*100 <= s*conf.AcceptableStale {
+			break
+		}
+		if time.Since(start) > limit {
+			timeCapReached = true
+			break
+		}
+	}
+	elapsed := time.Since(start)
+
+	root := db.root
+	root.expireLock.Lock()
+	if sampled > 0 {
+		// 与 redis 相同, 取移动平均, 使该值较为平滑
+		perc := float64(expired) * 100 / float64(sampled)
+		root.expireStats.StalePercent = perc*0.05 + root.expireStats.StalePercent*0.95
+	}
+	if timeCapReached {
+		root.expireStats.TimeCapReachedCount++
+	}
+	root.expireStats.CycleTime += elapsed
+	root.expireLock.Unlock()
+
+	db.log.Debug("[DB doExpire] finished", zap.Int("sampled", sampled), zap.Int("expired", expired),
+		zap.Duration("elapsed", elapsed))
+}
+
+// expireLoop checks at most n keys with the least expire time, and deletes the
+// expired ones. It returns the number of keys checked and deleted. The deletions
+// run inside Shared like a command, and are propagated as del commands so that the
+// append-only file and the replicas stay consistent with the dataset.
+func (db *DB) expireLoop(n int) (sampled, expired int) {
+	db.Shared(func() {
+		var keys []string
+		sampled, keys = db.expireSample(n)
+		for _, key := range keys {
+			db.Propagate([]string{"del", key}, func() bool {
+				db.dirtyLock.RLock()
+				defer db.dirtyLock.RUnlock()
+
+				if !db.expireIfNeededLocked(key) {
+					return false
+				}
+				expired++
+				return true
+			})
+		}
+	})
+	return sampled, expired
+}
+
+// expireSample checks at most n keys with the least expire time, and returns the
+// number of keys checked and the expired ones.
+func (db *DB) expireSample(n int) (sampled int, keys []string) {
+	db.dirtyLock.RLock()
+	defer db.dirtyLock.RUnlock()
+
+	target := db.expireList
+	// 持久化中
+	if db.onSave {
+		target = db.dirtyExpireList
+	}
+
+	now := util.NowMs()
+	keys = make([]string, 0, n)
+	target.RLock()
+	defer target.RUnlock()
+	for node := target.GetNodeLeastScore(0); node != nil && sampled < n; node = node.Next() { // ignore -1
+		sampled++
+		// 按过期时间排序, 之后的 key 都未过期
+		if node.Score() > now {
+			break
+		}
+		keys = append(keys, node.Val())
+	}
+	return sampled, keys
+}
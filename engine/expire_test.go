@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/clovers4/gres/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_ActiveExpire(t *testing.T) {
+	db := NewDB(ActiveExpireHzOption(1), ActiveExpireKeysPerLoopOption(5))
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint(i)
+		db.Set(key, []byte(key))
+		// 过期时间已到, 但 key 尚未被删除
+		db.addExpireLocked(key, int64(i+1))
+	}
+	db.Set("a", []byte("A"))
+	db.Expire("a", 100)
+	db.Set("b", []byte("B"))
+
+	// 过期的 key 较多时会多轮执行, 直到删除全部过期的 key
+	db.doExpire()
+	assert.Equal(t, 2, db.DbSize())
+	assert.Equal(t, 1, db.ExpiresSize())
+	stats := db.ExpireStats()
+	assert.Equal(t, uint64(100), stats.ExpiredKeys)
+	assert.True(t, stats.StalePercent > 0)
+
+	db.ResetStats()
+	assert.Equal(t, ExpireStats{}, db.ExpireStats())
+}
+
+func TestDB_ActiveExpire_Propagate(t *testing.T) {
+	db := NewDB(DbnumOption(2))
+	db1, _ := db.Select(1)
+	db1.Set("a", []byte("A"))
+	db1.addExpireLocked("a", 1)
+	db1.Set("b", []byte("B"))
+
+	var snapshot bytes.Buffer
+	stream, err := db.SyncReplica(&snapshot, DefaultReplicaBufferLimit)
+	assert.Nil(t, err)
+	defer stream.Close()
+
+	// 删除过期的 key 时向 replica 传播 del
+	db1.doExpire()
+	data, err := stream.Read()
+	assert.Nil(t, err)
+	var cmds [][]string
+	rd := proto.NewReader(bytes.NewReader(data))
+	for {
+		args, err := rd.ReadCommand()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		cmds = append(cmds, args)
+	}
+	assert.Equal(t, [][]string{{"select", "1"}, {"del", "a"}}, cmds)
+}
+
+func TestDB_ActiveExpire_TimeCap(t *testing.T) {
+	db := NewDB(ActiveExpireHzOption(500), ActiveExpireKeysPerLoopOption(1), ActiveExpireCyclePercentOption(1))
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprint(i)
+		db.Set(key, []byte(key))
+		db.addExpireLocked(key, 1)
+	}
+
+	db.doExpire()
+	assert.True(t, db.ExpireStats().TimeCapReachedCount > 0)
+}
+
+func TestDB_UpdateActiveExpire(t *testing.T) {
+	db := NewDB()
+	assert.Equal(t, DefaultActiveExpireConfig, db.ActiveExpire())
+
+	sibling, err := db.Select(1)
+	assert.Nil(t, err)
+	assert.Nil(t, sibling.UpdateActiveExpire(func(conf *ActiveExpireConfig) {
+		conf.KeysPerLoop = 50
+	}))
+	assert.Equal(t, 50, db.ActiveExpire().KeysPerLoop)
+
+	// 不合法时不做修改
+	assert.NotNil(t, db.UpdateActiveExpire(func(conf *ActiveExpireConfig) {
+		conf.KeysPerLoop = 100
+		conf.Hz = 0
+	}))
+	assert.Equal(t, 50, db.ActiveExpire().KeysPerLoop)
+	assert.Equal(t, 10, db.ActiveExpire().Hz)
+}
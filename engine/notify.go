@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NotifyClass is a set of keyspace event classes, like notify-keyspace-events of redis.
+type NotifyClass int
+
+const (
+	NotifyKeyspace NotifyClass = 1 << iota // K: __keyspace@<db>__:<key> 频道, 消息为事件名
+	NotifyKeyevent                         // E: __keyevent@<db>__:<event> 频道, 消息为 key
+	NotifyGeneric                          // g: del, expire, move 等与类型无关的命令
+	NotifyString                           // $
+	NotifyList                             // l
+	NotifySet                              // s
+	NotifyHash                             // h
+	NotifyZSet                             // z
+	NotifyExpired                          // x: key 过期被删除
+	NotifyEvicted                          // e: key 因 maxmemory 被逐出
+
+	NotifyAll = NotifyGeneric | NotifyString | NotifyList | NotifySet | NotifyHash | NotifyZSet |
+		NotifyExpired | NotifyEvicted // A
+)
+
+var notifyFlags = []struct {
+	flag  byte
+	class NotifyClass
+}{
+	{'A', NotifyAll},
+	{'g', NotifyGeneric},
+	{'$', NotifyString},
+	{'l', NotifyList},
+	{'s', NotifySet},
+	{'h', NotifyHash},
+	{'z', NotifyZSet},
+	{'x', NotifyExpired},
+	{'e', NotifyEvicted},
+	{'K', NotifyKeyspace},
+	{'E', NotifyKeyevent},
+}
+
+// ParseNotifyClasses parses the flags such as "KEA" or "Ex". The empty string
+// disables the notifications.
+func ParseNotifyClasses(s string) (NotifyClass, error) {
+	var classes NotifyClass
+	for i := 0; i < len(s); i++ {
+		found := false
+		for _, f := range notifyFlags {
+			if f.flag == s[i] {
+				classes |= f.class
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("invalid keyspace event class: %q", s[i])
+		}
+	}
+	return classes, nil
+}
+
+func (c NotifyClass) String() string {
+	var b strings.Builder
+	for _, f := range notifyFlags {
+		if c&f.class == f.class {
+			b.WriteByte(f.flag)
+			c &^= f.class
+		}
+	}
+	return b.String()
+}
+
+// NotifyFunc publishes a keyspace event to a pub/sub channel.
+type NotifyFunc func(channel, message string)
+
+// SetNotifyKeyspaceEvents changes the classes of the keyspace events to publish.
+func (db *DB) SetNotifyKeyspaceEvents(classes NotifyClass) {
+	root := db.root
+	root.confLock.Lock()
+	defer root.confLock.Unlock()
+	root.notifyClasses = classes
+}
+
+// notify publishes the event of key if the class of the event is enabled.
+// Nothing is published unless K or E is enabled as well.
+func (db *DB) notify(class NotifyClass, event, key string) {
+	root := db.root
+	root.confLock.RLock()
+	classes := root.notifyClasses
+	root.confLock.RUnlock()
+	if root.notifyFunc == nil || classes&class == 0 {
+		return
+	}
+
+	if classes&NotifyKeyspace != 0 {
+		root.notifyFunc(fmt.Sprintf("__keyspace@%d__:%s", db.index, key), event)
+	}
+	if classes&NotifyKeyevent != 0 {
+		root.notifyFunc(fmt.Sprintf("__keyevent@%d__:%s", db.index, event), key)
+	}
+}
@@ -0,0 +1,75 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type notifyRecorder struct {
+	channels []string
+	messages []string
+}
+
+func (r *notifyRecorder) publish(channel, message string) {
+	r.channels = append(r.channels, channel)
+	r.messages = append(r.messages, message)
+}
+
+func TestDB_Notify(t *testing.T) {
+	r := &notifyRecorder{}
+	db := NewDB(NotifyKeyspaceEventsOption(NotifyKeyspace|NotifyKeyevent|NotifyAll), NotifyFuncOption(r.publish))
+
+	db.Set("a", []byte("A"))
+	assert.Equal(t, []string{"__keyspace@0__:a", "__keyevent@0__:set"}, r.channels)
+	assert.Equal(t, []string{"set", "a"}, r.messages)
+
+	// 列表为空时同时发出 del
+	r = &notifyRecorder{}
+	db.notifyFunc = r.publish
+	db.RPush("l", "x")
+	db.LPop("l")
+	assert.Equal(t, []string{"rpush", "l", "lpop", "l", "del", "l"}, r.messages)
+
+	r = &notifyRecorder{}
+	db.notifyFunc = r.publish
+	sibling, _ := db.Select(1)
+	sibling.Set("b", []byte("B"))
+	sibling.Expire("b", 100)
+	assert.Equal(t, "__keyspace@1__:b", r.channels[2])
+	assert.Equal(t, "expire", r.messages[2])
+	sibling.Expire("b", -1)
+	assert.Equal(t, "del", r.messages[4])
+}
+
+func TestDB_NotifyExpired(t *testing.T) {
+	r := &notifyRecorder{}
+	classes, err := ParseNotifyClasses("Ex")
+	assert.Nil(t, err)
+	db := NewDB(NotifyKeyspaceEventsOption(classes), NotifyFuncOption(r.publish))
+
+	db.Set("a", []byte("A"))
+	db.Del("a")
+	assert.Empty(t, r.channels)
+
+	// 过期时间已到, 但 key 尚未被删除
+	db.Set("b", []byte("B"))
+	db.addExpireLocked("b", 1)
+	assert.False(t, db.Exists("b"))
+	assert.Equal(t, []string{"__keyevent@0__:expired"}, r.channels)
+	assert.Equal(t, []string{"b"}, r.messages)
+}
+
+func TestParseNotifyClasses(t *testing.T) {
+	classes, err := ParseNotifyClasses("KEA")
+	assert.Nil(t, err)
+	assert.Equal(t, NotifyKeyspace|NotifyKeyevent|NotifyAll, classes)
+	assert.Equal(t, "AKE", classes.String())
+
+	classes, err = ParseNotifyClasses("Kgl")
+	assert.Nil(t, err)
+	assert.Equal(t, "glK", classes.String())
+
+	_, err = ParseNotifyClasses("KQ")
+	assert.NotNil(t, err)
+}
@@ -91,9 +91,7 @@ func (h *Hash) Marshal(w io.Writer) error {
 			return err
 		}
 
-		// use Plain to marshal
-		p := plain.New(v)
-		if err := p.Marshal(w); err != nil {
+		if err := plain.WriteVal(w, v); err != nil {
 			return err
 		}
 	}
@@ -112,12 +110,12 @@ func (h *Hash) Unmarshal(r io.Reader) error {
 			return err
 		}
 
-		p := plain.New(nil)
-		if err := p.Unmarshal(r); err != nil {
+		val, err := plain.ReadVal(r)
+		if err != nil {
 			return err
 		}
 
-		h.Set(key, p.Val())
+		h.Set(key, val)
 	}
 	return nil
 }
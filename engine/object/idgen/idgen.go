@@ -0,0 +1,194 @@
+package idgen
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/clovers4/gres/util"
+)
+
+// snowflake 风格的 id: 1 位符号位为 0, 41 位毫秒时间戳(相对 epoch), 10 位节点 id, 12 位序号
+const (
+	nodeBits = 10
+	seqBits  = 12
+	timeBits = 63 - nodeBits - seqBits
+
+	MaxNode = 1<<nodeBits - 1
+	maxSeq  = 1<<seqBits - 1
+	maxTime = 1<<timeBits - 1
+
+	DefaultEpoch = 1577836800000 // 2020-01-01 00:00:00 UTC, unix 毫秒
+)
+
+var (
+	ErrOverflow = errors.New("ERR the id generator is exhausted")
+	ErrNode     = fmt.Errorf("ERR the node id must be between 0 and %d", MaxNode)
+	ErrStep     = errors.New("ERR the step must be positive")
+)
+
+// Kind is how the ids are generated.
+type Kind uint8
+
+const (
+	Snowflake Kind = iota // 时间戳 + 节点 id + 序号
+	Segment               // 按步长递增的计数器
+)
+
+func (kind Kind) String() string {
+	switch kind {
+	case Snowflake:
+		return "snowflake"
+	case Segment:
+		return "segment"
+	}
+	return "unknown"
+}
+
+// IDGen generates unique ids which increase monotonically. Only the last id is
+// the state, so a generator restored from the last id never goes back, even if
+// the clock does.
+type IDGen struct {
+	kind  Kind
+	node  int64 // [snowflake] 节点 id, 不同节点的 id 不会重复
+	epoch int64 // [snowflake] 时间戳的起点, unix 毫秒
+	step  int64 // [segment] 相邻 id 的差
+	last  int64 // 最近发放的 id
+}
+
+// NewSnowflake returns a snowflake generator of node, whose time component counts
+// the milliseconds since epoch.
+func NewSnowflake(node, epoch int64) (*IDGen, error) {
+	if node < 0 || node > MaxNode {
+		return nil, ErrNode
+	}
+	return &IDGen{kind: Snowflake, node: node, epoch: epoch}, nil
+}
+
+// NewSegment returns a counter whose first id is start, and the ids after it
+// increase by step.
+func NewSegment(start, step int64) (*IDGen, error) {
+	if step <= 0 {
+		return nil, ErrStep
+	}
+	last, ok := add(start, -step)
+	if !ok {
+		return nil, ErrOverflow
+	}
+	return &IDGen{kind: Segment, step: step, last: last}, nil
+}
+
+func (g *IDGen) Kind() Kind {
+	return g.kind
+}
+
+func (g *IDGen) Node() int64 {
+	return g.node
+}
+
+func (g *IDGen) Epoch() int64 {
+	return g.epoch
+}
+
+func (g *IDGen) Step() int64 {
+	return g.step
+}
+
+// Last returns the last id generated.
+func (g *IDGen) Last() int64 {
+	return g.last
+}
+
+// SetLast restores the last id generated, the ids after it are greater.
+func (g *IDGen) SetLast(last int64) {
+	g.last = last
+}
+
+// Next returns n new ids, now is the unix time in milliseconds. Either all of them
+// are generated, or none if the generator is exhausted.
+func (g *IDGen) Next(n int, now int64) ([]int64, error) {
+	ids := make([]int64, n)
+	last := g.last
+	for i := range ids {
+		var err error
+		if g.kind == Snowflake {
+			last, err = g.nextSnowflake(last, now)
+		} else {
+			last, err = g.nextSegment(last)
+		}
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = last
+	}
+	g.last = last
+	return ids, nil
+}
+
+func (g *IDGen) nextSnowflake(last, now int64) (int64, error) {
+	t, seq := now-g.epoch, int64(0)
+	if t < 0 {
+		t = 0
+	}
+	lastTime, lastSeq := last>>(nodeBits+seqBits), last&maxSeq
+	if t <= lastTime {
+		// 同一毫秒内或时钟回拨, 沿用上次的时间戳递增序号; 序号用完时借用下一毫秒
+		t, seq = lastTime, lastSeq+1
+		if seq > maxSeq || g.compose(t, seq) <= last {
+			t, seq = lastTime+1, 0
+		}
+	}
+	if t > maxTime {
+		return 0, ErrOverflow
+	}
+	return g.compose(t, seq), nil
+}
+
+func (g *IDGen) compose(t, seq int64) int64 {
+	return t<<(nodeBits+seqBits) | g.node<<seqBits | seq
+}
+
+func (g *IDGen) nextSegment(last int64) (int64, error) {
+	id, ok := add(last, g.step)
+	if !ok {
+		return 0, ErrOverflow
+	}
+	return id, nil
+}
+
+// add returns a+b, and reports whether it does not overflow.
+func add(a, b int64) (int64, bool) {
+	c := a + b
+	return c, (c > a) == (b > 0)
+}
+
+// Only for test
+func (g *IDGen) String() string {
+	if g.kind == Snowflake {
+		return fmt.Sprintf("{%v node=%d epoch=%d last=%d}", g.kind, g.node, g.epoch, g.last)
+	}
+	return fmt.Sprintf("{%v step=%d last=%d}", g.kind, g.step, g.last)
+}
+
+func (g *IDGen) Marshal(w io.Writer) error {
+	for _, v := range []interface{}{uint8(g.kind), g.node, g.epoch, g.step, g.last} {
+		if err := util.Write(w, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *IDGen) Unmarshal(r io.Reader) error {
+	var kind uint8
+	if err := util.Read(r, &kind); err != nil {
+		return err
+	}
+	g.kind = Kind(kind)
+	for _, v := range []*int64{&g.node, &g.epoch, &g.step, &g.last} {
+		if err := util.Read(r, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
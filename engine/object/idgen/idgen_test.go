@@ -0,0 +1,83 @@
+package idgen
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIDGen_Snowflake(t *testing.T) {
+	_, err := NewSnowflake(MaxNode+1, DefaultEpoch)
+	assert.Equal(t, ErrNode, err)
+
+	g, err := NewSnowflake(3, DefaultEpoch)
+	assert.Nil(t, err)
+	now := int64(DefaultEpoch + 1000)
+	ids, err := g.Next(2, now)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{1000<<22 | 3<<12, 1000<<22 | 3<<12 | 1}, ids)
+
+	// 时钟回拨时仍然递增
+	ids, _ = g.Next(1, now-500)
+	assert.Equal(t, int64(1000<<22|3<<12|2), ids[0])
+	ids, _ = g.Next(1, now+1)
+	assert.Equal(t, int64(1001<<22|3<<12), ids[0])
+
+	// 一毫秒内的序号用完后借用下一毫秒
+	ids, _ = g.Next(maxSeq+1, now+1)
+	assert.Equal(t, int64(1001<<22|3<<12|maxSeq), ids[maxSeq-1])
+	assert.Equal(t, int64(1002<<22|3<<12), ids[maxSeq])
+	for i := 1; i < len(ids); i++ {
+		assert.True(t, ids[i] > ids[i-1])
+	}
+
+	// 从其他节点的 id 恢复也不会变小
+	g.SetLast(1002<<22 | 5<<12 | 7)
+	ids, _ = g.Next(1, now)
+	assert.Equal(t, int64(1003<<22|3<<12), ids[0])
+
+	g.SetLast(maxTime<<22 | 3<<12 | maxSeq)
+	_, err = g.Next(1, now)
+	assert.Equal(t, ErrOverflow, err)
+	assert.Equal(t, int64(maxTime<<22|3<<12|maxSeq), g.Last())
+}
+
+func TestIDGen_Segment(t *testing.T) {
+	_, err := NewSegment(1, 0)
+	assert.Equal(t, ErrStep, err)
+	_, err = NewSegment(math.MinInt64, 1)
+	assert.Equal(t, ErrOverflow, err)
+
+	g, err := NewSegment(1, 1)
+	assert.Nil(t, err)
+	ids, err := g.Next(3, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{1, 2, 3}, ids)
+
+	g, _ = NewSegment(100, 10)
+	ids, _ = g.Next(2, 0)
+	assert.Equal(t, []int64{100, 110}, ids)
+
+	// 用完时不发放任何 id
+	g.SetLast(math.MaxInt64 - 15)
+	_, err = g.Next(2, 0)
+	assert.Equal(t, ErrOverflow, err)
+	assert.Equal(t, int64(math.MaxInt64-15), g.Last())
+	ids, _ = g.Next(1, 0)
+	assert.Equal(t, []int64{math.MaxInt64 - 5}, ids)
+}
+
+func TestIDGen_Marshal(t *testing.T) {
+	g, _ := NewSnowflake(7, DefaultEpoch)
+	g.Next(1, DefaultEpoch+1)
+
+	buf := new(bytes.Buffer)
+	assert.Nil(t, g.Marshal(buf))
+	newG := new(IDGen)
+	assert.Nil(t, newG.Unmarshal(buf))
+	assert.Equal(t, g.String(), newG.String())
+	assert.Equal(t, Snowflake, newG.Kind())
+	assert.Equal(t, int64(7), newG.Node())
+}
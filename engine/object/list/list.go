@@ -165,9 +165,7 @@ func (ls *List) Marshal(w io.Writer) error {
 
 	// loop write score and val
 	for n := ls.Front(); n != nil; n = n.Next() {
-		// use Plain to marshal
-		p := plain.New(n.Val())
-		if err := p.Marshal(w); err != nil {
+		if err := plain.WriteVal(w, n.Val()); err != nil {
 			return err
 		}
 	}
@@ -181,12 +179,12 @@ func (ls *List) Unmarshal(r io.Reader) error {
 	}
 
 	for i := 0; i < int(total); i++ {
-		p := plain.New(nil)
-		if err := p.Unmarshal(r); err != nil {
+		val, err := plain.ReadVal(r)
+		if err != nil {
 			return err
 		}
 
-		ls.RPush(p.Val())
+		ls.RPush(val)
 	}
 	return nil
 }
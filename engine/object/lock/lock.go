@@ -0,0 +1,54 @@
+package lock
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/clovers4/gres/util"
+)
+
+// Lock is a lock held by owner. The lease is the expire time of its key, so only
+// the owner and the fencing token issued on acquiring are the state.
+type Lock struct {
+	owner []byte // 持有者, 按字节比较
+	token int64  // 获取时发放的 fencing token
+}
+
+// New returns a lock held by owner with the fencing token.
+func New(owner string, token int64) *Lock {
+	return &Lock{owner: []byte(owner), token: token}
+}
+
+func (l *Lock) Owner() string {
+	return string(l.owner)
+}
+
+func (l *Lock) Token() int64 {
+	return l.token
+}
+
+// HeldBy reports whether the lock is held by owner. The owners are compared byte
+// by byte, so "7" and "007" are different owners.
+func (l *Lock) HeldBy(owner string) bool {
+	return bytes.Equal(l.owner, util.StringToBytes(owner))
+}
+
+// Only for test
+func (l *Lock) String() string {
+	return fmt.Sprintf("{owner=%q token=%d}", l.owner, l.token)
+}
+
+func (l *Lock) Marshal(w io.Writer) error {
+	if err := util.Write(w, l.owner); err != nil {
+		return err
+	}
+	return util.Write(w, l.token)
+}
+
+func (l *Lock) Unmarshal(r io.Reader) error {
+	if err := util.Read(r, &l.owner); err != nil {
+		return err
+	}
+	return util.Read(r, &l.token)
+}
@@ -0,0 +1,31 @@
+package lock
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLock(t *testing.T) {
+	l := New("7", 3)
+	assert.Equal(t, "7", l.Owner())
+	assert.Equal(t, int64(3), l.Token())
+
+	// 按字节比较, 数值相等的 owner 不是同一个
+	assert.True(t, l.HeldBy("7"))
+	assert.False(t, l.HeldBy("007"))
+	assert.False(t, l.HeldBy("7.0"))
+	assert.False(t, l.HeldBy(""))
+}
+
+func TestLock_Marshal(t *testing.T) {
+	l := New("a\x00b", 42)
+
+	buf := new(bytes.Buffer)
+	assert.Nil(t, l.Marshal(buf))
+	newL := new(Lock)
+	assert.Nil(t, newL.Unmarshal(buf))
+	assert.Equal(t, l.String(), newL.String())
+	assert.True(t, newL.HeldBy("a\x00b"))
+}
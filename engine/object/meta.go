@@ -0,0 +1,136 @@
+package object
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// LFU, 与 redis 相同: 计数器为对数增长, 且随时间衰减
+const (
+	LFUInitVal   = 5  // 新对象的计数, 避免刚写入就被逐出
+	LFULogFactor = 10 // 计数增长的难度
+	LFUDecayTime = 1  // 每经过多少分钟, 计数减 1
+)
+
+// lruClock returns the access clock in seconds.
+func lruClock(now time.Time) uint32 {
+	return uint32(now.Unix())
+}
+
+// lfuClock returns the decrement clock in minutes, only the low 16 bits are used.
+func lfuClock(now time.Time) uint32 {
+	return uint32(now.Unix()/60) & 0xffff
+}
+
+func (obj *Object) initMeta() {
+	now := time.Now()
+	// 与 Touch 相同原子地写入, 读取时不会混用原子与非原子访问
+	atomic.StoreUint32(&obj.lru, lruClock(now))
+	atomic.StoreUint32(&obj.lfu, lfuClock(now)<<8|LFUInitVal)
+}
+
+// Touch records an access of obj. It may be called concurrently by readers,
+// so the metadata is only written atomically and a lost update is acceptable.
+func (obj *Object) Touch(now time.Time) {
+	atomic.StoreUint32(&obj.lru, lruClock(now))
+
+	counter := obj.LFUCount(now)
+	counter = lfuLogIncr(counter)
+	atomic.StoreUint32(&obj.lfu, lfuClock(now)<<8|uint32(counter))
+}
+
+// IdleTime returns how long obj has not been accessed.
+func (obj *Object) IdleTime(now time.Time) time.Duration {
+	idle := int64(lruClock(now)) - int64(atomic.LoadUint32(&obj.lru))
+	if idle < 0 {
+		return 0
+	}
+	return time.Duration(idle) * time.Second
+}
+
+// LFUCount returns the access frequency counter of obj after decay.
+func (obj *Object) LFUCount(now time.Time) uint8 {
+	lfu := atomic.LoadUint32(&obj.lfu)
+	counter := uint8(lfu & 0xff)
+
+	// 16 位的分钟时钟会回绕
+	elapsed := (lfuClock(now) - lfu>>8) & 0xffff
+	periods := elapsed / LFUDecayTime
+	if periods >= uint32(counter) {
+		return 0
+	}
+	return counter - uint8(periods)
+}
+
+func lfuLogIncr(counter uint8) uint8 {
+	if counter == 255 {
+		return 255
+	}
+
+	base := float64(0)
+	if counter > LFUInitVal {
+		base = float64(counter - LFUInitVal)
+	}
+	p := 1.0 / (base*LFULogFactor + 1)
+	if rand.Float64() < p {
+		counter++
+	}
+	return counter
+}
+
+// 估算内存时使用的常量, 大致对应 go 的内存布局
+const (
+	objectOverhead = int(unsafe.Sizeof(Object{})) + 16
+	entryOverhead  = 48 // map/list/skiplist 中每个元素的额外开销
+)
+
+// Size estimates the bytes used by obj. It is only used to account for the
+// memory freed by eviction, so it does not need to be exact.
+func (obj *Object) Size() int {
+	size := objectOverhead
+	switch obj.kind {
+	case ObjPlain:
+		p, _ := obj.Plain()
+		if _, ok := p.Int(); ok {
+			size += 8
+		} else {
+			size += 16 + p.Len()
+		}
+	case ObjList:
+		ls, _ := obj.List()
+		for n := ls.Front(); n != nil; n = n.Next() {
+			size += entryOverhead + valSize(n.Val())
+		}
+	case ObjSet:
+		s, _ := obj.Set()
+		for _, v := range s.Vals() {
+			size += entryOverhead + valSize(v)
+		}
+	case ObjZset:
+		zs, _ := obj.ZSet()
+		for n := zs.GetNodeByRank(0); n != nil; n = n.Next() {
+			size += 2*entryOverhead + 8 + len(n.Val())
+		}
+	case ObjHash:
+		h, _ := obj.Hash()
+		kvs := h.KeyVals()
+		for i := 0; i < len(kvs); i += 2 {
+			size += entryOverhead + valSize(kvs[i]) + valSize(kvs[i+1])
+		}
+	case ObjIDGen:
+		size += 48
+	case ObjLock:
+		l, _ := obj.Lock()
+		size += 24 + len(l.Owner())
+	}
+	return size
+}
+
+func valSize(val interface{}) int {
+	if s, ok := val.(string); ok {
+		return 16 + len(s)
+	}
+	return 16
+}
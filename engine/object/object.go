@@ -1,11 +1,15 @@
 package object
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"sync/atomic"
 
 	"github.com/clovers4/gres/engine/object/hash"
+	"github.com/clovers4/gres/engine/object/idgen"
 	"github.com/clovers4/gres/engine/object/list"
+	"github.com/clovers4/gres/engine/object/lock"
 	"github.com/clovers4/gres/engine/object/plain"
 	"github.com/clovers4/gres/engine/object/set"
 	"github.com/clovers4/gres/engine/object/zset"
@@ -27,30 +31,46 @@ const (
 	ObjSet
 	ObjZset
 	ObjHash
+	ObjIDGen
+	ObjLock
 )
 
 var ObjKinds = map[ObjKind]string{
-	ObjPlain: "plain", // string, int, float, ... todo: 兼容redis协议
+	ObjPlain: "plain", // binary-safe string, int encoded if possible
 	ObjList:  "list",
 	ObjSet:   "set",
 	ObjZset:  "zset",
 	ObjHash:  "hash",
+	ObjIDGen: "idgen", // 唯一 id 生成器
+	ObjLock:  "lock",  // 带 fencing token 的锁
 }
 
 type Object struct {
 	kind ObjKind
 	data interface{}
+
+	// 访问信息, 用于逐出; 不会被持久化
+	lru uint32 // 最近一次访问时间, 秒
+	lfu uint32 // 高 16 位为最近一次衰减时间(分钟), 低 8 位为访问频率计数
 }
 
 func newObject(kind ObjKind, data interface{}) *Object {
-	return &Object{
+	obj := &Object{
 		kind: kind,
 		data: data,
 	}
+	obj.initMeta()
+	return obj
+}
+
+// PlainObject returns a string object of b, which is owned by the object afterwards.
+func PlainObject(b []byte) *Object {
+	return newObject(ObjPlain, plain.New(b))
 }
 
-func PlainObject(val interface{}) *Object {
-	return newObject(ObjPlain, plain.New(val))
+// IntObject returns an int encoded string object.
+func IntObject(n int64) *Object {
+	return newObject(ObjPlain, plain.NewInt(n))
 }
 
 func ListObject() *Object {
@@ -69,6 +89,16 @@ func HashObject() *Object {
 	return newObject(ObjHash, hash.New())
 }
 
+// IDGenObject returns an id generator object of g, which is owned by the object afterwards.
+func IDGenObject(g *idgen.IDGen) *Object {
+	return newObject(ObjIDGen, g)
+}
+
+// LockObject returns a lock object of l, which is owned by the object afterwards.
+func LockObject(l *lock.Lock) *Object {
+	return newObject(ObjLock, l)
+}
+
 func (obj *Object) Kind() ObjKind {
 	return obj.kind
 }
@@ -98,10 +128,36 @@ func (obj *Object) Hash() (*hash.Hash, bool) {
 	return h, ok
 }
 
+func (obj *Object) IDGen() (*idgen.IDGen, bool) {
+	g, ok := obj.data.(*idgen.IDGen)
+	return g, ok
+}
+
+func (obj *Object) Lock() (*lock.Lock, bool) {
+	l, ok := obj.data.(*lock.Lock)
+	return l, ok
+}
+
 func (obj *Object) String() string {
 	return fmt.Sprintf("[%v] %v", ObjKinds[obj.kind], obj.data)
 }
 
+// Clone returns a deep copy of obj.
+func (obj *Object) Clone() *Object {
+	buf := new(bytes.Buffer)
+	if err := obj.Marshal(buf); err != nil {
+		panic(err)
+	}
+
+	clone := new(Object)
+	if err := clone.Unmarshal(buf); err != nil {
+		panic(err)
+	}
+	atomic.StoreUint32(&clone.lru, atomic.LoadUint32(&obj.lru))
+	atomic.StoreUint32(&clone.lfu, atomic.LoadUint32(&obj.lfu))
+	return clone
+}
+
 func (obj *Object) Marshal(w io.Writer) error {
 	kind := uint8(obj.kind)
 	if err := util.Write(w, kind); err != nil {
@@ -121,6 +177,7 @@ func (obj *Object) Unmarshal(r io.Reader) error {
 		return err
 	}
 	obj.kind = ObjKind(kind)
+	obj.initMeta()
 
 	switch ObjKind(kind) {
 	case ObjPlain:
@@ -153,6 +210,18 @@ func (obj *Object) Unmarshal(r io.Reader) error {
 			return err
 		}
 		obj.data = data
+	case ObjIDGen:
+		data := new(idgen.IDGen)
+		if err := data.Unmarshal(r); err != nil {
+			return err
+		}
+		obj.data = data
+	case ObjLock:
+		data := new(lock.Lock)
+		if err := data.Unmarshal(r); err != nil {
+			return err
+		}
+		obj.data = data
 	default:
 		return fmt.Errorf("unsupported object type [%v]", kind)
 	}
@@ -5,11 +5,13 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/clovers4/gres/engine/object/idgen"
+	"github.com/clovers4/gres/engine/object/lock"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestObject_Marshal_Plain(t *testing.T) {
-	obj := PlainObject(int64(12))
+	obj := IntObject(12)
 	fmt.Println(obj)
 
 	// marshal
@@ -111,3 +113,48 @@ func TestObject_Marshal_Hash(t *testing.T) {
 	assert.Equal(t, obj.String(), newObj.String())
 	fmt.Println(newObj.String())
 }
+
+func TestObject_Marshal_IDGen(t *testing.T) {
+	g, err := idgen.NewSegment(100, 10)
+	assert.Nil(t, err)
+	obj := IDGenObject(g)
+	g.Next(2, 0)
+	assert.Equal(t, "idgen", obj.Kind().String())
+
+	// marshal
+	buf := new(bytes.Buffer)
+	err = obj.Marshal(buf)
+	assert.Nil(t, err)
+
+	// unmarshal
+	newObj := &Object{}
+	r := bytes.NewReader(buf.Bytes())
+	err = newObj.Unmarshal(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "[idgen] {segment step=10 last=110}", newObj.String())
+}
+
+func TestObject_Marshal_Lock(t *testing.T) {
+	obj := LockObject(lock.New("007", 3))
+	assert.Equal(t, "lock", obj.Kind().String())
+
+	buf := new(bytes.Buffer)
+	assert.Nil(t, obj.Marshal(buf))
+	newObj := &Object{}
+	assert.Nil(t, newObj.Unmarshal(bytes.NewReader(buf.Bytes())))
+	assert.Equal(t, `[lock] {owner="007" token=3}`, newObj.String())
+}
+
+func TestObject_Clone(t *testing.T) {
+	obj := ListObject()
+	ls, _ := obj.List()
+	ls.RPush("A")
+
+	clone := obj.Clone()
+	cls, ok := clone.List()
+	assert.Equal(t, true, ok)
+	cls.RPush("B")
+
+	assert.Equal(t, 1, ls.Length())
+	assert.Equal(t, "[list] {A, B}", clone.String())
+}
@@ -2,135 +2,270 @@ package plain
 
 import (
 	"fmt"
-	"github.com/clovers4/gres/util"
 	"io"
 	"reflect"
+	"strconv"
+
+	"github.com/clovers4/gres/util"
 )
 
-// only support int8/int16/int32/int64 uint8/uint16/uint32/uint64 float32/float64 string
-// NOT support int/uint
+// encodings of Plain, like redis
+const (
+	EncInt    = "int"    // 64 位整数
+	EncEmbStr = "embstr" // 短字符串
+	EncRaw    = "raw"    // 长字符串
+)
+
+// 与 redis 相同, 不超过该长度的字符串称为 embstr
+const embStrMaxLen = 44
+
+// 持久化时的类型标记, 与 reflect.Kind 不重叠, 以便读取老版本按 go 类型存储的值
+const (
+	tagInt   uint8 = 0x80
+	tagBytes uint8 = 0x81
+)
+
+// Plain is a binary-safe string. Like redis, a string which is the canonical form
+// of a 64-bit integer, such as "12" but not "012" or "+12", is int encoded to save
+// memory, so it is read back as the same bytes.
 type Plain struct {
-	val interface{}
+	isInt bool
+	n     int64
+	b     []byte
+}
+
+// New returns a Plain of b, which is owned by the Plain afterwards.
+func New(b []byte) *Plain {
+	p := new(Plain)
+	p.SetBytes(b)
+	return p
+}
+
+// NewInt returns an int encoded Plain.
+func NewInt(n int64) *Plain {
+	p := new(Plain)
+	p.SetInt(n)
+	return p
 }
 
-func New(val interface{}) *Plain {
-	return &Plain{
-		val: val,
+// Bytes returns the string, which must not be modified. It is never nil.
+func (p *Plain) Bytes() []byte {
+	if p.isInt {
+		return strconv.AppendInt(nil, p.n, 10)
 	}
+	return p.b
+}
+
+// Int returns the integer if p is int encoded.
+func (p *Plain) Int() (int64, bool) {
+	return p.n, p.isInt
 }
 
-func (p *Plain) Val() interface{} {
-	return p.val
+// SetBytes sets the string to b, which is owned by the Plain afterwards.
+func (p *Plain) SetBytes(b []byte) {
+	if n, ok := parseInt(b); ok {
+		p.SetInt(n)
+		return
+	}
+	if b == nil {
+		b = []byte{}
+	}
+	p.isInt, p.n, p.b = false, 0, b
 }
 
-func (p *Plain) SetVal(val interface{}) interface{} {
-	old := p.val
-	p.val = val
-	return old
+func (p *Plain) SetInt(n int64) {
+	p.isInt, p.n, p.b = true, n, nil
+}
+
+// SetRange overwrites the string from offset with b, padding with zero bytes if
+// the string is shorter than offset. It returns the length.
+func (p *Plain) SetRange(offset int, b []byte) int {
+	old := p.Bytes()
+	n := offset + len(b)
+	if n < len(old) {
+		n = len(old)
+	}
+	// 复制一份再修改, 读者持有的切片不变
+	nb := make([]byte, n)
+	copy(nb, old)
+	copy(nb[offset:], b)
+	p.SetBytes(nb)
+	return n
+}
+
+// Len returns the length of the string.
+func (p *Plain) Len() int {
+	if p.isInt {
+		return len(strconv.AppendInt(make([]byte, 0, 20), p.n, 10))
+	}
+	return len(p.b)
+}
+
+// Encoding returns EncInt, EncEmbStr or EncRaw.
+func (p *Plain) Encoding() string {
+	switch {
+	case p.isInt:
+		return EncInt
+	case len(p.b) <= embStrMaxLen:
+		return EncEmbStr
+	default:
+		return EncRaw
+	}
 }
 
 // Only for test
 func (p *Plain) String() string {
-	return fmt.Sprintf("%v", p.val)
+	return string(p.Bytes())
+}
+
+// parseInt parses b if it is the canonical form of a 64-bit integer.
+func parseInt(b []byte) (int64, bool) {
+	// 最长为 "-9223372036854775808"
+	if len(b) == 0 || len(b) > 20 {
+		return 0, false
+	}
+	s := util.BytesToString(b)
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != s {
+		return 0, false
+	}
+	return n, true
 }
 
 func (p *Plain) Marshal(w io.Writer) error {
+	if p.isInt {
+		if err := util.Write(w, tagInt); err != nil {
+			return err
+		}
+		return util.Write(w, p.n)
+	}
+
+	if err := util.Write(w, tagBytes); err != nil {
+		return err
+	}
+	return util.Write(w, p.b)
+}
+
+func (p *Plain) Unmarshal(r io.Reader) error {
+	var tag uint8
+	if err := util.Read(r, &tag); err != nil {
+		return err
+	}
+
+	switch tag {
+	case tagInt:
+		var n int64
+		if err := util.Read(r, &n); err != nil {
+			return err
+		}
+		p.SetInt(n)
+	case tagBytes:
+		var b []byte
+		if err := util.Read(r, &b); err != nil {
+			return err
+		}
+		p.SetBytes(b)
+	default:
+		// 老版本按 go 的类型存储, 转换成回复给客户端的字符串
+		v, err := readKindVal(r, tag)
+		if err != nil {
+			return err
+		}
+		p.SetBytes([]byte(formatVal(v)))
+	}
+	return nil
+}
+
+// formatVal formats val like the reply of GET in the old versions.
+func formatVal(val interface{}) string {
+	switch v := val.(type) {
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// WriteVal writes val with its type, val should be one of int8/int16/int32/int64
+// uint8/uint16/uint32/uint64 float32/float64 string, such as a field of hash.
+func WriteVal(w io.Writer, val interface{}) error {
 	// 经常使用 int 忘了转成 int64, 这里做一层防御
-	if v, ok := p.val.(int); ok {
-		p.val = int64(v)
+	if v, ok := val.(int); ok {
+		val = int64(v)
 	}
 
-	kind := uint8(reflect.TypeOf(p.val).Kind())
+	kind := uint8(reflect.TypeOf(val).Kind())
 	if err := util.Write(w, kind); err != nil {
 		return err
 	}
-	if err := util.Write(w, p.val); err != nil {
+	if err := util.Write(w, val); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (p *Plain) Unmarshal(r io.Reader) error {
+// ReadVal reads the val written by WriteVal.
+func ReadVal(r io.Reader) (interface{}, error) {
 	var kind uint8
 	if err := util.Read(r, &kind); err != nil {
-		return err
+		return nil, err
 	}
+	return readKindVal(r, kind)
+}
 
+func readKindVal(r io.Reader, kind uint8) (interface{}, error) {
 	switch reflect.Kind(kind) {
 	case reflect.Bool:
 		var v bool
-		if err := util.Read(r, &v); err != nil {
-			return err
-		}
-		p.val = v
+		err := util.Read(r, &v)
+		return v, err
 	case reflect.Int8:
 		var v int8
-		if err := util.Read(r, &v); err != nil {
-			return err
-		}
-		p.val = v
+		err := util.Read(r, &v)
+		return v, err
 	case reflect.Int16:
 		var v int16
-		if err := util.Read(r, &v); err != nil {
-			return err
-		}
-		p.val = v
+		err := util.Read(r, &v)
+		return v, err
 	case reflect.Int32:
 		var v int32
-		if err := util.Read(r, &v); err != nil {
-			return err
-		}
-		p.val = v
+		err := util.Read(r, &v)
+		return v, err
 	case reflect.Int64:
 		var v int64
-		if err := util.Read(r, &v); err != nil {
-			return err
-		}
-		p.val = v
+		err := util.Read(r, &v)
+		return v, err
 	case reflect.Uint8:
 		var v uint8
-		if err := util.Read(r, &v); err != nil {
-			return err
-		}
-		p.val = v
+		err := util.Read(r, &v)
+		return v, err
 	case reflect.Uint16:
 		var v uint16
-		if err := util.Read(r, &v); err != nil {
-			return err
-		}
-		p.val = v
+		err := util.Read(r, &v)
+		return v, err
 	case reflect.Uint32:
 		var v uint32
-		if err := util.Read(r, &v); err != nil {
-			return err
-		}
-		p.val = v
+		err := util.Read(r, &v)
+		return v, err
 	case reflect.Uint64:
 		var v uint64
-		if err := util.Read(r, &v); err != nil {
-			return err
-		}
-		p.val = v
+		err := util.Read(r, &v)
+		return v, err
 	case reflect.Float32:
 		var v float32
-		if err := util.Read(r, &v); err != nil {
-			return err
-		}
-		p.val = v
+		err := util.Read(r, &v)
+		return v, err
 	case reflect.Float64:
 		var v float64
-		if err := util.Read(r, &v); err != nil {
-			return err
-		}
-		p.val = v
+		err := util.Read(r, &v)
+		return v, err
 	case reflect.String:
 		var v string
-		if err := util.Read(r, &v); err != nil {
-			return err
-		}
-		p.val = v
+		err := util.Read(r, &v)
+		return v, err
 	default:
-		return fmt.Errorf("unsupported plain type [%v]", kind)
+		return nil, fmt.Errorf("unsupported plain type [%v]", kind)
 	}
-	return nil
 }
@@ -8,7 +8,7 @@ import (
 )
 
 func TestPlain_Marshal_String(t *testing.T) {
-	p := New("marshal is success")
+	p := New([]byte("marshal is success"))
 
 	// marshal
 	buf := new(bytes.Buffer)
@@ -19,11 +19,16 @@ func TestPlain_Marshal_String(t *testing.T) {
 	newP := New(nil)
 	r := bytes.NewReader(buf.Bytes())
 	err = newP.Unmarshal(r)
-	assert.Equal(t, p.Val(), newP.Val())
+	assert.Nil(t, err)
+	assert.Equal(t, p.Bytes(), newP.Bytes())
+	assert.Equal(t, EncEmbStr, newP.Encoding())
 }
 
 func TestPlain_Marshal_Int64(t *testing.T) {
-	p := New(int64(23))
+	p := New([]byte("-23"))
+	n, ok := p.Int()
+	assert.True(t, ok)
+	assert.Equal(t, int64(-23), n)
 
 	// marshal
 	buf := new(bytes.Buffer)
@@ -34,20 +39,47 @@ func TestPlain_Marshal_Int64(t *testing.T) {
 	newP := New(nil)
 	r := bytes.NewReader(buf.Bytes())
 	err = newP.Unmarshal(r)
-	assert.Equal(t, p.Val(), newP.Val())
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("-23"), newP.Bytes())
+	assert.Equal(t, EncInt, newP.Encoding())
 }
 
-func TestPlain_Marshal_Float64(t *testing.T) {
-	p := New(float64(23.2333))
+func TestPlain_BinarySafe(t *testing.T) {
+	// 不是规范形式的整数, 不能 int 编码
+	for _, s := range []string{"007", "+1", "-0", " 1", "1.50", "9223372036854775808", "", "\x00\xff\r\n"} {
+		p := New([]byte(s))
+		_, ok := p.Int()
+		assert.False(t, ok, s)
+		assert.Equal(t, len(s), p.Len())
 
-	// marshal
-	buf := new(bytes.Buffer)
-	err := p.Marshal(buf)
-	assert.Nil(t, err)
+		buf := new(bytes.Buffer)
+		assert.Nil(t, p.Marshal(buf))
+		newP := New(nil)
+		assert.Nil(t, newP.Unmarshal(buf))
+		assert.Equal(t, []byte(s), newP.Bytes(), s)
+	}
 
-	// unmarshal
-	newP := New(nil)
-	r := bytes.NewReader(buf.Bytes())
-	err = newP.Unmarshal(r)
-	assert.Equal(t, p.Val(), newP.Val())
+	assert.Equal(t, []byte{}, New(nil).Bytes())
+	assert.Equal(t, EncRaw, New(bytes.Repeat([]byte("a"), 45)).Encoding())
+}
+
+func TestPlain_Unmarshal_Old(t *testing.T) {
+	// 老版本按 go 的类型存储
+	cases := []struct {
+		val interface{}
+		s   string
+	}{
+		{int8(12), "12"},
+		{int64(-5), "-5"},
+		{float64(23.2333), "23.2333"},
+		{float64(1e21), "1000000000000000000000"},
+		{"old", "old"},
+	}
+	for _, c := range cases {
+		buf := new(bytes.Buffer)
+		assert.Nil(t, WriteVal(buf, c.val))
+		p := New(nil)
+		assert.Nil(t, p.Unmarshal(buf))
+		assert.Equal(t, c.s, p.String())
+	}
 }
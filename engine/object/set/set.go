@@ -109,9 +109,7 @@ func (s *Set) Marshal(w io.Writer) error {
 	}
 
 	for val := range s.m {
-		// use Plain to marshal
-		p := plain.New(val)
-		if err := p.Marshal(w); err != nil {
+		if err := plain.WriteVal(w, val); err != nil {
 			return err
 		}
 	}
@@ -125,11 +123,11 @@ func (s *Set) Unmarshal(r io.Reader) error {
 	}
 
 	for i := 0; i < int(total); i++ {
-		p := plain.New(nil)
-		if err := p.Unmarshal(r); err != nil {
+		val, err := plain.ReadVal(r)
+		if err != nil {
 			return err
 		}
-		s.m[p.Val()] = true
+		s.m[val] = true
 	}
 	return nil
 }
@@ -0,0 +1,439 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/clovers4/gres/proto"
+)
+
+const (
+	// DefaultReplicaBufferLimit is the default max bytes of the commands buffered for
+	// a replica which reads slowly, like the client-output-buffer-limit of redis.
+	DefaultReplicaBufferLimit = 256 * MB
+	// DefaultReplBacklogSize is the default bytes of the backlog, which a replica can
+	// continue from after a disconnection.
+	DefaultReplBacklogSize = 1 * MB
+)
+
+var (
+	ErrReplicaBufferLimit = errors.New("the buffer of the replica reaches its limit")
+	ErrReplicaClosed      = errors.New("the stream of the replica is closed")
+	ErrNeedFullSync       = errors.New("the backlog does not cover the offset of the replica")
+)
+
+// newReplID returns a random replication id of 40 hex characters, like redis.
+func newReplID() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// replication streams the write commands to the replicas, in the format of the
+// append-only file. offset is the number of bytes streamed, which is the position
+// of the replicas in the stream. id names the stream, the offsets of a replica are
+// meaningful only with the same id.
+type replication struct {
+	cmdStream
+	id          string
+	buf         bytes.Buffer
+	offset      int64
+	backlog     *backlog // 第一个 replica 连接时创建, 之后一直保留
+	backlogSize int
+	replicas    map[*ReplicaStream]struct{}
+	active      int32 // backlog 已创建; 之前没有 replica, 不需要编码命令
+
+	mu sync.Mutex
+}
+
+func newReplication() *replication {
+	r := &replication{
+		id:          newReplID(),
+		backlogSize: DefaultReplBacklogSize,
+		replicas:    make(map[*ReplicaStream]struct{}),
+	}
+	r.wr = proto.NewWriter(&r.buf)
+	r.selected = -1
+	return r
+}
+
+// streaming reports whether the write commands are streamed, which starts once
+// the first replica is attached.
+func (r *replication) streaming() bool {
+	return atomic.LoadInt32(&r.active) != 0
+}
+
+func (r *replication) feed(index int, vals []interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.backlog == nil {
+		return nil
+	}
+	flush, err := r.write(index, vals)
+	if err != nil || !flush {
+		return err
+	}
+	return r.flush()
+}
+
+// flush hands the encoded commands to the backlog and every replica.
+func (r *replication) flush() error {
+	if err := r.wr.Flush(); err != nil {
+		return err
+	}
+	b := r.buf.Bytes()
+	r.backlog.write(b)
+	for s := range r.replicas {
+		if !s.push(b) {
+			delete(r.replicas, s)
+		}
+	}
+	r.offset += int64(len(b))
+	r.buf.Reset()
+	return nil
+}
+
+func (r *replication) beginTx() {
+	r.mu.Lock()
+	r.cmdStream.beginTx()
+	r.mu.Unlock()
+}
+
+func (r *replication) endTx() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ended, err := r.cmdStream.endTx()
+	if err != nil || !ended {
+		return err
+	}
+	return r.flush()
+}
+
+// attach starts a stream at the current offset. The caller must hold the cmdLock,
+// so it never starts in the middle of a command or a transaction.
+func (r *replication) attach(limit int) *ReplicaStream {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.backlog == nil {
+		r.backlog = newBacklog(r.backlogSize)
+		atomic.StoreInt32(&r.active, 1)
+	}
+	// 新的 replica 不知道上一条命令所在的 db
+	r.selected = -1
+	return r.newStream(nil, r.offset, limit)
+}
+
+// resume starts a stream at offset with the commands in the backlog after it,
+// if the stream of id still covers it.
+func (r *replication) resume(id string, offset int64, limit int) (*ReplicaStream, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.backlog == nil || id != r.id || offset > r.offset || r.offset-offset > int64(r.backlog.histlen) {
+		return nil, ErrNeedFullSync
+	}
+	return r.newStream(r.backlog.last(int(r.offset-offset)), offset, limit), nil
+}
+
+func (r *replication) newStream(buf []byte, offset int64, limit int) *ReplicaStream {
+	s := &ReplicaStream{
+		repl:   r,
+		buf:    buf,
+		limit:  limit,
+		offset: offset,
+	}
+	s.cond = sync.NewCond(&s.mu)
+	r.replicas[s] = struct{}{}
+	return s
+}
+
+// reset starts a new stream after the dataset is replaced, which the replicas
+// must sync fully again.
+func (r *replication) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.id = newReplID()
+	if r.backlog != nil {
+		r.backlog = newBacklog(r.backlogSize)
+	}
+	r.selected = -1
+	for s := range r.replicas {
+		s.fail(ErrReplicaClosed)
+		delete(r.replicas, s)
+	}
+}
+
+// backlog keeps the latest bytes of the stream in a circular buffer.
+type backlog struct {
+	buf     []byte
+	next    int // 下一个字节写入的位置
+	histlen int // 保存的字节数, 不超过 len(buf)
+}
+
+func newBacklog(size int) *backlog {
+	return &backlog{buf: make([]byte, size)}
+}
+
+func (b *backlog) write(p []byte) {
+	if len(p) > len(b.buf) {
+		p = p[len(p)-len(b.buf):]
+	}
+	n := copy(b.buf[b.next:], p)
+	copy(b.buf, p[n:])
+	b.next = (b.next + len(p)) % len(b.buf)
+	b.histlen += len(p)
+	if b.histlen > len(b.buf) {
+		b.histlen = len(b.buf)
+	}
+}
+
+// last returns a copy of the last n bytes, n must not exceed histlen.
+func (b *backlog) last(n int) []byte {
+	p := make([]byte, n)
+	start := (b.next - n + len(b.buf)) % len(b.buf)
+	m := copy(p, b.buf[start:])
+	copy(p[m:], b.buf)
+	return p
+}
+
+// ReplicaStream is the write commands streamed to one replica. The commands are
+// buffered until the replica reads them, and the stream fails once the buffer
+// exceeds its limit, so a slow replica never blocks the commands.
+type ReplicaStream struct {
+	repl   *replication
+	buf    []byte
+	limit  int
+	offset int64 // 已读取的命令在复制流中的位置
+	err    error
+
+	mu   sync.Mutex
+	cond *sync.Cond
+}
+
+// push appends b to the buffer, and reports false if the stream fails.
+func (s *ReplicaStream) push(b []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return false
+	}
+	if len(s.buf)+len(b) > s.limit {
+		s.failLocked(ErrReplicaBufferLimit)
+		return false
+	}
+	s.buf = append(s.buf, b...)
+	s.cond.Broadcast()
+	return true
+}
+
+// fail stops the stream with err, and wakes up Read.
+func (s *ReplicaStream) fail(err error) {
+	s.mu.Lock()
+	s.failLocked(err)
+	s.mu.Unlock()
+}
+
+func (s *ReplicaStream) failLocked(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+	s.buf = nil
+	s.cond.Broadcast()
+}
+
+// Read blocks until there are commands in the buffer, and returns all of them. It
+// fails once the stream is closed, or the buffer exceeded its limit.
+func (s *ReplicaStream) Read() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.buf) == 0 && s.err == nil {
+		s.cond.Wait()
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	b := s.buf
+	s.buf = nil
+	s.offset += int64(len(b))
+	return b, nil
+}
+
+// Offset returns the position in the replication stream of the commands read,
+// which is the offset of the snapshot, or the offset resumed from, before any Read.
+func (s *ReplicaStream) Offset() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.offset
+}
+
+// Close detaches the stream, and wakes up Read.
+func (s *ReplicaStream) Close() {
+	s.repl.mu.Lock()
+	delete(s.repl.replicas, s)
+	s.repl.mu.Unlock()
+	s.fail(ErrReplicaClosed)
+}
+
+// SyncReplica writes a snapshot of all dbs to w in the format of the snapshot file,
+// and returns the stream of the write commands after the snapshot. limit is the max
+// bytes buffered in the stream. The caller must close the stream.
+func (db *DB) SyncReplica(w io.Writer, limit int) (*ReplicaStream, error) {
+	root := db.root
+	var stream *ReplicaStream
+	err := root.saveTo(w, func() {
+		stream = root.repl.attach(limit)
+	})
+	if err != nil {
+		if stream != nil {
+			stream.Close()
+		}
+		return nil, err
+	}
+	return stream, nil
+}
+
+// ResumeReplica returns the stream of the write commands after offset, which
+// continues the stream of id that a replica was disconnected from. It returns
+// ErrNeedFullSync if the backlog no longer covers offset.
+func (db *DB) ResumeReplica(id string, offset int64, limit int) (*ReplicaStream, error) {
+	return db.root.repl.resume(id, offset, limit)
+}
+
+// ReplOffset returns the number of bytes streamed to the replicas.
+func (db *DB) ReplOffset() int64 {
+	root := db.root
+	root.repl.mu.Lock()
+	defer root.repl.mu.Unlock()
+	return root.repl.offset
+}
+
+// ReplInfo is the state of the replication stream.
+type ReplInfo struct {
+	ID                     string // 复制 id
+	Offset                 int64  // 已传播的字节数
+	BacklogActive          bool
+	BacklogSize            int
+	BacklogFirstByteOffset int64 // backlog 中第一个字节的位置
+	BacklogHistlen         int   // backlog 中的字节数
+}
+
+// ReplInfo returns the state of the replication stream.
+func (db *DB) ReplInfo() ReplInfo {
+	r := db.root.repl
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	info := ReplInfo{
+		ID:          r.id,
+		Offset:      r.offset,
+		BacklogSize: r.backlogSize,
+	}
+	if r.backlog != nil {
+		info.BacklogActive = true
+		info.BacklogHistlen = r.backlog.histlen
+		info.BacklogFirstByteOffset = r.offset - int64(r.backlog.histlen) + 1
+	}
+	return info
+}
+
+// SetReplBacklogSize changes the bytes of the backlog. The commands in the backlog
+// are dropped, like redis does.
+func (db *DB) SetReplBacklogSize(bytes int) error {
+	if bytes <= 0 {
+		return fmt.Errorf("invalid backlog size: %v", bytes)
+	}
+
+	r := db.root.repl
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.backlogSize = bytes
+	if r.backlog != nil {
+		r.backlog = newBacklog(bytes)
+	}
+	return nil
+}
+
+// ReplicaApplier applies the write commands streamed from the primary, which are
+// in the format of the append-only file. The commands between multi and exec are
+// applied atomically at exec.
+type ReplicaApplier struct {
+	db      *DB // 当前命令所在的 db
+	inTx    bool
+	pending [][]string
+}
+
+// NewReplicaApplier returns an applier which starts at the db numbered 0.
+func (db *DB) NewReplicaApplier() *ReplicaApplier {
+	return &ReplicaApplier{db: db.root}
+}
+
+// Apply applies one command of the stream. The error of a command is returned
+// after it is applied, and the following commands can still be applied.
+func (a *ReplicaApplier) Apply(args []string) error {
+	if replayFunc == nil {
+		return errors.New("no replay func registered")
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "multi":
+		a.inTx = true
+		a.pending = a.pending[:0]
+		return nil
+	case "exec":
+		var err error
+		a.inTx = false
+		a.db.Exclusive(func() {
+			for _, args := range a.pending {
+				if e := a.apply(args); e != nil && err == nil {
+					err = e
+				}
+			}
+			a.db.ServeBlocked()
+		})
+		a.pending = a.pending[:0]
+		return err
+	}
+	if a.inTx {
+		a.pending = append(a.pending, args)
+		return nil
+	}
+
+	var err error
+	a.db.Shared(func() {
+		err = a.apply(args)
+		a.db.ServeBlocked()
+	})
+	return err
+}
+
+func (a *ReplicaApplier) apply(args []string) error {
+	if strings.ToLower(args[0]) == "select" && len(args) == 2 {
+		index, err := strconv.Atoi(args[1])
+		if err != nil {
+			return err
+		}
+		db, err := a.db.Select(index)
+		if err != nil {
+			return err
+		}
+		a.db = db
+		return nil
+	}
+	return replayFunc(a.db, args)
+}
@@ -0,0 +1,175 @@
+package engine
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/clovers4/gres/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_SyncReplica(t *testing.T) {
+	RegisterReplayFunc(testReplay)
+	primary := NewDB(DbnumOption(2))
+	db1, _ := primary.Select(1)
+	assert.Nil(t, primary.Set("a", []byte("A")))
+	assert.Nil(t, db1.Set("b", []byte("B")))
+	assert.True(t, db1.Expire("b", 100))
+
+	var snapshot bytes.Buffer
+	stream, err := primary.SyncReplica(&snapshot, DefaultReplicaBufferLimit)
+	assert.Nil(t, err)
+	defer stream.Close()
+	assert.Equal(t, int64(0), stream.Offset())
+
+	// 快照之后的写命令
+	propagate := func(db *DB, args ...string) {
+		db.Propagate(args, func() bool {
+			return testReplay(db, args) == nil
+		})
+	}
+	propagate(db1, "set", "c", "C")
+	primary.Exclusive(func() {
+		propagate(primary, "set", "d", "D")
+		propagate(primary, "del", "a")
+	})
+
+	replica := NewDB(DbnumOption(2))
+	assert.Nil(t, replica.Set("stale", []byte("S")))
+	assert.Nil(t, replica.Load(&snapshot))
+	assert.False(t, replica.Exists("stale"))
+	assert.True(t, replica.Exists("a"))
+	replica1, _ := replica.Select(1)
+	ttl := replica1.Ttl("b")
+	assert.True(t, ttl > 0 && ttl <= 100)
+
+	data, err := stream.Read()
+	assert.Nil(t, err)
+	assert.Equal(t, primary.ReplOffset(), stream.Offset())
+	assert.Equal(t, int64(len(data)), stream.Offset())
+
+	applier := replica.NewReplicaApplier()
+	rd := proto.NewReader(bytes.NewReader(data))
+	for {
+		args, err := rd.ReadCommand()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		assert.Nil(t, applier.Apply(args))
+	}
+	val, _ := replica1.Get("c")
+	assert.Equal(t, []byte("C"), val)
+	val, _ = replica.Get("d")
+	assert.Equal(t, []byte("D"), val)
+	assert.False(t, replica.Exists("a"))
+}
+
+func TestReplicaStream_Limit(t *testing.T) {
+	RegisterReplayFunc(testReplay)
+	db := NewDB()
+	assert.False(t, db.repl.streaming())
+
+	var snapshot bytes.Buffer
+	stream, err := db.SyncReplica(&snapshot, 16)
+	assert.Nil(t, err)
+	assert.True(t, db.repl.streaming())
+
+	args := []string{"set", "key", "a value longer than the limit"}
+	db.Propagate(args, func() bool {
+		return testReplay(db, args) == nil
+	})
+	_, err = stream.Read()
+	assert.Equal(t, ErrReplicaBufferLimit, err)
+	assert.Equal(t, 0, len(db.repl.replicas))
+	// 没有 replica 时, 命令仍然写入 backlog
+	assert.True(t, db.repl.streaming())
+
+	stream.Close()
+	_, err = stream.Read()
+	assert.Equal(t, ErrReplicaBufferLimit, err)
+}
+
+func TestDB_ResumeReplica(t *testing.T) {
+	RegisterReplayFunc(testReplay)
+	db := NewDB(DbnumOption(2), ReplBacklogSizeOption(128))
+	propagate := func(db *DB, args ...string) {
+		db.Propagate(args, func() bool {
+			return testReplay(db, args) == nil
+		})
+	}
+	id := db.ReplInfo().ID
+	_, err := db.ResumeReplica(id, 0, DefaultReplicaBufferLimit)
+	assert.Equal(t, ErrNeedFullSync, err)
+
+	var snapshot bytes.Buffer
+	stream, err := db.SyncReplica(&snapshot, DefaultReplicaBufferLimit)
+	assert.Nil(t, err)
+	db1, _ := db.Select(1)
+	propagate(db1, "set", "a", "A")
+	first, err := stream.Read()
+	assert.Nil(t, err)
+	stream.Close()
+
+	// 断开期间的命令从 backlog 中读取
+	propagate(db1, "set", "b", "B")
+	resumed, err := db.ResumeReplica(id, stream.Offset(), DefaultReplicaBufferLimit)
+	assert.Nil(t, err)
+	propagate(db1, "set", "c", "C")
+	data, err := resumed.Read()
+	assert.Nil(t, err)
+	assert.Equal(t, db.ReplOffset(), resumed.Offset())
+	resumed.Close()
+
+	replica := NewDB(DbnumOption(2))
+	applier := replica.NewReplicaApplier()
+	rd := proto.NewReader(bytes.NewReader(append(first, data...)))
+	for {
+		args, err := rd.ReadCommand()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		assert.Nil(t, applier.Apply(args))
+	}
+	replica1, _ := replica.Select(1)
+	assert.True(t, replica1.Exists("a") && replica1.Exists("b") && replica1.Exists("c"))
+
+	_, err = db.ResumeReplica("other", stream.Offset(), DefaultReplicaBufferLimit)
+	assert.Equal(t, ErrNeedFullSync, err)
+	_, err = db.ResumeReplica(id, db.ReplOffset()+1, DefaultReplicaBufferLimit)
+	assert.Equal(t, ErrNeedFullSync, err)
+
+	// backlog 已被覆盖
+	for i := 0; i < 10; i++ {
+		propagate(db1, "set", "key", "a value to fill the backlog")
+	}
+	info := db.ReplInfo()
+	assert.True(t, info.BacklogActive)
+	assert.Equal(t, 128, info.BacklogHistlen)
+	assert.Equal(t, info.Offset-127, info.BacklogFirstByteOffset)
+	_, err = db.ResumeReplica(id, stream.Offset(), DefaultReplicaBufferLimit)
+	assert.Equal(t, ErrNeedFullSync, err)
+	resumed, err = db.ResumeReplica(id, info.Offset-128, DefaultReplicaBufferLimit)
+	assert.Nil(t, err)
+	resumed.Close()
+
+	// 加载快照后, 复制流重新开始
+	assert.Nil(t, db.Load(&snapshot))
+	assert.NotEqual(t, id, db.ReplInfo().ID)
+	assert.Equal(t, 0, db.ReplInfo().BacklogHistlen)
+}
+
+func TestBacklog(t *testing.T) {
+	b := newBacklog(4)
+	b.write([]byte("ab"))
+	assert.Equal(t, []byte("ab"), b.last(2))
+	b.write([]byte("cde"))
+	assert.Equal(t, 4, b.histlen)
+	assert.Equal(t, []byte("bcde"), b.last(4))
+	assert.Equal(t, []byte("de"), b.last(2))
+	b.write([]byte("fghijk"))
+	assert.Equal(t, []byte("hijk"), b.last(4))
+	assert.Equal(t, []byte{}, b.last(0))
+}
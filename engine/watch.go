@@ -0,0 +1,85 @@
+package engine
+
+import (
+	"sync/atomic"
+)
+
+// watchedKey 记录被 WATCH 的 key 的版本号, 每次修改时加 1.
+// 只有被 watch 的 key 才会记录, 没有 watch 时修改 key 几乎没有额外开销.
+type watchedKey struct {
+	version uint64
+	refs    int // watch 该 key 的连接个数
+}
+
+// Watch starts tracking the version of key, and returns the current version.
+// Every Watch must be paired with an Unwatch.
+func (db *DB) Watch(key string) uint64 {
+	db.watchLock.Lock()
+	defer db.watchLock.Unlock()
+
+	if db.watchedKeys == nil {
+		db.watchedKeys = make(map[string]*watchedKey)
+	}
+	w, ok := db.watchedKeys[key]
+	if !ok {
+		w = &watchedKey{}
+		db.watchedKeys[key] = w
+		atomic.AddInt32(&db.watching, 1)
+	}
+	w.refs++
+	return w.version
+}
+
+// Unwatch stops tracking key for one watcher.
+func (db *DB) Unwatch(key string) {
+	db.watchLock.Lock()
+	defer db.watchLock.Unlock()
+
+	w, ok := db.watchedKeys[key]
+	if !ok {
+		return
+	}
+	w.refs--
+	if w.refs <= 0 {
+		delete(db.watchedKeys, key)
+		atomic.AddInt32(&db.watching, -1)
+	}
+}
+
+// WatchedVersion returns the version of a watched key.
+func (db *DB) WatchedVersion(key string) uint64 {
+	db.watchLock.Lock()
+	defer db.watchLock.Unlock()
+
+	if w, ok := db.watchedKeys[key]; ok {
+		return w.version
+	}
+	return 0
+}
+
+// touch marks key as modified. It is called by every write of dataMap and
+// dirtyDataMap, so a change during Save is seen as well.
+func (db *DB) touch(key string) {
+	if atomic.LoadInt32(&db.watching) == 0 {
+		return
+	}
+
+	db.watchLock.Lock()
+	if w, ok := db.watchedKeys[key]; ok {
+		w.version++
+	}
+	db.watchLock.Unlock()
+}
+
+// touchAll marks all keys of db as modified, such as by FLUSHDB.
+func (db *DB) touchAll() {
+	if atomic.LoadInt32(&db.watching) == 0 {
+		return
+	}
+
+	db.watchLock.Lock()
+	for _, w := range db.watchedKeys {
+		w.version++
+	}
+	db.watchLock.Unlock()
+}
@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/clovers4/gres/engine/cmap"
+	"github.com/clovers4/gres/zset"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Watch(t *testing.T) {
+	db := NewDB()
+	db.Set("A", []byte("A"))
+
+	v := db.Watch("A")
+	db.Get("A")
+	assert.Equal(t, v, db.WatchedVersion("A"))
+
+	db.Set("A", []byte("B"))
+	assert.NotEqual(t, v, db.WatchedVersion("A"))
+
+	v = db.WatchedVersion("A")
+	db.Del("B")
+	assert.Equal(t, v, db.WatchedVersion("A"))
+	db.Expire("A", 100)
+	assert.NotEqual(t, v, db.WatchedVersion("A"))
+
+	v = db.WatchedVersion("A")
+	db.FlushDB()
+	assert.NotEqual(t, v, db.WatchedVersion("A"))
+
+	db.Unwatch("A")
+	assert.Equal(t, int32(0), db.watching)
+}
+
+func TestDB_WatchNoChange(t *testing.T) {
+	db := NewDB()
+	db.Set("A", []byte("A"))
+	db.RPush("L", "A")
+	db.SAdd("S", "A")
+	v := db.Watch("A")
+	vl := db.Watch("L")
+	vs := db.Watch("S")
+
+	// 类型错误或者没有修改的写命令不会改变 key
+	_, err := db.LPush("A", "B")
+	assert.Equal(t, ErrWrongTypeOps, err)
+	_, err = db.HSet("A", "f", "B")
+	assert.Equal(t, ErrWrongTypeOps, err)
+	_, err = db.Append("L", []byte("B"))
+	assert.Equal(t, ErrWrongTypeOps, err)
+	db.SetRange("A", 0, nil)
+	db.SAdd("S", "A")
+	db.SRem("S", "B")
+	db.ZRem("Z", "A")
+	assert.Equal(t, v, db.WatchedVersion("A"))
+	assert.Equal(t, vl, db.WatchedVersion("L"))
+	assert.Equal(t, vs, db.WatchedVersion("S"))
+
+	db.SAdd("S", "B")
+	assert.NotEqual(t, vs, db.WatchedVersion("S"))
+	db.LPop("L")
+	assert.NotEqual(t, vl, db.WatchedVersion("L"))
+}
+
+func TestDB_WatchOnSave(t *testing.T) {
+	db := NewDB()
+	db.RPush("L", "A")
+	v := db.Watch("L")
+
+	db.dirtyLock.Lock()
+	db.onSave = true
+	db.dirtyDataMap = cmap.New()
+	db.dirtyExpireList = zset.New()
+	db.dirtyLock.Unlock()
+
+	// 持久化中的修改写入 dirtyDataMap, 同样会被发现
+	db.RPush("L", "B")
+	assert.NotEqual(t, v, db.WatchedVersion("L"))
+
+	v = db.WatchedVersion("L")
+	db.Del("L")
+	assert.NotEqual(t, v, db.WatchedVersion("L"))
+	v = db.WatchedVersion("L")
+	db.Del("L")
+	assert.Equal(t, v, db.WatchedVersion("L"))
+}
@@ -66,6 +66,22 @@ func (cn *Conn) WithWriter(ctx context.Context, timeout time.Duration, fn func(w
 	return cn.wr.Flush()
 }
 
+// Peek blocks until there is data to read, without consuming it. It returns an
+// error if the connection is closed, or the read deadline is reached.
+func (cn *Conn) Peek() error {
+	_, err := cn.rd.Peek(1)
+	return err
+}
+
+func (cn *Conn) SetReadDeadline(t time.Time) error {
+	return cn.netConn.SetReadDeadline(t)
+}
+
+// SetProto sets the version of the protocol used to write replies.
+func (cn *Conn) SetProto(protover int) {
+	cn.wr.SetProto(protover)
+}
+
 func (cn *Conn) Close() error {
 	return cn.netConn.Close()
 }
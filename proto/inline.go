@@ -0,0 +1,113 @@
+package proto
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrUnbalancedQuotes = errors.New("unbalanced quotes")
+
+// SplitArgs splits an inline command into args like redis does. An arg can be
+// quoted by double quotes with escapes such as \n and \x41, or by single
+// quotes with only \' escaped.
+func SplitArgs(line string) ([]string, error) {
+	var args []string
+	i := 0
+	for {
+		// 跳过空白
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		var arg strings.Builder
+		inQuotes, inSingleQuotes := false, false
+		for done := false; !done; {
+			if i == len(line) {
+				if inQuotes || inSingleQuotes {
+					return nil, ErrUnbalancedQuotes
+				}
+				break
+			}
+
+			c := line[i]
+			switch {
+			case inQuotes:
+				if c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]) {
+					arg.WriteByte(unhex(line[i+2])<<4 | unhex(line[i+3]))
+					i += 3
+				} else if c == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						arg.WriteByte('\n')
+					case 'r':
+						arg.WriteByte('\r')
+					case 't':
+						arg.WriteByte('\t')
+					case 'b':
+						arg.WriteByte('\b')
+					case 'a':
+						arg.WriteByte('\a')
+					default:
+						arg.WriteByte(line[i])
+					}
+				} else if c == '"' {
+					// 引号后必须是空白或结尾
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, ErrUnbalancedQuotes
+					}
+					done = true
+				} else {
+					arg.WriteByte(c)
+				}
+			case inSingleQuotes:
+				if c == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					arg.WriteByte('\'')
+					i++
+				} else if c == '\'' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, ErrUnbalancedQuotes
+					}
+					done = true
+				} else {
+					arg.WriteByte(c)
+				}
+			default:
+				switch {
+				case isSpace(c):
+					done = true
+				case c == '"':
+					inQuotes = true
+				case c == '\'':
+					inSingleQuotes = true
+				default:
+					arg.WriteByte(c)
+				}
+			}
+			i++
+		}
+		args = append(args, arg.String())
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
@@ -4,12 +4,18 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"math"
 
 	"github.com/clovers4/gres/util"
 )
 
 const Nil = RedisError("redis: nil")
 
+const (
+	maxBulkLen   = 512 * 1024 * 1024 // 与 redis 的 proto-max-bulk-len 相同
+	maxInlineLen = 64 * 1024         // inline 命令的最大长度
+)
+
 type ArraysHook func(*Reader, int64) (interface{}, error)
 
 type Reader struct {
@@ -22,7 +28,15 @@ func NewReader(rd io.Reader) *Reader {
 	}
 }
 
-// if err==nil, interface{} will be string/int/[]string
+// ProtocolError means the input is not valid RESP, and the connection should be closed.
+type ProtocolError string
+
+func (e ProtocolError) Error() string { return "Protocol error: " + string(e) }
+
+// ReadReply reads a RESP2 or RESP3 reply. If err==nil, interface{} will be
+// string (status, bulk and verbatim string), int64, float64, bool, RedisError,
+// nil (null bulk string, null array and null) or []interface{} whose elements are
+// any of them. The keys and values of a map are alternated in []interface{}.
 func (r *Reader) ReadReply() (interface{}, error) {
 	line, err := r.ReadLine()
 	if err != nil {
@@ -37,34 +51,150 @@ func (r *Reader) ReadReply() (interface{}, error) {
 	case IntReply:
 		return util.ParseInt(line[1:], 10, 64)
 	case BulkStringReply:
+		if isNilReply(line) {
+			return nil, nil
+		}
 		return r.readBulkStringReply(line)
 	case ArraysReply:
+		if isNilReply(line) {
+			return nil, nil
+		}
 		n, err := parseArrayLen(line)
 		if err != nil {
 			return nil, err
 		}
-		vals := make([]string, n, n)
-		for i := 0; i < int(n); i++ {
-			v, err := r.ReadReply()
-			if err != nil {
-				return nil, err
-			}
-			vals[i] = v.(string)
+		return r.readAggregate(n)
+	case SetReply, PushReply:
+		n, err := parseArrayLen(line)
+		if err != nil {
+			return nil, err
 		}
-		return vals, nil
+		return r.readAggregate(n)
+	case MapReply:
+		n, err := parseArrayLen(line)
+		if err != nil {
+			return nil, err
+		}
+		return r.readAggregate(2 * n)
+	case NullReply:
+		return nil, nil
+	case BoolReply:
+		if len(line) != 2 || line[1] != 't' && line[1] != 'f' {
+			return nil, fmt.Errorf("redis: invalid bool %.100q", line)
+		}
+		return line[1] == 't', nil
+	case DoubleReply:
+		switch s := string(line[1:]); s {
+		case "inf":
+			return math.Inf(1), nil
+		case "-inf":
+			return math.Inf(-1), nil
+		default:
+			return util.ParseFloat(line[1:], 64)
+		}
+	case VerbatimReply:
+		s, err := r.readBulkStringReply(line)
+		if err != nil {
+			return nil, err
+		}
+		if len(s) < 4 {
+			return nil, fmt.Errorf("redis: invalid verbatim string %.100q", s)
+		}
+		return s[4:], nil // 去掉 txt: 等格式前缀
 	}
 	return nil, fmt.Errorf("redis: type is incorrect %.100q", line)
 }
 
-func (r *Reader) ReadLine() ([]byte, error) {
+func (r *Reader) readAggregate(n int64) ([]interface{}, error) {
+	vals := make([]interface{}, 0, preallocLen(n))
+	for i := int64(0); i < n; i++ {
+		v, err := r.ReadReply()
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, v)
+	}
+	return vals, nil
+}
+
+// ReadCommand reads a command sent by a client, which is an array of bulk strings,
+// or an inline command such as typed in telnet. An empty inline command returns
+// no args, which should be skipped.
+func (r *Reader) ReadCommand() ([]string, error) {
+	b, err := r.rd.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if b[0] != ArraysReply {
+		return r.readInlineCommand()
+	}
+
 	line, err := r.readLine()
 	if err != nil {
 		return nil, err
 	}
 	if isNilReply(line) {
-		return nil, Nil
+		return nil, nil
+	}
+	n, err := parseArrayLen(line)
+	if err != nil {
+		return nil, ProtocolError("invalid multibulk length")
+	}
+
+	args := make([]string, 0, preallocLen(n))
+	for i := int64(0); i < n; i++ {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line[0] != BulkStringReply {
+			return nil, ProtocolError(fmt.Sprintf("expected '$', got '%c'", line[0]))
+		}
+		arg, err := r.readBulkStringReply(line)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
 	}
-	return line, nil
+	return args, nil
+}
+
+func (r *Reader) readInlineCommand() ([]string, error) {
+	var buf []byte
+	for {
+		b, err := r.rd.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			buf = append(buf, b...)
+			if len(buf) > maxInlineLen {
+				return nil, ProtocolError("too big inline request")
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, b...)
+		break
+	}
+	if len(buf) > maxInlineLen {
+		return nil, ProtocolError("too big inline request")
+	}
+
+	// telnet 发送 \r\n, nc 等可能只发送 \n
+	buf = buf[:len(buf)-1]
+	if len(buf) > 0 && buf[len(buf)-1] == '\r' {
+		buf = buf[:len(buf)-1]
+	}
+
+	args, err := SplitArgs(string(buf))
+	if err != nil {
+		return nil, ProtocolError("unbalanced quotes in request")
+	}
+	return args, nil
+}
+
+func (r *Reader) ReadLine() ([]byte, error) {
+	return r.readLine()
 }
 
 // readLine that returns an error if:
@@ -76,20 +206,16 @@ func (r *Reader) readLine() ([]byte, error) {
 		return nil, err
 	}
 	if len(b) <= 2 || b[len(b)-1] != '\n' || b[len(b)-2] != '\r' {
-		return nil, fmt.Errorf("redis: invalid reply: %v", b)
+		return nil, ProtocolError(fmt.Sprintf("invalid line %.100q", b))
 	}
 	b = b[:len(b)-2]
 	return b, nil
 }
 
 func (r *Reader) readBulkStringReply(line []byte) (string, error) {
-	if isNilReply(line) {
-		return Nil.Error(), nil
-	}
-
 	replyLen, err := util.Atoi(line[1:])
-	if err != nil {
-		return "", err
+	if err != nil || replyLen < 0 || replyLen > maxBulkLen {
+		return "", ProtocolError("invalid bulk length")
 	}
 
 	b := make([]byte, replyLen+2)
@@ -97,10 +223,35 @@ func (r *Reader) readBulkStringReply(line []byte) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if b[replyLen] != '\r' || b[replyLen+1] != '\n' {
+		return "", ProtocolError("bulk string does not end with CRLF")
+	}
 
 	return util.BytesToString(b[:replyLen]), nil
 }
 
+// ReadBulkStream reads the header of a bulk string, and returns the reader of its n
+// bytes payload, which must be consumed before the next read. Unlike ReadReply, the
+// payload is not held in memory and has no CRLF after it, like the snapshot sent by
+// the primary of replication.
+func (r *Reader) ReadBulkStream() (io.Reader, int64, error) {
+	line, err := r.ReadLine()
+	if err != nil {
+		return nil, 0, err
+	}
+	switch line[0] {
+	case ErrReply:
+		return nil, 0, parseErrorReply(line)
+	case BulkStringReply:
+		n, err := util.ParseInt(line[1:], 10, 64)
+		if err != nil || n < 0 {
+			return nil, 0, ProtocolError("invalid bulk length")
+		}
+		return io.LimitReader(r.rd, n), n, nil
+	}
+	return nil, 0, fmt.Errorf("redis: type is incorrect %.100q", line)
+}
+
 func (r *Reader) Buffered() int {
 	return r.rd.Buffered()
 }
@@ -120,10 +271,23 @@ func isNilReply(b []byte) bool {
 }
 
 func parseArrayLen(line []byte) (int64, error) {
-	if isNilReply(line) {
-		return 0, Nil
+	n, err := util.ParseInt(line[1:], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("redis: invalid array length %v", n)
+	}
+	return n, nil
+}
+
+// preallocLen limits the memory allocated before the elements are actually read,
+// since the length is sent by the peer.
+func preallocLen(n int64) int {
+	if n > 1024 {
+		return 1024
 	}
-	return util.ParseInt(line[1:], 10, 64)
+	return int(n)
 }
 
 type RedisError string
@@ -0,0 +1,104 @@
+package proto
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReader_ReadReply(t *testing.T) {
+	r := NewReader(strings.NewReader("*4\r\n:1\r\n$-1\r\n*2\r\n+OK\r\n-ERR bad\r\n*-1\r\n" +
+		"$-1\r\n*0\r\n$0\r\n\r\n"))
+
+	val, err := r.ReadReply()
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{int64(1), nil, []interface{}{"OK", RedisError("ERR bad")}, nil}, val)
+
+	val, err = r.ReadReply()
+	assert.Nil(t, err)
+	assert.Nil(t, val)
+
+	val, err = r.ReadReply()
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{}, val)
+
+	val, err = r.ReadReply()
+	assert.Nil(t, err)
+	assert.Equal(t, "", val)
+
+	_, err = r.ReadReply()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestReader_ReadCommand(t *testing.T) {
+	r := NewReader(strings.NewReader("*2\r\n$3\r\nget\r\n$3\r\na\r\n\r\n" +
+		"set k \"hello world\\n\" 'it\\'s'\r\n" +
+		"\r\n" +
+		"ping\n"))
+
+	args, err := r.ReadCommand()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"get", "a\r\n"}, args)
+
+	args, err = r.ReadCommand()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"set", "k", "hello world\n", "it's"}, args)
+
+	args, err = r.ReadCommand()
+	assert.Nil(t, err)
+	assert.Empty(t, args)
+
+	args, err = r.ReadCommand()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ping"}, args)
+}
+
+func TestReader_ReadCommand_ProtocolError(t *testing.T) {
+	for _, input := range []string{
+		"*1\r\n:1\r\n",
+		"*1\r\n$-2\r\n",
+		"*x\r\n",
+		"*1\r\n$3\r\nabcd\r\n",
+		"get \"a\r\n",
+	} {
+		_, err := NewReader(strings.NewReader(input)).ReadCommand()
+		_, ok := err.(ProtocolError)
+		assert.True(t, ok, input)
+	}
+
+	long := bytes.Repeat([]byte("a"), maxInlineLen+1)
+	_, err := NewReader(bytes.NewReader(append(long, '\n'))).ReadCommand()
+	assert.Equal(t, ProtocolError("too big inline request"), err)
+}
+
+func TestReader_ReadBulkStream(t *testing.T) {
+	r := NewReader(strings.NewReader("$5\r\nhello*1\r\n$4\r\nping\r\n-ERR no\r\n"))
+
+	payload, n, err := r.ReadBulkStream()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), n)
+	b, err := ioutil.ReadAll(payload)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	// 其后紧接着命令
+	args, err := r.ReadCommand()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ping"}, args)
+
+	_, _, err = r.ReadBulkStream()
+	assert.Equal(t, RedisError("ERR no"), err)
+}
+
+func TestSplitArgs(t *testing.T) {
+	args, err := SplitArgs(`  set  "\x41\x62c" d"e" ''`)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"set", "Abc", "de", ""}, args)
+
+	_, err = SplitArgs(`"a"b`)
+	assert.Equal(t, ErrUnbalancedQuotes, err)
+}
@@ -10,6 +10,15 @@ const (
 	ReplyKindInt
 	ReplyKindBlukString
 	ReplyKindArrays
+
+	// RESP3, 连接使用 RESP2 时被写为相近的类型
+	ReplyKindMap      // Val 为 []interface{}, key 与 value 交替排列
+	ReplyKindSet      // Val 为 []interface{}
+	ReplyKindDouble   // Val 为 float64, nil 为 null
+	ReplyKindNull     // Val 为 nil
+	ReplyKindBool     // Val 为 bool
+	ReplyKindPush     // Val 为 []interface{}
+	ReplyKindVerbatim // Val 为 string
 )
 
 type Reply struct {
@@ -43,6 +52,20 @@ func (r *Reply) String() string {
 		kind = "BlukString"
 	case ReplyKindArrays:
 		kind = "Arrays"
+	case ReplyKindMap:
+		kind = "Map"
+	case ReplyKindSet:
+		kind = "Set"
+	case ReplyKindDouble:
+		kind = "Double"
+	case ReplyKindNull:
+		kind = "Null"
+	case ReplyKindBool:
+		kind = "Bool"
+	case ReplyKindPush:
+		kind = "Push"
+	case ReplyKindVerbatim:
+		kind = "Verbatim"
 	}
 	return fmt.Sprintf("[%v] val=%v, err=%v", kind, r.Val, r.Err)
 }
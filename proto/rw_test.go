@@ -2,7 +2,9 @@ package proto
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -36,3 +38,69 @@ func TestRW(t *testing.T) {
 	assert.Equal(t, "OK", fmt.Sprintf("%v", val))
 
 }
+
+func TestRESP3(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+
+	write := func(fn func() error) string {
+		buf.Reset()
+		assert.Nil(t, fn())
+		assert.Nil(t, w.Flush())
+		return buf.String()
+	}
+	kvs := []interface{}{"a", 1}
+
+	// RESP2 使用兼容的类型
+	assert.Equal(t, "*2\r\n$1\r\na\r\n$1\r\n1\r\n", write(func() error { return w.ReplyMap(kvs) }))
+	assert.Equal(t, "$-1\r\n", write(w.ReplyNull))
+	assert.Equal(t, ":1\r\n", write(func() error { return w.ReplyBool(true) }))
+	assert.Equal(t, "$3\r\n1.5\r\n", write(func() error { return w.ReplyDouble(1.5) }))
+	assert.Equal(t, "$2\r\nhi\r\n", write(func() error { return w.ReplyVerbatim("txt", "hi") }))
+
+	w.SetProto(RESP3)
+	assert.Equal(t, "%1\r\n$1\r\na\r\n$1\r\n1\r\n", write(func() error { return w.ReplyMap(kvs) }))
+	assert.Equal(t, "~2\r\n$1\r\na\r\n$1\r\n1\r\n", write(func() error { return w.ReplySet(kvs) }))
+	assert.Equal(t, ">2\r\n$1\r\na\r\n$1\r\n1\r\n", write(func() error { return w.ReplyPush(kvs) }))
+	assert.Equal(t, "_\r\n", write(w.ReplyNull))
+	assert.Equal(t, "_\r\n", write(w.ReplyNilArrays))
+	assert.Equal(t, "#f\r\n", write(func() error { return w.ReplyBool(false) }))
+	assert.Equal(t, ",1.5\r\n", write(func() error { return w.ReplyDouble(1.5) }))
+	assert.Equal(t, ",-inf\r\n", write(func() error { return w.ReplyDouble(math.Inf(-1)) }))
+	assert.Equal(t, "=6\r\ntxt:hi\r\n", write(func() error { return w.ReplyVerbatim("txt", "hi") }))
+
+	// 读取
+	r := NewReader(bytes.NewBufferString("%1\r\n$1\r\na\r\n:1\r\n#t\r\n,inf\r\n_\r\n=6\r\ntxt:hi\r\n"))
+	val, err := r.ReadReply()
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"a", int64(1)}, val)
+	val, _ = r.ReadReply()
+	assert.Equal(t, true, val)
+	val, _ = r.ReadReply()
+	assert.Equal(t, math.Inf(1), val)
+	val, _ = r.ReadReply()
+	assert.Nil(t, val)
+	val, _ = r.ReadReply()
+	assert.Equal(t, "hi", val)
+}
+
+func TestReplyErr(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+
+	write := func(reply error) string {
+		buf.Reset()
+		assert.Nil(t, w.ReplyErr(reply))
+		assert.Nil(t, w.Flush())
+		return buf.String()
+	}
+
+	// 客户端识别的错误码原样返回, 其余错误加上 ERR
+	assert.Equal(t, "-ERR syntax error\r\n", write(errors.New("syntax error")))
+	assert.Equal(t, "-ERR value is not an integer\r\n", write(errors.New("ERR value is not an integer")))
+	assert.Equal(t, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n",
+		write(errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")))
+	assert.Equal(t, "-READONLY You can't write against a read only replica.\r\n",
+		write(errors.New("READONLY You can't write against a read only replica.")))
+	assert.Equal(t, "-NOTLEADER 127.0.0.1:6380\r\n", write(errors.New("NOTLEADER 127.0.0.1:6380")))
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"github.com/clovers4/gres/util"
 	"io"
+	"math"
 	"strconv"
+	"strings"
 )
 
 const (
@@ -14,10 +16,26 @@ const (
 	IntReply        = ':'
 	BulkStringReply = '$'
 	ArraysReply     = '*'
+
+	// RESP3
+	MapReply      = '%'
+	SetReply      = '~'
+	DoubleReply   = ','
+	NullReply     = '_'
+	BoolReply     = '#'
+	PushReply     = '>'
+	VerbatimReply = '='
+)
+
+// the versions of the protocol
+const (
+	RESP2 = 2
+	RESP3 = 3
 )
 
 type Writer struct {
-	wr *bufio.Writer
+	wr       *bufio.Writer
+	protover int // RESP2 时, RESP3 的类型被写为 RESP2 中相近的类型
 
 	lenBuf []byte
 	numBuf []byte
@@ -27,11 +45,21 @@ func NewWriter(wr io.Writer) *Writer {
 	return &Writer{
 		wr: bufio.NewWriter(wr),
 
-		lenBuf: make([]byte, 128),
-		numBuf: make([]byte, 128),
+		protover: RESP2,
+		lenBuf:   make([]byte, 128),
+		numBuf:   make([]byte, 128),
 	}
 }
 
+// SetProto sets the version of the protocol, RESP2 or RESP3.
+func (w *Writer) SetProto(protover int) {
+	w.protover = protover
+}
+
+func (w *Writer) Proto() int {
+	return w.protover
+}
+
 func (w *Writer) Reply(reply *Reply) error {
 	switch reply.Kind {
 	case ReplyKindStatus:
@@ -46,8 +74,28 @@ func (w *Writer) Reply(reply *Reply) error {
 	case ReplyKindBlukString:
 		return w.ReplyBulkStringV(reply.Val)
 	case ReplyKindArrays:
+		if reply.Val == nil {
+			return w.ReplyNilArrays()
+		}
 		arrays := reply.Val.([]interface{})
 		return w.ReplyArrays(arrays)
+	case ReplyKindMap:
+		return w.ReplyMap(reply.Val.([]interface{}))
+	case ReplyKindSet:
+		return w.ReplySet(reply.Val.([]interface{}))
+	case ReplyKindDouble:
+		if reply.Val == nil {
+			return w.ReplyNull()
+		}
+		return w.ReplyDouble(reply.Val.(float64))
+	case ReplyKindNull:
+		return w.ReplyNull()
+	case ReplyKindBool:
+		return w.ReplyBool(reply.Val.(bool))
+	case ReplyKindPush:
+		return w.ReplyPush(reply.Val.([]interface{}))
+	case ReplyKindVerbatim:
+		return w.ReplyVerbatim("txt", reply.Val.(string))
 	default:
 		return fmt.Errorf("unknown type of reply")
 	}
@@ -67,6 +115,10 @@ func (w *Writer) ReplyStatus(status string) error {
 	return w.crlf()
 }
 
+// errCodes are the prefixes of errors which the clients recognize, the other
+// errors are prefixed by ERR.
+var errCodes = []string{"ERR ", "WRONGTYPE ", "EXECABORT ", "OOM ", "NOPROTO ", "WRONGPASS ", "NOAUTH ", "NOPERM ", "NOTLEADER ", "READONLY "}
+
 // 错误回复（error reply）的第一个字节是 "-"
 func (w *Writer) ReplyErr(reply error) error {
 	err := w.wr.WriteByte(ErrReply)
@@ -74,13 +126,26 @@ func (w *Writer) ReplyErr(reply error) error {
 		return err
 	}
 
-	_, err = w.wr.Write(util.StringToBytes("ERR " + reply.Error()))
+	msg := reply.Error()
+	if !hasErrCode(msg) {
+		msg = "ERR " + msg
+	}
+	_, err = w.wr.Write(util.StringToBytes(msg))
 	if err != nil {
 		return err
 	}
 	return w.crlf()
 }
 
+func hasErrCode(msg string) bool {
+	for _, code := range errCodes {
+		if strings.HasPrefix(msg, code) {
+			return true
+		}
+	}
+	return false
+}
+
 // 整数回复（integer reply）的第一个字节是 ":"
 func (w *Writer) ReplyInt(num int) error {
 	err := w.wr.WriteByte(IntReply)
@@ -137,9 +202,158 @@ func (w *Writer) ReplyArrays(args []interface{}) error {
 	return nil
 }
 
+// ReplyNilArrays writes a null array, such as the reply of an aborted EXEC.
+func (w *Writer) ReplyNilArrays() error {
+	if w.protover == RESP3 {
+		return w.ReplyNull()
+	}
+	err := w.wr.WriteByte(ArraysReply)
+	if err != nil {
+		return err
+	}
+	return w.writeLen(-1)
+}
+
+// ReplyNull writes a null, which is a null bulk string in RESP2.
+func (w *Writer) ReplyNull() error {
+	if w.protover == RESP3 {
+		err := w.wr.WriteByte(NullReply)
+		if err != nil {
+			return err
+		}
+		return w.crlf()
+	}
+	err := w.wr.WriteByte(BulkStringReply)
+	if err != nil {
+		return err
+	}
+	return w.writeLen(-1)
+}
+
+// ReplyMap writes a map whose keys and values are alternated in kvs, such as
+// the reply of HGETALL. It is a flat array in RESP2.
+func (w *Writer) ReplyMap(kvs []interface{}) error {
+	if w.protover != RESP3 {
+		return w.ReplyArrays(kvs)
+	}
+	return w.aggregate(MapReply, len(kvs)/2, kvs)
+}
+
+// ReplySet writes an unordered set, such as the reply of SMEMBERS. It is an array in RESP2.
+func (w *Writer) ReplySet(vals []interface{}) error {
+	if w.protover != RESP3 {
+		return w.ReplyArrays(vals)
+	}
+	return w.aggregate(SetReply, len(vals), vals)
+}
+
+// ReplyPush writes an out of band message, such as of pub/sub. It is an array in RESP2.
+func (w *Writer) ReplyPush(vals []interface{}) error {
+	if w.protover != RESP3 {
+		return w.ReplyArrays(vals)
+	}
+	return w.aggregate(PushReply, len(vals), vals)
+}
+
+func (w *Writer) aggregate(kind byte, n int, vals []interface{}) error {
+	err := w.wr.WriteByte(kind)
+	if err != nil {
+		return err
+	}
+
+	err = w.writeLen(n)
+	if err != nil {
+		return err
+	}
+
+	for _, val := range vals {
+		err := w.ReplyBulkStringV(val)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplyDouble writes a floating point number, such as the reply of ZSCORE.
+// It is a bulk string in RESP2.
+func (w *Writer) ReplyDouble(f float64) error {
+	if w.protover != RESP3 {
+		return w.float(f)
+	}
+
+	err := w.wr.WriteByte(DoubleReply)
+	if err != nil {
+		return err
+	}
+	switch {
+	case math.IsInf(f, 1):
+		w.numBuf = append(w.numBuf[:0], "inf"...)
+	case math.IsInf(f, -1):
+		w.numBuf = append(w.numBuf[:0], "-inf"...)
+	default:
+		w.numBuf = strconv.AppendFloat(w.numBuf[:0], f, 'f', -1, 64)
+	}
+	_, err = w.wr.Write(w.numBuf)
+	if err != nil {
+		return err
+	}
+	return w.crlf()
+}
+
+// ReplyBool writes a boolean. It is the integer 1 or 0 in RESP2.
+func (w *Writer) ReplyBool(b bool) error {
+	if w.protover != RESP3 {
+		if b {
+			return w.ReplyInt(1)
+		}
+		return w.ReplyInt(0)
+	}
+
+	err := w.wr.WriteByte(BoolReply)
+	if err != nil {
+		return err
+	}
+	c := byte('f')
+	if b {
+		c = 't'
+	}
+	err = w.wr.WriteByte(c)
+	if err != nil {
+		return err
+	}
+	return w.crlf()
+}
+
+// ReplyVerbatim writes a string which should be shown to the user as is, such as
+// the reply of INFO. format is 3 bytes such as txt or mkd. It is a bulk string in RESP2.
+func (w *Writer) ReplyVerbatim(format string, s string) error {
+	if w.protover != RESP3 {
+		return w.string(s)
+	}
+
+	err := w.wr.WriteByte(VerbatimReply)
+	if err != nil {
+		return err
+	}
+	err = w.writeLen(len(format) + 1 + len(s))
+	if err != nil {
+		return err
+	}
+	_, err = w.wr.WriteString(format + ":")
+	if err != nil {
+		return err
+	}
+	_, err = w.wr.WriteString(s)
+	if err != nil {
+		return err
+	}
+	return w.crlf()
+}
+
 // length + n + crlf
 func (w *Writer) writeLen(n int) error {
-	w.lenBuf = strconv.AppendUint(w.lenBuf[:0], uint64(n), 10)
+	w.lenBuf = strconv.AppendInt(w.lenBuf[:0], int64(n), 10)
 	w.lenBuf = append(w.lenBuf, '\r', '\n')
 	_, err := w.wr.Write(w.lenBuf)
 	return err
@@ -149,12 +363,18 @@ func (w *Writer) writeLen(n int) error {
 func (w *Writer) ReplyBulkStringV(v interface{}) error {
 	switch v := v.(type) {
 	case nil:
-		return w.string("")
+		return w.ReplyNull()
+	case *Reply:
+		// 嵌套的回复, 如 EXEC 的结果
+		return w.Reply(v)
 	case string:
 		return w.string(v)
 	case *string:
 		return w.string(*v)
 	case []byte:
+		if v == nil {
+			return w.ReplyNull()
+		}
 		return w.ReplyBulkString(v)
 	case int:
 		return w.int(int64(v))
@@ -0,0 +1,254 @@
+package gres
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/clovers4/gres/commands"
+	"github.com/clovers4/gres/proto"
+	"github.com/clovers4/gres/util"
+	"go.uber.org/zap"
+)
+
+// 每个连接最多缓存的待推送消息个数
+const pushBufSize = 1024
+
+// pubsub is the channel registry of a server. It implements commands.PubSub.
+type pubsub struct {
+	mu       sync.RWMutex
+	channels map[string]map[*Client]struct{} // channel -> 订阅的连接
+	patterns map[string]map[*Client]struct{} // pattern -> 订阅的连接
+}
+
+func newPubsub() *pubsub {
+	return &pubsub{
+		channels: make(map[string]map[*Client]struct{}),
+		patterns: make(map[string]map[*Client]struct{}),
+	}
+}
+
+func (ps *pubsub) add(m map[string]map[*Client]struct{}, name string, cli *Client) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	clients, ok := m[name]
+	if !ok {
+		clients = make(map[*Client]struct{})
+		m[name] = clients
+	}
+	clients[cli] = struct{}{}
+}
+
+func (ps *pubsub) remove(m map[string]map[*Client]struct{}, name string, cli *Client) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	clients, ok := m[name]
+	if !ok {
+		return
+	}
+	delete(clients, cli)
+	if len(clients) == 0 {
+		delete(m, name)
+	}
+}
+
+// Publish sends message to the clients which subscribe channel, or a pattern
+// matching channel, and returns the number of clients received it.
+func (ps *pubsub) Publish(channel, message string) int {
+	// 在读锁内推送, 保证连接关闭时不会再收到消息
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	count := 0
+	for cli := range ps.channels[channel] {
+		cli.push([]interface{}{"message", channel, message})
+		count++
+	}
+	for pattern, clients := range ps.patterns {
+		if !util.Match(pattern, channel) {
+			continue
+		}
+		for cli := range clients {
+			cli.push([]interface{}{"pmessage", pattern, channel, message})
+			count++
+		}
+	}
+	return count
+}
+
+// Channels returns the active channels matching pattern. All the channels are
+// returned if pattern is empty.
+func (ps *pubsub) Channels(pattern string) []string {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	channels := make([]string, 0, len(ps.channels))
+	for channel := range ps.channels {
+		if pattern == "" || util.Match(pattern, channel) {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// NumSub returns the number of subscribers of channel, not counting the patterns.
+func (ps *pubsub) NumSub(channel string) int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return len(ps.channels[channel])
+}
+
+// NumPat returns the number of the subscribed patterns.
+func (ps *pubsub) NumPat() int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	count := 0
+	for _, clients := range ps.patterns {
+		count += len(clients)
+	}
+	return count
+}
+
+// Subscribe implements commands.Session.
+func (cli *Client) Subscribe(channels ...string) {
+	// 先启动推送, 再加入 registry, 否则可能丢失消息
+	cli.startPush()
+	for _, channel := range channels {
+		if _, ok := cli.channels[channel]; !ok {
+			cli.channels[channel] = struct{}{}
+			cli.srv.pubsub.add(cli.srv.pubsub.channels, channel, cli)
+		}
+		cli.pushReply("subscribe", channel)
+	}
+}
+
+// Unsubscribe implements commands.Session. All the channels are unsubscribed
+// if channels is empty.
+func (cli *Client) Unsubscribe(channels ...string) {
+	if len(channels) == 0 {
+		channels = keys(cli.channels)
+		if len(channels) == 0 {
+			cli.pushReply("unsubscribe", nil)
+			return
+		}
+	}
+	for _, channel := range channels {
+		if _, ok := cli.channels[channel]; ok {
+			delete(cli.channels, channel)
+			cli.srv.pubsub.remove(cli.srv.pubsub.channels, channel, cli)
+		}
+		cli.pushReply("unsubscribe", channel)
+	}
+}
+
+// PSubscribe implements commands.Session.
+func (cli *Client) PSubscribe(patterns ...string) {
+	cli.startPush()
+	for _, pattern := range patterns {
+		if _, ok := cli.patterns[pattern]; !ok {
+			cli.patterns[pattern] = struct{}{}
+			cli.srv.pubsub.add(cli.srv.pubsub.patterns, pattern, cli)
+		}
+		cli.pushReply("psubscribe", pattern)
+	}
+}
+
+// PUnsubscribe implements commands.Session. All the patterns are unsubscribed
+// if patterns is empty.
+func (cli *Client) PUnsubscribe(patterns ...string) {
+	if len(patterns) == 0 {
+		patterns = keys(cli.patterns)
+		if len(patterns) == 0 {
+			cli.pushReply("punsubscribe", nil)
+			return
+		}
+	}
+	for _, pattern := range patterns {
+		if _, ok := cli.patterns[pattern]; ok {
+			delete(cli.patterns, pattern)
+			cli.srv.pubsub.remove(cli.srv.pubsub.patterns, pattern, cli)
+		}
+		cli.pushReply("punsubscribe", pattern)
+	}
+}
+
+// PubSub implements commands.Session.
+func (cli *Client) PubSub() commands.PubSub {
+	return cli.srv.pubsub
+}
+
+// subscribed reports whether the connection is in the subscribed mode.
+func (cli *Client) subscribed() bool {
+	return len(cli.channels)+len(cli.patterns) > 0
+}
+
+// pushReply pushes the reply of (un)subscribe, which has the number of
+// subscriptions left. It goes the same way as messages to keep the order.
+func (cli *Client) pushReply(kind string, name interface{}) {
+	count := proto.NewReply(proto.ReplyKindInt, len(cli.channels)+len(cli.patterns), nil)
+	cli.startPush()
+	cli.pushCh <- []interface{}{kind, name, count}
+}
+
+// push sends a message to the connection without blocking the publisher.
+// The connection is closed if it cannot keep up, like redis does.
+func (cli *Client) push(msg []interface{}) {
+	select {
+	case cli.pushCh <- msg:
+	default:
+		cli.log.Warn("[Client push] too many pending messages, close the connection")
+		cli.conn.Close()
+	}
+}
+
+// startPush starts the goroutine which writes the pushed messages.
+func (cli *Client) startPush() {
+	if cli.pushCh != nil {
+		return
+	}
+	cli.pushCh = make(chan []interface{}, pushBufSize)
+	cli.pushDone = make(chan struct{})
+	go func() {
+		defer close(cli.pushDone)
+		for msg := range cli.pushCh {
+			msg := msg
+			err := cli.write(func(wr *proto.Writer) error {
+				return wr.ReplyPush(msg)
+			})
+			if err != nil {
+				cli.log.Warn("[Client startPush] write", zap.String("err", err.Error()))
+			}
+		}
+	}()
+}
+
+// stopPush unsubscribes all and stops the push goroutine after the pending
+// messages are written.
+func (cli *Client) stopPush() {
+	for channel := range cli.channels {
+		cli.srv.pubsub.remove(cli.srv.pubsub.channels, channel, cli)
+	}
+	for pattern := range cli.patterns {
+		cli.srv.pubsub.remove(cli.srv.pubsub.patterns, pattern, cli)
+	}
+	cli.channels = make(map[string]struct{})
+	cli.patterns = make(map[string]struct{})
+
+	if cli.pushCh != nil {
+		close(cli.pushCh)
+		<-cli.pushDone
+		cli.pushCh = nil
+	}
+}
+
+func keys(m map[string]struct{}) []string {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
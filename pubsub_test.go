@@ -0,0 +1,60 @@
+package gres
+
+import (
+	"testing"
+
+	"github.com/clovers4/gres/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+// newPushClient returns a client whose pushed messages are kept in pushCh.
+func newPushClient(srv *Server) *Client {
+	return &Client{
+		srv:      srv,
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+		pushCh:   make(chan []interface{}, 16),
+	}
+}
+
+func pushed(cli *Client) []interface{} {
+	msg := <-cli.pushCh
+	if r, ok := msg[len(msg)-1].(*proto.Reply); ok {
+		msg[len(msg)-1] = r.Val
+	}
+	return msg
+}
+
+func TestPubSub(t *testing.T) {
+	srv := &Server{pubsub: newPubsub()}
+	a, b := newPushClient(srv), newPushClient(srv)
+
+	a.Subscribe("news.tech", "news.art")
+	assert.Equal(t, []interface{}{"subscribe", "news.tech", 1}, pushed(a))
+	assert.Equal(t, []interface{}{"subscribe", "news.art", 2}, pushed(a))
+	b.PSubscribe("news.*")
+	assert.Equal(t, []interface{}{"psubscribe", "news.*", 1}, pushed(b))
+	assert.True(t, a.subscribed())
+
+	assert.Equal(t, 2, srv.pubsub.Publish("news.tech", "go"))
+	assert.Equal(t, []interface{}{"message", "news.tech", "go"}, pushed(a))
+	assert.Equal(t, []interface{}{"pmessage", "news.*", "news.tech", "go"}, pushed(b))
+	assert.Equal(t, 0, srv.pubsub.Publish("sport", "ball"))
+
+	assert.Equal(t, []string{"news.art", "news.tech"}, srv.pubsub.Channels(""))
+	assert.Equal(t, []string{"news.art"}, srv.pubsub.Channels("*art"))
+	assert.Equal(t, 1, srv.pubsub.NumSub("news.tech"))
+	assert.Equal(t, 1, srv.pubsub.NumPat())
+
+	a.Unsubscribe()
+	assert.Equal(t, []interface{}{"unsubscribe", "news.art", 1}, pushed(a))
+	assert.Equal(t, []interface{}{"unsubscribe", "news.tech", 0}, pushed(a))
+	a.Unsubscribe()
+	assert.Equal(t, []interface{}{"unsubscribe", nil, 0}, pushed(a))
+	assert.False(t, a.subscribed())
+	assert.Empty(t, srv.pubsub.Channels(""))
+
+	b.PUnsubscribe("news.*")
+	assert.Equal(t, []interface{}{"punsubscribe", "news.*", 0}, pushed(b))
+	assert.Equal(t, 0, srv.pubsub.NumPat())
+}
@@ -0,0 +1,371 @@
+package gres
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clovers4/gres/commands"
+	"github.com/clovers4/gres/engine"
+	"github.com/clovers4/gres/proto"
+	"github.com/clovers4/gres/raft"
+	"github.com/clovers4/gres/util"
+	"go.uber.org/zap"
+)
+
+const raftTimeout = 5 * time.Second // 等待写命令提交, 以及成员变更的超时时间
+
+var (
+	errRaftReplicaOf = errors.New("ERR REPLICAOF is not allowed in raft mode")
+	errRaftTimeout   = errors.New("ERR timeout waiting for the raft log, the command may still be applied")
+)
+
+// raftGroup runs the server as a node of a raft group. The write commands go through
+// the raft log, and are applied by every node in the same order. The reads of the
+// keys are served by the leader, after it confirms it is still the leader.
+type raftGroup struct {
+	srv  *Server
+	node *raft.Node
+	tr   *raftTransport
+}
+
+func newRaftGroup(srv *Server) (*raftGroup, error) {
+	opts := srv.opts
+	id := opts.raftID
+	if id == "" {
+		id = srv.listenAddrs()[0]
+	}
+	// raft-peers 不包含自己时, 等待 leader 将其加入
+	peers := strings.Fields(opts.raftPeers)
+	if len(peers) == 0 {
+		peers = []string{id}
+	} else if !containsString(peers, id) {
+		peers = nil
+	}
+
+	g := &raftGroup{
+		srv: srv,
+		tr:  newRaftTransport(srv),
+	}
+	node, err := raft.NewNode(raft.Config{
+		ID:                id,
+		Peers:             peers,
+		Dir:               filepath.Join(opts.dir, opts.dbFilename+"-raft"),
+		HeartbeatInterval: opts.raftHeartbeat,
+		ElectionTimeout:   opts.raftElection,
+		SnapshotEntries:   opts.raftSnapEntries,
+		Log:               srv.log,
+	}, &raftMachine{db: srv.db}, g.tr)
+	if err != nil {
+		return nil, err
+	}
+	g.node = node
+	return g, nil
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *raftGroup) stop() {
+	g.node.Stop()
+	g.tr.close()
+}
+
+// do runs a command of cli in raft mode. The write commands are proposed to the log,
+// and their replies are returned once applied. The reads of the keys wait until the
+// leader has applied all the commands before them, then run locally, and so do the
+// other commands. handled reports whether the command has run.
+func (g *raftGroup) do(cli *Client, args []string) (reply *proto.Reply, handled bool, err error) {
+	name := args[0]
+	// 事务与阻塞命令无法作为一条日志执行
+	if !commands.Queueable(name) || commands.MayBlock(name) && commands.AccessesKeys(name) {
+		return nil, true, commands.ErrRaftUnsupported
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), raftTimeout)
+	defer cancel()
+	switch {
+	case commands.IsWrite(name):
+		args = engine.AbsExpireArgs(args)
+		val, err := g.node.Propose(ctx, encodeRaftCommand(cli.db.Index(), util.NowMs(), args))
+		if err != nil {
+			return nil, true, g.error(err)
+		}
+		return val.(*proto.Reply), true, nil
+	case commands.AccessesKeys(name):
+		if err := g.node.ReadIndex(ctx); err != nil {
+			return nil, true, g.error(err)
+		}
+	}
+	return nil, false, nil
+}
+
+// error converts an error of the raft node to the reply. The clients are redirected
+// to the leader by NOTLEADER.
+func (g *raftGroup) error(err error) error {
+	switch err {
+	case raft.ErrNotLeader, raft.ErrLeadershipLost:
+		if leader := g.node.Leader(); leader != "" && leader != g.node.ID() {
+			return errors.New("NOTLEADER " + leader)
+		}
+		return errors.New("NOTLEADER no leader elected")
+	case context.DeadlineExceeded:
+		return errRaftTimeout
+	}
+	return fmt.Errorf("ERR %v", err)
+}
+
+// Message implements commands.Raft.
+func (g *raftGroup) Message(payload string) (string, error) {
+	msg, err := raft.DecodeMessage([]byte(payload))
+	if err != nil {
+		return "", fmt.Errorf("ERR %v", err)
+	}
+	resp, err := g.node.Handle(msg)
+	if err != nil {
+		return "", fmt.Errorf("ERR %v", err)
+	}
+	b, err := raft.EncodeMessage(resp)
+	return string(b), err
+}
+
+// AddNode implements commands.Raft.
+func (g *raftGroup) AddNode(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, raftTimeout)
+	defer cancel()
+	if err := g.node.AddNode(ctx, id); err != nil {
+		return g.error(err)
+	}
+	g.srv.log.Info("[raftGroup AddNode]", zap.String("id", id))
+	return nil
+}
+
+// RemoveNode implements commands.Raft.
+func (g *raftGroup) RemoveNode(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, raftTimeout)
+	defer cancel()
+	if err := g.node.RemoveNode(ctx, id); err != nil {
+		return g.error(err)
+	}
+	g.srv.log.Info("[raftGroup RemoveNode]", zap.String("id", id))
+	return nil
+}
+
+// Nodes implements commands.Raft.
+func (g *raftGroup) Nodes() []string {
+	return g.node.Status().Members
+}
+
+// Info implements commands.Raft.
+func (g *raftGroup) Info() []string {
+	st := g.node.Status()
+	return []string{
+		"raft_id", st.ID,
+		"raft_state", st.State.String(),
+		"raft_term", strconv.FormatUint(st.Term, 10),
+		"raft_leader", st.Leader,
+		"raft_members", strings.Join(st.Members, ","),
+		"raft_commit_index", strconv.FormatUint(st.CommitIndex, 10),
+		"raft_applied_index", strconv.FormatUint(st.AppliedIndex, 10),
+		"raft_last_index", strconv.FormatUint(st.LastIndex, 10),
+		"raft_snapshot_index", strconv.FormatUint(st.SnapshotIndex, 10),
+	}
+}
+
+// encodeRaftCommand encodes a write command, the index of its db and now, the time
+// of the leader, to an entry.
+func encodeRaftCommand(index int, now int64, args []string) []byte {
+	var buf bytes.Buffer
+	util.Write(&buf, int64(index))
+	util.Write(&buf, now)
+	util.Write(&buf, int64(len(args)))
+	for _, arg := range args {
+		util.Write(&buf, arg)
+	}
+	return buf.Bytes()
+}
+
+func decodeRaftCommand(data []byte) (index int, now int64, args []string, err error) {
+	r := bytes.NewReader(data)
+	var idx, n int64
+	if err := util.Read(r, &idx); err != nil {
+		return 0, 0, nil, err
+	}
+	if err := util.Read(r, &now); err != nil {
+		return 0, 0, nil, err
+	}
+	if err := util.Read(r, &n); err != nil {
+		return 0, 0, nil, err
+	}
+	args = make([]string, n)
+	for i := range args {
+		if err := util.Read(r, &args[i]); err != nil {
+			return 0, 0, nil, err
+		}
+	}
+	return int(idx), now, args, nil
+}
+
+// raftMachine applies the write commands of the raft log to the db. The snapshot
+// is in the format of the snapshot file.
+type raftMachine struct {
+	db *engine.DB
+}
+
+// Apply returns the reply of the command.
+func (m *raftMachine) Apply(data []byte) interface{} {
+	index, now, args, err := decodeRaftCommand(data)
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, err)
+	}
+	db, err := m.db.Select(index)
+	if err != nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, err)
+	}
+	cmd := commands.GetCmd(args[0])
+	if cmd == nil {
+		return proto.NewReply(proto.ReplyKindErr, nil, commands.ErrUnknownCmd)
+	}
+	// 使用 leader 的时间, 各个节点的结果相同, 如 ID.NEXT 的 snowflake id
+	return cmd.Do(engine.CtxWithNow(engine.CtxWithDB(context.Background(), db), now), args)
+}
+
+func (m *raftMachine) Snapshot(w io.Writer) error {
+	return m.db.WriteSnapshot(w)
+}
+
+func (m *raftMachine) Restore(r io.Reader) error {
+	return m.db.Load(r)
+}
+
+// raftTransport sends the raft messages to the other nodes by RAFT MESSAGE, on one
+// connection for each node.
+type raftTransport struct {
+	srv *Server
+
+	mu    sync.Mutex
+	conns map[string]*raftConn
+}
+
+type raftConn struct {
+	mu   sync.Mutex // 同一时间只有一个请求
+	conn *proto.Conn
+}
+
+func newRaftTransport(srv *Server) *raftTransport {
+	return &raftTransport{
+		srv:   srv,
+		conns: make(map[string]*raftConn),
+	}
+}
+
+// Send implements raft.Transport.
+func (t *raftTransport) Send(ctx context.Context, id string, msg *raft.Message) (*raft.Message, error) {
+	payload, err := raft.EncodeMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	c, ok := t.conns[id]
+	if !ok {
+		c = &raftConn{}
+		t.conns[id] = c
+	}
+	t.mu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		if c.conn, err = t.dial(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := raftRequest(ctx, c.conn, "raft", "message", payload)
+	if err != nil {
+		// 不再使用出错的连接, 下次重新连接
+		if _, ok := err.(proto.RedisError); !ok {
+			c.conn.Close()
+			c.conn = nil
+		}
+		return nil, err
+	}
+	s, _ := reply.(string)
+	return raft.DecodeMessage([]byte(s))
+}
+
+// dial connects to the node of id, and authenticates as masteruser like a replica.
+func (t *raftTransport) dial(ctx context.Context, id string) (*proto.Conn, error) {
+	var d net.Dialer
+	netConn, err := d.DialContext(ctx, "tcp", id)
+	if err != nil {
+		return nil, err
+	}
+	conn := proto.NewConn(netConn)
+
+	t.srv.configLock.Lock()
+	user, pass := t.srv.opts.masterUser, t.srv.opts.masterAuth
+	t.srv.configLock.Unlock()
+	if pass != "" {
+		args := []interface{}{"auth", pass}
+		if user != "" {
+			args = []interface{}{"auth", user, pass}
+		}
+		if _, err := raftRequest(ctx, conn, args...); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("AUTH: %v", err)
+		}
+	}
+	return conn, nil
+}
+
+func (t *raftTransport) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, c := range t.conns {
+		c.mu.Lock()
+		if c.conn != nil {
+			c.conn.Close()
+			c.conn = nil
+		}
+		c.mu.Unlock()
+		delete(t.conns, id)
+	}
+}
+
+// raftRequest is like request, with the deadline of ctx.
+func raftRequest(ctx context.Context, conn *proto.Conn, args ...interface{}) (interface{}, error) {
+	err := conn.WithWriter(ctx, 0, func(wr *proto.Writer) error {
+		return wr.ReplyArrays(args)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var reply interface{}
+	err = conn.WithReader(ctx, 0, func(rd *proto.Reader) (err error) {
+		reply, err = rd.ReadReply()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := reply.(proto.RedisError); ok {
+		return nil, e
+	}
+	return reply, nil
+}
@@ -0,0 +1,88 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/gob"
+	"strings"
+)
+
+// EntryType is the type of a log entry.
+type EntryType uint8
+
+const (
+	EntryCommand EntryType = iota // 交给状态机执行的命令
+	EntryConfig                   // 新的成员配置, 写入日志即生效
+	EntryNoop                     // leader 当选后写入, 以提交之前任期的日志
+)
+
+// Entry is an entry of the replicated log.
+type Entry struct {
+	Index uint64
+	Term  uint64
+	Type  EntryType
+	Data  []byte
+}
+
+// MessageType is the type of a message between the nodes.
+type MessageType uint8
+
+const (
+	MsgVote MessageType = iota
+	MsgVoteResp
+	MsgAppend
+	MsgAppendResp
+	MsgSnapshot
+	MsgSnapshotResp
+)
+
+// Message is a request or a reply of the raft protocol. Only the fields of its type are set.
+type Message struct {
+	Type MessageType
+	Term uint64
+	From string
+
+	// MsgVote
+	LastLogIndex uint64
+	LastLogTerm  uint64
+	// MsgVoteResp
+	Granted bool
+
+	// MsgAppend
+	PrevLogIndex uint64
+	PrevLogTerm  uint64
+	Entries      []Entry
+	Commit       uint64
+	// MsgAppendResp. Match 是成功时最后一条匹配的日志, 失败时 leader 应重试的位置之前一条
+	Success bool
+	Match   uint64
+
+	// MsgSnapshot, 快照文件的全部内容
+	Snapshot []byte
+}
+
+// EncodeMessage encodes msg to be sent by a Transport.
+func EncodeMessage(msg *Message) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(msg); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeMessage decodes a message encoded by EncodeMessage.
+func DecodeMessage(b []byte) (*Message, error) {
+	msg := new(Message)
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// 成员以空格分隔, 节点的 id 是地址, 不含空格
+func encodeMembers(members []string) []byte {
+	return []byte(strings.Join(members, " "))
+}
+
+func decodeMembers(data []byte) []string {
+	return strings.Fields(string(data))
+}
@@ -0,0 +1,1146 @@
+// Package raft replicates a log of commands across a small group of nodes by the
+// raft consensus algorithm, so that every node applies the same commands in the
+// same order. The members are changed one node at a time.
+package raft
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultHeartbeatInterval = 100 * time.Millisecond
+	DefaultElectionTimeout   = time.Second
+	DefaultSnapshotEntries   = 10000
+
+	maxAppendEntries = 512 // 一次 MsgAppend 最多发送的日志条数
+)
+
+var (
+	ErrNotLeader      = errors.New("raft: not the leader")
+	ErrLeadershipLost = errors.New("raft: leadership lost before the entry is committed")
+	ErrStopped        = errors.New("raft: the node is stopped")
+	ErrAlreadyMember  = errors.New("raft: the node is already a member")
+	ErrNotMember      = errors.New("raft: the node is not a member")
+)
+
+// State is the role of a node.
+type State uint8
+
+const (
+	Follower State = iota
+	Candidate
+	Leader
+)
+
+func (s State) String() string {
+	switch s {
+	case Follower:
+		return "follower"
+	case Candidate:
+		return "candidate"
+	default:
+		return "leader"
+	}
+}
+
+// StateMachine applies the committed commands. Its methods are called by one goroutine.
+type StateMachine interface {
+	// Apply applies a command, and returns the result to the proposer.
+	Apply(data []byte) interface{}
+	// Snapshot writes the state after all the commands applied.
+	Snapshot(w io.Writer) error
+	// Restore replaces the state with a snapshot written by Snapshot.
+	Restore(r io.Reader) error
+}
+
+// Transport sends the messages to the other nodes.
+type Transport interface {
+	// Send sends msg to the node of id, which handles it by Node.Handle, and returns the reply.
+	Send(ctx context.Context, id string, msg *Message) (*Message, error)
+}
+
+// Config is the configuration of a node.
+type Config struct {
+	ID                string   // 节点的 id, 即其他节点访问它的地址
+	Peers             []string // 初始的成员, 包括自己; 为空时等待 leader 将其加入
+	Dir               string   // 保存日志与快照的目录
+	HeartbeatInterval time.Duration
+	ElectionTimeout   time.Duration // 实际的超时时间在 [ElectionTimeout, 2*ElectionTimeout) 之间随机
+	SnapshotEntries   int           // 快照之后应用了多少条日志时, 生成新的快照
+	Log               *zap.Logger
+}
+
+// Status is the state of a node.
+type Status struct {
+	ID            string
+	State         State
+	Term          uint64
+	Leader        string
+	CommitIndex   uint64
+	AppliedIndex  uint64
+	LastIndex     uint64
+	SnapshotIndex uint64
+	Members       []string
+}
+
+type result struct {
+	val interface{}
+	err error
+}
+
+// proposal waits for an entry appended by the leader to be applied.
+type proposal struct {
+	term uint64
+	done chan result
+}
+
+// replicator sends the entries to a follower, once triggered or every heartbeat.
+type replicator struct {
+	trigger chan struct{}
+	stop    chan struct{}
+}
+
+// Node is a member of a raft group.
+type Node struct {
+	cfg Config
+	id  string
+	sm  StateMachine
+	tr  Transport
+	st  *storage
+	log *zap.Logger
+
+	mu              sync.Mutex
+	state           State
+	term            uint64
+	votedFor        string
+	leader          string
+	entries         []Entry  // entries[0] 是快照的最后一条日志, 只有 Index 与 Term
+	snapMembers     []string // 快照中的成员
+	members         []string // 日志中最新的成员配置, 包括尚未提交的
+	configIndex     uint64   // members 所在的日志, 0 表示来自快照
+	commitIndex     uint64
+	lastApplied     uint64
+	lastContact     time.Time // 最后一次收到 leader 的消息或投票的时间
+	electionTimeout time.Duration
+
+	// leader 的状态
+	nextIndex   map[string]uint64
+	matchIndex  map[string]uint64
+	lastAck     map[string]time.Time // follower 最后一次回复的时间
+	readSeq     uint64               // ReadIndex 的序号, 随消息发送
+	ackSeq      map[string]uint64    // follower 回复的最大序号
+	replicators map[string]*replicator
+	pending     map[uint64]*proposal
+
+	changed chan struct{} // 状态变化时关闭, 唤醒等待者
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewNode loads the state of the node from cfg.Dir, and starts it. The state
+// machine is restored from the snapshot, and the committed entries after it are
+// applied once the leader tells the commit index.
+func NewNode(cfg Config, sm StateMachine, tr Transport) (*Node, error) {
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if cfg.ElectionTimeout <= 0 {
+		cfg.ElectionTimeout = DefaultElectionTimeout
+	}
+	if cfg.SnapshotEntries <= 0 {
+		cfg.SnapshotEntries = DefaultSnapshotEntries
+	}
+	if cfg.Log == nil {
+		cfg.Log = zap.NewNop()
+	}
+
+	st, err := openStorage(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	n := &Node{
+		cfg:         cfg,
+		id:          cfg.ID,
+		sm:          sm,
+		tr:          tr,
+		st:          st,
+		log:         cfg.Log,
+		entries:     []Entry{{}},
+		snapMembers: cfg.Peers,
+		lastContact: time.Now(),
+		changed:     make(chan struct{}),
+		stop:        make(chan struct{}),
+	}
+	if err := n.load(); err != nil {
+		st.close()
+		return nil, err
+	}
+	n.resetElectionTimeout()
+
+	n.wg.Add(2)
+	go n.tick()
+	go n.applyLoop()
+	return n, nil
+}
+
+// load reads the term, the snapshot and the log.
+func (n *Node) load() (err error) {
+	if n.term, n.votedFor, err = n.st.loadState(); err != nil {
+		return err
+	}
+	b, err := n.st.readSnapshot()
+	if err != nil {
+		return err
+	}
+	if b != nil {
+		meta, _, err := parseSnapshot(b)
+		if err != nil {
+			return err
+		}
+		n.entries[0] = Entry{Index: meta.Index, Term: meta.Term}
+		n.snapMembers = meta.Members
+		// 快照中的日志都已提交, 由 applyLoop 恢复状态机
+		n.commitIndex = meta.Index
+	}
+	entries, err := n.st.loadLog()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Index > n.snapIndex() {
+			n.entries = append(n.entries, e)
+		}
+	}
+	n.updateMembers()
+	return nil
+}
+
+// Stop stops the node. The proposals waiting fail with ErrStopped.
+func (n *Node) Stop() {
+	n.mu.Lock()
+	if n.stopped() {
+		n.mu.Unlock()
+		return
+	}
+	close(n.stop)
+	n.failPending(ErrStopped)
+	if n.state == Leader {
+		n.becomeFollower(n.term, "")
+	}
+	n.mu.Unlock()
+
+	n.wg.Wait()
+	n.st.close()
+}
+
+// ID returns the id of the node.
+func (n *Node) ID() string {
+	return n.id
+}
+
+// Leader returns the id of the leader known by the node, empty if unknown.
+func (n *Node) Leader() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leader
+}
+
+// Status returns the state of the node.
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return Status{
+		ID:            n.id,
+		State:         n.state,
+		Term:          n.term,
+		Leader:        n.leader,
+		CommitIndex:   n.commitIndex,
+		AppliedIndex:  n.lastApplied,
+		LastIndex:     n.lastIndex(),
+		SnapshotIndex: n.snapIndex(),
+		Members:       append([]string(nil), n.members...),
+	}
+}
+
+// Propose appends a command to the log, and returns the result of the state machine
+// once it is applied. Only the leader accepts proposals. If ctx is done first, the
+// command may still be applied.
+func (n *Node) Propose(ctx context.Context, data []byte) (interface{}, error) {
+	n.mu.Lock()
+	p, err := n.appendLocked(EntryCommand, data)
+	n.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return n.wait(ctx, p)
+}
+
+func (n *Node) wait(ctx context.Context, p *proposal) (interface{}, error) {
+	select {
+	case r := <-p.done:
+		return r.val, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// ReadIndex blocks until the state machine of the leader has applied all the commands
+// committed before the call, so that a read of it after ReadIndex is linearizable.
+// The leader confirms that it is still the leader by a round of heartbeats.
+func (n *Node) ReadIndex(ctx context.Context) error {
+	var term, readIndex, seq uint64
+	// 当前任期的日志提交之前, commitIndex 可能落后于之前的 leader
+	err := n.waitFor(ctx, func() (bool, error) {
+		if n.state != Leader {
+			return false, ErrNotLeader
+		}
+		if n.termAt(n.commitIndex) != n.term {
+			return false, nil
+		}
+		term, readIndex = n.term, n.commitIndex
+		n.readSeq++
+		seq = n.readSeq
+		n.triggerAll()
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return n.waitFor(ctx, func() (bool, error) {
+		if n.state != Leader || n.term != term {
+			return false, ErrNotLeader
+		}
+		acks := 0
+		for _, m := range n.members {
+			if m == n.id || n.ackSeq[m] >= seq {
+				acks++
+			}
+		}
+		return acks >= n.quorum() && n.lastApplied >= readIndex, nil
+	})
+}
+
+// AddNode adds the node of id to the members. The node should be started with no
+// peers, and receives the log from the leader.
+func (n *Node) AddNode(ctx context.Context, id string) error {
+	return n.changeMembers(ctx, id, true)
+}
+
+// RemoveNode removes the node of id from the members. The leader steps down once it
+// removes itself.
+func (n *Node) RemoveNode(ctx context.Context, id string) error {
+	return n.changeMembers(ctx, id, false)
+}
+
+// changeMembers appends a config entry of one node added or removed, after the
+// previous one is committed.
+func (n *Node) changeMembers(ctx context.Context, id string, add bool) error {
+	var p *proposal
+	err := n.waitFor(ctx, func() (bool, error) {
+		if n.state != Leader {
+			return false, ErrNotLeader
+		}
+		// 上一次变更提交, 且当前任期已提交日志后, 才能开始新的变更
+		if n.configIndex > n.commitIndex || n.termAt(n.commitIndex) != n.term {
+			return false, nil
+		}
+
+		var members []string
+		found := false
+		for _, m := range n.members {
+			if m == id {
+				found = true
+				if !add {
+					continue
+				}
+			}
+			members = append(members, m)
+		}
+		if add && found {
+			return false, ErrAlreadyMember
+		}
+		if !add && !found {
+			return false, ErrNotMember
+		}
+		if add {
+			members = append(members, id)
+		}
+
+		var err error
+		p, err = n.appendLocked(EntryConfig, encodeMembers(members))
+		return err == nil, err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = n.wait(ctx, p)
+	return err
+}
+
+// waitFor calls cond with n.mu held, until it returns true or an error. cond is
+// called again once the state of the node changes.
+func (n *Node) waitFor(ctx context.Context, cond func() (bool, error)) error {
+	for {
+		n.mu.Lock()
+		ok, err := cond()
+		changed := n.changed
+		n.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-n.stop:
+			return ErrStopped
+		}
+	}
+}
+
+func (n *Node) stopped() bool {
+	select {
+	case <-n.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// notify wakes up the waiters of waitFor and applyLoop.
+func (n *Node) notify() {
+	close(n.changed)
+	n.changed = make(chan struct{})
+}
+
+func (n *Node) snapIndex() uint64 {
+	return n.entries[0].Index
+}
+
+func (n *Node) lastIndex() uint64 {
+	return n.entries[len(n.entries)-1].Index
+}
+
+func (n *Node) lastTerm() uint64 {
+	return n.entries[len(n.entries)-1].Term
+}
+
+// termAt returns the term of the entry at index, 0 if it is not in the log.
+func (n *Node) termAt(index uint64) uint64 {
+	if index < n.snapIndex() || index > n.lastIndex() {
+		return 0
+	}
+	return n.entries[index-n.snapIndex()].Term
+}
+
+func (n *Node) isMember(id string) bool {
+	for _, m := range n.members {
+		if m == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Node) quorum() int {
+	return len(n.members)/2 + 1
+}
+
+// updateMembers finds the latest config entry in the log.
+func (n *Node) updateMembers() {
+	for i := len(n.entries) - 1; i > 0; i-- {
+		if e := n.entries[i]; e.Type == EntryConfig {
+			n.members = decodeMembers(e.Data)
+			n.configIndex = e.Index
+			return
+		}
+	}
+	n.members = n.snapMembers
+	n.configIndex = 0
+}
+
+func (n *Node) resetElectionTimeout() {
+	n.electionTimeout = n.cfg.ElectionTimeout + time.Duration(rand.Int63n(int64(n.cfg.ElectionTimeout)))
+}
+
+func (n *Node) saveState() error {
+	if err := n.st.saveState(n.term, n.votedFor); err != nil {
+		n.log.Error("[Node saveState]", zap.String("err", err.Error()))
+		return err
+	}
+	return nil
+}
+
+// tick starts an election once the leader is lost, and makes the leader step down
+// once it loses the majority.
+func (n *Node) tick() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.cfg.HeartbeatInterval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+		}
+
+		n.mu.Lock()
+		if n.state == Leader {
+			n.checkQuorum()
+		} else if time.Since(n.lastContact) >= n.electionTimeout && n.isMember(n.id) {
+			n.campaign()
+		}
+		n.mu.Unlock()
+	}
+}
+
+func (n *Node) checkQuorum() {
+	acks := 0
+	for _, m := range n.members {
+		if m == n.id || time.Since(n.lastAck[m]) < n.cfg.ElectionTimeout {
+			acks++
+		}
+	}
+	if acks < n.quorum() {
+		n.log.Warn("[Node checkQuorum] lost the majority, step down", zap.Uint64("term", n.term))
+		n.becomeFollower(n.term, "")
+	}
+}
+
+// campaign starts an election of a new term.
+func (n *Node) campaign() {
+	n.state = Candidate
+	n.leader = ""
+	n.term++
+	n.votedFor = n.id
+	n.lastContact = time.Now()
+	n.resetElectionTimeout()
+	if n.saveState() != nil {
+		return
+	}
+	n.log.Info("[Node campaign] start an election", zap.Uint64("term", n.term))
+	n.notify()
+
+	votes := 1
+	if votes >= n.quorum() {
+		n.becomeLeader()
+		return
+	}
+	msg := &Message{
+		Type:         MsgVote,
+		Term:         n.term,
+		From:         n.id,
+		LastLogIndex: n.lastIndex(),
+		LastLogTerm:  n.lastTerm(),
+	}
+	for _, m := range n.members {
+		if m == n.id {
+			continue
+		}
+		go func(peer string) {
+			ctx, cancel := context.WithTimeout(context.Background(), n.cfg.ElectionTimeout)
+			resp, err := n.tr.Send(ctx, peer, msg)
+			cancel()
+			if err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if n.stopped() {
+				return
+			}
+			if resp.Term > n.term {
+				n.becomeFollower(resp.Term, "")
+				return
+			}
+			if n.state != Candidate || n.term != msg.Term || !resp.Granted {
+				return
+			}
+			votes++
+			if votes >= n.quorum() {
+				n.becomeLeader()
+			}
+		}(m)
+	}
+}
+
+// becomeFollower follows the leader of term, empty if unknown.
+func (n *Node) becomeFollower(term uint64, leader string) {
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		n.saveState()
+	}
+	if n.state == Leader {
+		n.stopReplicators()
+		n.failPending(ErrLeadershipLost)
+	}
+	if n.state != Follower || n.leader != leader {
+		n.log.Info("[Node becomeFollower]", zap.Uint64("term", n.term), zap.String("leader", leader))
+	}
+	n.state = Follower
+	n.leader = leader
+	n.notify()
+}
+
+func (n *Node) becomeLeader() {
+	n.log.Info("[Node becomeLeader]", zap.Uint64("term", n.term))
+	n.state = Leader
+	n.leader = n.id
+	n.nextIndex = make(map[string]uint64)
+	n.matchIndex = make(map[string]uint64)
+	n.lastAck = make(map[string]time.Time)
+	n.ackSeq = make(map[string]uint64)
+	n.replicators = make(map[string]*replicator)
+	n.pending = make(map[uint64]*proposal)
+	n.startReplicators()
+	// 提交当前任期的日志, 之前任期的日志随之提交
+	if _, err := n.appendLocked(EntryNoop, nil); err != nil {
+		n.becomeFollower(n.term, "")
+		return
+	}
+	n.notify()
+}
+
+// startReplicators starts a replicator for each member which has none.
+func (n *Node) startReplicators() {
+	for _, m := range n.members {
+		if _, ok := n.replicators[m]; ok || m == n.id {
+			continue
+		}
+		n.nextIndex[m] = n.lastIndex() + 1
+		n.lastAck[m] = time.Now()
+		r := &replicator{
+			trigger: make(chan struct{}, 1),
+			stop:    make(chan struct{}),
+		}
+		n.replicators[m] = r
+		n.wg.Add(1)
+		go n.replicate(m, r)
+	}
+}
+
+func (n *Node) stopReplicators() {
+	for m, r := range n.replicators {
+		close(r.stop)
+		delete(n.replicators, m)
+	}
+}
+
+func (n *Node) triggerAll() {
+	for _, r := range n.replicators {
+		select {
+		case r.trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (n *Node) failPending(err error) {
+	for index, p := range n.pending {
+		p.done <- result{err: err}
+		delete(n.pending, index)
+	}
+}
+
+// appendLocked appends an entry to the log of the leader, and returns the proposal
+// waiting for it.
+func (n *Node) appendLocked(typ EntryType, data []byte) (*proposal, error) {
+	if n.state != Leader {
+		return nil, ErrNotLeader
+	}
+	e := Entry{Index: n.lastIndex() + 1, Term: n.term, Type: typ, Data: data}
+	if err := n.st.appendLog([]Entry{e}); err != nil {
+		return nil, err
+	}
+	n.entries = append(n.entries, e)
+	if typ == EntryConfig {
+		n.updateMembers()
+		n.startReplicators()
+	}
+
+	p := &proposal{term: n.term, done: make(chan result, 1)}
+	n.pending[e.Index] = p
+	n.advanceCommit()
+	n.triggerAll()
+	return p, nil
+}
+
+// advanceCommit commits the entries of the current term replicated on the majority.
+func (n *Node) advanceCommit() {
+	for index := n.lastIndex(); index > n.commitIndex && n.termAt(index) == n.term; index-- {
+		count := 0
+		for _, m := range n.members {
+			if m == n.id || n.matchIndex[m] >= index {
+				count++
+			}
+		}
+		if count < n.quorum() {
+			continue
+		}
+
+		n.commitIndex = index
+		n.notify()
+		return
+	}
+}
+
+// replicate sends the entries, or the snapshot if the entries are compacted, to peer
+// until the replicator is stopped.
+func (n *Node) replicate(peer string, r *replicator) {
+	defer n.wg.Done()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-r.stop:
+			return
+		case <-r.trigger:
+		case <-timer.C:
+		}
+
+		more := n.sendAppend(peer)
+		if more {
+			select {
+			case r.trigger <- struct{}{}:
+			default:
+			}
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(n.cfg.HeartbeatInterval)
+	}
+}
+
+// sendAppend sends a message of entries or the snapshot to peer, and reports
+// whether there are more to send.
+func (n *Node) sendAppend(peer string) bool {
+	n.mu.Lock()
+	if n.state != Leader {
+		n.mu.Unlock()
+		return false
+	}
+	msg := &Message{Term: n.term, From: n.id}
+	seq := n.readSeq
+	next := n.nextIndex[peer]
+	if next <= n.snapIndex() {
+		b, err := n.st.readSnapshot()
+		if err != nil {
+			n.mu.Unlock()
+			n.log.Error("[Node sendAppend] readSnapshot", zap.String("err", err.Error()))
+			return false
+		}
+		msg.Type = MsgSnapshot
+		msg.Snapshot = b
+	} else {
+		msg.Type = MsgAppend
+		msg.PrevLogIndex = next - 1
+		msg.PrevLogTerm = n.termAt(next - 1)
+		end := n.lastIndex() + 1
+		if end-next > maxAppendEntries {
+			end = next + maxAppendEntries
+		}
+		msg.Entries = append([]Entry(nil), n.entries[next-n.snapIndex():end-n.snapIndex()]...)
+		msg.Commit = n.commitIndex
+	}
+	n.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.cfg.ElectionTimeout)
+	resp, err := n.tr.Send(ctx, peer, msg)
+	cancel()
+	if err != nil {
+		return false
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if resp.Term > n.term {
+		n.becomeFollower(resp.Term, "")
+		return false
+	}
+	if n.state != Leader || n.term != msg.Term {
+		return false
+	}
+	// 同一任期的回复, 说明 follower 仍承认此 leader
+	n.lastAck[peer] = time.Now()
+	if seq > n.ackSeq[peer] {
+		n.ackSeq[peer] = seq
+		n.notify()
+	}
+
+	if resp.Success {
+		if resp.Match > n.matchIndex[peer] {
+			n.matchIndex[peer] = resp.Match
+			n.advanceCommit()
+		}
+		n.nextIndex[peer] = resp.Match + 1
+		// 被移除的节点收到移除它的配置后, 不再发送
+		if !n.isMember(peer) && resp.Match >= n.configIndex {
+			if r, ok := n.replicators[peer]; ok {
+				close(r.stop)
+				delete(n.replicators, peer)
+			}
+			return false
+		}
+	} else {
+		// 回退到 follower 提示的位置
+		next := n.nextIndex[peer] - 1
+		if resp.Match+1 < next {
+			next = resp.Match + 1
+		}
+		if next < 1 {
+			next = 1
+		}
+		n.nextIndex[peer] = next
+	}
+	return n.nextIndex[peer] <= n.lastIndex()
+}
+
+// Handle handles a message from another node, and returns the reply.
+func (n *Node) Handle(msg *Message) (*Message, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.stopped() {
+		return nil, ErrStopped
+	}
+	switch msg.Type {
+	case MsgVote:
+		return n.handleVote(msg)
+	case MsgAppend:
+		return n.handleAppend(msg)
+	case MsgSnapshot:
+		return n.handleSnapshot(msg)
+	}
+	return nil, errors.New("raft: unknown message type")
+}
+
+func (n *Node) handleVote(msg *Message) (*Message, error) {
+	resp := &Message{Type: MsgVoteResp, From: n.id}
+	// 仍能收到 leader 的消息时, 不理会被移除的节点等发起的选举
+	if msg.Term > n.term && (n.state == Leader || n.leader != "" && time.Since(n.lastContact) < n.cfg.ElectionTimeout) {
+		resp.Term = n.term
+		return resp, nil
+	}
+	if msg.Term > n.term {
+		n.becomeFollower(msg.Term, "")
+	}
+	resp.Term = n.term
+	if msg.Term < n.term || n.votedFor != "" && n.votedFor != msg.From {
+		return resp, nil
+	}
+	// 只投给日志至少一样新的节点
+	if msg.LastLogTerm < n.lastTerm() || msg.LastLogTerm == n.lastTerm() && msg.LastLogIndex < n.lastIndex() {
+		return resp, nil
+	}
+
+	n.votedFor = msg.From
+	if err := n.saveState(); err != nil {
+		return nil, err
+	}
+	n.lastContact = time.Now()
+	resp.Granted = true
+	return resp, nil
+}
+
+// follow accepts the leader of msg, and reports false if msg is of an old term.
+func (n *Node) follow(msg *Message) bool {
+	if msg.Term < n.term {
+		return false
+	}
+	if msg.Term > n.term || n.state != Follower || n.leader != msg.From {
+		n.becomeFollower(msg.Term, msg.From)
+	}
+	n.lastContact = time.Now()
+	return true
+}
+
+func (n *Node) handleAppend(msg *Message) (*Message, error) {
+	resp := &Message{Type: MsgAppendResp, From: n.id}
+	if !n.follow(msg) {
+		resp.Term = n.term
+		return resp, nil
+	}
+	resp.Term = n.term
+
+	// 快照之前的日志都已提交, 一定与 leader 一致
+	prev, entries := msg.PrevLogIndex, msg.Entries
+	if prev < n.snapIndex() {
+		skip := n.snapIndex() - prev
+		if skip > uint64(len(entries)) {
+			skip = uint64(len(entries))
+		}
+		entries = entries[skip:]
+		prev = n.snapIndex()
+	} else if prev > n.lastIndex() {
+		resp.Match = n.lastIndex()
+		return resp, nil
+	} else if term := n.termAt(prev); term != msg.PrevLogTerm {
+		// 跳过冲突的整个任期
+		index := prev
+		for index > n.snapIndex()+1 && n.termAt(index-1) == term {
+			index--
+		}
+		resp.Match = index - 1
+		return resp, nil
+	}
+
+	for i, e := range entries {
+		if e.Index <= n.lastIndex() {
+			if n.termAt(e.Index) == e.Term {
+				continue
+			}
+			// 删除冲突的日志及之后的日志
+			n.entries = append(n.entries[:e.Index-n.snapIndex()], entries[i:]...)
+			if err := n.st.rewriteLog(n.entries[1:]); err != nil {
+				return nil, err
+			}
+		} else {
+			if err := n.st.appendLog(entries[i:]); err != nil {
+				return nil, err
+			}
+			n.entries = append(n.entries, entries[i:]...)
+		}
+		n.updateMembers()
+		break
+	}
+
+	resp.Success = true
+	resp.Match = prev + uint64(len(entries))
+	if commit := min(msg.Commit, resp.Match); commit > n.commitIndex {
+		n.commitIndex = commit
+		n.notify()
+	}
+	return resp, nil
+}
+
+func (n *Node) handleSnapshot(msg *Message) (*Message, error) {
+	resp := &Message{Type: MsgSnapshotResp, From: n.id}
+	if !n.follow(msg) {
+		resp.Term = n.term
+		return resp, nil
+	}
+	resp.Term = n.term
+
+	meta, data, err := parseSnapshot(msg.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+	resp.Success = true
+	resp.Match = meta.Index
+	if meta.Index <= n.snapIndex() {
+		return resp, nil
+	}
+
+	temp, err := n.st.createSnapshot(meta, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := n.compact(temp, meta); err != nil {
+		return nil, err
+	}
+	if meta.Index > n.commitIndex {
+		n.commitIndex = meta.Index
+	}
+	n.log.Info("[Node handleSnapshot] installed the snapshot", zap.Uint64("index", meta.Index), zap.String("leader", msg.From))
+	n.notify()
+	return resp, nil
+}
+
+// compact replaces the snapshot with the temp file of meta, and drops the entries
+// included in it. The entries after it are kept if they match.
+func (n *Node) compact(temp string, meta snapshotMeta) error {
+	if err := n.st.installSnapshot(temp); err != nil {
+		return err
+	}
+
+	var rest []Entry
+	if n.termAt(meta.Index) == meta.Term {
+		rest = n.entries[meta.Index-n.snapIndex()+1:]
+	}
+	n.entries = append([]Entry{{Index: meta.Index, Term: meta.Term}}, rest...)
+	n.snapMembers = meta.Members
+	n.updateMembers()
+	return n.st.rewriteLog(n.entries[1:])
+}
+
+// applyLoop applies the committed entries to the state machine, restores it from
+// a snapshot installed by the leader, and takes snapshots.
+func (n *Node) applyLoop() {
+	defer n.wg.Done()
+	for {
+		n.mu.Lock()
+		changed := n.changed
+		switch {
+		case n.lastApplied < n.snapIndex():
+			n.mu.Unlock()
+			if err := n.restore(); err != nil {
+				n.log.Error("[Node applyLoop] restore", zap.String("err", err.Error()))
+				// 等待下一个快照
+				select {
+				case <-changed:
+				case <-n.stop:
+					return
+				}
+			}
+			continue
+		case n.lastApplied < n.commitIndex:
+			entries := append([]Entry(nil), n.entries[n.lastApplied+1-n.snapIndex():n.commitIndex+1-n.snapIndex()]...)
+			n.mu.Unlock()
+			n.apply(entries)
+			continue
+		}
+		n.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+// restore restores the state machine from the snapshot. The state machine is called
+// without n.mu, which it may wait for indirectly, such as by the lock of the db held
+// while a message is handled. A newer snapshot installed meanwhile is restored next.
+func (n *Node) restore() error {
+	n.mu.Lock()
+	b, err := n.st.readSnapshot()
+	n.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	meta, data, err := parseSnapshot(b)
+	if err != nil {
+		return err
+	}
+	if err := n.sm.Restore(bytes.NewReader(data)); err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	if meta.Index > n.lastApplied {
+		n.lastApplied = meta.Index
+	}
+	n.notify()
+	n.mu.Unlock()
+	return nil
+}
+
+func (n *Node) apply(entries []Entry) {
+	for _, e := range entries {
+		var val interface{}
+		if e.Type == EntryCommand {
+			val = n.sm.Apply(e.Data)
+		}
+
+		n.mu.Lock()
+		// 期间安装了更新的快照
+		if e.Index != n.lastApplied+1 {
+			n.mu.Unlock()
+			return
+		}
+		n.lastApplied = e.Index
+		if p, ok := n.pending[e.Index]; ok {
+			if p.term == e.Term {
+				p.done <- result{val: val}
+			} else {
+				p.done <- result{err: ErrLeadershipLost}
+			}
+			delete(n.pending, e.Index)
+		}
+		// 不在新的成员中的 leader, 应用移除自己的配置之后退出
+		if n.state == Leader && !n.isMember(n.id) && n.configIndex <= n.lastApplied {
+			n.log.Info("[Node apply] removed from the members, step down")
+			n.becomeFollower(n.term, "")
+		}
+		n.notify()
+		n.mu.Unlock()
+	}
+
+	n.mu.Lock()
+	take := n.lastApplied-n.snapIndex() >= uint64(n.cfg.SnapshotEntries)
+	var meta snapshotMeta
+	if take {
+		meta = n.snapshotMeta()
+	}
+	n.mu.Unlock()
+	if take {
+		if err := n.snapshot(meta); err != nil {
+			n.log.Error("[Node apply] snapshot", zap.String("err", err.Error()))
+		}
+	}
+}
+
+// snapshotMeta returns the meta of a snapshot at the last entry applied.
+func (n *Node) snapshotMeta() snapshotMeta {
+	meta := snapshotMeta{Index: n.lastApplied, Term: n.termAt(n.lastApplied), Members: n.members}
+	// 快照中的成员是 lastApplied 时的配置
+	if n.configIndex > n.lastApplied {
+		for i := n.lastApplied; ; i-- {
+			if i <= n.snapIndex() {
+				meta.Members = n.snapMembers
+				break
+			}
+			if e := n.entries[i-n.snapIndex()]; e.Type == EntryConfig {
+				meta.Members = decodeMembers(e.Data)
+				break
+			}
+		}
+	}
+	return meta
+}
+
+// snapshot writes the state machine to a new snapshot of meta, and compacts the log.
+// It is called by applyLoop without n.mu, so no entry is applied meanwhile.
+func (n *Node) snapshot(meta snapshotMeta) error {
+	temp, err := n.st.createSnapshot(meta, n.sm.Snapshot)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	// 期间安装了 leader 发送的更新的快照
+	if meta.Index <= n.snapIndex() {
+		os.Remove(temp)
+		return nil
+	}
+	if err := n.compact(temp, meta); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	n.log.Info("[Node snapshot] took a snapshot", zap.Uint64("index", meta.Index))
+	return nil
+}
+
+func min(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,342 @@
+package raft
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// kvMachine applies "key=value" commands.
+type kvMachine struct {
+	mu sync.Mutex
+	kv map[string]string
+}
+
+func newKVMachine() *kvMachine {
+	return &kvMachine{kv: make(map[string]string)}
+}
+
+func (m *kvMachine) Apply(data []byte) interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kv := strings.SplitN(string(data), "=", 2)
+	m.kv[kv[0]] = kv[1]
+	return len(m.kv)
+}
+
+func (m *kvMachine) Snapshot(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return gob.NewEncoder(w).Encode(m.kv)
+}
+
+func (m *kvMachine) Restore(r io.Reader) error {
+	kv := make(map[string]string)
+	if err := gob.NewDecoder(r).Decode(&kv); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.kv = kv
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *kvMachine) get(key string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.kv[key]
+}
+
+// cluster connects the nodes in memory. A node disconnected neither sends nor receives.
+type cluster struct {
+	t   *testing.T
+	dir string
+
+	mu       sync.Mutex
+	nodes    map[string]*Node
+	machines map[string]*kvMachine
+	down     map[string]bool
+}
+
+func newCluster(t *testing.T, ids ...string) *cluster {
+	dir, err := ioutil.TempDir("", "raft-test-")
+	assert.Nil(t, err)
+	c := &cluster{
+		t:        t,
+		dir:      dir,
+		nodes:    make(map[string]*Node),
+		machines: make(map[string]*kvMachine),
+		down:     make(map[string]bool),
+	}
+	for _, id := range ids {
+		c.start(id, ids, 0)
+	}
+	return c
+}
+
+func (c *cluster) start(id string, peers []string, snapshotEntries int) *Node {
+	sm := newKVMachine()
+	n, err := NewNode(Config{
+		ID:                id,
+		Peers:             peers,
+		Dir:               filepath.Join(c.dir, id),
+		HeartbeatInterval: 10 * time.Millisecond,
+		ElectionTimeout:   100 * time.Millisecond,
+		SnapshotEntries:   snapshotEntries,
+	}, sm, c)
+	assert.Nil(c.t, err)
+	c.mu.Lock()
+	c.nodes[id] = n
+	c.machines[id] = sm
+	c.mu.Unlock()
+	return n
+}
+
+func (c *cluster) stop(id string) {
+	c.node(id).Stop()
+}
+
+func (c *cluster) close() {
+	for id := range c.nodes {
+		c.stop(id)
+	}
+	os.RemoveAll(c.dir)
+}
+
+func (c *cluster) node(id string) *Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nodes[id]
+}
+
+func (c *cluster) machine(id string) *kvMachine {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.machines[id]
+}
+
+func (c *cluster) setDown(id string, down bool) {
+	c.mu.Lock()
+	c.down[id] = down
+	c.mu.Unlock()
+}
+
+func (c *cluster) Send(ctx context.Context, id string, msg *Message) (*Message, error) {
+	c.mu.Lock()
+	n := c.nodes[id]
+	down := c.down[id] || c.down[msg.From]
+	c.mu.Unlock()
+	if n == nil || down {
+		return nil, errors.New("unreachable")
+	}
+
+	// 经过编码, 不与发送者共享数据
+	b, err := EncodeMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	if msg, err = DecodeMessage(b); err != nil {
+		return nil, err
+	}
+	return n.Handle(msg)
+}
+
+// leader waits for a leader known by all the nodes up, which is not one of except.
+func (c *cluster) leader(except ...string) string {
+	var leader string
+	waitFor(c.t, "leader", func() bool {
+		leader = ""
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for id, n := range c.nodes {
+			if c.down[id] || n.stopped() || contains(except, id) {
+				continue
+			}
+			l := n.Leader()
+			if l == "" || contains(except, l) || leader != "" && l != leader {
+				return false
+			}
+			leader = l
+		}
+		return leader != ""
+	})
+	return leader
+}
+
+func contains(ids []string, id string) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *cluster) propose(id, kv string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := c.node(id).Propose(ctx, []byte(kv))
+	assert.Nil(c.t, err)
+}
+
+func (c *cluster) waitValue(id, key, value string) {
+	waitFor(c.t, id+" applies "+key, func() bool { return c.machine(id).get(key) == value })
+}
+
+// waitFor polls cond until it is true, or fails the test after 5 seconds.
+func waitFor(t *testing.T, msg string, cond func() bool) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for %v", msg)
+}
+
+func TestNode_Replication(t *testing.T) {
+	c := newCluster(t, "a", "b", "c")
+	defer c.close()
+
+	leader := c.leader()
+	assert.Equal(t, Leader, c.node(leader).Status().State)
+	val, err := c.node(leader).Propose(context.Background(), []byte("x=1"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, val)
+	for _, id := range []string{"a", "b", "c"} {
+		c.waitValue(id, "x", "1")
+	}
+
+	// 只有 leader 接受写入
+	for _, id := range []string{"a", "b", "c"} {
+		if id != leader {
+			_, err := c.node(id).Propose(context.Background(), []byte("y=1"))
+			assert.Equal(t, ErrNotLeader, err)
+			assert.Equal(t, ErrNotLeader, c.node(id).ReadIndex(context.Background()))
+		}
+	}
+	assert.Nil(t, c.node(leader).ReadIndex(context.Background()))
+
+	// leader 断开后, 选出新的 leader
+	c.setDown(leader, true)
+	newLeader := c.leader(leader)
+	assert.NotEqual(t, leader, newLeader)
+	c.propose(newLeader, "x=2")
+
+	// 断开的 leader 失去多数派, 不再处理读请求
+	waitFor(t, "old leader steps down", func() bool { return c.node(leader).Status().State != Leader })
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	assert.NotNil(t, c.node(leader).ReadIndex(ctx))
+	cancel()
+
+	// 恢复后追上新的日志
+	c.setDown(leader, false)
+	c.waitValue(leader, "x", "2")
+	assert.Equal(t, newLeader, c.leader())
+}
+
+func TestNode_Restart(t *testing.T) {
+	c := newCluster(t, "a", "b", "c")
+	defer c.close()
+
+	leader := c.leader()
+	c.propose(leader, "x=1")
+	c.propose(leader, "y=2")
+	for _, id := range []string{"a", "b", "c"} {
+		c.waitValue(id, "y", "2")
+	}
+	term := c.node(leader).Status().Term
+
+	// 全部重启, 从磁盘恢复日志
+	for _, id := range []string{"a", "b", "c"} {
+		c.stop(id)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		c.start(id, []string{"a", "b", "c"}, 0)
+	}
+	leader = c.leader()
+	assert.True(t, c.node(leader).Status().Term > term)
+	for _, id := range []string{"a", "b", "c"} {
+		c.waitValue(id, "x", "1")
+		c.waitValue(id, "y", "2")
+	}
+}
+
+func TestNode_Snapshot(t *testing.T) {
+	c := newCluster(t)
+	defer c.close()
+	ids := []string{"a", "b", "c"}
+	for _, id := range ids {
+		c.start(id, ids, 5)
+	}
+
+	leader := c.leader()
+	var lagging string
+	for _, id := range ids {
+		if id != leader {
+			lagging = id
+			break
+		}
+	}
+	c.setDown(lagging, true)
+	for i := 0; i < 20; i++ {
+		c.propose(leader, "k"+strconv.Itoa(i)+"="+strconv.Itoa(i))
+	}
+	waitFor(t, "snapshot", func() bool { return c.node(leader).Status().SnapshotIndex > 0 })
+
+	// 落后的节点需要的日志已被压缩, 由 leader 发送快照
+	c.setDown(lagging, false)
+	c.waitValue(lagging, "k19", "19")
+	assert.Equal(t, "0", c.machine(lagging).get("k0"))
+	waitFor(t, "install snapshot", func() bool { return c.node(lagging).Status().SnapshotIndex > 0 })
+
+	// 重启后从快照与之后的日志恢复
+	c.stop(lagging)
+	c.start(lagging, ids, 5)
+	c.waitValue(lagging, "k19", "19")
+	c.propose(c.leader(), "k20=20")
+	c.waitValue(lagging, "k20", "20")
+}
+
+func TestNode_Membership(t *testing.T) {
+	c := newCluster(t, "a", "b", "c")
+	defer c.close()
+
+	leader := c.leader()
+	c.propose(leader, "x=1")
+
+	// 新节点不带成员启动, 等待 leader 加入
+	c.start("d", nil, 0)
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, Follower, c.node("d").Status().State)
+	assert.Equal(t, uint64(0), c.node("d").Status().Term)
+
+	ctx := context.Background()
+	assert.Nil(t, c.node(leader).AddNode(ctx, "d"))
+	assert.Equal(t, ErrAlreadyMember, c.node(leader).AddNode(ctx, "d"))
+	c.waitValue("d", "x", "1")
+	assert.Equal(t, []string{"a", "b", "c", "d"}, c.node("d").Status().Members)
+
+	// 移除 leader 自己, 其余节点选出新的 leader
+	assert.Nil(t, c.node(leader).RemoveNode(ctx, leader))
+	waitFor(t, "old leader steps down", func() bool { return c.node(leader).Status().State != Leader })
+	newLeader := c.leader(leader)
+	assert.NotEqual(t, leader, newLeader)
+	assert.Equal(t, ErrNotMember, c.node(newLeader).RemoveNode(ctx, leader))
+	assert.Equal(t, 3, len(c.node(newLeader).Status().Members))
+
+	c.stop(leader)
+	c.propose(newLeader, "x=2")
+	c.waitValue("d", "x", "2")
+}
@@ -0,0 +1,242 @@
+package raft
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/clovers4/gres/util"
+)
+
+const (
+	stateFilename    = "raft-state"
+	logFilename      = "raft-log"
+	snapshotFilename = "raft-snapshot"
+	tempFilePattern  = "temp-raft-*"
+)
+
+// snapshotMeta is the last entry included in a snapshot, and the members at it.
+type snapshotMeta struct {
+	Index   uint64
+	Term    uint64
+	Members []string
+}
+
+// storage keeps the state of a node in dir: the term and the vote, the entries
+// after the snapshot, and the snapshot. The entries are appended to the log file,
+// which is rewritten only when the log is truncated or compacted.
+type storage struct {
+	dir     string
+	logFile *os.File
+}
+
+func openStorage(dir string) (*storage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &storage{dir: dir}
+	if err := s.openLog(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *storage) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+func (s *storage) openLog() error {
+	f, err := os.OpenFile(s.path(logFilename), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	s.logFile = f
+	return nil
+}
+
+func (s *storage) close() error {
+	return s.logFile.Close()
+}
+
+// writeFile writes the file of name by write, through a temp file, so that the
+// file is either the old one or the new one after a crash.
+func (s *storage) writeFile(name string, write func(w io.Writer) error) error {
+	temp, err := s.createTemp(write)
+	if err != nil {
+		return err
+	}
+	return s.rename(temp, name)
+}
+
+// createTemp writes a temp file by write, and returns its path.
+func (s *storage) createTemp(write func(w io.Writer) error) (string, error) {
+	f, err := ioutil.TempFile(s.dir, tempFilePattern)
+	if err != nil {
+		return "", err
+	}
+	wr := bufio.NewWriter(f)
+	err = write(wr)
+	if err == nil {
+		err = wr.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func (s *storage) rename(temp, name string) error {
+	if err := os.Rename(temp, s.path(name)); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return nil
+}
+
+func (s *storage) loadState() (term uint64, votedFor string, err error) {
+	b, err := ioutil.ReadFile(s.path(stateFilename))
+	if os.IsNotExist(err) {
+		return 0, "", nil
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	r := bytes.NewReader(b)
+	if err := util.Read(r, &term); err != nil {
+		return 0, "", err
+	}
+	if err := util.Read(r, &votedFor); err != nil {
+		return 0, "", err
+	}
+	return term, votedFor, nil
+}
+
+func (s *storage) saveState(term uint64, votedFor string) error {
+	return s.writeFile(stateFilename, func(w io.Writer) error {
+		if err := util.Write(w, term); err != nil {
+			return err
+		}
+		return util.Write(w, votedFor)
+	})
+}
+
+func writeEntry(w io.Writer, e *Entry) error {
+	for _, v := range []interface{}{e.Index, e.Term, e.Type, e.Data} {
+		if err := util.Write(w, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readEntry(r io.Reader, e *Entry) error {
+	for _, v := range []interface{}{&e.Index, &e.Term, &e.Type, &e.Data} {
+		if err := util.Read(r, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadLog reads the entries of the log file. An entry written partly before a
+// crash is dropped.
+func (s *storage) loadLog() ([]Entry, error) {
+	if _, err := s.logFile.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	rd := bufio.NewReader(s.logFile)
+	var entries []Entry
+	for {
+		if _, err := rd.Peek(1); err == io.EOF {
+			return entries, nil
+		}
+		var e Entry
+		if err := readEntry(rd, &e); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return entries, s.rewriteLog(entries)
+			}
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+}
+
+func (s *storage) appendLog(entries []Entry) error {
+	var buf bytes.Buffer
+	for i := range entries {
+		if err := writeEntry(&buf, &entries[i]); err != nil {
+			return err
+		}
+	}
+	if _, err := s.logFile.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return s.logFile.Sync()
+}
+
+// rewriteLog replaces the log file with entries.
+func (s *storage) rewriteLog(entries []Entry) error {
+	err := s.writeFile(logFilename, func(w io.Writer) error {
+		for i := range entries {
+			if err := writeEntry(w, &entries[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.logFile.Close()
+	return s.openLog()
+}
+
+// createSnapshot writes a snapshot to a temp file, which replaces the snapshot by
+// installSnapshot.
+func (s *storage) createSnapshot(meta snapshotMeta, write func(w io.Writer) error) (string, error) {
+	return s.createTemp(func(w io.Writer) error {
+		for _, v := range []interface{}{meta.Index, meta.Term, encodeMembers(meta.Members)} {
+			if err := util.Write(w, v); err != nil {
+				return err
+			}
+		}
+		return write(w)
+	})
+}
+
+func (s *storage) installSnapshot(temp string) error {
+	return s.rename(temp, snapshotFilename)
+}
+
+// readSnapshot returns the content of the snapshot file, nil if there is no snapshot.
+func (s *storage) readSnapshot() ([]byte, error) {
+	b, err := ioutil.ReadFile(s.path(snapshotFilename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return b, err
+}
+
+// parseSnapshot returns the meta of a snapshot, and the data of the state machine.
+func parseSnapshot(b []byte) (snapshotMeta, []byte, error) {
+	var meta snapshotMeta
+	var members []byte
+	r := bytes.NewReader(b)
+	for _, v := range []interface{}{&meta.Index, &meta.Term, &members} {
+		if err := util.Read(r, v); err != nil {
+			return meta, nil, err
+		}
+	}
+	meta.Members = decodeMembers(members)
+	return meta, b[len(b)-r.Len():], nil
+}
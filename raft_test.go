@@ -0,0 +1,171 @@
+package gres
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clovers4/gres/proto"
+	"github.com/clovers4/gres/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// raftTestServer serves a node of a raft group on lis.
+func raftTestServer(t *testing.T, dir string, lis net.Listener, peers ...string) *Server {
+	id := lis.Addr().String()
+	opts := defaultServerOptions
+	opts.dir = dir
+	opts.dbFilename = strings.Replace(id, ":", "-", -1)
+	opts.raftEnabled = true
+	opts.raftID = id
+	opts.raftPeers = strings.Join(peers, " ")
+	opts.raftHeartbeat = 20 * time.Millisecond
+	opts.raftElection = 200 * time.Millisecond
+	opts.raftSnapEntries = 50
+
+	srv := newConfigServer(opts)
+	srv.pubsub = newPubsub()
+	var err error
+	srv.raft, err = newRaftGroup(srv)
+	assert.Nil(t, err)
+	go srv.serve(lis)
+	return srv
+}
+
+func dialTest(t *testing.T, addr string) *proto.Conn {
+	netConn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	return proto.NewConn(netConn)
+}
+
+// raftLeader waits for a leader among servers, and returns it.
+func raftLeader(t *testing.T, servers []*Server) *Server {
+	var leader *Server
+	waitFor(t, "leader", func() bool {
+		for _, srv := range servers {
+			if srv.raft.node.Status().State.String() == "leader" {
+				leader = srv
+				return true
+			}
+		}
+		return false
+	})
+	return leader
+}
+
+func TestServer_Raft(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gres-raft-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	var listeners []net.Listener
+	var peers []string
+	for i := 0; i < 4; i++ {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Nil(t, err)
+		defer lis.Close()
+		listeners = append(listeners, lis)
+		peers = append(peers, lis.Addr().String())
+	}
+	var servers []*Server
+	for _, lis := range listeners[:3] {
+		srv := raftTestServer(t, dir, lis, peers[:3]...)
+		defer srv.raft.stop()
+		servers = append(servers, srv)
+	}
+
+	leader := raftLeader(t, servers)
+	leaderAddr := leader.raft.node.ID()
+	conn := dialTest(t, leaderAddr)
+	defer conn.Close()
+	reply, err := request(conn, "set", "a", "A")
+	assert.Nil(t, err)
+	assert.Equal(t, "OK", reply)
+	_, err = request(conn, "setex", "b", "100", "B")
+	assert.Nil(t, err)
+	reply, err = request(conn, "get", "a")
+	assert.Nil(t, err)
+	assert.Equal(t, "A", reply)
+	_, err = request(conn, "multi")
+	assert.Equal(t, "ERR the command is not supported in raft mode", err.Error())
+	reply, err = request(conn, "info", "raft")
+	assert.Nil(t, err)
+	assert.Contains(t, reply, "raft_state:leader")
+
+	// 所有节点应用相同的写命令
+	for _, srv := range servers {
+		srv := srv
+		waitFor(t, "apply", func() bool { return srv.db.Exists("b") })
+		assert.True(t, srv.db.Ttl("b") > 90)
+	}
+
+	// 各节点使用 leader 的时间, 生成的 snowflake id 相同
+	_, err = request(conn, "id.create", "sf", "snowflake", "1")
+	assert.Nil(t, err)
+	first, err := request(conn, "id.next", "sf")
+	assert.Nil(t, err)
+	_, err = request(conn, "set", "d", "D")
+	assert.Nil(t, err)
+	now := util.NowMs()
+	var ids []int64
+	for _, srv := range servers {
+		srv := srv
+		waitFor(t, "apply id.next", func() bool { return srv.db.Exists("d") })
+		next, err := srv.db.IDNext("sf", 1, now)
+		assert.Nil(t, err)
+		assert.True(t, next[0] > first.(int64))
+		ids = append(ids, next[0])
+	}
+	assert.Equal(t, []int64{ids[0], ids[0], ids[0]}, ids)
+
+	// follower 把读写都重定向到 leader
+	var follower *Server
+	for _, srv := range servers {
+		if srv != leader {
+			follower = srv
+			break
+		}
+	}
+	followerConn := dialTest(t, follower.raft.node.ID())
+	defer followerConn.Close()
+	_, err = request(followerConn, "set", "a", "B")
+	assert.Equal(t, "NOTLEADER "+leaderAddr, err.Error())
+	_, err = request(followerConn, "get", "a")
+	assert.Equal(t, "NOTLEADER "+leaderAddr, err.Error())
+	_, err = request(followerConn, "ping")
+	assert.Nil(t, err)
+
+	// 新节点不带自己启动, 由 leader 加入后追上数据
+	joined := raftTestServer(t, dir, listeners[3], peers[:3]...)
+	defer joined.raft.stop()
+	_, err = request(conn, "raft", "addnode", peers[3])
+	assert.Nil(t, err)
+	waitFor(t, "join", func() bool { return joined.db.Exists("a") })
+	reply, err = request(conn, "raft", "nodes")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(reply.([]interface{})))
+	servers = append(servers, joined)
+
+	// leader 停止后, 其余节点选出新的 leader
+	leader.raft.stop()
+	var rest []*Server
+	for _, srv := range servers {
+		if srv != leader {
+			rest = append(rest, srv)
+		}
+	}
+	newLeader := raftLeader(t, rest)
+	newConn := dialTest(t, newLeader.raft.node.ID())
+	defer newConn.Close()
+	_, err = request(newConn, "set", "c", "C")
+	assert.Nil(t, err)
+	for _, srv := range rest {
+		srv := srv
+		waitFor(t, "apply after failover", func() bool { return srv.db.Exists("c") })
+	}
+
+	assert.Equal(t, errRaftReplicaOf, newLeader.ReplicaOf("127.0.0.1", "6379"))
+}
@@ -0,0 +1,736 @@
+package gres
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clovers4/gres/commands"
+	"github.com/clovers4/gres/engine"
+	"github.com/clovers4/gres/proto"
+	"go.uber.org/zap"
+)
+
+const (
+	replTimeout        = 60 * time.Second // 握手与接收快照的超时时间
+	replReconnectDelay = time.Second      // 与 primary 断开后, 重连前等待的时间
+	replAckInterval    = time.Second      // replica 定期向 primary 确认已应用的位置
+)
+
+// the states of the link to the primary, as ROLE replies
+const (
+	replStateConnect    = "connect"    // 等待重连
+	replStateConnecting = "connecting" // 连接与握手中
+	replStateSync       = "sync"       // 接收快照中
+	replStateConnected  = "connected"  // 接收写命令中
+)
+
+var (
+	errLinkClosed    = errors.New("the link to the primary is closed")
+	errWaitOnReplica = errors.New("ERR WAIT cannot be used with replica instances.")
+)
+
+// parseReplicaOf parses "host port" of the replicaof parameter, empty means a primary.
+func parseReplicaOf(val string) (host, port string, err error) {
+	fields := strings.Fields(val)
+	if len(fields) == 0 {
+		return "", "", nil
+	}
+	if len(fields) != 2 {
+		return "", "", errors.New("argument must be 'host port'")
+	}
+	if p, err := strconv.Atoi(fields[1]); err != nil || p <= 0 || p > 65535 {
+		return "", "", errors.New("invalid port of the primary")
+	}
+	return fields[0], fields[1], nil
+}
+
+// primaryLink is the link of a replica to its primary. It reconnects until closed,
+// and continues from its offset if the primary still has the commands after it.
+type primaryLink struct {
+	host   string
+	port   string
+	state  string
+	replID string    // primary 的复制 id
+	offset int64     // 已应用的复制流的位置
+	lastIO time.Time // 最后一次收到 primary 数据的时间
+	conn   net.Conn
+	closed bool
+	stop   chan struct{}
+
+	applier *engine.ReplicaApplier // 只在 replicate 的 goroutine 中使用, nil 表示需要全量同步
+
+	mu sync.Mutex
+}
+
+func newPrimaryLink(host, port string) *primaryLink {
+	return &primaryLink{
+		host:  host,
+		port:  port,
+		state: replStateConnect,
+		stop:  make(chan struct{}),
+	}
+}
+
+func (l *primaryLink) addr() string {
+	return net.JoinHostPort(l.host, l.port)
+}
+
+func (l *primaryLink) setState(state string) {
+	l.mu.Lock()
+	l.state = state
+	l.mu.Unlock()
+}
+
+// setConn keeps conn, so that close can interrupt it. It fails once the link is closed.
+func (l *primaryLink) setConn(conn net.Conn) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return errLinkClosed
+	}
+	l.conn = conn
+	return nil
+}
+
+// position returns the id and the offset of the stream applied.
+func (l *primaryLink) position() (string, int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.replID, l.offset
+}
+
+func (l *primaryLink) synced(replID string, offset int64) {
+	l.mu.Lock()
+	l.state = replStateConnected
+	l.replID = replID
+	l.offset = offset
+	l.lastIO = time.Now()
+	l.mu.Unlock()
+}
+
+func (l *primaryLink) addOffset(n int64) {
+	l.mu.Lock()
+	l.offset += n
+	l.lastIO = time.Now()
+	l.mu.Unlock()
+}
+
+func (l *primaryLink) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+	l.closed = true
+	close(l.stop)
+	if l.conn != nil {
+		l.conn.Close()
+	}
+}
+
+// ReplicaOf implements commands.Replication.
+func (srv *Server) ReplicaOf(host, port string) error {
+	srv.replMu.Lock()
+	defer srv.replMu.Unlock()
+	if srv.raft != nil && host != "" {
+		return errRaftReplicaOf
+	}
+
+	if link := srv.primary; link != nil {
+		if link.host == host && link.port == port {
+			return nil
+		}
+		link.close()
+		srv.primary = nil
+	}
+
+	srv.configLock.Lock()
+	srv.opts.replicaOf = strings.TrimSpace(host + " " + port)
+	srv.configLock.Unlock()
+
+	if host == "" {
+		srv.log.Info("[Server ReplicaOf] become a primary")
+		return nil
+	}
+	link := newPrimaryLink(host, port)
+	srv.primary = link
+	srv.log.Info("[Server ReplicaOf] become a replica", zap.String("primary", link.addr()))
+	go srv.replicate(link)
+	return nil
+}
+
+// Role implements commands.Replication.
+func (srv *Server) Role() []interface{} {
+	srv.replMu.Lock()
+	link := srv.primary
+	srv.replMu.Unlock()
+
+	if link != nil {
+		link.mu.Lock()
+		defer link.mu.Unlock()
+		port, _ := strconv.Atoi(link.port)
+		return []interface{}{
+			"slave", link.host, proto.NewReply(proto.ReplyKindInt, port, nil),
+			link.state, proto.NewReply(proto.ReplyKindInt, int(link.offset), nil),
+		}
+	}
+
+	replicas := []interface{}{}
+	for _, r := range srv.connectedReplicas() {
+		replicas = append(replicas, proto.NewReply(proto.ReplyKindArrays, []interface{}{
+			r.host, strconv.Itoa(r.port), strconv.FormatInt(r.ackOffset, 10),
+		}, nil))
+	}
+	return []interface{}{"master", proto.NewReply(proto.ReplyKindInt, int(srv.db.ReplOffset()), nil), replicas}
+}
+
+// Info implements commands.Replication.
+func (srv *Server) Info() []string {
+	var fields []string
+	add := func(name string, val interface{}) {
+		fields = append(fields, name, fmt.Sprint(val))
+	}
+
+	srv.replMu.Lock()
+	link := srv.primary
+	srv.replMu.Unlock()
+	if link == nil {
+		add("role", "master")
+	} else {
+		link.mu.Lock()
+		add("role", "slave")
+		add("master_host", link.host)
+		add("master_port", link.port)
+		if link.state == replStateConnected {
+			add("master_link_status", "up")
+		} else {
+			add("master_link_status", "down")
+		}
+		if link.lastIO.IsZero() {
+			add("master_last_io_seconds_ago", -1)
+		} else {
+			add("master_last_io_seconds_ago", int(time.Since(link.lastIO).Seconds()))
+		}
+		add("master_sync_in_progress", boolInt(link.state == replStateSync))
+		add("slave_repl_offset", link.offset)
+		link.mu.Unlock()
+
+		srv.configLock.Lock()
+		add("slave_read_only", boolInt(srv.opts.replicaReadOnly))
+		srv.configLock.Unlock()
+	}
+
+	replicas := srv.connectedReplicas()
+	add("connected_slaves", len(replicas))
+	for i, r := range replicas {
+		add("slave"+strconv.Itoa(i), fmt.Sprintf("ip=%s,port=%d,state=online,offset=%d,lag=%d",
+			r.host, r.port, r.ackOffset, int(r.lag.Seconds())))
+	}
+
+	info := srv.db.ReplInfo()
+	add("master_replid", info.ID)
+	add("master_repl_offset", info.Offset)
+	add("repl_backlog_active", boolInt(info.BacklogActive))
+	add("repl_backlog_size", info.BacklogSize)
+	add("repl_backlog_first_byte_offset", info.BacklogFirstByteOffset)
+	add("repl_backlog_histlen", info.BacklogHistlen)
+	return fields
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// replicaInfo is a replica connected to the server, as ROLE and INFO report.
+type replicaInfo struct {
+	host      string
+	port      int
+	ackOffset int64         // replica 确认已应用的位置
+	lag       time.Duration // 距上次确认的时间
+}
+
+// connectedReplicas returns the replicas which are streamed the write commands.
+func (srv *Server) connectedReplicas() []replicaInfo {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	var replicas []replicaInfo
+	for _, cli := range srv.clients {
+		if cli.stream == nil {
+			continue
+		}
+		host, _, _ := net.SplitHostPort(cli.conn.RemoteAddr().String())
+		replicas = append(replicas, replicaInfo{
+			host:      host,
+			port:      cli.replicaPort,
+			ackOffset: cli.ackOffset,
+			lag:       time.Since(cli.ackTime),
+		})
+	}
+	return replicas
+}
+
+// ReplConf implements commands.Replication.
+func (srv *Server) ReplConf(id int64, option, value string) error {
+	cli := srv.client(id)
+	if cli == nil {
+		return commands.ErrNoSession
+	}
+
+	switch option {
+	case "listening-port":
+		port, err := strconv.Atoi(value)
+		if err != nil || port < 0 || port > 65535 {
+			return errors.New("ERR invalid listening-port")
+		}
+		cli.replicaPort = port
+	case "capa":
+		// 忽略 replica 的能力, 如 eof 与 psync2
+	default:
+		return fmt.Errorf("ERR Unrecognized REPLCONF option: %v", option)
+	}
+	return nil
+}
+
+// Sync implements commands.Replication. The connection is served by serveReplica
+// once the command returns.
+func (srv *Server) Sync(id int64, replID string, offset int64) error {
+	cli := srv.client(id)
+	if cli == nil {
+		return commands.ErrNoSession
+	}
+	if cli.subscribed() {
+		return errors.New("ERR Replica can't be in the subscribed mode")
+	}
+	cli.syncing = true
+	cli.psyncID = replID
+	cli.psyncOffset = offset
+	return nil
+}
+
+// Wait implements commands.Replication. It waits for the offset of the stream when
+// it is called, which covers the write commands of the client before WAIT.
+func (srv *Server) Wait(ctx context.Context, numReplicas int, timeout time.Duration) (int, error) {
+	if srv.isReplica() {
+		return 0, errWaitOnReplica
+	}
+
+	offset := srv.db.ReplOffset()
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		n, acked := srv.ackedReplicas(offset)
+		if n >= numReplicas {
+			return n, nil
+		}
+		select {
+		case <-acked:
+		case <-expired:
+			return n, nil
+		case <-ctx.Done():
+			return n, nil
+		}
+	}
+}
+
+// ackedReplicas returns the number of replicas which acknowledged offset, and a
+// channel closed once any replica acknowledges again.
+func (srv *Server) ackedReplicas(offset int64) (int, <-chan struct{}) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	n := 0
+	for _, cli := range srv.clients {
+		if cli.stream != nil && cli.ackOffset >= offset {
+			n++
+		}
+	}
+	if srv.acked == nil {
+		srv.acked = make(chan struct{})
+	}
+	return n, srv.acked
+}
+
+// ack records the offset acknowledged by the replica of cli, and wakes up WAIT.
+func (srv *Server) ack(cli *Client, offset int64) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	cli.ackOffset = offset
+	cli.ackTime = time.Now()
+	if srv.acked != nil {
+		close(srv.acked)
+		srv.acked = nil
+	}
+}
+
+// client returns the connected client of id, nil if not found.
+func (srv *Server) client(id int64) *Client {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for _, cli := range srv.clients {
+		if cli.id == id {
+			return cli
+		}
+	}
+	return nil
+}
+
+// isReplica reports whether the server is a replica of another server.
+func (srv *Server) isReplica() bool {
+	srv.replMu.Lock()
+	defer srv.replMu.Unlock()
+	return srv.primary != nil
+}
+
+// checkWritable rejects the write commands of clients, if the server is a read-only replica.
+func (srv *Server) checkWritable(name string) error {
+	if !commands.IsWrite(name) || !srv.isReplica() {
+		return nil
+	}
+	srv.configLock.Lock()
+	readOnly := srv.opts.replicaReadOnly
+	srv.configLock.Unlock()
+	if readOnly {
+		return commands.ErrReadOnlyReplica
+	}
+	return nil
+}
+
+// serveReplica continues the stream of the replica of cli from the backlog, or sends
+// it a snapshot, then streams the write commands until the connection is closed.
+func (srv *Server) serveReplica(cli *Client) {
+	addr := cli.conn.RemoteAddr().String()
+	// PSYNC 的 offset 是 replica 需要的下一个字节, 从 1 开始
+	stream, err := srv.db.ResumeReplica(cli.psyncID, cli.psyncOffset-1, engine.DefaultReplicaBufferLimit)
+	if err == nil {
+		srv.log.Info("[Server serveReplica] partial resync", zap.String("replica", addr), zap.Int64("offset", stream.Offset()))
+		err = cli.write(func(wr *proto.Writer) error {
+			return wr.ReplyStatus("CONTINUE " + cli.psyncID)
+		})
+		if err != nil {
+			stream.Close()
+		}
+	} else {
+		stream, err = srv.sendSnapshot(cli)
+	}
+	if err != nil {
+		srv.log.Warn("[Server serveReplica] sync", zap.String("replica", addr), zap.String("err", err.Error()))
+		return
+	}
+	defer stream.Close()
+
+	srv.mu.Lock()
+	cli.stream = stream
+	cli.ackTime = time.Now()
+	srv.mu.Unlock()
+
+	// replica 只会发送 REPLCONF ACK, 读取失败说明连接已断开
+	go func() {
+		defer stream.Close()
+		for {
+			var args []string
+			err := cli.conn.WithReader(context.Background(), 0, func(rd *proto.Reader) (err error) {
+				args, err = rd.ReadCommand()
+				return err
+			})
+			if err != nil {
+				return
+			}
+			if len(args) == 3 && strings.EqualFold(args[0], "replconf") && strings.EqualFold(args[1], "ack") {
+				if offset, err := strconv.ParseInt(args[2], 10, 64); err == nil {
+					srv.ack(cli, offset)
+				}
+			}
+		}
+	}()
+
+	for {
+		b, err := stream.Read()
+		if err == nil {
+			err = cli.writeRaw(func(w io.Writer) error {
+				_, err := w.Write(b)
+				return err
+			})
+		}
+		if err != nil {
+			srv.log.Warn("[Server serveReplica] lost the replica", zap.String("replica", addr), zap.String("err", err.Error()))
+			return
+		}
+	}
+}
+
+// sendSnapshot sends a snapshot to the replica of cli, and returns the stream of the
+// write commands after it.
+func (srv *Server) sendSnapshot(cli *Client) (*engine.ReplicaStream, error) {
+	addr := cli.conn.RemoteAddr().String()
+	replyErr := func(err error) error {
+		srv.log.Error("[Server sendSnapshot] full sync", zap.String("replica", addr), zap.String("err", err.Error()))
+		cli.write(func(wr *proto.Writer) error {
+			return wr.ReplyErr(fmt.Errorf("ERR full sync failed: %v", err))
+		})
+		return err
+	}
+
+	// 快照先写入临时文件, 以便在发送前得到其长度
+	file, err := ioutil.TempFile(srv.opts.dir, engine.TempFilenamePrefix+"repl-*"+engine.DBSuffix)
+	if err != nil {
+		return nil, replyErr(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	// 快照与之后的写命令属于同一复制流
+	replID := srv.db.ReplInfo().ID
+	stream, err := srv.db.SyncReplica(file, engine.DefaultReplicaBufferLimit)
+	if err != nil {
+		return nil, replyErr(err)
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		stream.Close()
+		return nil, replyErr(err)
+	}
+	srv.log.Info("[Server sendSnapshot] full sync", zap.String("replica", addr), zap.Int64("offset", stream.Offset()), zap.Int64("bytes", size))
+
+	err = cli.write(func(wr *proto.Writer) error {
+		return wr.ReplyStatus(fmt.Sprintf("FULLRESYNC %s %d", replID, stream.Offset()))
+	})
+	if err == nil {
+		err = cli.writeRaw(func(w io.Writer) error {
+			if _, err := fmt.Fprintf(w, "$%d\r\n", size); err != nil {
+				return err
+			}
+			_, err := io.Copy(w, file)
+			return err
+		})
+	}
+	if err != nil {
+		stream.Close()
+		return nil, err
+	}
+	return stream, nil
+}
+
+// replicate syncs with the primary of link, and reconnects after the link is broken,
+// until link is closed.
+func (srv *Server) replicate(link *primaryLink) {
+	for {
+		err := srv.syncWithPrimary(link)
+		select {
+		case <-link.stop:
+			return
+		default:
+		}
+		srv.log.Warn("[Server replicate] lost the primary", zap.String("primary", link.addr()), zap.String("err", err.Error()))
+		link.setState(replStateConnect)
+
+		select {
+		case <-link.stop:
+			return
+		case <-time.After(replReconnectDelay):
+		}
+	}
+}
+
+// syncWithPrimary connects to the primary, continues from the offset applied or loads
+// a snapshot, then applies the write commands streamed, until the connection fails.
+func (srv *Server) syncWithPrimary(link *primaryLink) error {
+	link.setState(replStateConnecting)
+	netConn, err := net.DialTimeout("tcp", link.addr(), replTimeout)
+	if err != nil {
+		return err
+	}
+	defer netConn.Close()
+	if err := link.setConn(netConn); err != nil {
+		return err
+	}
+	conn := proto.NewConn(netConn)
+
+	srv.configLock.Lock()
+	user, pass, port := srv.opts.masterUser, srv.opts.masterAuth, srv.opts.port
+	srv.configLock.Unlock()
+	if pass != "" {
+		args := []interface{}{"auth", pass}
+		if user != "" {
+			args = []interface{}{"auth", user, pass}
+		}
+		if _, err := request(conn, args...); err != nil {
+			return fmt.Errorf("AUTH: %v", err)
+		}
+	}
+	if _, err := request(conn, "replconf", "listening-port", port); err != nil {
+		return fmt.Errorf("REPLCONF: %v", err)
+	}
+
+	replID, offset := link.position()
+	psync := []interface{}{"psync", "?", "-1"}
+	if link.applier != nil {
+		psync = []interface{}{"psync", replID, strconv.FormatInt(offset+1, 10)}
+	}
+	reply, err := request(conn, psync...)
+	if err != nil {
+		return fmt.Errorf("PSYNC: %v", err)
+	}
+	s, _ := reply.(string)
+	fields := strings.Fields(s)
+	switch {
+	case len(fields) >= 1 && fields[0] == "CONTINUE" && link.applier != nil:
+		if len(fields) == 2 {
+			replID = fields[1]
+		}
+		link.synced(replID, offset)
+		srv.log.Info("[Server syncWithPrimary] partial resync", zap.String("primary", link.addr()), zap.Int64("offset", offset))
+	case len(fields) == 3 && fields[0] == "FULLRESYNC":
+		replID = fields[1]
+		if offset, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+			return fmt.Errorf("unexpected reply of PSYNC: %v", reply)
+		}
+		// 加载失败后不能再从之前的位置继续
+		link.applier = nil
+		if err := srv.loadSnapshot(link, conn); err != nil {
+			return fmt.Errorf("full sync: %v", err)
+		}
+		link.applier = srv.db.NewReplicaApplier()
+		link.synced(replID, offset)
+		srv.log.Info("[Server syncWithPrimary] full sync finished", zap.String("primary", link.addr()), zap.Int64("offset", offset))
+	default:
+		return fmt.Errorf("unexpected reply of PSYNC: %v", reply)
+	}
+
+	// 应用完已收到的命令后, 以及每隔 replAckInterval, 确认已应用的位置
+	var wmu sync.Mutex
+	ack := func() error {
+		_, offset := link.position()
+		wmu.Lock()
+		defer wmu.Unlock()
+		return conn.WithWriter(context.Background(), replTimeout, func(wr *proto.Writer) error {
+			return wr.ReplyArrays([]interface{}{"replconf", "ack", strconv.FormatInt(offset, 10)})
+		})
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(replAckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if ack() != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		var args []string
+		var idle bool
+		err := conn.WithReader(context.Background(), 0, func(rd *proto.Reader) (err error) {
+			args, err = rd.ReadCommand()
+			idle = rd.Buffered() == 0
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if err := link.applier.Apply(args); err != nil {
+			srv.log.Warn("[Server syncWithPrimary] apply", zap.Strings("args", args), zap.String("err", err.Error()))
+		}
+		link.addOffset(commandLen(args))
+		if idle {
+			if err := ack(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// loadSnapshot receives the snapshot from the primary, and replaces the dataset.
+func (srv *Server) loadSnapshot(link *primaryLink, conn *proto.Conn) error {
+	// 先接收到临时文件, 加载时才阻塞命令
+	link.setState(replStateSync)
+	file, err := ioutil.TempFile(srv.opts.dir, engine.TempFilenamePrefix+"repl-*"+engine.DBSuffix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	err = conn.WithReader(context.Background(), replTimeout, func(rd *proto.Reader) error {
+		payload, size, err := rd.ReadBulkStream()
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(file, payload)
+		if err == nil && n != size {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	})
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return err
+	}
+	srv.log.Info("[Server loadSnapshot] loading the snapshot", zap.String("primary", link.addr()))
+	return srv.db.Load(bufio.NewReader(file))
+}
+
+// request sends a command to the primary, and reads its reply.
+func request(conn *proto.Conn, args ...interface{}) (interface{}, error) {
+	err := conn.WithWriter(context.Background(), replTimeout, func(wr *proto.Writer) error {
+		return wr.ReplyArrays(args)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var reply interface{}
+	err = conn.WithReader(context.Background(), replTimeout, func(rd *proto.Reader) (err error) {
+		reply, err = rd.ReadReply()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := reply.(proto.RedisError); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+// commandLen returns the bytes of args encoded as an array of bulk strings, which
+// is how the commands are streamed.
+func commandLen(args []string) int64 {
+	n := 1 + len(strconv.Itoa(len(args))) + 2
+	for _, arg := range args {
+		n += 1 + len(strconv.Itoa(len(arg))) + 2 + len(arg) + 2
+	}
+	return int64(n)
+}
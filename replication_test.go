@@ -0,0 +1,151 @@
+package gres
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clovers4/gres/commands"
+	"github.com/clovers4/gres/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+// waitFor polls cond until it is true, or fails the test after 5 seconds.
+func waitFor(t *testing.T, msg string, cond func() bool) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for %v", msg)
+}
+
+// serveTest serves srv on a random port of localhost, and returns the port.
+func serveTest(t *testing.T, srv *Server) (int, func()) {
+	srv.pubsub = newPubsub()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go srv.serve(lis)
+	return lis.Addr().(*net.TCPAddr).Port, func() { lis.Close() }
+}
+
+func TestServer_Replication(t *testing.T) {
+	primary := newConfigServer(defaultServerOptions)
+	port, stop := serveTest(t, primary)
+	defer stop()
+	assert.Nil(t, primary.db.Set("a", []byte("A")))
+
+	replica := newConfigServer(defaultServerOptions)
+	replica.pubsub = newPubsub()
+	assert.Nil(t, replica.ReplicaOf("127.0.0.1", strconv.Itoa(port)))
+	defer replica.ReplicaOf("", "")
+	assert.Equal(t, "127.0.0.1 "+strconv.Itoa(port), replica.opts.replicaOf)
+
+	// 全量同步
+	waitFor(t, "full sync", func() bool { return replica.db.Exists("a") })
+
+	// 之后的写命令
+	netConn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	assert.Nil(t, err)
+	defer netConn.Close()
+	conn := proto.NewConn(netConn)
+	for _, args := range [][]interface{}{
+		{"select", 1},
+		{"set", "b", "B"},
+		{"multi"}, {"set", "c", "C"}, {"del", "b"}, {"exec"},
+	} {
+		_, err := request(conn, args...)
+		assert.Nil(t, err)
+	}
+	db1, _ := replica.db.Select(1)
+	waitFor(t, "streamed commands", func() bool { return db1.Exists("c") })
+	assert.False(t, db1.Exists("b"))
+
+	role := replica.Role()
+	assert.Equal(t, "slave", role[0])
+	assert.Equal(t, replStateConnected, role[3])
+	assert.Equal(t, int(primary.db.ReplOffset()), role[4].(*proto.Reply).Val)
+	role = primary.Role()
+	assert.Equal(t, "master", role[0])
+	assert.Equal(t, 1, len(role[2].([]interface{})))
+
+	// replica 默认只读
+	assert.Equal(t, commands.ErrReadOnlyReplica, replica.checkWritable("set"))
+	assert.Nil(t, replica.checkWritable("get"))
+	assert.Nil(t, replica.ConfigSet("replica-read-only", "no"))
+	assert.Nil(t, replica.checkWritable("set"))
+	assert.Nil(t, primary.checkWritable("set"))
+
+	// WAIT 等待 replica 确认之前的写命令
+	n, err := primary.Wait(context.Background(), 1, 5*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	start := time.Now()
+	n, _ = primary.Wait(context.Background(), 2, 50*time.Millisecond)
+	assert.Equal(t, 1, n)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	_, err = replica.Wait(context.Background(), 1, 0)
+	assert.Equal(t, errWaitOnReplica, err)
+
+	info := strings.Join(primary.Info(), " ")
+	assert.Contains(t, info, "role master connected_slaves 1 slave0 ip=127.0.0.1,port=")
+	assert.Contains(t, info, "master_repl_offset "+strconv.FormatInt(primary.db.ReplOffset(), 10))
+	assert.Contains(t, info, "repl_backlog_active 1")
+	info = strings.Join(replica.Info(), " ")
+	assert.Contains(t, info, "role slave master_host 127.0.0.1 master_port "+strconv.Itoa(port)+" master_link_status up")
+
+	disconnect := func() {
+		primary.mu.Lock()
+		for _, cli := range primary.clients {
+			if cli.stream != nil {
+				cli.conn.Close()
+			}
+		}
+		primary.mu.Unlock()
+	}
+
+	// 断开后自动重连, 从 backlog 继续, 不会再次加载快照
+	assert.Nil(t, replica.db.Set("local", []byte("L")))
+	disconnect()
+	_, err = request(conn, "set", "d", "D")
+	assert.Nil(t, err)
+	waitFor(t, "reconnect", func() bool { return db1.Exists("d") })
+	assert.True(t, replica.db.Exists("local"))
+
+	// backlog 不再包含断开的位置时, 全量同步
+	waitFor(t, "ack", func() bool {
+		n, _ := primary.Wait(context.Background(), 1, time.Millisecond)
+		return n == 1
+	})
+	assert.Nil(t, primary.ConfigSet("repl-backlog-size", "16kb"))
+	disconnect()
+	_, err = request(conn, "set", "e", strings.Repeat("E", 20*1024))
+	assert.Nil(t, err)
+	waitFor(t, "full sync again", func() bool { return db1.Exists("e") })
+	assert.False(t, replica.db.Exists("local"))
+
+	assert.Nil(t, replica.ReplicaOf("", ""))
+	assert.False(t, replica.isReplica())
+	assert.Equal(t, "master", replica.Role()[0])
+	assert.True(t, db1.Exists("c"))
+}
+
+func TestParseReplicaOf(t *testing.T) {
+	host, port, err := parseReplicaOf(" 127.0.0.1  6379 ")
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1", host)
+	assert.Equal(t, "6379", port)
+
+	host, _, err = parseReplicaOf("")
+	assert.Nil(t, err)
+	assert.Equal(t, "", host)
+
+	_, _, err = parseReplicaOf("127.0.0.1")
+	assert.NotNil(t, err)
+	_, _, err = parseReplicaOf("127.0.0.1 port")
+	assert.NotNil(t, err)
+}
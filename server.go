@@ -1,19 +1,26 @@
 package gres
 
 import (
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/clovers4/gres/util"
 	"net"
 	"os"
 	"os/signal"
-	"runtime"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/clovers4/gres/commands"
 	"github.com/clovers4/gres/engine"
+	"github.com/clovers4/gres/raft"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 const (
@@ -26,7 +33,36 @@ const (
 )
 
 var (
-	port = flag.Int("p", 9876, "specify port to use.  defaults to 9876.")
+	configFile  = flag.String("config", "", "path of the config file, like redis.conf.")
+	port        = flag.Int("p", 9876, "specify port to use.  defaults to 9876.")
+	bind        = flag.String("bind", "127.0.0.1", "addresses to listen on, separated by spaces, such as \"127.0.0.1 ::1\".")
+	dir         = flag.String("dir", "", "directory of the data files and the log. defaults to the current directory.")
+	dbFilename  = flag.String("dbfilename", engine.DefaultDBFilename, "prefix of the data files, such as gres for gres_<stamp>.db.")
+	databases   = flag.Int("databases", engine.DefaultDbnum, "number of databases.")
+	appendOnly  = flag.Bool("appendonly", false, "log every write command to the append-only file.")
+	appendFsync = flag.String("appendfsync", "everysec", "fsync policy of the append-only file: always, everysec or no.")
+
+	unixSocket     = flag.String("unixsocket", "", "path of the unix socket to listen on. empty means disabled.")
+	unixSocketPerm = flag.String("unixsocketperm", "0", "permission of the unix socket in octal, such as 700. 0 means by umask.")
+
+	tlsPort       = flag.Int("tls-port", 0, "specify TLS port to use. 0 means disabled.")
+	tlsCertFile   = flag.String("tls-cert-file", "", "certificate of the server for the TLS port, in PEM.")
+	tlsKeyFile    = flag.String("tls-key-file", "", "private key of the certificate, in PEM.")
+	tlsCACertFile = flag.String("tls-ca-cert-file", "", "CA certificates to verify the clients, in PEM.")
+	tlsAuthClient = flag.String("tls-auth-clients", "no", "whether clients must send a certificate: no, optional or yes.")
+	tlsMinVersion = flag.String("tls-min-version", "TLSv1.2", "min version of TLS, such as TLSv1.2.")
+
+	maxMemory        = flag.String("maxmemory", "0", "memory limit of the dataset, such as 100mb. 0 means no limit.")
+	maxMemoryPolicy  = flag.String("maxmemory-policy", "noeviction", "how to evict keys when maxmemory is reached.")
+	maxMemorySamples = flag.Int("maxmemory-samples", 5, "number of keys sampled for each eviction.")
+
+	notifyKeyspaceEvents = flag.String("notify-keyspace-events", "", "classes of the keyspace events to publish, such as KEA. empty means disabled.")
+
+	replicaOf = flag.String("replicaof", "", "make the server a replica of \"host port\". empty means a primary.")
+
+	raftEnabled = flag.Bool("raft-enabled", false, "replicate the write commands by a raft group.")
+	raftID      = flag.String("raft-id", "", "address of the node in the raft group. defaults to the first bind address and the port.")
+	raftPeers   = flag.String("raft-peers", "", "addresses of the initial members, separated by spaces. empty means only the node itself.")
 )
 
 func init() {
@@ -34,12 +70,25 @@ func init() {
 }
 
 type Server struct {
-	opts serverOptions
+	opts       serverOptions
+	configLock sync.Mutex // 保护运行中被 CONFIG SET 修改的 opts
 	// db
 	db *engine.DB
+	// pub/sub
+	pubsub *pubsub
+	// users
+	acl *commands.ACL
+	// replication
+	primary *primaryLink  // 作为 replica 时与 primary 的连接, nil 表示是 primary
+	acked   chan struct{} // replica 回复 ACK 时关闭, 唤醒 WAIT
+	replMu  sync.Mutex
+	// raft mode, nil 表示未启用
+	raft *raftGroup
 	//	networking
-	clients []*Client
-	log     *zap.Logger
+	clients      []*Client
+	nextClientID int64
+	log          *zap.Logger
+	logLevel     zap.AtomicLevel
 
 	mu sync.Mutex
 
@@ -49,26 +98,153 @@ type Server struct {
 type serverOptions struct {
 	configFile        string
 	port              int
+	bind              string // 监听的地址, 以空格分隔
+	unixSocket        string // unix socket 的路径, 空表示不监听
+	unixSocketPerm    os.FileMode
+	tlsPort           int // 0 表示不监听
+	tlsCertFile       string
+	tlsKeyFile        string
+	tlsCACertFile     string
+	tlsAuthClients    tlsAuthClients
+	tlsMinVersion     uint16
+	dbnum             int
+	dir               string // 数据目录, 空表示当前目录
+	dbFilename        string // 数据文件名的前缀
+	persistTime       time.Duration
 	connectionTimeout time.Duration
+	appendOnly        bool
+	appendFsync       engine.AppendFsync
+	maxMemory         uint64
+	maxMemoryPolicy   engine.EvictPolicy
+	maxMemorySamples  int
+	notifyClasses     engine.NotifyClass
+	activeExpire      engine.ActiveExpireConfig
+	logLevel          zapcore.Level
+	logFile           string // 空表示 db_<unix>.log
+	requirePass       string // default 用户的密码, 空表示不需要密码
+	aclFile           string // ACL LOAD 与 ACL SAVE 使用的文件, 相对路径以配置文件所在目录为准
+	replicaOf         string // primary 的 "host port", 空表示是 primary
+	masterUser        string // 连接 primary 时 AUTH 的用户与密码
+	masterAuth        string
+	replicaReadOnly   bool // replica 是否拒绝客户端的写命令
+	replBacklogSize   int  // 断开的 replica 可以从中继续的字节数
+	raftEnabled       bool
+	raftID            string // 节点在 raft group 中的地址, 空表示第一个监听的地址
+	raftPeers         string // 初始成员的地址, 以空格分隔; 不包含自己时等待 leader 加入
+	raftSnapEntries   int    // 快照之后应用了多少条日志时, 生成新的快照
+	raftHeartbeat     time.Duration
+	raftElection      time.Duration // 选举超时时间
 }
 
 var defaultServerOptions = serverOptions{
 	port:              9876,
+	bind:              "127.0.0.1",
+	dbnum:             engine.DefaultDbnum,
+	dbFilename:        engine.DefaultDBFilename,
+	tlsAuthClients:    tlsAuthClientsNo,
+	tlsMinVersion:     tls.VersionTLS12,
+	persistTime:       1 * time.Second,
 	connectionTimeout: 120 * time.Second,
+	appendFsync:       engine.AppendFsyncEverySec,
+	maxMemoryPolicy:   engine.EvictNoEviction,
+	maxMemorySamples:  5,
+	activeExpire:      engine.DefaultActiveExpireConfig,
+	logLevel:          zapcore.InfoLevel,
+	replicaReadOnly:   true,
+	replBacklogSize:   engine.DefaultReplBacklogSize,
+
+	raftSnapEntries: raft.DefaultSnapshotEntries,
+	raftHeartbeat:   raft.DefaultHeartbeatInterval,
+	raftElection:    raft.DefaultElectionTimeout,
 }
 
 // A ServerOption sets options such as keepalive parameters, etc.
 type ServerOption func(opts *serverOptions)
 
+// readFlag only overrides the options whose flags are set explicitly.
 func (opt *serverOptions) readFlag() {
-	opt.port = *port
-}
-
-func (opt *serverOptions) readConfigFile() {
-	// todo:
-	if opt.configFile != "" {
-
-	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "config":
+			opt.configFile = *configFile
+		case "p":
+			opt.port = *port
+		case "bind":
+			opt.bind = *bind
+		case "unixsocket":
+			opt.unixSocket = *unixSocket
+		case "unixsocketperm":
+			if err := parsePerm(*unixSocketPerm, &opt.unixSocketPerm); err != nil {
+				panic(err)
+			}
+		case "tls-port":
+			opt.tlsPort = *tlsPort
+		case "tls-cert-file":
+			opt.tlsCertFile = *tlsCertFile
+		case "tls-key-file":
+			opt.tlsKeyFile = *tlsKeyFile
+		case "tls-ca-cert-file":
+			opt.tlsCACertFile = *tlsCACertFile
+		case "tls-auth-clients":
+			auth, err := parseTLSAuthClients(*tlsAuthClient)
+			if err != nil {
+				panic(err)
+			}
+			opt.tlsAuthClients = auth
+		case "tls-min-version":
+			version, err := util.ParseTLSVersion(*tlsMinVersion)
+			if err != nil {
+				panic(err)
+			}
+			opt.tlsMinVersion = version
+		case "dir":
+			opt.dir = *dir
+		case "dbfilename":
+			opt.dbFilename = *dbFilename
+		case "databases":
+			opt.dbnum = *databases
+		case "appendonly":
+			opt.appendOnly = *appendOnly
+		case "appendfsync":
+			fsync, err := engine.ParseAppendFsync(*appendFsync)
+			if err != nil {
+				panic(err)
+			}
+			opt.appendFsync = fsync
+		case "maxmemory":
+			bytes, err := util.ParseMemory(*maxMemory)
+			if err != nil {
+				panic(err)
+			}
+			opt.maxMemory = bytes
+		case "maxmemory-policy":
+			policy, err := engine.ParseEvictPolicy(*maxMemoryPolicy)
+			if err != nil {
+				panic(err)
+			}
+			opt.maxMemoryPolicy = policy
+		case "maxmemory-samples":
+			opt.maxMemorySamples = *maxMemorySamples
+		case "notify-keyspace-events":
+			classes, err := engine.ParseNotifyClasses(*notifyKeyspaceEvents)
+			if err != nil {
+				panic(err)
+			}
+			opt.notifyClasses = classes
+		case "replicaof":
+			host, port, err := parseReplicaOf(*replicaOf)
+			if err != nil {
+				panic(err)
+			}
+			opt.replicaOf = strings.TrimSpace(host + " " + port)
+		case "raft-enabled":
+			opt.raftEnabled = *raftEnabled
+		case "raft-id":
+			opt.raftID = *raftID
+		case "raft-peers":
+			opt.raftPeers = strings.Join(strings.Fields(*raftPeers), " ")
+		}
+	})
 }
 
 func ConfigFileOption(f string) ServerOption {
@@ -85,6 +261,76 @@ func PortOption(p int) ServerOption {
 	}
 }
 
+// BindOption sets the addresses to listen on, separated by spaces.
+func BindOption(addrs string) ServerOption {
+	return func(opts *serverOptions) {
+		opts.bind = addrs
+	}
+}
+
+// UnixSocketOption sets the path of the unix socket to listen on, in addition to TCP.
+func UnixSocketOption(path string) ServerOption {
+	return func(opts *serverOptions) {
+		opts.unixSocket = path
+	}
+}
+
+// UnixSocketPermOption sets the permission of the unix socket, 0 means by umask.
+func UnixSocketPermOption(perm os.FileMode) ServerOption {
+	return func(opts *serverOptions) {
+		opts.unixSocketPerm = perm
+	}
+}
+
+// TLSOption listens on the TLS port with the certificate and its private key.
+func TLSOption(port int, certFile, keyFile string) ServerOption {
+	return func(opts *serverOptions) {
+		opts.tlsPort = port
+		opts.tlsCertFile = certFile
+		opts.tlsKeyFile = keyFile
+	}
+}
+
+// TLSAuthClientsOption verifies the certificates of the clients by the CA certificates.
+// The clients must send a certificate if required, or it is only verified if given.
+func TLSAuthClientsOption(caCertFile string, required bool) ServerOption {
+	return func(opts *serverOptions) {
+		opts.tlsCACertFile = caCertFile
+		opts.tlsAuthClients = tlsAuthClientsOptional
+		if required {
+			opts.tlsAuthClients = tlsAuthClientsYes
+		}
+	}
+}
+
+// TLSMinVersionOption sets the min version of TLS, such as tls.VersionTLS12.
+func TLSMinVersionOption(version uint16) ServerOption {
+	return func(opts *serverOptions) {
+		opts.tlsMinVersion = version
+	}
+}
+
+// DirOption sets the directory of the data files and the log.
+func DirOption(dir string) ServerOption {
+	return func(opts *serverOptions) {
+		opts.dir = dir
+	}
+}
+
+// DBFilenameOption sets the prefix of the data files.
+func DBFilenameOption(name string) ServerOption {
+	return func(opts *serverOptions) {
+		opts.dbFilename = name
+	}
+}
+
+// DbnumOption sets the number of databases.
+func DbnumOption(n int) ServerOption {
+	return func(opts *serverOptions) {
+		opts.dbnum = n
+	}
+}
+
 // ConnectionTimeoutOption set the time duration of connectionTimeout.
 // The connection will be auto closed after the timeout
 // MAYBE just for test, benchmark, etc.
@@ -94,6 +340,75 @@ func ConnectionTimeoutOption(t time.Duration) ServerOption {
 	}
 }
 
+// AppendOnlyOption enables the append-only file, which logs every write command.
+func AppendOnlyOption(appendOnly bool) ServerOption {
+	return func(opts *serverOptions) {
+		opts.appendOnly = appendOnly
+	}
+}
+
+// AppendFsyncOption sets the fsync policy of the append-only file.
+func AppendFsyncOption(fsync engine.AppendFsync) ServerOption {
+	return func(opts *serverOptions) {
+		opts.appendFsync = fsync
+	}
+}
+
+// MaxMemoryOption sets the memory limit of the dataset in bytes, 0 means no limit.
+func MaxMemoryOption(bytes uint64) ServerOption {
+	return func(opts *serverOptions) {
+		opts.maxMemory = bytes
+	}
+}
+
+// MaxMemoryPolicyOption sets how to evict keys when maxmemory is reached.
+func MaxMemoryPolicyOption(policy engine.EvictPolicy) ServerOption {
+	return func(opts *serverOptions) {
+		opts.maxMemoryPolicy = policy
+	}
+}
+
+// MaxMemorySamplesOption sets the number of keys sampled for each eviction.
+func MaxMemorySamplesOption(samples int) ServerOption {
+	return func(opts *serverOptions) {
+		opts.maxMemorySamples = samples
+	}
+}
+
+// NotifyKeyspaceEventsOption sets the classes of the keyspace events published
+// to the pub/sub channels.
+func NotifyKeyspaceEventsOption(classes engine.NotifyClass) ServerOption {
+	return func(opts *serverOptions) {
+		opts.notifyClasses = classes
+	}
+}
+
+// ReplicaOfOption makes the server a replica of the primary at host:port.
+func ReplicaOfOption(host string, port int) ServerOption {
+	return func(opts *serverOptions) {
+		opts.replicaOf = host + " " + strconv.Itoa(port)
+	}
+}
+
+// RaftOption enables raft mode, the server is the node of id in the raft group. peers
+// are the initial members, or the node waits to be added by the leader if they do
+// not include id.
+func RaftOption(id string, peers ...string) ServerOption {
+	return func(opts *serverOptions) {
+		opts.raftEnabled = true
+		opts.raftID = id
+		opts.raftPeers = strings.Join(peers, " ")
+	}
+}
+
+// RaftSnapshotEntriesOption sets the number of entries applied after the last
+// snapshot, which triggers a new snapshot of the raft log.
+func RaftSnapshotEntriesOption(n int) ServerOption {
+	return func(opts *serverOptions) {
+		opts.raftSnapEntries = n
+	}
+}
+
 // NewServer creates a gres server, ready to Serve.
 func NewServer(opt ...ServerOption) *Server {
 	opts := defaultServerOptions
@@ -101,29 +416,114 @@ func NewServer(opt ...ServerOption) *Server {
 		o(&opts)
 	}
 	opts.readFlag()
-	opts.readConfigFile()
+	if err := opts.readConfigFile(); err != nil {
+		panic(err)
+	}
+
+	if opts.dir != "" {
+		dir, err := filepath.Abs(opts.dir)
+		if err != nil {
+			panic(err)
+		}
+		opts.dir = dir
+	}
 
-	logFilename := fmt.Sprintf("db_%v.log", time.Now().Unix())
+	logFilename := opts.logFile
+	if logFilename == "" {
+		logFilename = fmt.Sprintf("db_%v.log", time.Now().Unix())
+	}
+	if !filepath.IsAbs(logFilename) {
+		logFilename = filepath.Join(opts.dir, logFilename)
+	}
 	logHook, err := util.FileLogHook(logFilename)
 	if err != nil {
 		panic(err)
 	}
-	log, err := zap.NewProduction(zap.Hooks(logHook))
+	logLevel := zap.NewAtomicLevelAt(opts.logLevel)
+	logConfig := zap.NewProductionConfig()
+	logConfig.Level = logLevel
+	log, err := logConfig.Build(zap.Hooks(logHook))
 	if err != nil {
 		panic(err)
 	}
 	log.Info(fmt.Sprintf("server-options:%+v", opts))
 
+	setMemoryLimit(opts.maxMemory)
+
 	srv := &Server{
-		opts: opts,
-		log:  log,
+		opts:     opts,
+		pubsub:   newPubsub(),
+		acl:      commands.NewACL(),
+		log:      log,
+		logLevel: logLevel,
 	}
+	if err := srv.loadACL(); err != nil {
+		panic(err)
+	}
+	// raft mode 下, 数据由 raft 的日志与快照持久化
 	srv.db = engine.NewDB(
-		engine.PersistOption(true),
+		engine.PersistOption(!opts.raftEnabled),
+		engine.PersistTimeOption(opts.persistTime),
+		engine.DirOption(opts.dir),
+		engine.DBFilenameOption(opts.dbFilename),
+		engine.DbnumOption(opts.dbnum),
+		engine.AppendOnlyOption(opts.appendOnly && !opts.raftEnabled),
+		engine.AppendFsyncOption(opts.appendFsync),
+		engine.MaxMemoryOption(opts.maxMemory),
+		engine.MaxMemoryPolicyOption(opts.maxMemoryPolicy),
+		engine.MaxMemorySamplesOption(opts.maxMemorySamples),
+		engine.NotifyKeyspaceEventsOption(opts.notifyClasses),
+		engine.ActiveExpireHzOption(opts.activeExpire.Hz),
+		engine.ActiveExpireKeysPerLoopOption(opts.activeExpire.KeysPerLoop),
+		engine.ActiveExpireAcceptableStaleOption(opts.activeExpire.AcceptableStale),
+		engine.ActiveExpireCyclePercentOption(opts.activeExpire.CyclePercent),
+		engine.ReplBacklogSizeOption(opts.replBacklogSize),
+		engine.NotifyFuncOption(func(channel, message string) {
+			srv.pubsub.Publish(channel, message)
+		}),
 		engine.LogOption(log))
+
+	if opts.raftEnabled {
+		if srv.raft, err = newRaftGroup(srv); err != nil {
+			panic(err)
+		}
+	}
+
+	host, port, err := parseReplicaOf(opts.replicaOf)
+	if err != nil {
+		panic(err)
+	}
+	if host != "" {
+		if err := srv.ReplicaOf(host, port); err != nil {
+			panic(err)
+		}
+	}
 	return srv
 }
 
+// loadACL reads the users from the aclfile if it exists, then applies requirepass
+// to the default user.
+func (srv *Server) loadACL() error {
+	if filename := srv.opts.aclFile; filename != "" {
+		if !filepath.IsAbs(filename) && srv.opts.configFile != "" {
+			filename = filepath.Join(filepath.Dir(srv.opts.configFile), filename)
+		}
+		srv.acl.SetFilename(filename)
+		// 文件不存在时, 由 ACL SAVE 创建
+		if _, err := os.Stat(filename); err == nil {
+			if err := srv.acl.Load(); err != nil {
+				return err
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if srv.opts.requirePass != "" {
+		return setRequirePass(srv.acl, srv.opts.requirePass)
+	}
+	return nil
+}
+
 func (srv *Server) Start() {
 	srv.listenExist()
 	srv.listenAndServe()
@@ -139,18 +539,120 @@ func (srv *Server) Start() {
 // Serve will return a non-nil error unless Stop or GracefulStop is called.
 func (srv *Server) listenAndServe() {
 	// todo:	signal.Notify(quitCh, os.Kill, os.Interrupt, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
-	addr := fmt.Sprintf("%s:%d", "127.0.0.1", srv.opts.port) // net.ResolveTCPAddr(
-	lis, err := net.Listen("tcp", addr)
+	listeners, err := srv.listen()
 	if err != nil {
 		panic(err)
 	}
-	srv.log.Info("gres start serving.", zap.String("addr", addr))
 
+	var wg sync.WaitGroup
+	for _, lis := range listeners {
+		wg.Add(1)
+		go func(lis net.Listener) {
+			defer wg.Done()
+			srv.serve(lis)
+		}(lis)
+	}
+	wg.Wait()
+}
+
+// listen listens on the TCP addresses and the unix socket. It fails if any of them fails,
+// and the ones already listened on are closed.
+func (srv *Server) listen() ([]net.Listener, error) {
+	var listeners []net.Listener
+	closeAll := func() {
+		for _, lis := range listeners {
+			lis.Close()
+		}
+	}
+
+	// port 为 0 时不监听 TCP
+	if srv.opts.port != 0 {
+		for _, addr := range srv.listenAddrs() {
+			lis, err := net.Listen("tcp", addr)
+			if err != nil {
+				closeAll()
+				return nil, err
+			}
+			srv.log.Info("gres start serving.", zap.String("addr", addr))
+			listeners = append(listeners, lis)
+		}
+	}
+
+	if srv.opts.tlsPort != 0 {
+		conf, err := srv.opts.tlsConfig()
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		for _, addr := range srv.tlsListenAddrs() {
+			lis, err := tls.Listen("tcp", addr, conf)
+			if err != nil {
+				closeAll()
+				return nil, err
+			}
+			srv.log.Info("gres start serving.", zap.String("tls addr", addr))
+			listeners = append(listeners, lis)
+		}
+	}
+
+	if path := srv.opts.unixSocket; path != "" {
+		// 删除上次未正常退出时残留的 socket 文件
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			closeAll()
+			return nil, err
+		}
+		lis, err := net.Listen("unix", path)
+		if err == nil && srv.opts.unixSocketPerm != 0 {
+			if err = os.Chmod(path, srv.opts.unixSocketPerm); err != nil {
+				lis.Close()
+			}
+		}
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		srv.log.Info("gres start serving.", zap.String("unixsocket", path))
+		listeners = append(listeners, lis)
+	}
+
+	if len(listeners) == 0 {
+		return nil, errors.New("nothing to listen on, port, tls-port and unixsocket are all disabled")
+	}
+	return listeners, nil
+}
+
+// listenAddrs returns the addresses to listen on. "*" means all the interfaces.
+func (srv *Server) listenAddrs() []string {
+	return srv.bindAddrs(srv.opts.port)
+}
+
+// tlsListenAddrs returns the addresses of the tls port to listen on.
+func (srv *Server) tlsListenAddrs() []string {
+	return srv.bindAddrs(srv.opts.tlsPort)
+}
+
+func (srv *Server) bindAddrs(p int) []string {
+	port := strconv.Itoa(p)
+	var addrs []string
+	for _, host := range strings.Fields(srv.opts.bind) {
+		if host == "*" {
+			host = ""
+		}
+		addrs = append(addrs, net.JoinHostPort(host, port))
+	}
+	return addrs
+}
+
+// serve accepts the connections of lis until it is closed.
+func (srv *Server) serve(lis net.Listener) {
 	for {
 		conn, err := lis.Accept()
 		if err != nil {
 			srv.log.Error("listener.Accpet failed", zap.String("err", err.Error()))
-			continue
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
 		}
 		go srv.handleConn(conn)
 	}
@@ -195,19 +697,21 @@ func (srv *Server) handleConn(conn net.Conn) {
 	cli.Interact()
 }
 
-func (srv *Server) freeMemoryIfNeed() {
-	// todo: gopsutil?
-	var ms runtime.MemStats
-	runtime.ReadMemStats(&ms)
-	fmt.Printf("%+v\n", ms)
-}
-
 func (srv *Server) Stop() {
 	if srv.close {
 		return
 	}
 
 	srv.close = true
+	srv.replMu.Lock()
+	if srv.primary != nil {
+		srv.primary.close()
+	}
+	srv.replMu.Unlock()
+	if srv.raft != nil {
+		srv.raft.stop()
+	}
+
 	var err error
 	for _, cli := range srv.clients {
 		if err = cli.Close(); err != nil {
@@ -220,5 +724,10 @@ func (srv *Server) Stop() {
 	if err = srv.db.Close(); err != nil {
 		srv.log.Warn("[Server Stop] db.Close()", zap.String("err", err.Error()))
 	}
+	if srv.opts.unixSocket != "" {
+		if err = os.Remove(srv.opts.unixSocket); err != nil && !os.IsNotExist(err) {
+			srv.log.Warn("[Server Stop] remove unixsocket", zap.String("err", err.Error()))
+		}
+	}
 	srv.log.Info("[Server Stop] finished")
 }
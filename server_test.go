@@ -1,9 +1,16 @@
 package gres
 
 import (
+	"bufio"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestServe(t *testing.T) {
@@ -11,3 +18,43 @@ func TestServe(t *testing.T) {
 	fmt.Printf("%+v\n", s)
 	s.Start()
 }
+
+func TestServer_UnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gres-unix")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "gres.sock")
+	// 上次残留的 socket 文件
+	assert.Nil(t, ioutil.WriteFile(path, nil, 0600))
+
+	opts := defaultServerOptions
+	opts.port = 0
+	opts.unixSocket = path
+	opts.unixSocketPerm = 0700
+	srv := newConfigServer(opts)
+	srv.pubsub = newPubsub()
+
+	listeners, err := srv.listen()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(listeners))
+	defer listeners[0].Close()
+	go srv.serve(listeners[0])
+
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+
+	conn, err := net.Dial("unix", path)
+	assert.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("*1\r\n$4\r\nPING\r\n"))
+	assert.Nil(t, err)
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "+PONG\r\n", line)
+
+	opts.unixSocket = ""
+	_, err = newConfigServer(opts).listen()
+	assert.NotNil(t, err)
+}
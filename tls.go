@@ -0,0 +1,63 @@
+package gres
+
+import (
+	"crypto/tls"
+	"errors"
+	"strings"
+
+	"github.com/clovers4/gres/util"
+)
+
+// tlsAuthClients is whether the clients of the tls port must send a certificate.
+type tlsAuthClients string
+
+const (
+	tlsAuthClientsNo       tlsAuthClients = "no"       // 不要求客户端证书
+	tlsAuthClientsOptional tlsAuthClients = "optional" // 客户端发送证书时才校验
+	tlsAuthClientsYes      tlsAuthClients = "yes"      // 双向 TLS, 必须发送由 CA 签发的证书
+)
+
+func parseTLSAuthClients(s string) (tlsAuthClients, error) {
+	switch a := tlsAuthClients(strings.ToLower(s)); a {
+	case tlsAuthClientsNo, tlsAuthClientsOptional, tlsAuthClientsYes:
+		return a, nil
+	}
+	return "", errors.New("argument must be 'no', 'optional' or 'yes'")
+}
+
+func (a tlsAuthClients) clientAuth() tls.ClientAuthType {
+	switch a {
+	case tlsAuthClientsOptional:
+		return tls.VerifyClientCertIfGiven
+	case tlsAuthClientsYes:
+		return tls.RequireAndVerifyClientCert
+	}
+	return tls.NoClientCert
+}
+
+// tlsConfig returns the config of the tls port, the certificates are read when it is called.
+func (opt *serverOptions) tlsConfig() (*tls.Config, error) {
+	if opt.tlsCertFile == "" || opt.tlsKeyFile == "" {
+		return nil, errors.New("tls-cert-file and tls-key-file are required by tls-port")
+	}
+	cert, err := tls.LoadX509KeyPair(opt.tlsCertFile, opt.tlsKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   opt.tlsMinVersion,
+		ClientAuth:   opt.tlsAuthClients.clientAuth(),
+	}
+	if opt.tlsCACertFile != "" {
+		pool, err := util.LoadCertPool(opt.tlsCACertFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.ClientCAs = pool
+	} else if opt.tlsAuthClients != tlsAuthClientsNo {
+		return nil, errors.New("tls-ca-cert-file is required to verify the certificates of clients")
+	}
+	return conf, nil
+}
@@ -0,0 +1,120 @@
+package gres
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/clovers4/gres/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeCert signs a certificate by the parent, or a self-signed CA if parent is nil,
+// and writes it with the private key to <dir>/<name>.crt and <dir>/<name>.key.
+func writeCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return cert, key
+}
+
+func TestServer_TLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gres-tls")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	ca, caKey := writeCert(t, dir, "ca", nil, nil)
+	writeCert(t, dir, "server", ca, caKey)
+	writeCert(t, dir, "client", ca, caKey)
+	file := func(name string) string { return filepath.Join(dir, name) }
+
+	opts := defaultServerOptions
+	TLSOption(6380, file("server.crt"), file("server.key"))(&opts)
+	TLSAuthClientsOption(file("ca.crt"), true)(&opts)
+	srv := newConfigServer(opts)
+	srv.pubsub = newPubsub()
+
+	conf, err := srv.opts.tlsConfig()
+	assert.Nil(t, err)
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", conf)
+	assert.Nil(t, err)
+	defer lis.Close()
+	go srv.serve(lis)
+	addr := lis.Addr().String()
+
+	ping := func(conf *tls.Config) (string, error) {
+		conn, err := tls.Dial("tcp", addr, conf)
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte("*1\r\n$4\r\nPING\r\n")); err != nil {
+			return "", err
+		}
+		return bufio.NewReader(conn).ReadString('\n')
+	}
+
+	// 双向 TLS
+	clientConf, err := util.ClientTLSConfig("127.0.0.1", file("client.crt"), file("client.key"), file("ca.crt"), false)
+	assert.Nil(t, err)
+	line, err := ping(clientConf)
+	assert.Nil(t, err)
+	assert.Equal(t, "+PONG\r\n", line)
+
+	// 没有客户端证书
+	clientConf, err = util.ClientTLSConfig("127.0.0.1", "", "", file("ca.crt"), false)
+	assert.Nil(t, err)
+	_, err = ping(clientConf)
+	assert.NotNil(t, err)
+
+	// 低于最低版本
+	clientConf, err = util.ClientTLSConfig("127.0.0.1", file("client.crt"), file("client.key"), file("ca.crt"), false)
+	assert.Nil(t, err)
+	clientConf.MaxVersion = tls.VersionTLS11
+	_, err = ping(clientConf)
+	assert.NotNil(t, err)
+
+	// 校验客户端证书时需要 CA
+	opts.tlsCACertFile = ""
+	_, err = opts.tlsConfig()
+	assert.NotNil(t, err)
+	opts.tlsAuthClients = tlsAuthClientsNo
+	_, err = opts.tlsConfig()
+	assert.Nil(t, err)
+}
@@ -7,34 +7,57 @@ import (
 
 var DefaultByteOrder = binary.BigEndian
 
+// Write writes data in binary. A string or []byte is written with its length.
 func Write(w io.Writer, data interface{}) error {
-	if s, ok := data.(string); ok {
-		bs := StringToBytes(s)
-		if err := binary.Write(w, DefaultByteOrder, int64(len(bs))); err != nil {
-			return err
-		}
-		if err := binary.Write(w, DefaultByteOrder, bs); err != nil {
-			return err
-		}
-		return nil
+	var bs []byte
+	switch v := data.(type) {
+	case string:
+		bs = StringToBytes(v)
+	case []byte:
+		bs = v
+	default:
+		return binary.Write(w, DefaultByteOrder, data)
+	}
+
+	if err := binary.Write(w, DefaultByteOrder, int64(len(bs))); err != nil {
+		return err
+	}
+	if err := binary.Write(w, DefaultByteOrder, bs); err != nil {
+		return err
 	}
-	return binary.Write(w, DefaultByteOrder, data)
+	return nil
 }
 
+// Read reads data written by Write.
 func Read(r io.Reader, data interface{}) error {
-	if s, ok := data.(*string); ok {
-		var len int64
-		if err := binary.Read(r, DefaultByteOrder, &len); err != nil {
+	switch v := data.(type) {
+	case *string:
+		bs, err := readBytes(r)
+		if err != nil {
 			return err
 		}
-
-		bs := make([]byte, len)
-		if _, err := io.ReadFull(r, bs); err != nil {
+		*v = BytesToString(bs)
+		return nil
+	case *[]byte:
+		bs, err := readBytes(r)
+		if err != nil {
 			return err
 		}
-
-		*s = BytesToString(bs)
+		*v = bs
 		return nil
 	}
 	return binary.Read(r, DefaultByteOrder, data)
 }
+
+func readBytes(r io.Reader) ([]byte, error) {
+	var len int64
+	if err := binary.Read(r, DefaultByteOrder, &len); err != nil {
+		return nil, err
+	}
+
+	bs := make([]byte, len)
+	if _, err := io.ReadFull(r, bs); err != nil {
+		return nil, err
+	}
+	return bs, nil
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/clovers4/gres/errs"
 	"math"
 	"strconv"
+	"strings"
 )
 
 func Add(a, b int) (int, bool) {
@@ -62,3 +63,36 @@ func String2Int(s string) (num int, err error) {
 func String2Float(s string) (num float64, err error) {
 	return strconv.ParseFloat(s, 64)
 }
+
+var memoryUnits = []struct {
+	suffix string
+	bytes  uint64
+}{
+	// 长后缀优先匹配, 与 redis 相同: k=1000, kb=1024
+	{"kb", 1 << 10},
+	{"mb", 1 << 20},
+	{"gb", 1 << 30},
+	{"k", 1000},
+	{"m", 1000 * 1000},
+	{"g", 1000 * 1000 * 1000},
+	{"b", 1},
+}
+
+// ParseMemory parses a memory size such as "100mb" or "1gb" into bytes.
+func ParseMemory(s string) (uint64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	unit := uint64(1)
+	for _, u := range memoryUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSuffix(s, u.suffix)
+			unit = u.bytes
+			break
+		}
+	}
+
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * unit, nil
+}
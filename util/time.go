@@ -0,0 +1,13 @@
+package util
+
+import "time"
+
+// UnixMs returns t as unix time in milliseconds.
+func UnixMs(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// NowMs returns the current unix time in milliseconds.
+func NowMs() int64 {
+	return UnixMs(time.Now())
+}
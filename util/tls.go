@@ -0,0 +1,78 @@
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{
+	"tlsv1":   tls.VersionTLS10,
+	"tlsv1.0": tls.VersionTLS10,
+	"tlsv1.1": tls.VersionTLS11,
+	"tlsv1.2": tls.VersionTLS12,
+	"tlsv1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion parses the version of TLS, such as TLSv1.2.
+func ParseTLSVersion(s string) (uint16, error) {
+	if v, ok := tlsVersions[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("invalid tls version: %v", s)
+}
+
+// FormatTLSVersion is the reverse of ParseTLSVersion.
+func FormatTLSVersion(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "TLSv1.0"
+	case tls.VersionTLS11:
+		return "TLSv1.1"
+	case tls.VersionTLS12:
+		return "TLSv1.2"
+	case tls.VersionTLS13:
+		return "TLSv1.3"
+	}
+	return fmt.Sprintf("0x%04x", v)
+}
+
+// LoadCertPool reads the PEM encoded certificates of the CA.
+func LoadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %v", caFile)
+	}
+	return pool, nil
+}
+
+// ClientTLSConfig returns the config of a TLS client. The certificate of the client
+// is sent if certFile is set, and the certificate of the server is verified by caFile,
+// or by the system roots if caFile is empty.
+func ClientTLSConfig(serverName, certFile, keyFile, caFile string, insecure bool) (*tls.Config, error) {
+	conf := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: insecure,
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pool, err := LoadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = pool
+	}
+	return conf, nil
+}